@@ -41,6 +41,9 @@ type InstanceConfig struct {
 	TelnetPort     int
 	TelnetPassword string
 	AvoidBloodMoonRestart bool
+	// Container is the Docker container running the server; when set, the
+	// lifecycle goes through the Docker Engine API instead of the host.
+	Container string
 	// Optional game-specific config (e.g. log subpath, RCON port)
 	Extra map[string]interface{}
 }
@@ -23,6 +23,8 @@ type Client interface {
 	SubmitJobResult(ctx context.Context, hostID string, jobID string, result *JobResultPayload) error
 	// SubmitJobProgress reports a nonterminal display phase without completing the run.
 	SubmitJobProgress(ctx context.Context, hostID string, jobID string, phase string, message string) error
+	// ReportServerEvent records a supervisor event, such as a crash, for a server instance.
+	ReportServerEvent(ctx context.Context, hostID string, serverInstanceID string, event *ServerEvent) error
 	// StreamLog uploads log chunks (e.g. multipart or chunked body). Optional for MVP.
 	StreamLog(ctx context.Context, hostID string, serverInstanceID string, r io.Reader) error
 }
@@ -72,6 +74,18 @@ type DiscoveredServer struct {
 	Config         map[string]interface{} `json:"config,omitempty"`
 }
 
+// ServerEvent is a crash or restart observed by the process supervisor.
+type ServerEvent struct {
+	Type          string    `json:"type"`
+	Message       string    `json:"message,omitempty"`
+	PID           int       `json:"pid,omitempty"`
+	PreviousPID   int       `json:"previousPid,omitempty"`
+	CrashCount    int       `json:"crashCount,omitempty"`
+	WindowSeconds int       `json:"windowSeconds,omitempty"`
+	LogTail       string    `json:"logTail,omitempty"`
+	OccurredAt    time.Time `json:"occurredAt"`
+}
+
 // JobResultPayload is sent when submitting a job result.
 type JobResultPayload struct {
 	Status       string                 `json:"status"`
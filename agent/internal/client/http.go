@@ -313,6 +313,31 @@ func (c *HTTPClient) SubmitJobProgress(ctx context.Context, hostID string, jobID
 	return nil
 }
 
+// ReportServerEvent implements Client.
+func (c *HTTPClient) ReportServerEvent(ctx context.Context, hostID string, serverInstanceID string, event *ServerEvent) error {
+	ctx, cancel := c.timeoutContext(ctx, c.ordinaryTimeout())
+	defer cancel()
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/api/agent/hosts/"+url.PathEscape(hostID)+"/server-instances/"+url.PathEscape(serverInstanceID)+"/events", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.AgentKey)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer closeResponse(resp.Body)
+	if !isSuccess(resp.StatusCode) {
+		return responseError("report server event", resp)
+	}
+	return nil
+}
+
 // StreamLog implements Client.
 func (c *HTTPClient) StreamLog(ctx context.Context, hostID string, serverInstanceID string, r io.Reader) error {
 	content, err := io.ReadAll(r)
@@ -24,6 +24,81 @@ type Config struct {
 	Host            HostCfg      `yaml:"host" json:"host"`
 	Discovery       DiscoveryCfg `yaml:"discovery" json:"discovery"`
 	Logs            LogsCfg      `yaml:"logs" json:"logs"`
+	// HelperSocket is the privileged helper's unix socket.
+	HelperSocket string `yaml:"helper_socket" json:"helper_socket"`
+	// Supervisors lists server instances the agent restarts after crashes.
+	Supervisors []SupervisorCfg `yaml:"supervisors" json:"supervisors"`
+	// BackupTargets are off-host destinations for 7DTD save backups.
+	BackupTargets []BackupTargetCfg `yaml:"backup_targets" json:"backup_targets"`
+	// BackupRecipientFile is a PEM public key (X25519 or RSA) that 7DTD
+	// save backups are encrypted to. Empty leaves backups unencrypted.
+	BackupRecipientFile string `yaml:"backup_recipient_file" json:"backup_recipient_file"`
+	// SaveBackups places each server instance's 7DTD save backups.
+	SaveBackups SaveBackupsCfg `yaml:"save_backups" json:"save_backups"`
+	// ServerConfigBackupRoot keeps 7DTD serverconfig.xml backups, one
+	// directory per server instance. Empty keeps the agent's state dir.
+	ServerConfigBackupRoot string `yaml:"server_config_backup_root" json:"server_config_backup_root"`
+	// DiskReserveMB is the free space 7DTD backups, restores and mod
+	// uploads must leave on their destination filesystem. 0 means 512;
+	// a negative value disables the reserve.
+	DiskReserveMB int `yaml:"disk_reserve_mb" json:"disk_reserve_mb"`
+}
+
+// SaveBackupsCfg configures where 7DTD save backups live. Each server
+// instance keeps its backups under <root>/.mastermind-instances/<id>.
+type SaveBackupsCfg struct {
+	// Root is the default base directory; empty keeps the Region Healer
+	// Saves directory.
+	Root string `yaml:"root" json:"root"`
+	// InstanceRoots overrides Root per server instance ID.
+	InstanceRoots map[string]string `yaml:"instance_roots" json:"instance_roots"`
+	// RegionHealerInstance is the server instance whose world Region Healer
+	// snapshots; only it lists and restores those snapshots.
+	RegionHealerInstance string `yaml:"region_healer_instance" json:"region_healer_instance"`
+}
+
+// BackupTargetCfg is one off-host backup destination: an S3-compatible
+// bucket or an SFTP server.
+type BackupTargetCfg struct {
+	Name string `yaml:"name" json:"name"`
+	// Type is "s3" or "sftp".
+	Type string `yaml:"type" json:"type"`
+	// Prefix is the key prefix in the bucket, or the remote directory.
+	Prefix         string `yaml:"prefix" json:"prefix"`
+	Endpoint       string `yaml:"endpoint" json:"endpoint"`
+	Region         string `yaml:"region" json:"region"`
+	Bucket         string `yaml:"bucket" json:"bucket"`
+	AccessKey      string `yaml:"access_key" json:"access_key"`
+	SecretKey      string `yaml:"secret_key" json:"secret_key"`
+	PathStyle      bool   `yaml:"path_style" json:"path_style"`
+	PartSizeMB     int    `yaml:"part_size_mb" json:"part_size_mb"`
+	Host           string `yaml:"host" json:"host"`
+	Port           int    `yaml:"port" json:"port"`
+	User           string `yaml:"user" json:"user"`
+	IdentityFile   string `yaml:"identity_file" json:"identity_file"`
+	KnownHostsFile string `yaml:"known_hosts_file" json:"known_hosts_file"`
+	// RetentionCount keeps this many newest backups remotely; 0 keeps all.
+	RetentionCount int `yaml:"retention_count" json:"retention_count"`
+	// ReplicateAfterBackup copies every new backup to this target.
+	ReplicateAfterBackup bool `yaml:"replicate_after_backup" json:"replicate_after_backup"`
+}
+
+// SupervisorCfg enables crash supervision for one server instance.
+type SupervisorCfg struct {
+	ServerInstanceID string `yaml:"server_instance_id" json:"server_instance_id"`
+	// Mode is "systemd" (default) or "native" for servers the agent starts
+	// itself from start.sh, server.jar or a start_command.
+	Mode string `yaml:"mode" json:"mode"`
+	// Unit is the systemd unit to watch and restart.
+	Unit string `yaml:"unit" json:"unit"`
+	// LogPath is tailed into crash reports; the unit journal is used when empty.
+	LogPath           string `yaml:"log_path" json:"log_path"`
+	LogLines          int    `yaml:"log_lines" json:"log_lines"`
+	MaxCrashes        int    `yaml:"max_crashes" json:"max_crashes"`
+	CrashWindowSec    int    `yaml:"crash_window_sec" json:"crash_window_sec"`
+	PollIntervalSec   int    `yaml:"poll_interval_sec" json:"poll_interval_sec"`
+	BackoffInitialSec int    `yaml:"backoff_initial_sec" json:"backoff_initial_sec"`
+	BackoffMaxSec     int    `yaml:"backoff_max_sec" json:"backoff_max_sec"`
 }
 
 type LogsCfg struct {
@@ -62,6 +137,8 @@ type SevenDTDDiscoveryCfg struct {
 	ServerAdminXMLPath string `yaml:"server_admin_xml_path" json:"server_admin_xml_path"`
 	StartCommand       string `yaml:"start_command" json:"start_command"`
 	Name               string `yaml:"name" json:"name"`
+	// SteamCMDPath enables GAME_INSTALL and GAME_UPDATE when set.
+	SteamCMDPath string `yaml:"steamcmd_path" json:"steamcmd_path"`
 }
 
 // Load reads config from path. Supports .yaml, .yml, .json.
@@ -121,6 +198,9 @@ func (c *Config) Env() {
 	if v := os.Getenv("MASTERMIND_7DTD_NAME"); v != "" {
 		c.Discovery.SevenDTD.Name = v
 	}
+	if v := os.Getenv("MASTERMIND_7DTD_STEAMCMD"); v != "" {
+		c.Discovery.SevenDTD.SteamCMDPath = v
+	}
 	if v := os.Getenv("MASTERMIND_JOBS_MAX_CONCURRENT_READS"); v != "" {
 		// Invalid or non-positive environment values are ignored so they cannot
 		// accidentally disable the job loop's read worker pool.
@@ -157,4 +237,39 @@ func (c *Config) Defaults() {
 	if c.Logs.PollIntervalSec <= 0 {
 		c.Logs.PollIntervalSec = 2
 	}
+	if c.DiskReserveMB == 0 {
+		c.DiskReserveMB = 512
+	} else if c.DiskReserveMB < 0 {
+		c.DiskReserveMB = 0
+	}
+	if c.HelperSocket == "" {
+		c.HelperSocket = "/run/mastermind-helper/helper.sock"
+	}
+	for i := range c.Supervisors {
+		supervisor := &c.Supervisors[i]
+		if supervisor.Mode == "" {
+			supervisor.Mode = "systemd"
+		}
+		if supervisor.Unit == "" && supervisor.Mode == "systemd" {
+			supervisor.Unit = "7dtd.service"
+		}
+		if supervisor.LogLines <= 0 {
+			supervisor.LogLines = 100
+		}
+		if supervisor.MaxCrashes <= 0 {
+			supervisor.MaxCrashes = 3
+		}
+		if supervisor.CrashWindowSec <= 0 {
+			supervisor.CrashWindowSec = 900
+		}
+		if supervisor.PollIntervalSec <= 0 {
+			supervisor.PollIntervalSec = 5
+		}
+		if supervisor.BackoffInitialSec <= 0 {
+			supervisor.BackoffInitialSec = 10
+		}
+		if supervisor.BackoffMaxSec <= 0 {
+			supervisor.BackoffMaxSec = 300
+		}
+	}
 }
@@ -58,3 +58,28 @@ func TestDefaultsClampUnsafeJobSettings(t *testing.T) {
 		t.Fatalf("negative long poll = %d, want 0", cfg.Jobs.LongPollSec)
 	}
 }
+
+func TestDefaultsDiskReserve(t *testing.T) {
+	for _, tc := range []struct{ set, want int }{{0, 512}, {2048, 2048}, {-1, 0}} {
+		cfg := &Config{DiskReserveMB: tc.set}
+		cfg.Defaults()
+		if cfg.DiskReserveMB != tc.want {
+			t.Fatalf("disk reserve %d = %d, want %d", tc.set, cfg.DiskReserveMB, tc.want)
+		}
+	}
+}
+
+func TestDefaultsSupervisors(t *testing.T) {
+	cfg := &Config{Supervisors: []SupervisorCfg{{ServerInstanceID: "a"}, {ServerInstanceID: "b", Unit: "other.service", MaxCrashes: 5}, {ServerInstanceID: "c", Mode: "native"}}}
+	cfg.Defaults()
+	first, second, native := cfg.Supervisors[0], cfg.Supervisors[1], cfg.Supervisors[2]
+	if first.Mode != "systemd" || first.Unit != "7dtd.service" || first.MaxCrashes != 3 || first.CrashWindowSec != 900 || first.LogLines != 100 {
+		t.Fatalf("defaults = %+v", first)
+	}
+	if second.Unit != "other.service" || second.MaxCrashes != 5 {
+		t.Fatalf("explicit settings changed: %+v", second)
+	}
+	if native.Unit != "" {
+		t.Fatalf("native supervisor got a unit: %+v", native)
+	}
+}
@@ -0,0 +1,263 @@
+// Package docker is a small Docker Engine API client for game servers that
+// run in containers. It talks HTTP over the daemon's unix socket and covers
+// the lifecycle, logs and exec calls the game adapters need.
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultSocket is the Docker daemon's default unix socket.
+	DefaultSocket = "/var/run/docker.sock"
+	// apiVersion is the oldest Engine API version with every call used here
+	// (Docker 20.10).
+	apiVersion = "v1.41"
+	// maxOutputBytes bounds logs and exec output read into memory.
+	maxOutputBytes = 4 * 1024 * 1024
+)
+
+// Default is the client used by the game adapters.
+var Default = New(DefaultSocket)
+
+// Client calls the Docker Engine API.
+type Client struct {
+	http *http.Client
+	base string
+}
+
+// New returns a client for the daemon listening on socket.
+func New(socket string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+		MaxIdleConns:    4,
+		IdleConnTimeout: 30 * time.Second,
+	}
+	return &Client{http: &http.Client{Transport: transport}, base: "http://docker/" + apiVersion}
+}
+
+// APIError is an error response from the Engine API.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("docker: %s (HTTP %d)", e.Message, e.StatusCode)
+}
+
+// IsNotFound reports whether err is a missing container or exec instance.
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// State is the subset of a container's state the adapters report.
+type State struct {
+	Status     string    `json:"status"`
+	Running    bool      `json:"running"`
+	Restarting bool      `json:"restarting"`
+	OOMKilled  bool      `json:"oomKilled"`
+	PID        int       `json:"pid"`
+	ExitCode   int       `json:"exitCode"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Health     string    `json:"health,omitempty"`
+}
+
+// Inspect returns the state of container.
+func (c *Client) Inspect(ctx context.Context, container string) (State, error) {
+	var body struct {
+		State struct {
+			Status     string
+			Running    bool
+			Restarting bool
+			OOMKilled  bool
+			Pid        int
+			ExitCode   int
+			Error      string
+			StartedAt  time.Time
+			FinishedAt time.Time
+			Health     *struct{ Status string }
+		}
+	}
+	if err := c.do(ctx, http.MethodGet, containerPath(container, "json"), nil, nil, &body); err != nil {
+		return State{}, err
+	}
+	s := body.State
+	state := State{
+		Status: s.Status, Running: s.Running, Restarting: s.Restarting, OOMKilled: s.OOMKilled,
+		PID: s.Pid, ExitCode: s.ExitCode, Error: s.Error, StartedAt: s.StartedAt, FinishedAt: s.FinishedAt,
+	}
+	if s.Health != nil {
+		state.Health = s.Health.Status
+	}
+	return state, nil
+}
+
+// Start starts container. Starting a running container succeeds.
+func (c *Client) Start(ctx context.Context, container string) error {
+	return c.do(ctx, http.MethodPost, containerPath(container, "start"), nil, nil, nil)
+}
+
+// Stop sends the container's stop signal and kills it after timeout.
+// Stopping a stopped container succeeds.
+func (c *Client) Stop(ctx context.Context, container string, timeout time.Duration) error {
+	return c.do(ctx, http.MethodPost, containerPath(container, "stop"), timeoutQuery(timeout), nil, nil)
+}
+
+// Restart stops the container like Stop and starts it again.
+func (c *Client) Restart(ctx context.Context, container string, timeout time.Duration) error {
+	return c.do(ctx, http.MethodPost, containerPath(container, "restart"), timeoutQuery(timeout), nil, nil)
+}
+
+// Kill sends signal (SIGKILL when empty) to the container. Killing a
+// container that is not running succeeds.
+func (c *Client) Kill(ctx context.Context, container, signal string) error {
+	if signal == "" {
+		signal = "SIGKILL"
+	}
+	err := c.do(ctx, http.MethodPost, containerPath(container, "kill"), url.Values{"signal": {signal}}, nil, nil)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict {
+		return nil
+	}
+	return err
+}
+
+// Logs returns the last tail lines of the container's stdout and stderr.
+func (c *Client) Logs(ctx context.Context, container string, tail int) (string, error) {
+	query := url.Values{"stdout": {"1"}, "stderr": {"1"}, "tail": {"all"}}
+	if tail > 0 {
+		query.Set("tail", strconv.Itoa(tail))
+	}
+	var raw bytes.Buffer
+	if err := c.do(ctx, http.MethodGet, containerPath(container, "logs"), query, nil, &raw); err != nil {
+		return "", err
+	}
+	return demux(raw.Bytes()), nil
+}
+
+// ExecResult is the outcome of a command run inside a container.
+type ExecResult struct {
+	ExitCode int    `json:"exitCode"`
+	Output   string `json:"output"`
+}
+
+// Exec runs argv inside the running container and waits for it to finish.
+func (c *Client) Exec(ctx context.Context, container string, argv []string) (ExecResult, error) {
+	if len(argv) == 0 {
+		return ExecResult{}, fmt.Errorf("empty exec command")
+	}
+	var created struct{ Id string }
+	request := map[string]interface{}{"AttachStdout": true, "AttachStderr": true, "Cmd": argv}
+	if err := c.do(ctx, http.MethodPost, containerPath(container, "exec"), nil, request, &created); err != nil {
+		return ExecResult{}, err
+	}
+	var raw bytes.Buffer
+	if err := c.do(ctx, http.MethodPost, "/exec/"+url.PathEscape(created.Id)+"/start", nil, map[string]bool{"Detach": false, "Tty": false}, &raw); err != nil {
+		return ExecResult{}, err
+	}
+	var inspected struct {
+		ExitCode int
+		Running  bool
+	}
+	if err := c.do(ctx, http.MethodGet, "/exec/"+url.PathEscape(created.Id)+"/json", nil, nil, &inspected); err != nil {
+		return ExecResult{}, err
+	}
+	return ExecResult{ExitCode: inspected.ExitCode, Output: demux(raw.Bytes())}, nil
+}
+
+// do sends one request. out may be a *bytes.Buffer for raw bodies or a value
+// to decode JSON into; 304 Not Modified is the Engine API's "already in that
+// state" answer and is treated as success.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	target := c.base + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	var body io.Reader
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("docker %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	limited := io.LimitReader(resp.Body, maxOutputBytes)
+	if resp.StatusCode >= 400 {
+		var apiErr struct{ Message string }
+		content, _ := io.ReadAll(limited)
+		if json.Unmarshal(content, &apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(content))
+		}
+		return &APIError{StatusCode: resp.StatusCode, Message: apiErr.Message}
+	}
+	if resp.StatusCode == http.StatusNotModified || out == nil {
+		return nil
+	}
+	if buffer, ok := out.(*bytes.Buffer); ok {
+		_, err := buffer.ReadFrom(limited)
+		return err
+	}
+	return json.NewDecoder(limited).Decode(out)
+}
+
+func containerPath(container, action string) string {
+	return "/containers/" + url.PathEscape(container) + "/" + action
+}
+
+func timeoutQuery(timeout time.Duration) url.Values {
+	if timeout <= 0 {
+		return nil
+	}
+	return url.Values{"t": {strconv.Itoa(int(timeout / time.Second))}}
+}
+
+// demux strips the 8-byte stream headers Docker adds to logs and exec output
+// of containers without a TTY. Output from TTY containers has no headers and
+// is returned unchanged.
+func demux(raw []byte) string {
+	var out bytes.Buffer
+	rest := raw
+	for len(rest) > 0 {
+		if len(rest) < 8 || rest[0] > 2 || rest[1] != 0 || rest[2] != 0 || rest[3] != 0 {
+			return string(raw)
+		}
+		size := int(binary.BigEndian.Uint32(rest[4:8]))
+		if len(rest) < 8+size {
+			return string(raw)
+		}
+		out.Write(rest[8 : 8+size])
+		rest = rest[8+size:]
+	}
+	return out.String()
+}
@@ -0,0 +1,164 @@
+package docker
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEngine is an in-memory Docker Engine API for one container.
+type fakeEngine struct {
+	mu       sync.Mutex
+	running  bool
+	requests []string
+	execCmd  []string
+}
+
+func frame(stream byte, content string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(content)))
+	return append(header, content...)
+}
+
+func (f *fakeEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/"+apiVersion)
+	f.requests = append(f.requests, r.Method+" "+path+"?"+r.URL.RawQuery)
+	if strings.HasPrefix(path, "/containers/missing/") {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message":"No such container: missing"}`))
+		return
+	}
+	switch path {
+	case "/containers/mc/json":
+		status := "exited"
+		if f.running {
+			status = "running"
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"State": map[string]interface{}{
+			"Status": status, "Running": f.running, "Pid": 4242, "ExitCode": 0,
+			"StartedAt": "2024-05-01T10:00:00Z", "Health": map[string]string{"Status": "healthy"},
+		}})
+	case "/containers/mc/start":
+		if f.running {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		f.running = true
+		w.WriteHeader(http.StatusNoContent)
+	case "/containers/mc/stop", "/containers/mc/restart":
+		f.running = path == "/containers/mc/restart"
+		w.WriteHeader(http.StatusNoContent)
+	case "/containers/mc/kill":
+		if !f.running {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"message":"container is not running"}`))
+			return
+		}
+		f.running = false
+		w.WriteHeader(http.StatusNoContent)
+	case "/containers/mc/logs":
+		_, _ = w.Write(append(frame(1, "Done (5.2s)!\n"), frame(2, "WARN Can't keep up!\n")...))
+	case "/containers/mc/exec":
+		var request struct{ Cmd []string }
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		f.execCmd = request.Cmd
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"Id":"exec1"}`))
+	case "/exec/exec1/start":
+		_, _ = w.Write(frame(1, "saved\n"))
+	case "/exec/exec1/json":
+		_, _ = w.Write([]byte(`{"ExitCode":3,"Running":false}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newFakeEngine(t *testing.T) (*Client, *fakeEngine) {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := &fakeEngine{}
+	server := httptest.NewUnstartedServer(engine)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	return New(socket), engine
+}
+
+func TestClientLifecycle(t *testing.T) {
+	client, engine := newFakeEngine(t)
+	ctx := context.Background()
+	if err := client.Start(ctx, "mc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Start(ctx, "mc"); err != nil {
+		t.Fatalf("starting a running container: %v", err)
+	}
+	state, err := client.Inspect(ctx, "mc")
+	if err != nil || !state.Running || state.PID != 4242 || state.Health != "healthy" || state.StartedAt.IsZero() {
+		t.Fatalf("state = %+v, %v", state, err)
+	}
+	if err := client.Stop(ctx, "mc", 90*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Kill(ctx, "mc", ""); err != nil {
+		t.Fatalf("killing a stopped container: %v", err)
+	}
+	if err := client.Restart(ctx, "mc", 0); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"POST /containers/mc/start?", "POST /containers/mc/start?", "GET /containers/mc/json?",
+		"POST /containers/mc/stop?t=90", "POST /containers/mc/kill?signal=SIGKILL", "POST /containers/mc/restart?",
+	}
+	if strings.Join(engine.requests, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("requests:\n%s", strings.Join(engine.requests, "\n"))
+	}
+}
+
+func TestClientLogsAndExecDemultiplexOutput(t *testing.T) {
+	client, engine := newFakeEngine(t)
+	ctx := context.Background()
+	logs, err := client.Logs(ctx, "mc", 50)
+	if err != nil || logs != "Done (5.2s)!\nWARN Can't keep up!\n" {
+		t.Fatalf("logs = %q, %v", logs, err)
+	}
+	if last := engine.requests[len(engine.requests)-1]; !strings.Contains(last, "tail=50") {
+		t.Fatalf("logs request = %s", last)
+	}
+	result, err := client.Exec(ctx, "mc", []string{"rcon-cli", "save-all"})
+	if err != nil || result.ExitCode != 3 || result.Output != "saved\n" {
+		t.Fatalf("exec = %+v, %v", result, err)
+	}
+	if strings.Join(engine.execCmd, " ") != "rcon-cli save-all" {
+		t.Fatalf("exec cmd = %v", engine.execCmd)
+	}
+}
+
+func TestClientReportsAPIErrors(t *testing.T) {
+	client, _ := newFakeEngine(t)
+	_, err := client.Inspect(context.Background(), "missing")
+	if !IsNotFound(err) || !strings.Contains(err.Error(), "No such container") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestDemuxLeavesTTYOutputAlone(t *testing.T) {
+	if got := demux([]byte("plain tty output\n")); got != "plain tty output\n" {
+		t.Fatalf("demux = %q", got)
+	}
+}
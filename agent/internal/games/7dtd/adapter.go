@@ -18,9 +18,12 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/mastermind/agent/internal/agent"
+	"github.com/mastermind/agent/internal/privhelper"
+	"github.com/mastermind/agent/internal/procsup"
 )
 
 const gameSlug = "7dtd"
@@ -29,7 +32,43 @@ const gameSlug = "7dtd"
 type Adapter struct {
 	// Runner is used for Start/Stop/Restart when no custom commands are set.
 	Runner *runnerShim
-}
+	// SteamCMDPath is the agent-configured steamcmd binary used by
+	// GAME_INSTALL and GAME_UPDATE. Job payloads cannot override it.
+	SteamCMDPath string
+	// BackupTargets are the agent-configured off-host copies of the save
+	// backups, by name. Job payloads can only pick one.
+	BackupTargets map[string]BackupTarget
+	// BackupRecipient, when set, encrypts every new save backup to the
+	// agent-configured public key.
+	BackupRecipient *BackupRecipient
+	// SaveBackupRoot is where server instances keep their save backup
+	// namespaces; SaveBackupRoots overrides it per server instance ID.
+	// Empty means saveBackupRoot.
+	SaveBackupRoot  string
+	SaveBackupRoots map[string]string
+	// RegionHealerInstance is the server instance whose world Region
+	// Healer snapshots into saveBackupRoot.
+	RegionHealerInstance string
+	// ServerConfigBackupRoot keeps each server instance's serverconfig.xml
+	// backups. Empty means defaultServerConfigBackupRoot.
+	ServerConfigBackupRoot string
+	// DiskReserveMB is the free space backups, restores and mod uploads
+	// must leave on their destination filesystem.
+	DiskReserveMB int
+
+	isRunning func(context.Context) bool
+}
+
+// privileged performs unit control and root-owned file changes through the
+// helper daemon; tests point it at their own helper.
+var privileged = privhelper.Default
+
+// processes supervises servers started from start.sh; tests replace it.
+var processes = procsup.Default
+
+// nativeStopTimeout is how long a supervised server gets to save and exit
+// before its process group is killed.
+const nativeStopTimeout = 2 * time.Minute
 
 // runnerShim allows the adapter to run start/stop commands (could be replaced by agent runner).
 type runnerShim struct {
@@ -66,15 +105,36 @@ func (a *Adapter) Capabilities() []string {
 // Execute dispatches job types to the appropriate capability (e.g. SERVER_START -> Start).
 func (a *Adapter) Execute(ctx context.Context, job agent.Job) (agent.JobResult, error) {
 	cfg := jobPayloadToConfig(job.Payload)
+	// The job's own instance wins over the payload, which callers can extend;
+	// it decides whose save backups the job sees.
+	if job.ServerInstanceID != "" {
+		cfg.ServerInstanceID = job.ServerInstanceID
+	}
 	switch strings.ToUpper(job.Type) {
 	case "SERVER_START":
-		return resultOrErr(a.Start(ctx, cfg))
+		logPath, _ := a.GetLogPath(cfg)
+		offset := healthLogOffset(logPath)
+		if err := a.Start(ctx, cfg); err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		return a.verifyStart(ctx, cfg, job.Payload, logPath, offset, agent.JobResult{Status: "success"})
 	case "SERVER_STOP":
 		return resultOrErr(a.Stop(ctx, cfg))
 	case "SERVER_KILL":
-		return resultOrErr(a.Kill(ctx))
+		return resultOrErr(a.Kill(ctx, cfg))
+	case "SERVER_LOGS":
+		output, err := a.serverLogs(ctx, cfg, getInt(job.Payload, "lines", defaultServerLogLines))
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		return agent.JobResult{Status: "success", Output: output}, nil
 	case "SERVER_RESTART":
-		return resultOrErr(a.Restart(ctx, cfg))
+		logPath, _ := a.GetLogPath(cfg)
+		var offset int64
+		if err := a.restart(ctx, cfg, func() { offset = healthLogOffset(logPath) }); err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		return a.verifyStart(ctx, cfg, job.Payload, logPath, offset, agent.JobResult{Status: "success"})
 	case "SERVER_SAFE_RESTART":
 		return a.SafeRestart(ctx, cfg, job.Payload)
 	case "SERVER_WIPE_SAVE":
@@ -86,6 +146,45 @@ func (a *Adapter) Execute(ctx context.Context, job agent.Job) (agent.JobResult,
 			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
 		}
 		return agent.JobResult{Status: "success", Result: map[string]interface{}{"deletedSave": path, "restarted": true}}, nil
+	case "SERVER_CONFIG_READ":
+		state, err := a.ReadServerConfig(cfg, getString(job.Payload, "server_config_path", ""), getString(job.Payload, "game_version", ""))
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		return agent.JobResult{Status: "success", Result: map[string]interface{}{"serverConfig": state}}, nil
+	case "SERVER_CONFIG_WRITE":
+		result, err := a.WriteServerConfig(ctx, cfg, job.Payload)
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		if backup, ok := result["backup"].(serverConfigBackup); ok {
+			return withPendingChange(agent.JobResult{Status: "success", Result: result}, cfg, pendingChange{
+				Kind: "server_config", Summary: "server configuration write", ServerConfigPath: getString(job.Payload, "server_config_path", ""), BackupID: backup.ID,
+			}), nil
+		}
+		return agent.JobResult{Status: "success", Result: result}, nil
+	case "SERVER_CONFIG_ROLLBACK":
+		if !getBool(job.Payload, "confirmed") {
+			return agent.JobResult{Status: "failed", Error: "server configuration rollback requires explicit confirmation"}, nil
+		}
+		result, err := a.RollbackServerConfig(ctx, cfg, getString(job.Payload, "server_config_path", ""), getString(job.Payload, "backup_id", ""))
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		backup, _ := result["backup"].(serverConfigBackup)
+		return withPendingChange(agent.JobResult{Status: "success", Result: result}, cfg, pendingChange{
+			Kind: "server_config", Summary: "server configuration rollback to " + getString(job.Payload, "backup_id", ""), ServerConfigPath: getString(job.Payload, "server_config_path", ""), BackupID: backup.ID,
+		}), nil
+	case "GAME_INSTALL", "GAME_UPDATE":
+		options, err := steamCMDOptionsFromPayload(job.Payload)
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		result, output, err := a.UpdateGame(ctx, cfg, options, strings.EqualFold(job.Type, "GAME_INSTALL"))
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error(), Output: output}, nil
+		}
+		return agent.JobResult{Status: "success", Output: output, Result: map[string]interface{}{"game": result}}, nil
 	case "RCON", "SEND_COMMAND":
 		cmd := strings.TrimSpace(getString(job.Payload, "command", ""))
 		if cmd == "" {
@@ -111,6 +210,23 @@ func (a *Adapter) Execute(ctx context.Context, job agent.Job) (agent.JobResult,
 			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
 		}
 		return agent.JobResult{Status: "success", Result: map[string]interface{}{"admins": admins}}, nil
+	case "SERVER_ADMIN_LIST":
+		path, err := configuredServerAdminPath(job.Payload)
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		document, err := readServerAdminDocument(path)
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		return agent.JobResult{Status: "success", Result: map[string]interface{}{"serverAdmin": document}}, nil
+	case "SERVER_ADMIN_ADD", "SERVER_ADMIN_UPDATE", "SERVER_ADMIN_REMOVE":
+		action := strings.ToLower(strings.TrimPrefix(strings.ToUpper(job.Type), "SERVER_ADMIN_"))
+		document, viaConsole, err := a.ChangeServerAdmin(ctx, cfg, job.Payload, action)
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		return agent.JobResult{Status: "success", Result: map[string]interface{}{"serverAdmin": document, "appliedViaConsole": viaConsole}}, nil
 	case "PLAYER_ADMIN_PROMOTE", "PLAYER_ADMIN_DEMOTE":
 		identifier := sanitizeRCONArg(getString(job.Payload, "identifier", ""))
 		platform := getString(job.Payload, "platform", "")
@@ -138,47 +254,216 @@ func (a *Adapter) Execute(ctx context.Context, job agent.Job) (agent.JobResult,
 		}
 		return agent.JobResult{Status: "success", Output: out}, nil
 	case "REGION_HEALER_START":
-		return resultOrErr(a.Runner.run(ctx, "", "/usr/bin/sudo", "/usr/bin/systemctl", "start", "regionhealer.service"))
+		return resultOrErr(systemctlService(ctx, "start", "regionhealer.service"))
 	case "REGION_HEALER_STOP":
-		return resultOrErr(a.Runner.run(ctx, "", "/usr/bin/sudo", "/usr/bin/systemctl", "stop", "regionhealer.service"))
+		return resultOrErr(systemctlService(ctx, "stop", "regionhealer.service"))
+	case "REGION_LIST":
+		inventory, err := a.ListRegions(cfg, getString(job.Payload, "server_config_path", ""))
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		return agent.JobResult{Status: "success", Result: map[string]interface{}{"regions": inventory}}, nil
+	case "REGION_RESET":
+		dryRun := getBool(job.Payload, "dry_run")
+		if !dryRun && !getBool(job.Payload, "confirmed") {
+			return agent.JobResult{Status: "failed", Error: "region reset requires explicit confirmation"}, nil
+		}
+		regions, err := regionSelection(job.Payload)
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		format, err := saveBackupFormat(job.Payload)
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		policy, err := saveRetention(job.Payload)
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		reset, err := a.ResetRegions(ctx, cfg, getString(job.Payload, "server_config_path", ""), regions, dryRun, policy, format)
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error(), Result: map[string]interface{}{"reset": reset}}, nil
+		}
+		return agent.JobResult{Status: "success", Result: map[string]interface{}{"reset": reset}}, nil
 	case "SAVE_LIST":
+		if target := getString(job.Payload, "target", ""); target != "" {
+			saves, err := a.ListRemoteSaves(ctx, cfg, target)
+			if err != nil {
+				return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+			}
+			return agent.JobResult{Status: "success", Result: map[string]interface{}{"saves": saves, "target": target}}, nil
+		}
 		saves, err := a.ListSaves(cfg, getString(job.Payload, "server_config_path", ""))
 		if err != nil {
 			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
 		}
 		return agent.JobResult{Status: "success", Result: map[string]interface{}{"saves": saves}}, nil
+	case "SAVE_DIFF":
+		identity, err := parseSaveIdentity(getString(job.Payload, "private_key", ""))
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		diff, err := a.DiffSaves(ctx, cfg, getString(job.Payload, "server_config_path", ""), getString(job.Payload, "save_id", ""), getString(job.Payload, "compare_save_id", ""), identity)
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		return agent.JobResult{Status: "success", Result: map[string]interface{}{"diff": diff}}, nil
 	case "SAVE_BACKUP":
-		save, err := a.BackupSave(ctx, cfg, getString(job.Payload, "server_config_path", ""), getInt(job.Payload, "retention_count", 10))
+		format, err := saveBackupFormat(job.Payload)
 		if err != nil {
 			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
 		}
-		return agent.JobResult{Status: "success", Result: map[string]interface{}{"save": save}}, nil
+		policy, err := saveRetention(job.Payload)
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		save, err := a.BackupSave(ctx, cfg, getString(job.Payload, "server_config_path", ""), policy, format)
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		result := map[string]interface{}{"save": save}
+		if replication := a.replicateAfterBackup(ctx, cfg, save.ID); len(replication) > 0 {
+			result["replication"] = replication
+		}
+		return agent.JobResult{Status: "success", Result: result}, nil
 	case "SAVE_RESTORE":
 		if !getBool(job.Payload, "confirmed") {
 			return agent.JobResult{Status: "failed", Error: "save restore requires explicit confirmation"}, nil
 		}
-		save, err := a.RestoreSave(ctx, cfg, getString(job.Payload, "server_config_path", ""), getString(job.Payload, "save_id", ""))
+		if target := getString(job.Payload, "target", ""); target != "" {
+			if err := a.FetchRemoteSave(ctx, cfg, target, getString(job.Payload, "save_id", "")); err != nil {
+				return agent.JobResult{Status: "failed", Error: fmt.Sprintf("fetch backup from %s: %v", target, err)}, nil
+			}
+		}
+		identity, err := parseSaveIdentity(getString(job.Payload, "private_key", ""))
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		save, err := a.RestoreSave(ctx, cfg, getString(job.Payload, "server_config_path", ""), getString(job.Payload, "save_id", ""), identity, getBool(job.Payload, "force"))
 		if err != nil {
 			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
 		}
 		return agent.JobResult{Status: "success", Result: map[string]interface{}{"save": save, "serverStopped": true}}, nil
+	case "SAVE_RESTORE_REGIONS":
+		if !getBool(job.Payload, "confirmed") {
+			return agent.JobResult{Status: "failed", Error: "region restore requires explicit confirmation"}, nil
+		}
+		configOverride := getString(job.Payload, "server_config_path", "")
+		if getBool(job.Payload, "undo") {
+			rollback, err := a.UndoRegionRestore(ctx, cfg, configOverride)
+			if err != nil {
+				return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+			}
+			return agent.JobResult{Status: "success", Result: map[string]interface{}{"undone": rollback}}, nil
+		}
+		regions, err := regionSelection(job.Payload)
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		if target := getString(job.Payload, "target", ""); target != "" {
+			if err := a.FetchRemoteSave(ctx, cfg, target, getString(job.Payload, "save_id", "")); err != nil {
+				return agent.JobResult{Status: "failed", Error: fmt.Sprintf("fetch backup from %s: %v", target, err)}, nil
+			}
+		}
+		identity, err := parseSaveIdentity(getString(job.Payload, "private_key", ""))
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		restore, err := a.RestoreSaveRegions(ctx, cfg, configOverride, getString(job.Payload, "save_id", ""), regions, getBool(job.Payload, "include_decorations"), identity)
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error(), Result: map[string]interface{}{"restore": restore}}, nil
+		}
+		return agent.JobResult{Status: "success", Result: map[string]interface{}{"restore": restore}}, nil
+	case "SAVE_REPLICATE":
+		replication, err := a.ReplicateSaves(ctx, cfg, getString(job.Payload, "target", ""), getString(job.Payload, "save_id", ""))
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error(), Result: map[string]interface{}{"replication": replication}}, nil
+		}
+		var failures []string
+		for _, result := range replication {
+			if result.Error != "" {
+				failures = append(failures, result.Target+": "+result.Error)
+			}
+		}
+		if len(failures) > 0 {
+			return agent.JobResult{Status: "failed", Error: strings.Join(failures, "; "), Result: map[string]interface{}{"replication": replication}}, nil
+		}
+		return agent.JobResult{Status: "success", Result: map[string]interface{}{"replication": replication}}, nil
+	case "SAVE_VERIFY":
+		identity, err := parseSaveIdentity(getString(job.Payload, "private_key", ""))
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		verifications, err := a.VerifySaves(ctx, cfg, getString(job.Payload, "save_id", ""), identity)
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		result := map[string]interface{}{"verifications": verifications}
+		failed := 0
+		for _, verification := range verifications {
+			if !verification.OK {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return agent.JobResult{Status: "failed", Error: fmt.Sprintf("%d of %d backups failed verification", failed, len(verifications)), Result: result}, nil
+		}
+		return agent.JobResult{Status: "success", Result: result}, nil
 	case "SAVE_DELETE":
 		if !getBool(job.Payload, "confirmed") {
 			return agent.JobResult{Status: "failed", Error: "save deletion requires explicit confirmation"}, nil
 		}
-		if err := a.DeleteSaveBackup(ctx, getString(job.Payload, "save_id", "")); err != nil {
+		if err := a.DeleteSaveBackup(ctx, cfg, getString(job.Payload, "save_id", "")); err != nil {
 			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
 		}
 		return agent.JobResult{Status: "success", Result: map[string]interface{}{"deleted": getString(job.Payload, "save_id", "")}}, nil
 	case "SAVE_RETENTION":
-		retention := getInt(job.Payload, "retention_count", 10)
-		if retention < 1 || retention > 100 {
-			return agent.JobResult{Status: "failed", Error: "retention count must be between 1 and 100"}, nil
+		policy, err := saveRetention(job.Payload)
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
 		}
-		if err := pruneFullSaveBackups(retention); err != nil {
+		ns, err := a.saveNamespace(cfg)
+		if err != nil {
 			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
 		}
-		return agent.JobResult{Status: "success", Result: map[string]interface{}{"retentionCount": retention}}, nil
+		plan, err := applySaveRetention(ns.root, policy, getBool(job.Payload, "dry_run"))
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error(), Result: map[string]interface{}{"retention": plan}}, nil
+		}
+		return agent.JobResult{Status: "success", Result: map[string]interface{}{"retentionCount": policy.KeepLast, "retention": plan}}, nil
+	case "SAVE_PIN":
+		pinned, ok := job.Payload["pinned"].(bool)
+		if !ok {
+			pinned = true
+		}
+		if err := a.PinSave(cfg, getString(job.Payload, "save_id", ""), pinned); err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		return agent.JobResult{Status: "success", Result: map[string]interface{}{"saveId": getString(job.Payload, "save_id", ""), "pinned": pinned}}, nil
+	case "SAVE_MIGRATE":
+		var ids []string
+		if raw, ok := job.Payload["save_ids"].([]interface{}); ok {
+			for _, value := range raw {
+				if id, ok := value.(string); ok {
+					ids = append(ids, id)
+				}
+			}
+		}
+		migration, err := a.MigrateSaves(ctx, cfg, ids, getBool(job.Payload, "all"), getBool(job.Payload, "dry_run"))
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error(), Result: map[string]interface{}{"migration": migration}}, nil
+		}
+		return agent.JobResult{Status: "success", Result: map[string]interface{}{"migration": migration}}, nil
+	case "SAVE_PERMISSIONS_REPAIR":
+		report, err := a.RepairPermissions(ctx, cfg, job.Payload, getBool(job.Payload, "dry_run"))
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		result := map[string]interface{}{"permissions": report}
+		if len(report.Errors) > 0 {
+			return agent.JobResult{Status: "failed", Error: strings.Join(report.Errors, "; "), Result: result}, nil
+		}
+		return agent.JobResult{Status: "success", Result: result}, nil
 	case "PLAYER_KICK":
 		identifier := playerCommandIdentifier(job.Payload)
 		reason := sanitizeRCONArg(getString(job.Payload, "reason", "Removed by administrator"))
@@ -242,10 +527,42 @@ func (a *Adapter) Execute(ctx context.Context, job agent.Job) (agent.JobResult,
 		if err != nil {
 			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
 		}
-		return agent.JobResult{Status: "success", Result: map[string]interface{}{"mods": mods}}, nil
+		activeSet, drifted := activeModSet(cfg, getString(job.Payload, "mods_path", ""))
+		return agent.JobResult{Status: "success", Result: map[string]interface{}{"mods": mods, "activeModSet": activeSet, "activeModSetDrifted": drifted}}, nil
+	case "MOD_SET_LIST":
+		result, err := listModSets(cfg, getString(job.Payload, "mods_path", ""))
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		return agent.JobResult{Status: "success", Result: result}, nil
+	case "MOD_SET_SAVE":
+		entries, err := modSetEntriesFromPayload(job.Payload)
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		set, err := saveModSet(cfg, getString(job.Payload, "mods_path", ""), strings.TrimSpace(getString(job.Payload, "name", "")), entries, getBool(job.Payload, "from_current"))
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		return agent.JobResult{Status: "success", Result: map[string]interface{}{"modSet": set}}, nil
+	case "MOD_SET_DELETE":
+		name := strings.TrimSpace(getString(job.Payload, "name", ""))
+		if err := deleteModSet(cfg, getString(job.Payload, "mods_path", ""), name); err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		return agent.JobResult{Status: "success", Result: map[string]interface{}{"deleted": name}}, nil
+	case "MOD_SET_APPLY":
+		result, err := a.ApplyModSet(ctx, cfg, getString(job.Payload, "mods_path", ""), strings.TrimSpace(getString(job.Payload, "name", "")))
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		return withPendingChange(agent.JobResult{Status: "success", Result: map[string]interface{}{"modSet": result}}, cfg, pendingChange{
+			Kind: "mod_set", Summary: "mod set " + result.Name, ModsPath: getString(job.Payload, "mods_path", ""), Moves: result.Moves,
+		}), nil
 	case "MOD_UPLOAD_QUARANTINE":
 		folders, err := installUploadedModsToQuarantine(
 			cfg,
+			a.diskReserve(),
 			getString(job.Payload, "mods_path", ""),
 			getString(job.Payload, "archive_path", ""),
 			getString(job.Payload, "originalName", "uploaded-mod.zip"),
@@ -254,6 +571,38 @@ func (a *Adapter) Execute(ctx context.Context, job agent.Job) (agent.JobResult,
 			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
 		}
 		return agent.JobResult{Status: "success", Result: map[string]interface{}{"folders": folders, "count": len(folders), "quarantined": true}}, nil
+	case "MOD_INSTALL":
+		mode := strings.ToLower(getString(job.Payload, "mode", "install"))
+		if mode != "install" && mode != "update" {
+			return agent.JobResult{Status: "failed", Error: "mode must be install or update"}, nil
+		}
+		archivePath := getString(job.Payload, "archive_path", "")
+		mods, err := installModArchive(cfg, getString(job.Payload, "mods_path", ""), archivePath, getString(job.Payload, "originalName", "uploaded-mod.zip"), modInstallOptions{
+			Update:         mode == "update",
+			AllowDowngrade: getBool(job.Payload, "allow_downgrade"),
+			Reserve:        a.diskReserve(),
+		})
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		return withPendingChange(agent.JobResult{Status: "success", Result: map[string]interface{}{
+			"mods":            mods,
+			"mode":            mode,
+			"archiveSha256":   modArchiveHash(archivePath),
+			"restartRequired": a.instanceRunning(ctx, cfg),
+		}}, cfg, pendingChange{Kind: "mod_install", Summary: "mod " + mode + " from " + getString(job.Payload, "originalName", "uploaded-mod.zip"), ModsPath: getString(job.Payload, "mods_path", ""), Installs: mods}), nil
+	case "MOD_CONFLICT_SCAN":
+		report, err := modConflictScan(cfg, getString(job.Payload, "mods_path", ""))
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		return agent.JobResult{Status: "success", Result: map[string]interface{}{"conflictScan": report}}, nil
+	case "MOD_VALIDATE":
+		report, err := validateMods(cfg, getString(job.Payload, "mods_path", ""))
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		return agent.JobResult{Status: "success", Result: map[string]interface{}{"validation": report}}, nil
 	case "MOD_QUARANTINE":
 		folder := getString(job.Payload, "folder", "")
 		if err := quarantineMod(cfg, getString(job.Payload, "mods_path", ""), folder); err != nil {
@@ -272,13 +621,20 @@ func (a *Adapter) Execute(ctx context.Context, job agent.Job) (agent.JobResult,
 		return agent.JobResult{Status: "success", Result: map[string]interface{}{"mods": mods}}, nil
 	case "MOD_RESTORE":
 		folder := getString(job.Payload, "folder", "")
+		if getBool(job.Payload, "preflight") {
+			if report, err := preflightModRestore(cfg, getString(job.Payload, "mods_path", ""), folder); err != nil {
+				return agent.JobResult{Status: "failed", Error: err.Error(), Result: map[string]interface{}{"validation": report}}, nil
+			}
+		}
 		if err := restoreMod(cfg, getString(job.Payload, "mods_path", ""), folder); err != nil {
 			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
 		}
-		return agent.JobResult{Status: "success", Result: map[string]interface{}{"restored": folder}}, nil
+		return withPendingChange(agent.JobResult{Status: "success", Result: map[string]interface{}{"restored": folder}}, cfg, pendingChange{
+			Kind: "mod_restore", Summary: "mod restore " + folder, ModsPath: getString(job.Payload, "mods_path", ""), Folders: []string{folder},
+		}), nil
 	case "MOD_DELETE":
 		folder := getString(job.Payload, "folder", "")
-		if err := deleteModWithPipe(ctx, cfg, getString(job.Payload, "mods_path", ""), folder); err != nil {
+		if err := deleteMod(ctx, cfg, getString(job.Payload, "mods_path", ""), folder); err != nil {
 			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
 		}
 		return agent.JobResult{Status: "success", Result: map[string]interface{}{"deleted": folder}}, nil
@@ -289,15 +645,52 @@ func (a *Adapter) Execute(ctx context.Context, job agent.Job) (agent.JobResult,
 		if err != nil {
 			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
 		}
-		return agent.JobResult{Status: "success", Result: map[string]interface{}{"folder": folder, "path": path, "content": content}}, nil
+		return agent.JobResult{Status: "success", Result: map[string]interface{}{"folder": folder, "path": path, "content": content, "hash": contentHash([]byte(content))}}, nil
 	case "MOD_CONFIG_WRITE":
 		folder := getString(job.Payload, "folder", "")
 		path := getString(job.Payload, "path", "")
 		content := getString(job.Payload, "content", "")
-		if err := writeModConfig(cfg, getString(job.Payload, "mods_path", ""), folder, path, content); err != nil {
+		if getBool(job.Payload, "preflight") {
+			if report, err := preflightModConfigWrite(cfg, getString(job.Payload, "mods_path", ""), folder, path, content); err != nil {
+				return agent.JobResult{Status: "failed", Error: err.Error(), Result: map[string]interface{}{"validation": report}}, nil
+			}
+		}
+		version, err := saveModConfigVersioned(cfg, getString(job.Payload, "mods_path", ""), modConfigWrite{
+			Folder: folder, Path: path, Content: content, Author: getString(job.Payload, "author", ""),
+			ExpectedHash: getString(job.Payload, "expected_hash", ""), Action: "write",
+		})
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		return withPendingChange(agent.JobResult{Status: "success", Result: map[string]interface{}{"folder": folder, "path": path, "saved": true, "version": version, "hash": version.Hash}}, cfg, pendingChange{
+			Kind: "mod_config", Summary: "mod config write " + folder + "/" + path, ModsPath: getString(job.Payload, "mods_path", ""),
+			Folder: folder, Path: path, VersionID: previousModConfigVersionID(cfg, getString(job.Payload, "mods_path", ""), folder, path, version.ID),
+		}), nil
+	case "MOD_CONFIG_HISTORY":
+		history, hash, err := modConfigHistoryFor(cfg, getString(job.Payload, "mods_path", ""), getString(job.Payload, "folder", ""), getString(job.Payload, "path", ""))
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		return agent.JobResult{Status: "success", Result: map[string]interface{}{"history": history, "hash": hash}}, nil
+	case "MOD_CONFIG_DIFF":
+		diff, err := diffModConfig(cfg, getString(job.Payload, "mods_path", ""), getString(job.Payload, "folder", ""), getString(job.Payload, "path", ""),
+			getString(job.Payload, "from", ""), getString(job.Payload, "to", "current"))
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		return agent.JobResult{Status: "success", Output: diff, Result: map[string]interface{}{"diff": diff, "changed": diff != ""}}, nil
+	case "MOD_CONFIG_REVERT":
+		folder := getString(job.Payload, "folder", "")
+		path := getString(job.Payload, "path", "")
+		version, err := revertModConfig(cfg, getString(job.Payload, "mods_path", ""), folder, path,
+			getString(job.Payload, "version_id", ""), getString(job.Payload, "author", ""), getString(job.Payload, "expected_hash", ""))
+		if err != nil {
 			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
 		}
-		return agent.JobResult{Status: "success", Result: map[string]interface{}{"folder": folder, "path": path, "saved": true}}, nil
+		return withPendingChange(agent.JobResult{Status: "success", Result: map[string]interface{}{"folder": folder, "path": path, "version": version, "hash": version.Hash}}, cfg, pendingChange{
+			Kind: "mod_config", Summary: "mod config revert " + folder + "/" + path, ModsPath: getString(job.Payload, "mods_path", ""),
+			Folder: folder, Path: path, VersionID: previousModConfigVersionID(cfg, getString(job.Payload, "mods_path", ""), folder, path, version.ID),
+		}), nil
 	case "PROFILE_LIST":
 		profiles, err := listPlayerProfiles(job.Payload)
 		if err != nil {
@@ -324,7 +717,10 @@ func (a *Adapter) Execute(ctx context.Context, job agent.Job) (agent.JobResult,
 // Keep the base64 job result below Nest's default JSON request limit.
 // Normal 7DTD profiles are only tens of KiB.
 const maxProfileEditorBytes = 64 * 1024
-const profileStagingRoot = "/var/lib/mastermind-agent/profile-staging"
+
+// profileStagingRoot is a variable so tests can point it at a temp dir.
+var profileStagingRoot = "/var/lib/mastermind-agent/profile-staging"
+
 const profileBackupRoot = "/var/lib/mastermind-agent/profile-backups"
 
 type playerProfile struct {
@@ -351,6 +747,9 @@ type persistentPlayerData struct {
 		UserID       string `xml:"userid,attr"`
 		NativeUserID string `xml:"nativeuserid,attr"`
 		PlayerName   string `xml:"playername,attr"`
+		LandClaims   []struct {
+			Pos string `xml:"pos,attr"`
+		} `xml:"lpblock"`
 	} `xml:"player"`
 }
 
@@ -608,6 +1007,14 @@ func applyStagedPlayerProfiles(serverID string) error {
 	if hasQueued && exec.Command("/usr/bin/systemctl", "is-active", "--quiet", "7dtd.service").Run() == nil {
 		return fmt.Errorf("7DTD must be fully stopped before applying staged profiles")
 	}
+	var applied []appliedProfile
+	defer func() {
+		// Journal what was written even when a later profile fails, so a
+		// failed health check after start can restore the originals.
+		if len(applied) > 0 {
+			_ = recordPendingChange(serverID, pendingChange{Kind: "profile", Summary: fmt.Sprintf("%d staged player profile(s)", len(applied)), Profiles: applied})
+		}
+	}()
 	for _, entry := range entries {
 		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
 			continue
@@ -680,6 +1087,11 @@ func applyStagedPlayerProfiles(serverID string) error {
 			_ = os.Remove(temporaryPath)
 			return fmt.Errorf("install replacement profile: %w", err)
 		}
+		profile := appliedProfile{Target: metadata.Target, Backup: filepath.Join(backupDir, originalName)}
+		if backupName != "" {
+			profile.CompanionBackup = filepath.Join(backupDir, backupName)
+		}
+		applied = append(applied, profile)
 		if err := os.Remove(dataPath); err != nil {
 			return err
 		}
@@ -732,8 +1144,8 @@ func quarantineMod(cfg *agent.InstanceConfig, override, folder string) error {
 	if _, err := os.Lstat(destination); !os.IsNotExist(err) {
 		return fmt.Errorf("quarantined mod already exists: %s", folder)
 	}
-	if output, err := exec.Command("/usr/bin/mv", "--", target, destination).CombinedOutput(); err != nil {
-		return fmt.Errorf("quarantine mod: %w: %s", err, strings.TrimSpace(string(output)))
+	if err := moveModTree(target, destination); err != nil {
+		return fmt.Errorf("quarantine mod: %w", err)
 	}
 	return nil
 }
@@ -741,21 +1153,63 @@ func quarantineMod(cfg *agent.InstanceConfig, override, folder string) error {
 const maxModArchiveFiles = 10000
 const maxModArchiveExpandedBytes int64 = 2 * 1024 * 1024 * 1024
 
-func installUploadedModsToQuarantine(cfg *agent.InstanceConfig, override, archivePath, originalName string) ([]string, error) {
+func installUploadedModsToQuarantine(cfg *agent.InstanceConfig, reserve int64, override, archivePath, originalName string) ([]string, error) {
+	quarantineRoot, err := quarantinePath(cfg, override)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(quarantineRoot, 0750); err != nil {
+		return nil, fmt.Errorf("create quarantine directory: %w", err)
+	}
+	// An unreadable ZIP fails validation below with a better message.
+	if expanded, err := zipExpandedSize(archivePath); err == nil {
+		if err := checkDiskSpace("the mod upload", quarantineRoot, expanded, reserve); err != nil {
+			return nil, err
+		}
+	}
+	stagingRoot, folders, err := stageModArchive(archivePath, originalName, quarantineRoot)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(stagingRoot)
+	for _, folder := range folders {
+		if _, err := os.Lstat(filepath.Join(quarantineRoot, folder)); !os.IsNotExist(err) {
+			return nil, fmt.Errorf("quarantined mod already exists: %s", folder)
+		}
+	}
+
+	moved := make([]string, 0, len(folders))
+	for _, folder := range folders {
+		destination := filepath.Join(quarantineRoot, folder)
+		if err := os.Rename(filepath.Join(stagingRoot, folder), destination); err != nil {
+			for _, rollback := range moved {
+				_ = os.RemoveAll(filepath.Join(quarantineRoot, rollback))
+			}
+			return nil, fmt.Errorf("place %s in quarantine: %w", folder, err)
+		}
+		moved = append(moved, folder)
+	}
+	return folders, nil
+}
+
+// stageModArchive validates an uploaded ZIP and extracts every mod it contains
+// into a hidden staging directory under stagingParent, one normalized folder
+// per ModInfo.xml. The caller owns the returned staging directory.
+func stageModArchive(archivePath, originalName, stagingParent string) (string, []string, error) {
 	archiveInfo, err := os.Lstat(archivePath)
 	if err != nil {
-		return nil, fmt.Errorf("open uploaded mod archive: %w", err)
+		return "", nil, fmt.Errorf("open uploaded mod archive: %w", err)
 	}
 	if !archiveInfo.Mode().IsRegular() || archiveInfo.Mode()&os.ModeSymlink != 0 {
-		return nil, fmt.Errorf("uploaded mod archive must be a regular file")
+		return "", nil, fmt.Errorf("uploaded mod archive must be a regular file")
 	}
 	reader, err := zip.OpenReader(archivePath)
 	if err != nil {
-		return nil, fmt.Errorf("read uploaded ZIP: %w", err)
+		return "", nil, fmt.Errorf("read uploaded ZIP: %w", err)
 	}
 	defer reader.Close()
 	if len(reader.File) == 0 || len(reader.File) > maxModArchiveFiles {
-		return nil, fmt.Errorf("ZIP must contain between 1 and %d entries", maxModArchiveFiles)
+		return "", nil, fmt.Errorf("ZIP must contain between 1 and %d entries", maxModArchiveFiles)
 	}
 
 	cleanNames := make(map[*zip.File]string, len(reader.File))
@@ -768,17 +1222,17 @@ func installUploadedModsToQuarantine(cfg *agent.InstanceConfig, override, archiv
 		}
 		clean := pathpkg.Clean(name)
 		if name == "" || strings.Contains(entry.Name, "\\") || pathpkg.IsAbs(name) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") || clean != name || regexp.MustCompile(`^[A-Za-z]:`).MatchString(clean) {
-			return nil, fmt.Errorf("unsafe ZIP entry: %q", entry.Name)
+			return "", nil, fmt.Errorf("unsafe ZIP entry: %q", entry.Name)
 		}
 		if entry.Mode()&os.ModeSymlink != 0 {
-			return nil, fmt.Errorf("ZIP symlinks are not allowed: %q", entry.Name)
+			return "", nil, fmt.Errorf("ZIP symlinks are not allowed: %q", entry.Name)
 		}
 		if entry.UncompressedSize64 > uint64(maxModArchiveExpandedBytes) {
-			return nil, fmt.Errorf("ZIP entry is too large: %q", entry.Name)
+			return "", nil, fmt.Errorf("ZIP entry is too large: %q", entry.Name)
 		}
 		expandedBytes += int64(entry.UncompressedSize64)
 		if expandedBytes > maxModArchiveExpandedBytes {
-			return nil, fmt.Errorf("expanded ZIP exceeds 2 GiB limit")
+			return "", nil, fmt.Errorf("expanded ZIP exceeds 2 GiB limit")
 		}
 		cleanNames[entry] = clean
 		if !entry.FileInfo().IsDir() && strings.EqualFold(pathpkg.Base(clean), "ModInfo.xml") {
@@ -786,7 +1240,7 @@ func installUploadedModsToQuarantine(cfg *agent.InstanceConfig, override, archiv
 		}
 	}
 	if len(rootSet) == 0 {
-		return nil, fmt.Errorf("ZIP does not contain a ModInfo.xml")
+		return "", nil, fmt.Errorf("ZIP does not contain a ModInfo.xml")
 	}
 	roots := make([]string, 0, len(rootSet))
 	for root := range rootSet {
@@ -796,23 +1250,21 @@ func installUploadedModsToQuarantine(cfg *agent.InstanceConfig, override, archiv
 	for i, root := range roots {
 		for _, other := range roots[i+1:] {
 			if root == "." || strings.HasPrefix(other, root+"/") {
-				return nil, fmt.Errorf("ambiguous ZIP: nested ModInfo.xml files at %q and %q", root, other)
+				return "", nil, fmt.Errorf("ambiguous ZIP: nested ModInfo.xml files at %q and %q", root, other)
 			}
 		}
 	}
 
-	quarantineRoot, err := quarantinePath(cfg, override)
+	stagingRoot, err := os.MkdirTemp(stagingParent, ".upload-*")
 	if err != nil {
-		return nil, err
+		return "", nil, fmt.Errorf("create mod staging directory: %w", err)
 	}
-	if err := os.MkdirAll(quarantineRoot, 0750); err != nil {
-		return nil, fmt.Errorf("create quarantine directory: %w", err)
-	}
-	stagingRoot, err := os.MkdirTemp(quarantineRoot, ".upload-*")
-	if err != nil {
-		return nil, fmt.Errorf("create quarantine staging directory: %w", err)
-	}
-	defer os.RemoveAll(stagingRoot)
+	staged := false
+	defer func() {
+		if !staged {
+			_ = os.RemoveAll(stagingRoot)
+		}
+	}()
 
 	archiveFolder := strings.TrimSuffix(filepath.Base(originalName), filepath.Ext(originalName))
 	folderForRoot := map[string]string{}
@@ -828,18 +1280,15 @@ func installUploadedModsToQuarantine(cfg *agent.InstanceConfig, override, archiv
 			folder = folder[:100]
 		}
 		if err := validateModFolder(folder); err != nil || folder == "" {
-			return nil, fmt.Errorf("could not derive a safe mod folder for %q", root)
+			return "", nil, fmt.Errorf("could not derive a safe mod folder for %q", root)
 		}
 		if usedFolders[strings.ToLower(folder)] {
-			return nil, fmt.Errorf("multiple mods resolve to the same folder: %s", folder)
+			return "", nil, fmt.Errorf("multiple mods resolve to the same folder: %s", folder)
 		}
 		usedFolders[strings.ToLower(folder)] = true
 		folderForRoot[root] = folder
-		if _, err := os.Lstat(filepath.Join(quarantineRoot, folder)); !os.IsNotExist(err) {
-			return nil, fmt.Errorf("quarantined mod already exists: %s", folder)
-		}
 		if err := os.MkdirAll(filepath.Join(stagingRoot, folder), 0750); err != nil {
-			return nil, err
+			return "", nil, err
 		}
 	}
 
@@ -863,63 +1312,63 @@ func installUploadedModsToQuarantine(cfg *agent.InstanceConfig, override, archiv
 			targetRoot := filepath.Join(stagingRoot, folderForRoot[root])
 			target := filepath.Join(targetRoot, filepath.FromSlash(relative))
 			if target != targetRoot && !strings.HasPrefix(target, targetRoot+string(filepath.Separator)) {
-				return nil, fmt.Errorf("unsafe extracted path: %q", entry.Name)
+				return "", nil, fmt.Errorf("unsafe extracted path: %q", entry.Name)
 			}
 			if writtenPaths[target] {
-				return nil, fmt.Errorf("duplicate ZIP output path: %q", relative)
+				return "", nil, fmt.Errorf("duplicate ZIP output path: %q", relative)
 			}
 			writtenPaths[target] = true
 			if entry.FileInfo().IsDir() {
 				if err := os.MkdirAll(target, 0750); err != nil {
-					return nil, err
+					return "", nil, err
 				}
 				break
 			}
 			if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
-				return nil, err
+				return "", nil, err
 			}
 			source, err := entry.Open()
 			if err != nil {
-				return nil, fmt.Errorf("open ZIP entry %q: %w", entry.Name, err)
+				return "", nil, fmt.Errorf("open ZIP entry %q: %w", entry.Name, err)
 			}
 			destination, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
 			if err != nil {
 				_ = source.Close()
-				return nil, fmt.Errorf("create extracted file %q: %w", relative, err)
+				return "", nil, fmt.Errorf("create extracted file %q: %w", relative, err)
 			}
 			copied, copyErr := io.Copy(destination, io.LimitReader(source, int64(entry.UncompressedSize64)+1))
 			closeErr := destination.Close()
 			sourceErr := source.Close()
 			if copyErr != nil || closeErr != nil || sourceErr != nil || copied != int64(entry.UncompressedSize64) {
-				return nil, fmt.Errorf("extract ZIP entry %q: archive data is incomplete or invalid", entry.Name)
+				return "", nil, fmt.Errorf("extract ZIP entry %q: archive data is incomplete or invalid", entry.Name)
 			}
 			break
 		}
 	}
 
 	folders := make([]string, 0, len(roots))
-	moved := make([]string, 0, len(roots))
 	for _, root := range roots {
 		folder := folderForRoot[root]
 		stagedMod := filepath.Join(stagingRoot, folder)
 		if _, err := os.Stat(filepath.Join(stagedMod, "ModInfo.xml")); err != nil {
-			return nil, fmt.Errorf("normalized mod %s is missing ModInfo.xml", folder)
+			return "", nil, fmt.Errorf("normalized mod %s is missing ModInfo.xml", folder)
 		}
 		if err := normalizeModPermissions(stagedMod); err != nil {
-			return nil, fmt.Errorf("normalize uploaded mod %s: %w", folder, err)
+			return "", nil, fmt.Errorf("normalize uploaded mod %s: %w", folder, err)
 		}
-		destination := filepath.Join(quarantineRoot, folder)
-		if err := os.Rename(stagedMod, destination); err != nil {
-			for _, rollback := range moved {
-				_ = os.RemoveAll(filepath.Join(quarantineRoot, rollback))
-			}
-			return nil, fmt.Errorf("place %s in quarantine: %w", folder, err)
-		}
-		moved = append(moved, folder)
 		folders = append(folders, folder)
 	}
 	sort.Strings(folders)
-	return folders, nil
+	staged = true
+	return stagingRoot, folders, nil
+}
+
+// modServerKey names per-server agent state for the mods under root.
+func modServerKey(cfg *agent.InstanceConfig, root string) string {
+	if cfg.ServerInstanceID != "" {
+		return cfg.ServerInstanceID
+	}
+	return filepath.Base(filepath.Dir(root))
 }
 
 func quarantinePath(cfg *agent.InstanceConfig, override string) (string, error) {
@@ -927,11 +1376,7 @@ func quarantinePath(cfg *agent.InstanceConfig, override string) (string, error)
 	if err != nil {
 		return "", err
 	}
-	serverKey := cfg.ServerInstanceID
-	if serverKey == "" {
-		serverKey = filepath.Base(filepath.Dir(root))
-	}
-	return filepath.Join("/var/lib/mastermind-agent/mod-quarantine", serverKey), nil
+	return filepath.Join(modQuarantineRoot, modServerKey(cfg, root)), nil
 }
 
 func restoreMod(cfg *agent.InstanceConfig, override, folder string) error {
@@ -951,8 +1396,8 @@ func restoreMod(cfg *agent.InstanceConfig, override, folder string) error {
 	if _, err := os.Lstat(destination); !os.IsNotExist(err) {
 		return fmt.Errorf("active mod folder already exists: %s", folder)
 	}
-	if output, err := exec.Command("/usr/bin/mv", "--", source, destination).CombinedOutput(); err != nil {
-		return fmt.Errorf("restore mod: %w: %s", err, strings.TrimSpace(string(output)))
+	if err := moveModTree(source, destination); err != nil {
+		return fmt.Errorf("restore mod: %w", err)
 	}
 	if err := normalizeModPermissions(destination); err != nil {
 		return fmt.Errorf("make restored mod readable by game server: %w", err)
@@ -1000,19 +1445,9 @@ type serverAdmin struct {
 }
 
 func listServerAdmins(payload map[string]interface{}) ([]serverAdmin, error) {
-	config, _ := payload["config"].(map[string]interface{})
-	discovery, _ := config["discovery"].(map[string]interface{})
-	path, _ := discovery["serverAdminPath"].(string)
-	path = filepath.Clean(strings.TrimSpace(path))
-	if path == "." || !filepath.IsAbs(path) || !strings.EqualFold(filepath.Base(path), "serveradmin.xml") {
-		return nil, fmt.Errorf("configured serveradmin.xml path required")
-	}
-	info, err := os.Lstat(path)
+	path, err := configuredServerAdminPath(payload)
 	if err != nil {
-		return nil, fmt.Errorf("read serveradmin.xml: %w", err)
-	}
-	if !info.Mode().IsRegular() || info.Mode()&os.ModeSymlink != 0 {
-		return nil, fmt.Errorf("serveradmin.xml must be a regular file")
+		return nil, err
 	}
 	file, err := os.Open(path)
 	if err != nil {
@@ -1284,7 +1719,7 @@ func readModInfo(path string) (map[string]string, error) {
 	return values, nil
 }
 
-func deleteModWithPipe(ctx context.Context, cfg *agent.InstanceConfig, override, folder string) error {
+func deleteMod(ctx context.Context, cfg *agent.InstanceConfig, override, folder string) error {
 	root, err := modsPath(cfg, override)
 	if err != nil {
 		return err
@@ -1293,11 +1728,10 @@ func deleteModWithPipe(ctx context.Context, cfg *agent.InstanceConfig, override,
 	if err != nil {
 		return err
 	}
-	// Positional shell arguments prevent command injection while retaining the requested Linux pipe.
-	const script = `find "$1" -mindepth 1 -maxdepth 1 -type d -name "$2" -print0 | xargs -0 -r rm -rf --`
-	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", script, "mod-delete", root, folder)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("delete mod: %w: %s", err, strings.TrimSpace(string(output)))
+	// Mods contain files written by the game account, so the helper deletes
+	// them; it only accepts paths inside its allowlisted Mods root.
+	if err := privileged.Delete(ctx, target); err != nil {
+		return fmt.Errorf("delete mod: %w", err)
 	}
 	if _, err := os.Lstat(target); !os.IsNotExist(err) {
 		return fmt.Errorf("mod folder still exists after delete")
@@ -1325,6 +1759,7 @@ func jobPayloadToConfig(p map[string]interface{}) *agent.InstanceConfig {
 		TelnetPort:            getInt(p, "telnet_port", 8081),
 		TelnetPassword:        getString(p, "telnet_password", ""),
 		AvoidBloodMoonRestart: getBool(p, "avoid_blood_moon_restart"),
+		Container:             containerName(p),
 	}
 	return cfg
 }
@@ -1360,20 +1795,40 @@ type serverConfiguration struct {
 	Properties []serverProperty `xml:"property"`
 }
 
-const saveBackupRoot = "/opt/regionhealer/RegionAutoFix/Saves"
+// saveBackupRoot is a variable so tests can point it at a temp dir.
+var saveBackupRoot = "/opt/regionhealer/RegionAutoFix/Saves"
 
 type SaveRecord struct {
 	ID        string    `json:"id"`
 	CreatedAt time.Time `json:"createdAt"`
 	GameDay   int       `json:"gameDay"`
 	Kind      string    `json:"kind"`
-	SizeBytes int64     `json:"sizeBytes"`
+	// SizeBytes is the logical size of the saved world; AddedBytes is what
+	// the backup added to disk. They differ for manifest backups, which
+	// share unchanged files with earlier backups.
+	SizeBytes  int64 `json:"sizeBytes"`
+	AddedBytes int64 `json:"addedBytes"`
+	// Encrypted backups name the fingerprint of the public key they were
+	// encrypted to; restoring them needs the matching private key.
+	Encrypted      bool   `json:"encrypted"`
+	KeyFingerprint string `json:"keyFingerprint,omitempty"`
+	// Pinned backups are never deleted by retention or SAVE_DELETE.
+	Pinned bool `json:"pinned"`
+	// CopyMode tells how the world was copied: reflink, copy, mixed,
+	// unchanged or archive. SnapshotMs is how long that took.
+	CopyMode   string `json:"copyMode,omitempty"`
+	SnapshotMs int64  `json:"snapshotMs,omitempty"`
 }
 
 type saveMetadata struct {
-	CreatedAt time.Time `json:"createdAt"`
-	GameDay   int       `json:"gameDay"`
-	Kind      string    `json:"kind"`
+	CreatedAt  time.Time `json:"createdAt"`
+	GameDay    int       `json:"gameDay"`
+	Kind       string    `json:"kind"`
+	Format     string    `json:"format,omitempty"`
+	AddedBytes int64     `json:"addedBytes,omitempty"`
+	Pinned     bool      `json:"pinned,omitempty"`
+	CopyMode   string    `json:"copyMode,omitempty"`
+	SnapshotMs int64     `json:"snapshotMs,omitempty"`
 }
 
 func resolveLiveSave(cfg *agent.InstanceConfig, configOverride string) (string, error) {
@@ -1418,13 +1873,6 @@ func validSaveID(id string) bool {
 	return matched
 }
 
-func saveBackupPath(id string) (string, error) {
-	if !validSaveID(id) {
-		return "", fmt.Errorf("invalid save ID")
-	}
-	return filepath.Join(saveBackupRoot, id), nil
-}
-
 func directorySize(root string) int64 {
 	var total int64
 	_ = filepath.Walk(root, func(_ string, info os.FileInfo, err error) error {
@@ -1445,7 +1893,7 @@ func copySaveTree(source, destination string, skipMetadata bool) error {
 		if err != nil {
 			return err
 		}
-		if skipMetadata && rel == ".mastermind-save.json" {
+		if skipMetadata && rel == saveMetadataName {
 			return nil
 		}
 		target := filepath.Join(destination, rel)
@@ -1459,6 +1907,8 @@ func copySaveTree(source, destination string, skipMetadata bool) error {
 	})
 }
 
+// copySaveFile copies source to destination, as a reflink clone where the
+// filesystem supports it and byte for byte otherwise.
 func copySaveFile(source, destination string, mode os.FileMode) error {
 	in, err := os.Open(source)
 	if err != nil {
@@ -1469,7 +1919,10 @@ func copySaveFile(source, destination string, mode os.FileMode) error {
 		_ = in.Close()
 		return err
 	}
-	_, copyErr := io.Copy(out, in)
+	var copyErr error
+	if cloneFile(out, in) != nil {
+		_, copyErr = io.Copy(out, in)
+	}
 	inErr := in.Close()
 	outErr := out.Close()
 	if copyErr != nil {
@@ -1485,34 +1938,52 @@ func (a *Adapter) ListSaves(cfg *agent.InstanceConfig, configOverride string) ([
 	if _, err := resolveLiveSave(cfg, configOverride); err != nil {
 		return nil, err
 	}
-	entries, err := os.ReadDir(saveBackupRoot)
+	ns, err := a.saveNamespace(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("read save backups: %w", err)
+		return nil, err
 	}
-	saves := make([]SaveRecord, 0, len(entries))
-	for _, entry := range entries {
-		if !entry.IsDir() || !validSaveID(entry.Name()) {
-			continue
-		}
-		path := filepath.Join(saveBackupRoot, entry.Name())
-		info, err := entry.Info()
+	dirs, err := ns.backupDirs()
+	if err != nil {
+		return nil, err
+	}
+	saves := make([]SaveRecord, 0, len(dirs))
+	for _, path := range dirs {
+		info, err := os.Lstat(path)
 		if err != nil {
 			continue
 		}
-		record := SaveRecord{ID: entry.Name(), CreatedAt: info.ModTime().UTC(), Kind: "region-healer", SizeBytes: directorySize(path)}
-		if data, err := os.ReadFile(filepath.Join(path, ".mastermind-save.json")); err == nil {
+		record := SaveRecord{ID: filepath.Base(path), CreatedAt: info.ModTime().UTC(), Kind: "region-healer", SizeBytes: directorySize(path)}
+		record.AddedBytes = record.SizeBytes
+		if data, err := os.ReadFile(filepath.Join(path, saveMetadataName)); err == nil {
 			var metadata saveMetadata
 			if json.Unmarshal(data, &metadata) == nil {
-				record.CreatedAt, record.GameDay, record.Kind = metadata.CreatedAt, metadata.GameDay, metadata.Kind
+				record.CreatedAt, record.GameDay, record.Kind, record.Pinned = metadata.CreatedAt, metadata.GameDay, metadata.Kind, metadata.Pinned
+				record.CopyMode, record.SnapshotMs = metadata.CopyMode, metadata.SnapshotMs
+				if metadata.Format != "" {
+					record.AddedBytes = metadata.AddedBytes
+				}
 			}
 		}
+		if manifest, err := readSaveManifest(path); err == nil {
+			record.SizeBytes = manifest.logicalSize()
+			record.setEncryption(manifest)
+		}
 		saves = append(saves, record)
 	}
 	sort.Slice(saves, func(i, j int) bool { return saves[i].CreatedAt.After(saves[j].CreatedAt) })
 	return saves, nil
 }
 
-func (a *Adapter) BackupSave(ctx context.Context, cfg *agent.InstanceConfig, configOverride string, retention int) (SaveRecord, error) {
+func (r *SaveRecord) setEncryption(manifest *saveManifest) {
+	if manifest.Encryption != nil {
+		r.Encrypted, r.KeyFingerprint = true, manifest.Encryption.Fingerprint
+	}
+}
+
+// BackupSave writes a full-world backup in format. With a BackupRecipient
+// configured it is always an encrypted archive, tar.gz unless format names
+// another archive format.
+func (a *Adapter) BackupSave(ctx context.Context, cfg *agent.InstanceConfig, configOverride string, policy retentionPolicy, format string) (SaveRecord, error) {
 	live, err := resolveLiveSave(cfg, configOverride)
 	if err != nil {
 		return SaveRecord{}, err
@@ -1520,8 +1991,18 @@ func (a *Adapter) BackupSave(ctx context.Context, cfg *agent.InstanceConfig, con
 	if info, err := os.Stat(live); err != nil || !info.IsDir() {
 		return SaveRecord{}, fmt.Errorf("live save is unavailable")
 	}
+	ns, err := a.saveNamespace(cfg)
+	if err != nil {
+		return SaveRecord{}, err
+	}
+	if a.BackupRecipient != nil && format != saveFormatTarZstd {
+		format = saveFormatTarGzip
+	}
+	if err := a.backupSpacePreflight(ctx, live, ns.root, policy, format); err != nil {
+		return SaveRecord{}, err
+	}
 	gameDay := 0
-	if serviceActive(ctx, "7dtd.service") {
+	if a.instanceRunning(ctx, cfg) {
 		if _, err := a.SendCommand(ctx, cfg, "saveworld"); err != nil {
 			return SaveRecord{}, fmt.Errorf("flush world before backup: %w", err)
 		}
@@ -1534,72 +2015,63 @@ func (a *Adapter) BackupSave(ctx context.Context, cfg *agent.InstanceConfig, con
 	}
 	created := time.Now().UTC()
 	id := "mastermind_" + created.Format("2006-01-02_15-04-05")
-	destination, _ := saveBackupPath(id)
-	if err := os.MkdirAll(saveBackupRoot, 0750); err != nil {
+	destination, _ := ns.backupPath(id)
+	if err := os.MkdirAll(ns.root, 0750); err != nil {
 		return SaveRecord{}, fmt.Errorf("create backup root: %w", err)
 	}
 	if _, err := os.Lstat(destination); !os.IsNotExist(err) {
 		return SaveRecord{}, fmt.Errorf("backup ID already exists; try again in one second")
 	}
-	if err := copySaveTree(live, destination, false); err != nil {
+	var manifest *saveManifest
+	var stats saveCopyStats
+	started := time.Now()
+	copyMode := copyModeArchive
+	if format == saveFormatTarGzip || format == saveFormatTarZstd {
+		manifest, stats.AddedBytes, err = writeSaveArchive(ctx, live, destination, format, a.BackupRecipient)
+	} else {
+		format = saveFormatManifest
+		manifest, stats, err = storeSaveTree(ctx, live, destination)
+		copyMode = stats.mode()
+	}
+	if err != nil {
 		_ = os.RemoveAll(destination)
-		return SaveRecord{}, fmt.Errorf("copy world save: %w", err)
+		return SaveRecord{}, fmt.Errorf("store world save: %w", err)
 	}
-	metadata := saveMetadata{CreatedAt: created, GameDay: gameDay, Kind: "full-world"}
+	snapshotMs := time.Since(started).Milliseconds()
+	agent.ReportProgress(ctx, "backup", fmt.Sprintf("Snapshot %s took %d ms (%s)", id, snapshotMs, copyMode))
+	added := stats.AddedBytes
+	metadata := saveMetadata{CreatedAt: created, GameDay: gameDay, Kind: "full-world", Format: format, AddedBytes: added, CopyMode: copyMode, SnapshotMs: snapshotMs}
 	data, _ := json.MarshalIndent(metadata, "", "  ")
-	if err := os.WriteFile(filepath.Join(destination, ".mastermind-save.json"), data, 0640); err != nil {
+	if err := os.WriteFile(filepath.Join(destination, saveMetadataName), data, 0640); err != nil {
 		_ = os.RemoveAll(destination)
 		return SaveRecord{}, fmt.Errorf("write backup metadata: %w", err)
 	}
-	if retention < 1 {
-		retention = 1
-	}
-	if retention > 100 {
-		retention = 100
-	}
-	record := SaveRecord{ID: id, CreatedAt: created, GameDay: gameDay, Kind: metadata.Kind, SizeBytes: directorySize(destination)}
+	record := SaveRecord{ID: id, CreatedAt: created, GameDay: gameDay, Kind: metadata.Kind, SizeBytes: manifest.logicalSize(), AddedBytes: added, CopyMode: copyMode, SnapshotMs: snapshotMs}
+	record.setEncryption(manifest)
 	// Retention is housekeeping, not part of creating the backup. Older
 	// RegionHealer snapshots may be owned by another service account. Failing a
 	// safe restart after saveworld and a successful backup leaves players seeing
 	// the full countdown while the server never restarts. Keep the valid backup
 	// and let an explicit policy-cleanup job report any ownership problem.
-	_ = pruneFullSaveBackups(retention)
+	_, _ = applySaveRetention(ns.root, policy, false)
 	return record, nil
 }
 
-func pruneFullSaveBackups(retention int) error {
-	entries, err := os.ReadDir(saveBackupRoot)
-	if err != nil {
-		return err
-	}
-	ids := make([]string, 0)
-	for _, entry := range entries {
-		if entry.IsDir() && strings.HasPrefix(entry.Name(), "mastermind_") && validSaveID(entry.Name()) {
-			ids = append(ids, entry.Name())
-		}
-	}
-	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
-	if len(ids) <= retention {
-		return nil
-	}
-	for _, id := range ids[retention:] {
-		path, _ := saveBackupPath(id)
-		if err := os.RemoveAll(path); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (a *Adapter) RestoreSave(ctx context.Context, cfg *agent.InstanceConfig, configOverride, id string) (SaveRecord, error) {
-	if serviceActive(ctx, "7dtd.service") {
+// RestoreSave replaces the live world with backup id. identity is the
+// private key for encrypted backups and may be nil otherwise.
+func (a *Adapter) RestoreSave(ctx context.Context, cfg *agent.InstanceConfig, configOverride, id string, identity *saveIdentity, force bool) (SaveRecord, error) {
+	if a.instanceRunning(ctx, cfg) {
 		return SaveRecord{}, fmt.Errorf("server must be stopped before restoring a save")
 	}
 	live, err := resolveLiveSave(cfg, configOverride)
 	if err != nil {
 		return SaveRecord{}, err
 	}
-	source, err := saveBackupPath(id)
+	ns, err := a.saveNamespace(cfg)
+	if err != nil {
+		return SaveRecord{}, err
+	}
+	source, err := ns.backupPath(id)
 	if err != nil {
 		return SaveRecord{}, err
 	}
@@ -1627,14 +2099,31 @@ func (a *Adapter) RestoreSave(ctx context.Context, cfg *agent.InstanceConfig, co
 		return SaveRecord{}, fmt.Errorf("save backup not found")
 	}
 	fullWorld := selected.Kind == "full-world"
+	if selected.Encrypted && identity == nil {
+		return SaveRecord{}, fmt.Errorf("backup is encrypted to key %s; supply private_key", selected.KeyFingerprint)
+	}
+	if fullWorld && !force {
+		agent.ReportProgress(ctx, "verify", "Verifying backup "+id)
+		if verification := verifySaveBackup(ctx, source, identity); !verification.OK {
+			return SaveRecord{}, fmt.Errorf("backup failed verification (%d missing, %d corrupt%s); restore with force to override",
+				len(verification.Missing), len(verification.Corrupt), verificationDetail(verification))
+		}
+	}
 	target := live
 	copySource := source
+	needed := selected.SizeBytes
 	if !fullWorld {
 		target = filepath.Join(live, "Region")
 		copySource = filepath.Join(source, "Region")
 		if info, err := os.Stat(copySource); err != nil || !info.IsDir() {
 			return SaveRecord{}, fmt.Errorf("Region Healer snapshot has no Region directory")
 		}
+		needed = directorySize(copySource)
+	}
+	// The current save stays beside the restored one until the restore
+	// succeeds, so the whole backup must fit.
+	if err := checkDiskSpace("the restore", filepath.Dir(target), needed, a.diskReserve()); err != nil {
+		return SaveRecord{}, err
 	}
 	old := target + ".mastermind-restore-old"
 	configPath := configOverride
@@ -1642,7 +2131,7 @@ func (a *Adapter) RestoreSave(ctx context.Context, cfg *agent.InstanceConfig, co
 		configPath = filepath.Join(filepath.Dir(cfg.InstallPath), "serverconfig.xml")
 	}
 	if _, err := os.Lstat(old); err == nil {
-		if err := removeRestoreRollback(ctx, configPath, old); err != nil {
+		if err := removeRestoreRollback(ctx, old); err != nil {
 			return SaveRecord{}, fmt.Errorf("clean stale restore rollback: %w", err)
 		}
 	} else if !os.IsNotExist(err) {
@@ -1653,7 +2142,11 @@ func (a *Adapter) RestoreSave(ctx context.Context, cfg *agent.InstanceConfig, co
 			return SaveRecord{}, fmt.Errorf("stage current save: %w", err)
 		}
 	}
-	if err := copySaveTree(copySource, target, fullWorld); err != nil {
+	restore := func() error { return copySaveTree(copySource, target, fullWorld) }
+	if fullWorld && hasSaveManifest(source) {
+		restore = func() error { return restoreSaveBackup(ctx, source, target, identity, force) }
+	}
+	if err := restore(); err != nil {
 		_ = os.RemoveAll(target)
 		_ = os.Rename(old, target)
 		return SaveRecord{}, fmt.Errorf("restore save: %w", err)
@@ -1664,23 +2157,21 @@ func (a *Adapter) RestoreSave(ctx context.Context, cfg *agent.InstanceConfig, co
 		return SaveRecord{}, fmt.Errorf("set restored save permissions: %w", err)
 	}
 	if fullWorld {
-		output, err := exec.CommandContext(ctx, "/usr/bin/sudo", "-n", "/usr/local/sbin/mastermind-fix-7dtd-save-permissions", configPath, target).CombinedOutput()
-		if err != nil {
+		if err := privileged.Chown(ctx, target); err != nil {
 			_ = os.RemoveAll(target)
 			_ = os.Rename(old, target)
-			return SaveRecord{}, fmt.Errorf("assign restored save to game account: %w: %s", err, strings.TrimSpace(string(output)))
+			return SaveRecord{}, fmt.Errorf("assign restored save to game account: %w", err)
 		}
 	}
-	if err := removeRestoreRollback(ctx, configPath, old); err != nil {
+	if err := removeRestoreRollback(ctx, old); err != nil {
 		return SaveRecord{}, fmt.Errorf("remove restore rollback after successful copy: %w", err)
 	}
 	return selected, nil
 }
 
-func removeRestoreRollback(ctx context.Context, configPath, old string) error {
-	output, err := exec.CommandContext(ctx, "/usr/bin/sudo", "-n", "/usr/local/sbin/mastermind-wipe-7dtd-save", configPath, old).CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("delete validated rollback directory: %w: %s", err, strings.TrimSpace(string(output)))
+func removeRestoreRollback(ctx context.Context, old string) error {
+	if err := privileged.Delete(ctx, old); err != nil {
+		return fmt.Errorf("delete validated rollback directory: %w", err)
 	}
 	if _, err := os.Lstat(old); !os.IsNotExist(err) {
 		return fmt.Errorf("rollback directory still exists after deletion")
@@ -1703,8 +2194,12 @@ func makeTreeGroupWritable(root string) error {
 	})
 }
 
-func (a *Adapter) DeleteSaveBackup(ctx context.Context, id string) error {
-	path, err := saveBackupPath(id)
+func (a *Adapter) DeleteSaveBackup(ctx context.Context, cfg *agent.InstanceConfig, id string) error {
+	ns, err := a.saveNamespace(cfg)
+	if err != nil {
+		return err
+	}
+	path, err := ns.backupPath(id)
 	if err != nil {
 		return err
 	}
@@ -1712,6 +2207,9 @@ func (a *Adapter) DeleteSaveBackup(ctx context.Context, id string) error {
 	if err != nil || !info.IsDir() || info.Mode()&os.ModeSymlink != 0 {
 		return fmt.Errorf("save backup not found")
 	}
+	if metadata, err := readSaveMetadata(path); err == nil && metadata.Pinned {
+		return fmt.Errorf("save backup is pinned; unpin it before deleting")
+	}
 	healerWasActive := serviceActive(ctx, "regionhealer.service")
 	gameWasActive := serviceActive(ctx, "7dtd.service")
 	if healerWasActive {
@@ -1725,10 +2223,14 @@ func (a *Adapter) DeleteSaveBackup(ctx context.Context, id string) error {
 	if err := os.RemoveAll(path); err != nil {
 		return fmt.Errorf("delete save backup: %w", err)
 	}
+	if err := collectSaveBlobs(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("release unreferenced backup data: %w", err)
+	}
 	return nil
 }
 
-func serviceActive(ctx context.Context, service string) bool {
+// serviceActive is a variable so tests can simulate a running server.
+var serviceActive = func(ctx context.Context, service string) bool {
 	return exec.CommandContext(ctx, "/usr/bin/systemctl", "is-active", "--quiet", service).Run() == nil
 }
 
@@ -1791,7 +2293,7 @@ func (a *Adapter) WipeSave(ctx context.Context, cfg *agent.InstanceConfig, confi
 			stopErr = waitFor7DTDState(ctx, false, 5*time.Second)
 		}
 		if stopErr != nil || serviceHasMainPID(ctx) {
-			if killErr := a.Kill(ctx); killErr != nil {
+			if killErr := a.Kill(ctx, cfg); killErr != nil {
 				return "", fmt.Errorf("safe shutdown failed (%v); forced kill also failed: %w", stopErr, killErr)
 			}
 		}
@@ -1806,8 +2308,8 @@ func (a *Adapter) WipeSave(ctx context.Context, cfg *agent.InstanceConfig, confi
 		}
 	}()
 	if targetErr == nil {
-		if output, err := exec.CommandContext(ctx, "/usr/bin/sudo", "-n", "/usr/local/sbin/mastermind-wipe-7dtd-save", configPath, target).CombinedOutput(); err != nil {
-			return "", fmt.Errorf("delete save: %w: %s", err, strings.TrimSpace(string(output)))
+		if err := privileged.Delete(ctx, target); err != nil {
+			return "", fmt.Errorf("delete save: %w", err)
 		}
 	}
 	if _, err := os.Lstat(target); !os.IsNotExist(err) {
@@ -1842,26 +2344,27 @@ func systemctl7DTD(ctx context.Context, action string) error {
 	return systemctlService(ctx, action, "7dtd.service")
 }
 
-// Kill immediately terminates every process in the 7DTD systemd unit. This is
+// Kill immediately terminates the server container, the agent-supervised
+// server process group, or every process in the 7DTD systemd unit. This is
 // intentionally not graceful and must only be exposed behind a destructive UI.
-func (a *Adapter) Kill(ctx context.Context) error {
+func (a *Adapter) Kill(ctx context.Context, cfg *agent.InstanceConfig) error {
+	if cfg.Container != "" {
+		return containers.Kill(ctx, cfg.Container, "SIGKILL")
+	}
+	if proc := processes.Process(cfg.ServerInstanceID); !isSystemdManaged7DTD(cfg) {
+		if _, running := proc.Running(); running {
+			return proc.Kill(ctx)
+		}
+	}
 	if !serviceHasMainPID(ctx) {
 		// Emergency stop controls should be idempotent. A repeated click after a
 		// successful kill still satisfies the requested final state.
 		return nil
 	}
-	output, err := exec.CommandContext(ctx, "/usr/bin/sudo", "-n", "/usr/bin/systemctl", "kill", "--kill-who=main", "--signal=SIGKILL", "7dtd.service").CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("kill 7DTD process: %w: %s", err, strings.TrimSpace(string(output)))
-	}
-	// A service configured with Restart=on-failure will otherwise immediately
-	// respawn after SIGKILL. Stop the now-dead unit to suppress that restart.
-	if err := systemctl7DTD(ctx, "stop"); err != nil {
-		return fmt.Errorf("prevent 7DTD restart after kill: %w", err)
-	}
-	output, err = exec.CommandContext(ctx, "/usr/bin/sudo", "-n", "/usr/bin/systemctl", "reset-failed", "7dtd.service").CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("clear killed 7DTD service state: %w: %s", err, strings.TrimSpace(string(output)))
+	// The helper stops the unit after SIGKILL and clears its failed state;
+	// a service configured with Restart=on-failure would otherwise respawn.
+	if err := privileged.Unit(ctx, privhelper.ActionKill, "7dtd.service"); err != nil {
+		return fmt.Errorf("kill 7DTD process: %w", err)
 	}
 	if err := waitFor7DTDState(ctx, false, 15*time.Second); err != nil {
 		return fmt.Errorf("7DTD process remained active after kill: %w", err)
@@ -1885,11 +2388,7 @@ func systemctlService(ctx context.Context, action, service string) error {
 	if service != "7dtd.service" && service != "regionhealer.service" {
 		return fmt.Errorf("unsupported systemctl service")
 	}
-	output, err := exec.CommandContext(ctx, "/usr/bin/sudo", "-n", "/usr/bin/systemctl", action, service).CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("systemctl %s: %w: %s", action, err, strings.TrimSpace(string(output)))
-	}
-	return nil
+	return privileged.Unit(ctx, action, service)
 }
 
 func waitFor7DTDState(ctx context.Context, active bool, timeout time.Duration) error {
@@ -1914,6 +2413,12 @@ func (a *Adapter) Start(ctx context.Context, cfg *agent.InstanceConfig) error {
 	if err := applyStagedPlayerProfiles(cfg.ServerInstanceID); err != nil {
 		return fmt.Errorf("apply staged player profiles before start: %w", err)
 	}
+	if cfg.Container != "" {
+		return containers.Start(ctx, cfg.Container)
+	}
+	if isSystemdManaged7DTD(cfg) {
+		return systemctl7DTD(ctx, "start")
+	}
 	if cfg.StartCommand != "" {
 		parts := strings.Fields(cfg.StartCommand)
 		if len(parts) == 0 {
@@ -1921,10 +2426,12 @@ func (a *Adapter) Start(ctx context.Context, cfg *agent.InstanceConfig) error {
 		}
 		return a.Runner.run(ctx, cfg.InstallPath, parts[0], parts[1:]...)
 	}
-	// Default: run start.sh in install path if present
+	// Default: run start.sh in install path if present, supervised by the
+	// agent so Stop, Kill and Status have a process to act on.
 	startPath := filepath.Join(cfg.InstallPath, "start.sh")
 	if _, err := os.Stat(startPath); err == nil {
-		return a.Runner.run(ctx, cfg.InstallPath, "/bin/sh", startPath)
+		_, err := processes.Process(cfg.ServerInstanceID).Start(procsup.Spec{Dir: cfg.InstallPath, Argv: []string{"/bin/sh", startPath}})
+		return err
 	}
 	return fmt.Errorf("no start_command and no start.sh in install_path %q", cfg.InstallPath)
 }
@@ -1935,7 +2442,23 @@ func (a *Adapter) Stop(ctx context.Context, cfg *agent.InstanceConfig) error {
 		if len(parts) == 0 {
 			return fmt.Errorf("empty stop_command")
 		}
-		return a.Runner.run(ctx, cfg.InstallPath, parts[0], parts[1:]...)
+		if err := a.runCommand(ctx, cfg, parts); err != nil {
+			return err
+		}
+		if cfg.Container != "" {
+			return containers.Stop(ctx, cfg.Container, nativeStopTimeout)
+		}
+		// The stop command already asked the server to quit; give a native
+		// process the stop timeout to exit before it is killed.
+		return processes.Process(cfg.ServerInstanceID).Stop(ctx, procsup.StopOptions{
+			Graceful: func(context.Context) error { return nil },
+			Timeout:  nativeStopTimeout,
+		})
+	}
+	if cfg.Container != "" {
+		// Docker sends the image's stop signal and kills the container once
+		// the timeout passes.
+		return containers.Stop(ctx, cfg.Container, nativeStopTimeout)
 	}
 	// Same-host deployments registered with the hardened systemd start command
 	// must stop through the matching unit. Telnet can acknowledge a connection
@@ -1943,6 +2466,21 @@ func (a *Adapter) Stop(ctx context.Context, cfg *agent.InstanceConfig) error {
 	if isSystemdManaged7DTD(cfg) {
 		return systemctl7DTD(ctx, "stop")
 	}
+	proc := processes.Process(cfg.ServerInstanceID)
+	if _, running := proc.Running(); running {
+		return proc.Stop(ctx, procsup.StopOptions{
+			Graceful: func(ctx context.Context) error {
+				resp, err := a.SendCommand(ctx, cfg, "quit")
+				if err == nil && resp == "" {
+					err = fmt.Errorf("telnet quit was not acknowledged")
+				}
+				return err
+			},
+			// 7DTD saves and shuts down on SIGINT, like Ctrl-C in a console.
+			Signal:  syscall.SIGINT,
+			Timeout: nativeStopTimeout,
+		})
+	}
 	// Try to send "quit" via telnet for graceful shutdown
 	resp, err := a.SendCommand(ctx, cfg, "quit")
 	if err == nil && resp != "" {
@@ -1962,6 +2500,12 @@ func isSystemdManaged7DTD(cfg *agent.InstanceConfig) bool {
 }
 
 func (a *Adapter) Restart(ctx context.Context, cfg *agent.InstanceConfig) error {
+	return a.restart(ctx, cfg, nil)
+}
+
+// restart stops and starts the server; beforeStart runs once the old process
+// is gone, which is where health checks mark their place in the log.
+func (a *Adapter) restart(ctx context.Context, cfg *agent.InstanceConfig, beforeStart func()) error {
 	if cfg.AvoidBloodMoonRestart {
 		if err := a.waitUntilRestartDay(ctx, cfg); err != nil {
 			return err
@@ -1974,13 +2518,16 @@ func (a *Adapter) Restart(ctx context.Context, cfg *agent.InstanceConfig) error
 	// can spend considerably longer than three seconds flushing its save and
 	// stopping. Starting systemd while the old unit is still active is a no-op,
 	// which previously made restart jobs report success without a restart.
-	if err := waitFor7DTDState(ctx, false, 2*time.Minute); err != nil {
+	if err := a.waitForServerState(ctx, cfg, false, 2*time.Minute); err != nil {
 		return fmt.Errorf("server did not stop before restart: %w", err)
 	}
+	if beforeStart != nil {
+		beforeStart()
+	}
 	if err := a.Start(ctx, cfg); err != nil {
 		return err
 	}
-	if err := waitFor7DTDState(ctx, true, 30*time.Second); err != nil {
+	if err := a.waitForServerState(ctx, cfg, true, 30*time.Second); err != nil {
 		return fmt.Errorf("server did not become active after restart: %w", err)
 	}
 	return nil
@@ -2022,7 +2569,15 @@ func (a *Adapter) SafeRestart(ctx context.Context, cfg *agent.InstanceConfig, pa
 		}
 	}
 
-	backup, err := a.BackupSave(ctx, cfg, getString(payload, "server_config_path", ""), getInt(payload, "retention_count", 10))
+	format, err := saveBackupFormat(payload)
+	if err != nil {
+		return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+	}
+	policy, err := saveRetention(payload)
+	if err != nil {
+		return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+	}
+	backup, err := a.BackupSave(ctx, cfg, getString(payload, "server_config_path", ""), policy, format)
 	if err != nil {
 		return agent.JobResult{Status: "failed", Error: fmt.Sprintf("safe restart backup: %v", err)}, nil
 	}
@@ -2055,14 +2610,24 @@ func (a *Adapter) SafeRestart(ctx context.Context, cfg *agent.InstanceConfig, pa
 	// it again after players have already been removed.
 	restartCfg := *cfg
 	restartCfg.AvoidBloodMoonRestart = false
-	if err := a.Restart(ctx, &restartCfg); err != nil {
+	logPath, _ := a.GetLogPath(cfg)
+	var offset int64
+	if err := a.restart(ctx, &restartCfg, func() { offset = healthLogOffset(logPath) }); err != nil {
 		return agent.JobResult{Status: "failed", Error: fmt.Sprintf("safe restart: %v", err), Output: kickOutput}, nil
 	}
-	return agent.JobResult{
+	result, err := a.verifyStart(ctx, cfg, payload, logPath, offset, agent.JobResult{
 		Status: "success",
 		Output: kickOutput + "\nVerification: 0 players online",
 		Result: map[string]interface{}{"backup": backup, "playersRemaining": 0, "restarted": true},
-	}, nil
+	})
+	// Off-host copies wait until the server is back so they never delay
+	// the restart.
+	if err == nil && result.Status == "success" && result.Result != nil {
+		if replication := a.replicateAfterBackup(ctx, cfg, backup.ID); len(replication) > 0 {
+			result.Result["replication"] = replication
+		}
+	}
+	return result, err
 }
 
 var gameDayPattern = regexp.MustCompile(`(?i)\bday\s+(\d+)\b`)
@@ -2113,6 +2678,19 @@ func (a *Adapter) waitUntilRestartDayWithNotice(ctx context.Context, cfg *agent.
 }
 
 func (a *Adapter) Status(ctx context.Context, cfg *agent.InstanceConfig) (string, error) {
+	if cfg.Container != "" {
+		state, err := containers.Inspect(ctx, cfg.Container)
+		if err != nil {
+			return "unknown", err
+		}
+		if state.Running {
+			return "running", nil
+		}
+		return "stopped", nil
+	}
+	if _, running := processes.Process(cfg.ServerInstanceID).Running(); running {
+		return "running", nil
+	}
 	// Try telnet "status" or "version" to see if server responds
 	out, err := a.SendCommand(ctx, cfg, "version")
 	if err == nil && len(out) > 0 {
@@ -2196,9 +2774,19 @@ func (a *Adapter) BanPlayer(ctx context.Context, cfg *agent.InstanceConfig, play
 	return err
 }
 
+// InstallMod installs the mods in the ZIP artifact at opts["archive_path"];
+// modID is used as the folder name when the archive has a top-level ModInfo.xml.
 func (a *Adapter) InstallMod(ctx context.Context, cfg *agent.InstanceConfig, modID string, opts map[string]interface{}) error {
-	// 7DTD mod install is typically file-based or workshop; not a single telnet command.
-	return agent.ErrUnsupported
+	archivePath := getString(opts, "archive_path", "")
+	if archivePath == "" {
+		return fmt.Errorf("archive_path required")
+	}
+	_, err := installModArchive(cfg, getString(opts, "mods_path", ""), archivePath, modID+".zip", modInstallOptions{
+		Update:         strings.EqualFold(getString(opts, "mode", "install"), "update"),
+		AllowDowngrade: getBool(opts, "allow_downgrade"),
+		Reserve:        a.diskReserve(),
+	})
+	return err
 }
 
 func (a *Adapter) GetLogPath(cfg *agent.InstanceConfig) (string, error) {
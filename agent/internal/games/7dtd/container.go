@@ -0,0 +1,121 @@
+package sevendtd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mastermind/agent/internal/agent"
+	"github.com/mastermind/agent/internal/docker"
+)
+
+const (
+	defaultServerLogLines = 200
+	maxServerLogLines     = 5000
+	// maxServerLogBytes bounds how much of a log file SERVER_LOGS reads.
+	maxServerLogBytes = 1024 * 1024
+)
+
+// containers runs servers configured with config.docker.container; tests
+// point it at a fake Engine API.
+var containers = docker.Default
+
+// containerName returns the Docker container from the instance config
+// (config.docker.container), or "" for servers on the host.
+func containerName(payload map[string]interface{}) string {
+	config, _ := payload["config"].(map[string]interface{})
+	settings, _ := config["docker"].(map[string]interface{})
+	name, _ := settings["container"].(string)
+	return strings.TrimSpace(name)
+}
+
+// runCommand runs a start or stop command: inside the container for
+// containerized servers, otherwise on the host through the runner.
+func (a *Adapter) runCommand(ctx context.Context, cfg *agent.InstanceConfig, argv []string) error {
+	if cfg.Container == "" {
+		return a.Runner.run(ctx, cfg.InstallPath, argv[0], argv[1:]...)
+	}
+	result, err := containers.Exec(ctx, cfg.Container, argv)
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("%s exited with code %d in container %s: %s", argv[0], result.ExitCode, cfg.Container, strings.TrimSpace(result.Output))
+	}
+	return nil
+}
+
+// instanceRunning reports whether this instance's server process is up.
+func (a *Adapter) instanceRunning(ctx context.Context, cfg *agent.InstanceConfig) bool {
+	if cfg.Container != "" && a.isRunning == nil {
+		state, err := containers.Inspect(ctx, cfg.Container)
+		return err == nil && state.Running
+	}
+	return a.serverRunning(ctx, cfg)
+}
+
+// waitForServerState waits for the container or the 7DTD unit to reach the
+// requested state.
+func (a *Adapter) waitForServerState(ctx context.Context, cfg *agent.InstanceConfig, active bool, timeout time.Duration) error {
+	if cfg.Container == "" {
+		return waitFor7DTDState(ctx, active, timeout)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		state, err := containers.Inspect(ctx, cfg.Container)
+		if err == nil && state.Running == active {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("timed out waiting for container %s: %w", cfg.Container, err)
+			}
+			return fmt.Errorf("timed out waiting for container %s to reach running=%t (status %s)", cfg.Container, active, state.Status)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(healthPollInterval):
+		}
+	}
+}
+
+// serverLogs returns the last lines of the server's output: the container
+// log for containerized servers, otherwise the game log file.
+func (a *Adapter) serverLogs(ctx context.Context, cfg *agent.InstanceConfig, lines int) (string, error) {
+	if lines <= 0 {
+		lines = defaultServerLogLines
+	}
+	if lines > maxServerLogLines {
+		lines = maxServerLogLines
+	}
+	if cfg.Container != "" {
+		return containers.Logs(ctx, cfg.Container, lines)
+	}
+	logPath, err := a.GetLogPath(cfg)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(logPath)
+	if err != nil {
+		return "", fmt.Errorf("read server log: %w", err)
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err == nil && info.Size() > maxServerLogBytes {
+		if _, err := file.Seek(-maxServerLogBytes, io.SeekEnd); err != nil {
+			return "", fmt.Errorf("read server log: %w", err)
+		}
+	}
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return "", fmt.Errorf("read server log: %w", err)
+	}
+	all := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	return strings.Join(all, "\n"), nil
+}
@@ -0,0 +1,128 @@
+package sevendtd
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mastermind/agent/internal/agent"
+	"github.com/mastermind/agent/internal/docker"
+)
+
+// fakeDocker is a minimal Engine API for the container "sdtd".
+type fakeDocker struct {
+	mu       sync.Mutex
+	running  bool
+	requests []string
+}
+
+func (f *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := r.URL.Path[strings.Index(r.URL.Path[1:], "/")+1:]
+	f.requests = append(f.requests, r.Method+" "+path)
+	switch path {
+	case "/containers/sdtd/json":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"State": map[string]interface{}{"Running": f.running}})
+	case "/containers/sdtd/start":
+		f.running = true
+		w.WriteHeader(http.StatusNoContent)
+	case "/containers/sdtd/stop", "/containers/sdtd/kill":
+		f.running = false
+		w.WriteHeader(http.StatusNoContent)
+	case "/containers/sdtd/exec":
+		_, _ = w.Write([]byte(`{"Id":"x"}`))
+	case "/exec/x/start":
+	case "/exec/x/json":
+		_, _ = w.Write([]byte(`{"ExitCode":0}`))
+	case "/containers/sdtd/logs":
+		line := "INF GameServer.StartGame done\n"
+		header := make([]byte, 8)
+		header[0] = 1
+		binary.BigEndian.PutUint32(header[4:], uint32(len(line)))
+		_, _ = w.Write(append(header, line...))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func useFakeDocker(t *testing.T) *fakeDocker {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := &fakeDocker{}
+	server := httptest.NewUnstartedServer(engine)
+	server.Listener = listener
+	server.Start()
+	previous := containers
+	t.Cleanup(func() { server.Close(); containers = previous })
+	containers = docker.New(socket)
+	return engine
+}
+
+func TestContainerLifecycleUsesEngineAPI(t *testing.T) {
+	useFastHealthPolling(t)
+	engine := useFakeDocker(t)
+	payload := map[string]interface{}{
+		"server_instance_id": "container-test",
+		"install_path":       t.TempDir(),
+		"stop_command":       "/opt/bin/saveworld",
+		"telnet_port":        float64(1),
+		"verify_health":      false,
+		"config":             map[string]interface{}{"docker": map[string]interface{}{"container": "sdtd"}},
+	}
+	a := NewAdapter()
+	ctx := context.Background()
+	for _, jobType := range []string{"SERVER_START", "SERVER_RESTART", "SERVER_KILL"} {
+		if result, _ := a.Execute(ctx, agent.Job{Type: jobType, Payload: payload}); result.Status != "success" {
+			t.Fatalf("%s: %+v", jobType, result)
+		}
+	}
+	if status, err := a.Status(ctx, jobPayloadToConfig(payload)); err != nil || status != "stopped" {
+		t.Fatalf("status after kill = %q, %v", status, err)
+	}
+	result, _ := a.Execute(ctx, agent.Job{Type: "SERVER_LOGS", Payload: payload})
+	if result.Status != "success" || result.Output != "INF GameServer.StartGame done\n" {
+		t.Fatalf("logs = %+v", result)
+	}
+
+	requests := strings.Join(engine.requests, "\n")
+	for _, want := range []string{
+		"POST /containers/sdtd/start", "POST /containers/sdtd/exec", "POST /containers/sdtd/stop",
+		"GET /containers/sdtd/json", "POST /containers/sdtd/kill", "GET /containers/sdtd/logs",
+	} {
+		if !strings.Contains(requests, want) {
+			t.Fatalf("missing %q in requests:\n%s", want, requests)
+		}
+	}
+}
+
+func TestContainerServerCountsAsRunning(t *testing.T) {
+	fake := useFakeDocker(t)
+	fake.running = true
+	simulateServer(t, false)
+	previous := saveBackupRoot
+	saveBackupRoot = t.TempDir()
+	t.Cleanup(func() { saveBackupRoot = previous })
+	config, _ := writeTestServer(t, map[string]string{"Region/r.0.0.7rg": "region"})
+	cfg := &agent.InstanceConfig{Container: "sdtd", InstallPath: t.TempDir()}
+	a := NewAdapter()
+	ctx := context.Background()
+
+	if _, err := a.ResetRegions(ctx, cfg, config, []regionCoord{{0, 0}}, false, retentionPolicy{KeepLast: 10}, saveFormatManifest); err == nil || !strings.Contains(err.Error(), "stopped") {
+		t.Errorf("region reset: %v, want refusal while the container runs", err)
+	}
+	if _, err := a.RestoreSave(ctx, cfg, config, "mastermind_2024-05-01_10-00-00", nil, false); err == nil || !strings.Contains(err.Error(), "stopped") {
+		t.Errorf("save restore: %v, want refusal while the container runs", err)
+	}
+}
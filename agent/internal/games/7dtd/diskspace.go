@@ -0,0 +1,127 @@
+package sevendtd
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+// diskSpaceError reports a job that would not fit on its destination
+// filesystem with the agent's reserve left over.
+type diskSpaceError struct {
+	What    string
+	Path    string
+	Needed  int64
+	Reserve int64
+	Free    int64
+}
+
+func (e *diskSpaceError) Error() string {
+	return fmt.Sprintf("not enough disk space for %s on %s: needs %d MB plus the %d MB reserve, %d MB free",
+		e.What, e.Path, megabytes(e.Needed), megabytes(e.Reserve), megabytes(e.Free))
+}
+
+// megabytes rounds up so a shortfall never prints as 0 MB.
+func megabytes(bytes int64) int64 {
+	return (bytes + 1<<20 - 1) >> 20
+}
+
+// diskReserve is the free space every backup, restore and mod upload must
+// leave on its destination filesystem.
+func (a *Adapter) diskReserve() int64 {
+	return int64(a.DiskReserveMB) << 20
+}
+
+// checkDiskSpace fails with a *diskSpaceError unless the filesystem holding
+// path has needed bytes free on top of reserve. path need not exist yet; its
+// nearest existing parent is checked.
+func checkDiskSpace(what, path string, needed, reserve int64) error {
+	dir := filepath.Clean(path)
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	free, err := saveDiskFree(dir)
+	if err != nil {
+		return fmt.Errorf("check free disk space: %w", err)
+	}
+	if free < needed+reserve {
+		return &diskSpaceError{What: what, Path: dir, Needed: needed, Reserve: reserve, Free: free}
+	}
+	return nil
+}
+
+// estimateSaveTreeBytes is what storing source in the blob store under root
+// can add: every file except those the newest manifest already holds with
+// the same size and mtime. Reflinks may make it cost far less.
+func estimateSaveTreeBytes(source, root string) int64 {
+	previous := latestSaveManifest(root)
+	var total int64
+	_ = filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		if rel, err := filepath.Rel(source, path); err == nil {
+			prior, ok := previous[filepath.ToSlash(rel)]
+			if ok && prior.Size == info.Size() && prior.ModTime.Equal(info.ModTime().UTC()) {
+				return nil
+			}
+		}
+		total += info.Size()
+		return nil
+	})
+	return total
+}
+
+// zipExpandedSize sums the uncompressed sizes the ZIP's entries declare.
+func zipExpandedSize(archivePath string) (int64, error) {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	var total int64
+	for _, entry := range reader.File {
+		if entry.UncompressedSize64 > uint64(maxModArchiveExpandedBytes) {
+			return 0, fmt.Errorf("ZIP entry is too large: %q", entry.Name)
+		}
+		total += int64(entry.UncompressedSize64)
+	}
+	return total, nil
+}
+
+// backupSpacePreflight checks that a backup of live in format fits in root.
+// With policy.PruneForSpace it first deletes the oldest unpinned backups,
+// beyond what the retention policy would, until it does.
+func (a *Adapter) backupSpacePreflight(ctx context.Context, live, root string, policy retentionPolicy, format string) error {
+	needed := directorySize(live)
+	if format != saveFormatTarGzip && format != saveFormatTarZstd {
+		needed = estimateSaveTreeBytes(live, root)
+	}
+	err := checkDiskSpace("the backup", root, needed, a.diskReserve())
+	var short *diskSpaceError
+	if !policy.PruneForSpace || !errors.As(err, &short) {
+		return err
+	}
+	agent.ReportProgress(ctx, "prune", fmt.Sprintf("Pruning backups to make room for %d MB", megabytes(needed)))
+	policy.roomFor = needed + a.diskReserve()
+	plan, pruneErr := applySaveRetention(root, policy, false)
+	if pruneErr != nil {
+		return fmt.Errorf("prune backups for space: %w", pruneErr)
+	}
+	if err := checkDiskSpace("the backup", root, needed, a.diskReserve()); err != nil {
+		return fmt.Errorf("%w after pruning %d backups", err, len(plan.Deleted))
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package sevendtd
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+// fakeDiskFree makes saveDiskFree report free bytes from free.
+func fakeDiskFree(t *testing.T, free func() int64) {
+	t.Helper()
+	previous := saveDiskFree
+	t.Cleanup(func() { saveDiskFree = previous })
+	saveDiskFree = func(string) (int64, error) { return free(), nil }
+}
+
+func randomContent(t *testing.T, size int) string {
+	t.Helper()
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestBackupSaveFailsFastWithoutSpace(t *testing.T) {
+	previous := saveBackupRoot
+	saveBackupRoot = t.TempDir()
+	t.Cleanup(func() { saveBackupRoot = previous })
+	ctx := context.Background()
+	const size = 256 << 10
+	config, live := writeTestServer(t, map[string]string{"Region/r.0.0.7rg": randomContent(t, size)})
+	for _, id := range []string{"mastermind_2024-05-01_10-00-00", "mastermind_2024-05-01_11-00-00", "mastermind_2024-05-01_12-00-00"} {
+		if err := os.WriteFile(filepath.Join(live, "Region", "r.0.0.7rg"), []byte(randomContent(t, size)), 0640); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := writeSaveArchive(ctx, live, filepath.Join(saveBackupRoot, id), saveFormatTarGzip, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The disk holds three backups' worth; the old archives fill it.
+	fakeDiskFree(t, func() int64 { return 3*size + 64<<10 - directorySize(saveBackupRoot) })
+	a := NewAdapter()
+	cfg := &agent.InstanceConfig{}
+	// The retention policy keeps every backup, so only pruning for space
+	// can make room.
+	policy := retentionPolicy{KeepLast: 10}
+	_, err := a.BackupSave(ctx, cfg, config, policy, saveFormatManifest)
+	var short *diskSpaceError
+	if !errors.As(err, &short) || short.Needed != size {
+		t.Fatalf("backup on a full disk: %v", err)
+	}
+	if ids, _ := (saveNamespace{root: saveBackupRoot}).agentBackupIDs(); len(ids) != 3 {
+		t.Fatalf("failed backup left %v", ids)
+	}
+
+	policy.PruneForSpace = true
+	record, err := a.BackupSave(ctx, cfg, config, policy, saveFormatManifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, _ := (saveNamespace{root: saveBackupRoot}).agentBackupIDs()
+	sort.Strings(ids)
+	want := []string{"mastermind_2024-05-01_11-00-00", "mastermind_2024-05-01_12-00-00", record.ID}
+	if strings.Join(ids, ",") != strings.Join(want, ",") {
+		t.Fatalf("backups after pruning for space = %v, want only the oldest deleted: %v", ids, want)
+	}
+}
+
+func TestRestoreSaveFailsFastWithoutSpace(t *testing.T) {
+	previous := saveBackupRoot
+	saveBackupRoot = t.TempDir()
+	t.Cleanup(func() { saveBackupRoot = previous })
+	ctx := context.Background()
+	config, live := writeTestServer(t, map[string]string{"Region/r.0.0.7rg": "region then"})
+	a := NewAdapter()
+	cfg := &agent.InstanceConfig{}
+	record, err := a.BackupSave(ctx, cfg, config, retentionPolicy{KeepLast: 10}, saveFormatManifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(live, "Region", "r.0.0.7rg"), []byte("region now"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	fakeDiskFree(t, func() int64 { return 1 << 30 })
+	a.DiskReserveMB = 2048
+	_, err = a.RestoreSave(ctx, cfg, config, record.ID, nil, false)
+	if err == nil || !strings.Contains(err.Error(), "not enough disk space for the restore") {
+		t.Fatalf("restore into the reserve: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(live, "Region", "r.0.0.7rg")); string(data) != "region now" {
+		t.Fatal("failed restore touched the live save")
+	}
+}
+
+func TestQuarantineUploadChecksExpandedSize(t *testing.T) {
+	quarantineRoot := modQuarantineRoot
+	t.Cleanup(func() { modQuarantineRoot = quarantineRoot })
+	modQuarantineRoot = t.TempDir()
+	archive := writeModZip(t, map[string]string{
+		"BigMod/ModInfo.xml":  `<xml><Name value="BigMod"/></xml>`,
+		"BigMod/Textures.bin": strings.Repeat("x", 4<<20),
+	})
+	// The ZIP compresses to a few KB; only its expanded size must count.
+	fakeDiskFree(t, func() int64 { return 3 << 20 })
+	cfg := &agent.InstanceConfig{InstallPath: t.TempDir()}
+	_, err := installUploadedModsToQuarantine(cfg, 0, "", archive, "BigMod.zip")
+	var short *diskSpaceError
+	if !errors.As(err, &short) || short.Needed < 4<<20 {
+		t.Fatalf("upload larger than the free space: %v", err)
+	}
+	fakeDiskFree(t, func() int64 { return 8 << 20 })
+	if _, err := installUploadedModsToQuarantine(cfg, 1<<20, "", archive, "BigMod.zip"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModInstallChecksExpandedSize(t *testing.T) {
+	cfg, mods := setupModInstall(t)
+	archive := writeModZip(t, map[string]string{
+		"BigMod/ModInfo.xml":  modInfoXML("BigMod", "1.0"),
+		"BigMod/Textures.bin": strings.Repeat("x", 4<<20),
+	})
+	fakeDiskFree(t, func() int64 { return 6 << 20 })
+	_, err := installModArchive(cfg, "", archive, "BigMod.zip", modInstallOptions{Reserve: 4 << 20})
+	var short *diskSpaceError
+	if !errors.As(err, &short) || short.Needed < 4<<20 || short.Reserve != 4<<20 {
+		t.Fatalf("install into the reserve: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(mods, "BigMod")); !os.IsNotExist(err) {
+		t.Fatalf("failed install left BigMod in Mods: %v", err)
+	}
+	if _, err := installModArchive(cfg, "", archive, "BigMod.zip", modInstallOptions{Reserve: 1 << 20}); err != nil {
+		t.Fatal(err)
+	}
+}
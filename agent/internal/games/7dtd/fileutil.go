@@ -0,0 +1,48 @@
+package sevendtd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// replaceFileAtomically writes content beside target and renames it into
+// place, so the game never observes a partially written file. The original
+// mode is kept and ownership is preserved when the agent is allowed to.
+func replaceFileAtomically(target string, content []byte, mode os.FileMode) error {
+	var uid, gid = -1, -1
+	if info, err := os.Stat(target); err == nil {
+		mode = info.Mode().Perm()
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid, gid = int(stat.Uid), int(stat.Gid)
+		}
+	}
+	temporary, err := os.CreateTemp(filepath.Dir(target), ".mastermind-"+filepath.Base(target)+"-*")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+	temporaryPath := temporary.Name()
+	defer os.Remove(temporaryPath)
+	if _, err = temporary.Write(content); err == nil {
+		err = temporary.Sync()
+	}
+	if closeErr := temporary.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write temporary file: %w", err)
+	}
+	if err := os.Chmod(temporaryPath, mode); err != nil {
+		return fmt.Errorf("preserve file permissions: %w", err)
+	}
+	if uid >= 0 {
+		// Best effort: an unprivileged agent may only keep its own ownership,
+		// and group-writable game files remain usable either way.
+		_ = os.Chown(temporaryPath, uid, gid)
+	}
+	if err := os.Rename(temporaryPath, target); err != nil {
+		return fmt.Errorf("replace file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,394 @@
+package sevendtd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+// pendingChangeRoot journals the mod, config and profile changes made since
+// the server last passed a health check, one file per server instance. A
+// failed check after start rolls every journaled change back, newest first,
+// because there is no telling which of them broke the server.
+var pendingChangeRoot = "/var/lib/mastermind-agent/pending-changes"
+
+var healthPollInterval = 2 * time.Second
+
+const defaultHealthTimeout = 5 * time.Minute
+
+// maxHealthExceptions bounds how many exception lines a report carries.
+const maxHealthExceptions = 20
+
+type pendingChange struct {
+	Kind       string    `json:"kind"`
+	Summary    string    `json:"summary"`
+	RecordedAt time.Time `json:"recordedAt"`
+	ModsPath   string    `json:"modsPath,omitempty"`
+	// Folders are the mods a MOD_RESTORE moved out of quarantine.
+	Folders  []string           `json:"folders,omitempty"`
+	Installs []modInstallResult `json:"installs,omitempty"`
+	Moves    []modSetMove       `json:"moves,omitempty"`
+	// ServerConfigPath and BackupID identify the serverconfig.xml backup
+	// taken before the change.
+	ServerConfigPath string `json:"serverConfigPath,omitempty"`
+	BackupID         string `json:"backupId,omitempty"`
+	// Folder, Path and VersionID name the mod config version to revert to.
+	Folder    string           `json:"folder,omitempty"`
+	Path      string           `json:"path,omitempty"`
+	VersionID string           `json:"versionId,omitempty"`
+	Profiles  []appliedProfile `json:"profiles,omitempty"`
+}
+
+// appliedProfile is a staged player profile written over a live one, and the
+// backups applyStagedPlayerProfiles took of the file and its .bak companion.
+type appliedProfile struct {
+	Target          string `json:"target"`
+	Backup          string `json:"backup"`
+	CompanionBackup string `json:"companionBackup,omitempty"`
+}
+
+type healthReport struct {
+	Healthy       bool     `json:"healthy"`
+	Started       bool     `json:"started"`
+	Marker        string   `json:"marker,omitempty"`
+	TelnetVersion string   `json:"telnetVersion,omitempty"`
+	Exceptions    []string `json:"exceptions,omitempty"`
+	Reason        string   `json:"reason,omitempty"`
+	DurationMs    int64    `json:"durationMs"`
+}
+
+type rollbackReport struct {
+	Changes []pendingChange `json:"changes"`
+	Errors  []string        `json:"errors,omitempty"`
+}
+
+func pendingChangesPath(serverID string) string {
+	if serverID == "" {
+		serverID = "default"
+	}
+	return filepath.Join(pendingChangeRoot, serverID+".json")
+}
+
+func loadPendingChanges(serverID string) ([]pendingChange, error) {
+	var changes []pendingChange
+	content, err := os.ReadFile(pendingChangesPath(serverID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read pending changes: %w", err)
+	}
+	if err := json.Unmarshal(content, &changes); err != nil {
+		return nil, fmt.Errorf("parse pending changes: %w", err)
+	}
+	return changes, nil
+}
+
+func savePendingChanges(serverID string, changes []pendingChange) error {
+	path := pendingChangesPath(serverID)
+	if len(changes) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("clear pending changes: %w", err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(pendingChangeRoot, 0750); err != nil {
+		return fmt.Errorf("create pending change directory: %w", err)
+	}
+	encoded, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFileAtomically(path, append(encoded, '\n'), 0640)
+}
+
+func recordPendingChange(serverID string, change pendingChange) error {
+	changes, err := loadPendingChanges(serverID)
+	if err != nil {
+		return err
+	}
+	change.RecordedAt = time.Now().UTC()
+	return savePendingChanges(serverID, append(changes, change))
+}
+
+// withPendingChange journals a successful change for rollback. The change
+// itself has already been made, so a journal failure is reported in the
+// result rather than failing the job.
+func withPendingChange(result agent.JobResult, cfg *agent.InstanceConfig, change pendingChange) agent.JobResult {
+	if err := recordPendingChange(cfg.ServerInstanceID, change); err != nil {
+		if result.Result == nil {
+			result.Result = map[string]interface{}{}
+		}
+		result.Result["rollbackUnavailable"] = err.Error()
+	}
+	return result
+}
+
+// previousModConfigVersionID returns the version recorded just before id, which
+// is what a write that produced id replaced.
+func previousModConfigVersionID(cfg *agent.InstanceConfig, override, folder, relativePath, id string) string {
+	history, _, err := modConfigHistoryFor(cfg, override, folder, relativePath)
+	if err != nil {
+		return ""
+	}
+	for index, version := range history.Versions {
+		if version.ID == id && index > 0 {
+			return history.Versions[index-1].ID
+		}
+	}
+	return ""
+}
+
+// isHealthStartMarker matches the lines 7DTD logs once the world has loaded
+// and the game has started.
+func isHealthStartMarker(line string) bool {
+	if strings.Contains(line, "GameServer.StartGame") {
+		return true
+	}
+	lower := strings.ToLower(line)
+	return strings.Contains(lower, "gameserver") && strings.Contains(lower, "initialized")
+}
+
+// isHealthException matches managed exceptions and the EXC/ERR log levels
+// 7DTD uses for failed XML patches and mod load errors.
+func isHealthException(line string) bool {
+	if strings.Contains(line, "Exception") {
+		return true
+	}
+	fields := strings.Fields(line)
+	for _, field := range fields[:min(len(fields), 3)] {
+		if field == "EXC" || field == "ERR" {
+			return true
+		}
+	}
+	return false
+}
+
+func healthLogOffset(logPath string) int64 {
+	if info, err := os.Stat(logPath); err == nil {
+		return info.Size()
+	}
+	return 0
+}
+
+// checkServerHealth follows the server log from offset until the start
+// marker appears, then waits for telnet to answer `version`. Exceptions
+// logged before the start marker mean something failed to load and fail the
+// check; later ones are only reported.
+func (a *Adapter) checkServerHealth(ctx context.Context, cfg *agent.InstanceConfig, logPath string, offset int64, timeout time.Duration) healthReport {
+	started := time.Now()
+	deadline := started.Add(timeout)
+	report := healthReport{}
+	monitorProcess := a.isRunning != nil || isSystemdManaged7DTD(cfg) || cfg.Container != ""
+	var partial string
+	finish := func(reason string) healthReport {
+		report.Reason = reason
+		report.DurationMs = time.Since(started).Milliseconds()
+		return report
+	}
+	for {
+		if info, err := os.Stat(logPath); err == nil && info.Size() < offset {
+			// The game truncates its log on launch.
+			offset, partial = 0, ""
+		}
+		if file, err := os.Open(logPath); err == nil {
+			if _, err := file.Seek(offset, io.SeekStart); err == nil {
+				content, _ := io.ReadAll(file)
+				offset += int64(len(content))
+				lines := strings.Split(partial+string(content), "\n")
+				partial = lines[len(lines)-1]
+				for _, line := range lines[:len(lines)-1] {
+					line = strings.TrimRight(line, "\r")
+					if isHealthException(line) && len(report.Exceptions) < maxHealthExceptions {
+						report.Exceptions = append(report.Exceptions, strings.TrimSpace(line))
+					}
+					if !report.Started && isHealthStartMarker(line) {
+						report.Started, report.Marker = true, strings.TrimSpace(line)
+						if len(report.Exceptions) > 0 {
+							return finish(fmt.Sprintf("%d exception(s) logged while the server was loading", len(report.Exceptions)))
+						}
+					}
+				}
+			}
+			_ = file.Close()
+		}
+		if report.Started {
+			output, err := a.SendCommand(ctx, cfg, "version")
+			if err == nil && strings.TrimSpace(output) != "" && !consoleRejected(output) {
+				report.Healthy, report.TelnetVersion = true, strings.TrimSpace(output)
+				return finish("")
+			}
+		}
+		if monitorProcess && !a.instanceRunning(ctx, cfg) {
+			return finish("server process exited during startup")
+		}
+		if time.Now().After(deadline) {
+			if report.Started {
+				return finish("telnet did not answer version before the health check timed out")
+			}
+			return finish("server did not log a start marker before the health check timed out")
+		}
+		select {
+		case <-ctx.Done():
+			return finish(ctx.Err().Error())
+		case <-time.After(healthPollInterval):
+		}
+	}
+}
+
+// rollbackPendingChanges undoes changes newest first. Changes that cannot be
+// undone are returned so they stay journaled.
+func (a *Adapter) rollbackPendingChanges(ctx context.Context, cfg *agent.InstanceConfig, changes []pendingChange) (rollbackReport, []pendingChange) {
+	report := rollbackReport{Changes: []pendingChange{}}
+	var remaining []pendingChange
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		if err := a.rollbackPendingChange(ctx, cfg, change); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", change.Summary, err))
+			remaining = append([]pendingChange{change}, remaining...)
+			continue
+		}
+		report.Changes = append(report.Changes, change)
+	}
+	return report, remaining
+}
+
+func (a *Adapter) rollbackPendingChange(ctx context.Context, cfg *agent.InstanceConfig, change pendingChange) error {
+	switch change.Kind {
+	case "mod_restore":
+		for _, folder := range change.Folders {
+			if err := quarantineMod(cfg, change.ModsPath, folder); err != nil {
+				return err
+			}
+		}
+	case "mod_install":
+		root, err := modsPath(cfg, change.ModsPath)
+		if err != nil {
+			return err
+		}
+		for i := len(change.Installs) - 1; i >= 0; i-- {
+			install := change.Installs[i]
+			if err := quarantineMod(cfg, change.ModsPath, install.Folder); err != nil {
+				return err
+			}
+			if install.BackupPath != "" {
+				if err := moveModTree(install.BackupPath, filepath.Join(root, install.ReplacedFolder)); err != nil {
+					return fmt.Errorf("restore %s from backup: %w", install.ReplacedFolder, err)
+				}
+			}
+		}
+	case "mod_set":
+		for i := len(change.Moves) - 1; i >= 0; i-- {
+			move := change.Moves[i]
+			var err error
+			if move.To == "mods" {
+				err = quarantineMod(cfg, change.ModsPath, move.Folder)
+			} else {
+				err = restoreMod(cfg, change.ModsPath, move.Folder)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case "server_config":
+		_, err := a.RollbackServerConfig(ctx, cfg, change.ServerConfigPath, change.BackupID)
+		return err
+	case "mod_config":
+		if change.VersionID == "" {
+			return fmt.Errorf("no earlier version recorded")
+		}
+		_, err := revertModConfig(cfg, change.ModsPath, change.Folder, change.Path, change.VersionID, "health check rollback", "")
+		return err
+	case "profile":
+		for _, profile := range change.Profiles {
+			if err := restoreProfileBackup(profile.Backup, profile.Target); err != nil {
+				return err
+			}
+			if profile.CompanionBackup != "" {
+				if err := restoreProfileBackup(profile.CompanionBackup, profile.Target+".bak"); err != nil {
+					return err
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("unknown change kind %q", change.Kind)
+	}
+	return nil
+}
+
+func restoreProfileBackup(backup, target string) error {
+	content, err := os.ReadFile(backup)
+	if err != nil {
+		return fmt.Errorf("read profile backup: %w", err)
+	}
+	return replaceFileAtomically(target, content, 0640)
+}
+
+// verifyStart runs the health check after a start or restart. It runs when
+// requested with verify_health, and otherwise whenever changes are pending,
+// since that is when a broken start can be undone. A failed check with
+// pending changes stops the server, rolls them back and starts it again.
+func (a *Adapter) verifyStart(ctx context.Context, cfg *agent.InstanceConfig, payload map[string]interface{}, logPath string, offset int64, result agent.JobResult) (agent.JobResult, error) {
+	pending, pendingErr := loadPendingChanges(cfg.ServerInstanceID)
+	verify := len(pending) > 0 || pendingErr != nil
+	if requested, ok := payload["verify_health"].(bool); ok {
+		verify = requested
+	}
+	if !verify {
+		return result, nil
+	}
+	if result.Result == nil {
+		result.Result = map[string]interface{}{}
+	}
+	timeout := time.Duration(getInt(payload, "health_timeout_seconds", int(defaultHealthTimeout/time.Second))) * time.Second
+	agent.ReportProgress(ctx, "running", "Verifying server health")
+	health := a.checkServerHealth(ctx, cfg, logPath, offset, timeout)
+	result.Result["health"] = health
+	if health.Healthy {
+		if err := savePendingChanges(cfg.ServerInstanceID, nil); err != nil {
+			result.Result["pendingChangeError"] = err.Error()
+		}
+		return result, nil
+	}
+	if pendingErr != nil {
+		return agent.JobResult{Status: "failed", Error: fmt.Sprintf("server failed health check: %s; rollback unavailable: %v", health.Reason, pendingErr), Output: result.Output, Result: result.Result}, nil
+	}
+	if len(pending) == 0 {
+		return agent.JobResult{Status: "failed", Error: "server failed health check: " + health.Reason, Output: result.Output, Result: result.Result}, nil
+	}
+
+	agent.ReportProgress(ctx, "running", fmt.Sprintf("Health check failed; rolling back %d change(s)", len(pending)))
+	if err := a.Stop(ctx, cfg); err != nil {
+		return agent.JobResult{Status: "failed", Error: fmt.Sprintf("server failed health check: %s; stop for rollback: %v", health.Reason, err), Output: result.Output, Result: result.Result}, nil
+	}
+	if err := waitFor7DTDState(ctx, false, 2*time.Minute); err != nil {
+		return agent.JobResult{Status: "failed", Error: fmt.Sprintf("server failed health check: %s; server did not stop for rollback: %v", health.Reason, err), Output: result.Output, Result: result.Result}, nil
+	}
+	rollback, remaining := a.rollbackPendingChanges(ctx, cfg, pending)
+	result.Result["rollback"] = rollback
+	if err := savePendingChanges(cfg.ServerInstanceID, remaining); err != nil {
+		rollback.Errors = append(rollback.Errors, err.Error())
+		result.Result["rollback"] = rollback
+	}
+	message := fmt.Sprintf("server failed health check: %s; rolled back %d of %d change(s)", health.Reason, len(rollback.Changes), len(pending))
+	offset = healthLogOffset(logPath)
+	if err := a.Start(ctx, cfg); err != nil {
+		return agent.JobResult{Status: "failed", Error: fmt.Sprintf("%s; restart after rollback: %v", message, err), Output: result.Output, Result: result.Result}, nil
+	}
+	recovery := a.checkServerHealth(ctx, cfg, logPath, offset, timeout)
+	result.Result["recoveryHealth"] = recovery
+	result.Result["recovered"] = recovery.Healthy
+	if recovery.Healthy {
+		message += "; server is healthy again"
+	} else {
+		message += "; server is still unhealthy: " + recovery.Reason
+	}
+	return agent.JobResult{Status: "failed", Error: message, Output: result.Output, Result: result.Result}, nil
+}
@@ -0,0 +1,184 @@
+package sevendtd
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mastermind/agent/internal/agent"
+	"github.com/mastermind/agent/internal/procsup"
+)
+
+// fakeTelnet answers every command with the 7DTD version banner.
+func fakeTelnet(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				_, _ = conn.Write([]byte("*** Connected with 7DTD server.\r\n"))
+				if _, err := bufio.NewReader(conn).ReadString('\n'); err == nil {
+					_, _ = conn.Write([]byte("Game version: V 1.0 (b333) Compatibility Version: V 1.0\r\n"))
+				}
+			}(conn)
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func useFastHealthPolling(t *testing.T) {
+	interval, root, native := healthPollInterval, pendingChangeRoot, processes
+	t.Cleanup(func() { healthPollInterval, pendingChangeRoot, processes = interval, root, native })
+	healthPollInterval, pendingChangeRoot, processes = 10*time.Millisecond, t.TempDir(), procsup.NewManager(t.TempDir())
+}
+
+func TestHealthLogClassification(t *testing.T) {
+	for line, want := range map[string]bool{
+		"2024-05-01T10:00:00 12.500 INF GameServer.StartGame done":              true,
+		"2024-05-01T10:00:00 12.500 INF GameServer initialized":                 true,
+		"2024-05-01T10:00:00 1.100 INF Physics initialized":                     false,
+		"2024-05-01T10:00:00 2.000 INF Loaded (local): items":                   false,
+		"2024-05-01T10:00:00 2.000 ERR XML loader: Patching 'items.xml' failed": false,
+	} {
+		if got := isHealthStartMarker(line); got != want {
+			t.Errorf("isHealthStartMarker(%q) = %v", line, got)
+		}
+	}
+	for line, want := range map[string]bool{
+		"2024-05-01T10:00:00 2.000 ERR XML loader: Patching 'items.xml' failed": true,
+		"2024-05-01T10:00:00 2.000 EXC Object reference not set":                true,
+		"NullReferenceException: Object reference not set to an instance":       true,
+		"2024-05-01T10:00:00 2.000 INF Player ERR joined":                       false,
+	} {
+		if got := isHealthException(line); got != want {
+			t.Errorf("isHealthException(%q) = %v", line, got)
+		}
+	}
+}
+
+func TestCheckServerHealthFollowsLogFromOffset(t *testing.T) {
+	useFastHealthPolling(t)
+	logPath := filepath.Join(t.TempDir(), "output_log.txt")
+	old := "2024-05-01T09:00:00 1.000 EXC Previous run crashed\n"
+	if err := os.WriteFile(logPath, []byte(old), 0640); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &agent.InstanceConfig{TelnetHost: "127.0.0.1", TelnetPort: fakeTelnet(t)}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		file, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+		_, _ = file.WriteString("2024-05-01T10:00:00 5.000 INF Loading world\n2024-05-01T10:00:00 30.000 INF GameServer.StartGame done\n")
+	}()
+	report := NewAdapter().checkServerHealth(context.Background(), cfg, logPath, int64(len(old)), 10*time.Second)
+	if !report.Healthy || !report.Started || len(report.Exceptions) != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+	if !strings.Contains(report.TelnetVersion, "Game version") {
+		t.Fatalf("telnet version = %q", report.TelnetVersion)
+	}
+
+	if err := os.WriteFile(logPath, []byte("2024-05-01T10:00:00 2.000 ERR XML loader: Patching 'items.xml' from mod 'BadMod' failed\n2024-05-01T10:00:00 30.000 INF GameServer.StartGame done\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	report = NewAdapter().checkServerHealth(context.Background(), cfg, logPath, int64(len(old)+500), 10*time.Second)
+	if report.Healthy || len(report.Exceptions) != 1 || !strings.Contains(report.Reason, "exception") {
+		t.Fatalf("truncated log with load error: report = %+v", report)
+	}
+}
+
+func TestCheckServerHealthTimesOutWithoutMarker(t *testing.T) {
+	useFastHealthPolling(t)
+	logPath := filepath.Join(t.TempDir(), "output_log.txt")
+	if err := os.WriteFile(logPath, []byte("2024-05-01T10:00:00 5.000 INF Loading world\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	report := NewAdapter().checkServerHealth(context.Background(), &agent.InstanceConfig{TelnetHost: "127.0.0.1", TelnetPort: 1}, logPath, 0, 50*time.Millisecond)
+	if report.Healthy || report.Started || !strings.Contains(report.Reason, "start marker") {
+		t.Fatalf("report = %+v", report)
+	}
+}
+
+func TestServerStartRollsBackRestoredModAfterFailedHealthCheck(t *testing.T) {
+	useFastHealthPolling(t)
+	quarantineRoot := modQuarantineRoot
+	t.Cleanup(func() { modQuarantineRoot = quarantineRoot })
+	modQuarantineRoot = t.TempDir()
+	install := t.TempDir()
+	for _, dir := range []string{"7DaysToDieServer_Data", "Mods"} {
+		if err := os.MkdirAll(filepath.Join(install, dir), 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The fake server logs a patch error while BadMod is active.
+	script := filepath.Join(install, "start.sh")
+	if err := os.WriteFile(script, []byte(`#!/bin/sh
+log=7DaysToDieServer_Data/output_log.txt
+: > "$log"
+if [ -d Mods/BadMod ]; then
+  echo "2024-05-01T10:00:00 2.000 ERR XML loader: Patching 'items.xml' from mod 'BadMod' failed" >> "$log"
+fi
+echo "2024-05-01T10:00:00 30.000 INF GameServer.StartGame done" >> "$log"
+`), 0750); err != nil {
+		t.Fatal(err)
+	}
+	payload := map[string]interface{}{
+		"server_instance_id": "health-test",
+		"install_path":       install,
+		"stop_command":       "/bin/true",
+		"telnet_port":        float64(fakeTelnet(t)),
+	}
+	cfg := jobPayloadToConfig(payload)
+	quarantine, err := quarantinePath(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeModlet(t, quarantine, "BadMod", map[string]string{})
+
+	a := NewAdapter()
+	result, _ := a.Execute(context.Background(), agent.Job{Type: "MOD_RESTORE", Payload: map[string]interface{}{
+		"server_instance_id": "health-test", "install_path": install, "folder": "BadMod",
+	}})
+	if result.Status != "success" {
+		t.Fatalf("restore: %+v", result)
+	}
+	if pending, _ := loadPendingChanges("health-test"); len(pending) != 1 || pending[0].Kind != "mod_restore" {
+		t.Fatalf("pending = %+v", pending)
+	}
+
+	result, _ = a.Execute(context.Background(), agent.Job{Type: "SERVER_START", Payload: payload})
+	if result.Status != "failed" || !strings.Contains(result.Error, "rolled back 1 of 1") {
+		t.Fatalf("start result = %+v", result)
+	}
+	if recovered, _ := result.Result["recovered"].(bool); !recovered {
+		t.Fatalf("server did not recover: %+v", result.Result)
+	}
+	if _, err := os.Stat(filepath.Join(quarantine, "BadMod", "ModInfo.xml")); err != nil {
+		t.Fatalf("BadMod was not re-quarantined: %v", err)
+	}
+	if pending, _ := loadPendingChanges("health-test"); len(pending) != 0 {
+		t.Fatalf("pending after rollback = %+v", pending)
+	}
+
+	result, _ = a.Execute(context.Background(), agent.Job{Type: "SERVER_START", Payload: payload})
+	if result.Status != "success" || result.Result != nil {
+		t.Fatalf("start without pending changes = %+v", result)
+	}
+}
@@ -0,0 +1,340 @@
+package sevendtd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mastermind/agent/internal/agent"
+	"github.com/mastermind/agent/internal/xmltree"
+)
+
+// modConfigHistoryRoot keeps every saved version of files edited through
+// MOD_CONFIG_WRITE, one directory per server, mod folder and file.
+var modConfigHistoryRoot = "/var/lib/mastermind-agent/mod-config-history"
+
+const modConfigHistoryRetention = 50
+
+type modConfigVersion struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	Author    string    `json:"author,omitempty"`
+	Action    string    `json:"action"`
+	Hash      string    `json:"hash"`
+	SizeBytes int       `json:"sizeBytes"`
+	// RevertedFrom names the version a revert restored.
+	RevertedFrom string `json:"revertedFrom,omitempty"`
+}
+
+type modConfigHistory struct {
+	Folder   string             `json:"folder"`
+	Path     string             `json:"path"`
+	Versions []modConfigVersion `json:"versions"`
+}
+
+// modConfigHistoryDir names the history directory by a hash of the relative
+// path so nested config paths map to a single directory level.
+func modConfigHistoryDir(cfg *agent.InstanceConfig, root, folder, relativePath string) string {
+	sum := sha256.Sum256([]byte(filepath.ToSlash(filepath.Clean(relativePath))))
+	return filepath.Join(modConfigHistoryRoot, modServerKey(cfg, root), folder, hex.EncodeToString(sum[:8]))
+}
+
+func loadModConfigHistory(dir string) (modConfigHistory, error) {
+	var history modConfigHistory
+	content, err := os.ReadFile(filepath.Join(dir, "versions.json"))
+	if os.IsNotExist(err) {
+		return history, nil
+	}
+	if err != nil {
+		return history, fmt.Errorf("read config history: %w", err)
+	}
+	if err := json.Unmarshal(content, &history); err != nil {
+		return history, fmt.Errorf("parse config history: %w", err)
+	}
+	return history, nil
+}
+
+// recordModConfigVersion stores content as a new version, pruning the oldest
+// versions beyond the retention limit.
+func recordModConfigVersion(dir string, history *modConfigHistory, content []byte, version modConfigVersion) (modConfigVersion, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return version, fmt.Errorf("create config history directory: %w", err)
+	}
+	version.CreatedAt = time.Now().UTC()
+	version.ID = version.CreatedAt.Format("20060102T150405.000000000Z")
+	version.Hash = contentHash(content)
+	version.SizeBytes = len(content)
+	if err := os.WriteFile(filepath.Join(dir, version.ID+".version"), content, 0640); err != nil {
+		return version, fmt.Errorf("store config version: %w", err)
+	}
+	history.Versions = append(history.Versions, version)
+	for len(history.Versions) > modConfigHistoryRetention {
+		_ = os.Remove(filepath.Join(dir, history.Versions[0].ID+".version"))
+		history.Versions = history.Versions[1:]
+	}
+	encoded, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return version, err
+	}
+	return version, replaceFileAtomically(filepath.Join(dir, "versions.json"), append(encoded, '\n'), 0640)
+}
+
+func readModConfigVersion(dir string, history modConfigHistory, id string) ([]byte, modConfigVersion, error) {
+	for _, version := range history.Versions {
+		if version.ID == id {
+			content, err := os.ReadFile(filepath.Join(dir, version.ID+".version"))
+			if err != nil {
+				return nil, version, fmt.Errorf("read config version %s: %w", id, err)
+			}
+			return content, version, nil
+		}
+	}
+	return nil, modConfigVersion{}, fmt.Errorf("config version not found: %s", id)
+}
+
+// checkModConfigContent rejects XML files that are not well-formed.
+func checkModConfigContent(relativePath, content string) error {
+	if !strings.EqualFold(filepath.Ext(relativePath), ".xml") {
+		return nil
+	}
+	if _, err := xmltree.ParseBytes([]byte(content)); err != nil {
+		if syntax, ok := err.(*xmltree.SyntaxError); ok {
+			return fmt.Errorf("content is not well-formed XML: line %d: %s", syntax.Line, syntax.Msg)
+		}
+		return fmt.Errorf("content is not well-formed XML: %w", err)
+	}
+	return nil
+}
+
+type modConfigWrite struct {
+	Folder       string
+	Path         string
+	Content      string
+	Author       string
+	ExpectedHash string
+	Action       string
+	RevertedFrom string
+}
+
+// saveModConfigVersioned writes a mod config through writeModConfig and
+// records the new content in the file's history. The first edit also records
+// the file as it was, so it can be reverted. A non-empty ExpectedHash must
+// match the file on disk, refusing writes over a concurrent change.
+func saveModConfigVersioned(cfg *agent.InstanceConfig, override string, write modConfigWrite) (modConfigVersion, error) {
+	if err := checkModConfigContent(write.Path, write.Content); err != nil {
+		return modConfigVersion{}, err
+	}
+	root, err := modsPath(cfg, override)
+	if err != nil {
+		return modConfigVersion{}, err
+	}
+	current, err := readModConfig(cfg, override, write.Folder, write.Path)
+	if err != nil {
+		return modConfigVersion{}, err
+	}
+	if write.ExpectedHash != "" && !strings.EqualFold(write.ExpectedHash, contentHash([]byte(current))) {
+		return modConfigVersion{}, fmt.Errorf("mod config changed since it was read; reload and reapply the edit")
+	}
+	dir := modConfigHistoryDir(cfg, root, write.Folder, write.Path)
+	history, err := loadModConfigHistory(dir)
+	if err != nil {
+		return modConfigVersion{}, err
+	}
+	history.Folder, history.Path = write.Folder, filepath.ToSlash(filepath.Clean(write.Path))
+	if len(history.Versions) == 0 || history.Versions[len(history.Versions)-1].Hash != contentHash([]byte(current)) {
+		// Record the on-disk content the first time, or after edits made
+		// outside the agent, so the write below can always be undone.
+		if _, err := recordModConfigVersion(dir, &history, []byte(current), modConfigVersion{Action: "original"}); err != nil {
+			return modConfigVersion{}, err
+		}
+	}
+	if err := writeModConfig(cfg, override, write.Folder, write.Path, write.Content); err != nil {
+		return modConfigVersion{}, err
+	}
+	return recordModConfigVersion(dir, &history, []byte(write.Content), modConfigVersion{
+		Author: write.Author, Action: write.Action, RevertedFrom: write.RevertedFrom,
+	})
+}
+
+func modConfigHistoryFor(cfg *agent.InstanceConfig, override, folder, relativePath string) (modConfigHistory, string, error) {
+	root, err := modsPath(cfg, override)
+	if err != nil {
+		return modConfigHistory{}, "", err
+	}
+	current, err := readModConfig(cfg, override, folder, relativePath)
+	if err != nil {
+		return modConfigHistory{}, "", err
+	}
+	history, err := loadModConfigHistory(modConfigHistoryDir(cfg, root, folder, relativePath))
+	if err != nil {
+		return modConfigHistory{}, "", err
+	}
+	history.Folder, history.Path = folder, filepath.ToSlash(filepath.Clean(relativePath))
+	if history.Versions == nil {
+		history.Versions = []modConfigVersion{}
+	}
+	return history, contentHash([]byte(current)), nil
+}
+
+// modConfigContentAt returns the content of a stored version, or of the live
+// file for "current" or an empty id.
+func modConfigContentAt(cfg *agent.InstanceConfig, override, folder, relativePath, id string) (string, error) {
+	if id == "" || id == "current" {
+		return readModConfig(cfg, override, folder, relativePath)
+	}
+	root, err := modsPath(cfg, override)
+	if err != nil {
+		return "", err
+	}
+	if _, err := resolveModConfig(root, folder, relativePath); err != nil {
+		return "", err
+	}
+	dir := modConfigHistoryDir(cfg, root, folder, relativePath)
+	history, err := loadModConfigHistory(dir)
+	if err != nil {
+		return "", err
+	}
+	content, _, err := readModConfigVersion(dir, history, id)
+	return string(content), err
+}
+
+// diffModConfig compares two versions; to defaults to the live file.
+func diffModConfig(cfg *agent.InstanceConfig, override, folder, relativePath, from, to string) (string, error) {
+	if from == "" {
+		return "", fmt.Errorf("from version required")
+	}
+	before, err := modConfigContentAt(cfg, override, folder, relativePath, from)
+	if err != nil {
+		return "", err
+	}
+	after, err := modConfigContentAt(cfg, override, folder, relativePath, to)
+	if err != nil {
+		return "", err
+	}
+	if to == "" {
+		to = "current"
+	}
+	return unifiedDiff(relativePath+"@"+from, relativePath+"@"+to, before, after), nil
+}
+
+// revertModConfig writes a stored version back as a new version.
+func revertModConfig(cfg *agent.InstanceConfig, override, folder, relativePath, id, author, expectedHash string) (modConfigVersion, error) {
+	if id == "" || id == "current" {
+		return modConfigVersion{}, fmt.Errorf("version_id required")
+	}
+	content, err := modConfigContentAt(cfg, override, folder, relativePath, id)
+	if err != nil {
+		return modConfigVersion{}, err
+	}
+	return saveModConfigVersioned(cfg, override, modConfigWrite{
+		Folder: folder, Path: relativePath, Content: content, Author: author,
+		ExpectedHash: expectedHash, Action: "revert", RevertedFrom: id,
+	})
+}
+
+// unifiedDiff renders a line diff with three lines of context. Mod configs
+// are capped at 64 KiB, so the quadratic LCS table stays small.
+func unifiedDiff(fromName, toName, before, after string) string {
+	a := splitDiffLines(before)
+	b := splitDiffLines(after)
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	type diffLine struct {
+		kind         byte
+		text         string
+		aLine, bLine int
+	}
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i], i, j})
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+			lines = append(lines, diffLine{'+', b[j], i, j})
+			j++
+		default:
+			lines = append(lines, diffLine{'-', a[i], i, j})
+			i++
+		}
+	}
+
+	const context = 3
+	var out strings.Builder
+	for index := 0; index < len(lines); {
+		if lines[index].kind == ' ' {
+			index++
+			continue
+		}
+		start := index - context
+		if start < 0 {
+			start = 0
+		}
+		end := index
+		for end < len(lines) {
+			if lines[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(lines) && lines[run].kind == ' ' {
+				run++
+			}
+			if run == len(lines) || run-end > 2*context {
+				end += context
+				if end > len(lines) {
+					end = len(lines)
+				}
+				break
+			}
+			end = run
+		}
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		aCount, bCount := 0, 0
+		for _, line := range lines[start:end] {
+			if line.kind != '+' {
+				aCount++
+			}
+			if line.kind != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", lines[start].aLine+1, aCount, lines[start].bLine+1, bCount)
+		for _, line := range lines[start:end] {
+			out.WriteByte(line.kind)
+			out.WriteString(line.text)
+			out.WriteByte('\n')
+		}
+		index = end
+	}
+	return out.String()
+}
+
+func splitDiffLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
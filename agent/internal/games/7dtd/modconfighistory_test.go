@@ -0,0 +1,64 @@
+package sevendtd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+func TestModConfigHistoryWriteDiffRevert(t *testing.T) {
+	historyRoot := modConfigHistoryRoot
+	t.Cleanup(func() { modConfigHistoryRoot = historyRoot })
+	modConfigHistoryRoot = t.TempDir()
+	install := t.TempDir()
+	cfg := &agent.InstanceConfig{InstallPath: install, ServerInstanceID: "test"}
+	original := "<configs>\n\t<set xpath=\"/a/@b\">1</set>\n</configs>\n"
+	writeModlet(t, filepath.Join(install, "Mods"), "Tweaks", map[string]string{"Config/items.xml": original})
+
+	edit := func(content, expected string) (modConfigVersion, error) {
+		return saveModConfigVersioned(cfg, "", modConfigWrite{Folder: "Tweaks", Path: "Config/items.xml", Content: content, Author: "alice", ExpectedHash: expected, Action: "write"})
+	}
+	if _, err := edit("<configs><set>", ""); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Fatalf("malformed XML err = %v", err)
+	}
+	edited := strings.Replace(original, ">1<", ">2<", 1)
+	version, err := edit(edited, contentHash([]byte(original)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version.Author != "alice" || version.Hash != contentHash([]byte(edited)) {
+		t.Fatalf("version = %+v", version)
+	}
+	if _, err := edit(original, contentHash([]byte(original))); err == nil || !strings.Contains(err.Error(), "changed since") {
+		t.Fatalf("stale hash err = %v", err)
+	}
+
+	history, hash, err := modConfigHistoryFor(cfg, "", "Tweaks", "Config/items.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Versions) != 2 || history.Versions[0].Action != "original" || hash != version.Hash {
+		t.Fatalf("history = %+v hash = %s", history, hash)
+	}
+	diff, err := diffModConfig(cfg, "", "Tweaks", "Config/items.xml", history.Versions[0].ID, "current")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff, "-\t<set xpath=\"/a/@b\">1</set>\n+\t<set xpath=\"/a/@b\">2</set>\n") || !strings.HasPrefix(diff, "--- Config/items.xml@") {
+		t.Fatalf("diff:\n%s", diff)
+	}
+
+	reverted, err := revertModConfig(cfg, "", "Tweaks", "Config/items.xml", history.Versions[0].ID, "bob", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reverted.Action != "revert" || reverted.RevertedFrom != history.Versions[0].ID {
+		t.Fatalf("reverted = %+v", reverted)
+	}
+	if content, _ := os.ReadFile(filepath.Join(install, "Mods", "Tweaks", "Config", "items.xml")); string(content) != original {
+		t.Fatalf("reverted content = %q", content)
+	}
+}
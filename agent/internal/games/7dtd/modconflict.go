@@ -0,0 +1,267 @@
+package sevendtd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mastermind/agent/internal/agent"
+	"github.com/mastermind/agent/internal/xmltree"
+)
+
+// modPatchOperations are the XPath patch elements 7DTD applies from a
+// modlet's Config/*.xml files.
+var modPatchOperations = map[string]bool{
+	"set": true, "setattribute": true, "append": true, "remove": true,
+	"removeattribute": true, "insertbefore": true, "insertafter": true, "csv": true,
+}
+
+// modPatch is one XPath operation in a modlet config file.
+type modPatch struct {
+	Mod       string `json:"mod"`
+	Folder    string `json:"folder"`
+	LoadOrder int    `json:"loadOrder"`
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Op        string `json:"op"`
+	XPath     string `json:"xpath"`
+	Value     string `json:"value,omitempty"`
+
+	// target is the normalized XPath of the node or attribute the patch
+	// changes; attribute operations name their attribute separately.
+	target string
+	node   *xmltree.Node
+}
+
+type modConflict struct {
+	Severity string     `json:"severity"`
+	File     string     `json:"file"`
+	Relation string     `json:"relation"`
+	Message  string     `json:"message"`
+	Patches  []modPatch `json:"patches"`
+}
+
+type modPatchError struct {
+	Folder string `json:"folder"`
+	File   string `json:"file"`
+	Line   int    `json:"line,omitempty"`
+	Error  string `json:"error"`
+}
+
+type modConflictReport struct {
+	Mods      []string        `json:"loadOrder"`
+	Patches   int             `json:"patches"`
+	Conflicts []modConflict   `json:"conflicts"`
+	Errors    []modPatchError `json:"errors"`
+	Summary   map[string]int  `json:"summary"`
+}
+
+var modConflictSeverityRank = map[string]int{"error": 0, "warning": 1, "info": 2}
+
+// modLoadOrder returns the active mod folders in the order 7DTD loads them:
+// alphabetically by folder name, ignoring case.
+func modLoadOrder(root string) ([]modInfo, error) {
+	mods, err := listModsAt(root)
+	if err != nil {
+		return nil, err
+	}
+	active := make([]modInfo, 0, len(mods))
+	for _, mod := range mods {
+		if !strings.HasPrefix(mod.Folder, ".") {
+			active = append(active, mod)
+		}
+	}
+	sort.SliceStable(active, func(i, j int) bool {
+		return strings.ToLower(active[i].Folder) < strings.ToLower(active[j].Folder)
+	})
+	return active, nil
+}
+
+// modPatchFiles lists a mod's XML patch files relative to its Config folder.
+func modPatchFiles(modRoot string) []string {
+	files := []string{}
+	for _, relative := range findModConfigFiles(modRoot) {
+		parts := strings.SplitN(relative, "/", 2)
+		if len(parts) == 2 && strings.EqualFold(parts[0], "Config") && strings.EqualFold(filepath.Ext(relative), ".xml") {
+			files = append(files, parts[1])
+		}
+	}
+	return files
+}
+
+// readModPatches parses the patch operations in one mod, reading files through
+// read so callers can substitute unsaved content. Malformed files are
+// reported as errors and skipped.
+func readModPatches(modRoot string, mod modInfo, loadOrder int, read func(string) ([]byte, error)) ([]modPatch, []modPatchError) {
+	var patches []modPatch
+	var errors []modPatchError
+	for _, file := range modPatchFiles(modRoot) {
+		content, err := read(filepath.Join(modRoot, "Config", filepath.FromSlash(file)))
+		if err != nil {
+			errors = append(errors, modPatchError{Folder: mod.Folder, File: file, Error: err.Error()})
+			continue
+		}
+		document, err := xmltree.ParseBytes(content)
+		if err != nil {
+			patchErr := modPatchError{Folder: mod.Folder, File: file, Error: err.Error()}
+			if syntax, ok := err.(*xmltree.SyntaxError); ok {
+				patchErr.Line, patchErr.Error = syntax.Line, syntax.Msg
+			}
+			errors = append(errors, patchErr)
+			continue
+		}
+		for _, element := range document.Root().Elements("") {
+			op := strings.ToLower(element.Name)
+			if !modPatchOperations[op] {
+				continue
+			}
+			xpath := strings.TrimSpace(element.AttrValue("xpath"))
+			if xpath == "" {
+				errors = append(errors, modPatchError{Folder: mod.Folder, File: file, Line: element.Line, Error: op + " has no xpath attribute"})
+				continue
+			}
+			patch := modPatch{
+				Mod: mod.Name, Folder: mod.Folder, LoadOrder: loadOrder, File: file,
+				Line: element.Line, Op: op, XPath: xpath, target: normalizeXPath(xpath), node: element,
+			}
+			switch op {
+			case "set", "setattribute":
+				patch.Value = strings.TrimSpace(element.Text())
+			case "csv":
+				patch.Value = strings.ToLower(element.AttrValue("op")) + " " + strings.TrimSpace(element.Text())
+			}
+			if op == "setattribute" || op == "removeattribute" {
+				patch.target += "/@" + strings.TrimSpace(element.AttrValue("name"))
+			}
+			patches = append(patches, patch)
+		}
+	}
+	return patches, errors
+}
+
+// normalizeXPath makes textually equivalent XPaths comparable: whitespace
+// outside literals is dropped and single quotes become double quotes.
+func normalizeXPath(xpath string) string {
+	var b strings.Builder
+	var quote rune
+	for _, r := range xpath {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+				r = '"'
+			}
+			b.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			b.WriteRune('"')
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return strings.TrimRight(b.String(), "/")
+}
+
+// xpathRelation reports whether b targets the same node as a, a descendant of
+// it ("descendant") or an ancestor of it ("ancestor"), judged textually.
+func xpathRelation(a, b string) string {
+	switch {
+	case a == b:
+		return "same"
+	case strings.HasPrefix(b, a) && (b[len(a)] == '/' || b[len(a)] == '['):
+		return "descendant"
+	case strings.HasPrefix(a, b) && (a[len(b)] == '/' || a[len(b)] == '['):
+		return "ancestor"
+	}
+	return ""
+}
+
+func isRemoval(op string) bool    { return op == "remove" || op == "removeattribute" }
+func isAssignment(op string) bool { return op == "set" || op == "setattribute" }
+
+// classifyModConflict grades two patches from different mods, where first
+// loads before second, returning the severity and a human-readable reason.
+func classifyModConflict(first, second modPatch, relation string) (string, string) {
+	// relation describes second's target relative to first's.
+	switch {
+	case isRemoval(first.Op) && (relation == "same" || relation == "descendant"):
+		return "error", fmt.Sprintf("%s removes the target before %s patches it; the later %s will match nothing", first.Mod, second.Mod, second.Op)
+	case isRemoval(second.Op) && (relation == "same" || relation == "ancestor"):
+		return "warning", fmt.Sprintf("%s removes what %s changed; the earlier %s is discarded", second.Mod, first.Mod, first.Op)
+	case relation == "same" && isAssignment(first.Op) && isAssignment(second.Op):
+		if first.Value == second.Value {
+			return "info", fmt.Sprintf("%s and %s set the same value", first.Mod, second.Mod)
+		}
+		return "warning", fmt.Sprintf("%s and %s set different values; %s wins by load order", first.Mod, second.Mod, second.Mod)
+	case relation == "same" && (first.Op == "csv" || second.Op == "csv") && (isAssignment(first.Op) || isAssignment(second.Op)):
+		return "warning", fmt.Sprintf("%s %s and %s %s edit the same value differently", first.Mod, first.Op, second.Mod, second.Op)
+	case relation == "ancestor" && second.Op == "set":
+		return "warning", fmt.Sprintf("%s replaces content that %s patched earlier", second.Mod, first.Mod)
+	case relation == "descendant" && second.Op == "set" && first.Op == "set":
+		return "info", fmt.Sprintf("%s and %s set overlapping targets", first.Mod, second.Mod)
+	}
+	return "info", fmt.Sprintf("%s %s and %s %s touch overlapping targets", first.Mod, first.Op, second.Mod, second.Op)
+}
+
+// scanModConflicts compares every patch against the patches of later mods on
+// the same config file.
+func scanModConflicts(root string) (modConflictReport, error) {
+	mods, err := modLoadOrder(root)
+	if err != nil {
+		return modConflictReport{}, err
+	}
+	report := modConflictReport{Mods: []string{}, Conflicts: []modConflict{}, Errors: []modPatchError{}, Summary: map[string]int{"error": 0, "warning": 0, "info": 0}}
+	byFile := map[string][]modPatch{}
+	for index, mod := range mods {
+		report.Mods = append(report.Mods, mod.Folder)
+		patches, errors := readModPatches(filepath.Join(root, mod.Folder), mod, index+1, os.ReadFile)
+		report.Errors = append(report.Errors, errors...)
+		report.Patches += len(patches)
+		for _, patch := range patches {
+			key := strings.ToLower(patch.File)
+			byFile[key] = append(byFile[key], patch)
+		}
+	}
+	for _, patches := range byFile {
+		for i, first := range patches {
+			for j := i + 1; j < len(patches); j++ {
+				second := patches[j]
+				if second.Folder == first.Folder {
+					continue
+				}
+				relation := xpathRelation(first.target, second.target)
+				if relation == "" {
+					continue
+				}
+				severity, message := classifyModConflict(first, second, relation)
+				report.Conflicts = append(report.Conflicts, modConflict{
+					Severity: severity, File: first.File, Relation: relation, Message: message,
+					Patches: []modPatch{first, second},
+				})
+				report.Summary[severity]++
+			}
+		}
+	}
+	sort.SliceStable(report.Conflicts, func(i, j int) bool {
+		a, b := report.Conflicts[i], report.Conflicts[j]
+		if a.Severity != b.Severity {
+			return modConflictSeverityRank[a.Severity] < modConflictSeverityRank[b.Severity]
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Patches[0].LoadOrder < b.Patches[0].LoadOrder
+	})
+	return report, nil
+}
+
+func modConflictScan(cfg *agent.InstanceConfig, override string) (modConflictReport, error) {
+	root, err := modsPath(cfg, override)
+	if err != nil {
+		return modConflictReport{}, err
+	}
+	return scanModConflicts(root)
+}
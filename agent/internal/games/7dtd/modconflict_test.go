@@ -0,0 +1,56 @@
+package sevendtd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeModlet(t *testing.T, root, folder string, files map[string]string) {
+	t.Helper()
+	files["ModInfo.xml"] = modInfoXML(folder, "1.0")
+	for name, content := range files {
+		path := filepath.Join(root, folder, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestScanModConflictsUsesLoadOrder(t *testing.T) {
+	root := t.TempDir()
+	writeModlet(t, root, "A-Remover", map[string]string{"Config/items.xml": `<configs>
+	<remove xpath="/items/item[@name='gunPistol']" />
+</configs>`})
+	writeModlet(t, root, "B-Tweaks", map[string]string{"Config/items.xml": `<configs>
+	<set xpath="/items/item[@name=&quot;gunPistol&quot;]/property[@name='Magazine']/@value">20</set>
+	<set xpath="/items/item[@name='ammo9mm']/property[@name='Stack']/@value">500</set>
+</configs>`})
+	writeModlet(t, root, "C-Stacks", map[string]string{
+		"Config/items.xml": `<configs>
+	<setattribute xpath="/items/item[@name='ammo9mm']/property[@name='Stack']" name="value">1000</setattribute>
+</configs>`,
+		"Config/broken.xml": "<configs>\n<set></configs>",
+	})
+
+	report, err := scanModConflicts(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Patches != 4 || len(report.Conflicts) != 2 {
+		t.Fatalf("report = %+v", report)
+	}
+	first, second := report.Conflicts[0], report.Conflicts[1]
+	if first.Severity != "error" || first.Patches[0].Folder != "A-Remover" || first.Patches[1].Line != 2 {
+		t.Fatalf("first conflict = %+v", first)
+	}
+	if second.Severity != "warning" || second.Relation != "same" || second.Patches[1].Folder != "C-Stacks" {
+		t.Fatalf("second conflict = %+v", second)
+	}
+	if len(report.Errors) != 1 || report.Errors[0].File != "broken.xml" || report.Errors[0].Line != 2 {
+		t.Fatalf("errors = %+v", report.Errors)
+	}
+}
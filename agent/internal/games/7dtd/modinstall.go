@@ -0,0 +1,504 @@
+package sevendtd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+// Quarantined mods, replaced mod versions and per-install records live
+// outside the game tree. They are variables so tests can point them at
+// temporary directories.
+var (
+	modQuarantineRoot   = "/var/lib/mastermind-agent/mod-quarantine"
+	modBackupRoot       = "/var/lib/mastermind-agent/mod-backups"
+	modInstallStateRoot = "/var/lib/mastermind-agent/mod-installs"
+)
+
+const modBackupRetention = 5
+
+type modDependency struct {
+	Name       string `json:"name"`
+	MinVersion string `json:"minVersion,omitempty"`
+}
+
+type modInstallResult struct {
+	Folder          string          `json:"folder"`
+	Name            string          `json:"name"`
+	Version         string          `json:"version,omitempty"`
+	Dependencies    []modDependency `json:"dependencies,omitempty"`
+	Action          string          `json:"action"`
+	ReplacedFolder  string          `json:"replacedFolder,omitempty"`
+	PreviousVersion string          `json:"previousVersion,omitempty"`
+	BackupPath      string          `json:"backupPath,omitempty"`
+	KeptConfigFiles []string        `json:"keptConfigFiles,omitempty"`
+}
+
+// modInstallRecord remembers the hash of every config file as shipped, so a
+// later update can tell user edits apart from the mod's own defaults.
+type modInstallRecord struct {
+	Name         string            `json:"name"`
+	Version      string            `json:"version,omitempty"`
+	InstalledAt  time.Time         `json:"installedAt"`
+	ConfigHashes map[string]string `json:"configHashes"`
+}
+
+type modInstallOptions struct {
+	// Update keeps config files the user edited since the previous install.
+	Update         bool
+	AllowDowngrade bool
+	// Reserve is the free space the install must leave on the Mods
+	// filesystem.
+	Reserve int64
+}
+
+// readModDependencies reads <Dependency value="Name" version="1.2" /> entries
+// (name/minVersion are accepted as attribute aliases) from ModInfo.xml.
+func readModDependencies(path string) ([]modDependency, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dependencies := []modDependency{}
+	decoder := xml.NewDecoder(f)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || !strings.EqualFold(start.Name.Local, "Dependency") {
+			continue
+		}
+		var dependency modDependency
+		for _, attr := range start.Attr {
+			switch strings.ToLower(attr.Name.Local) {
+			case "value", "name":
+				dependency.Name = strings.TrimSpace(attr.Value)
+			case "version", "minversion":
+				dependency.MinVersion = strings.TrimSpace(attr.Value)
+			}
+		}
+		if dependency.Name != "" {
+			dependencies = append(dependencies, dependency)
+		}
+	}
+	return dependencies, nil
+}
+
+var modVersionPartPattern = regexp.MustCompile(`\d+|[A-Za-z]+`)
+
+// compareModVersions orders dotted mod versions numerically part by part,
+// so 1.10 sorts after 1.9. Non-numeric parts compare case-insensitively.
+func compareModVersions(a, b string) int {
+	left := modVersionPartPattern.FindAllString(a, -1)
+	right := modVersionPartPattern.FindAllString(b, -1)
+	for i := 0; i < len(left) || i < len(right); i++ {
+		if i >= len(left) {
+			return -1
+		}
+		if i >= len(right) {
+			return 1
+		}
+		leftNumber, leftErr := strconv.Atoi(left[i])
+		rightNumber, rightErr := strconv.Atoi(right[i])
+		switch {
+		case leftErr == nil && rightErr == nil:
+			if leftNumber != rightNumber {
+				if leftNumber < rightNumber {
+					return -1
+				}
+				return 1
+			}
+		default:
+			if c := strings.Compare(strings.ToLower(left[i]), strings.ToLower(right[i])); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+func fileHash(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return contentHash(content), nil
+}
+
+func modConfigHashes(modRoot string) map[string]string {
+	hashes := map[string]string{}
+	for _, relative := range findModConfigFiles(modRoot) {
+		if hash, err := fileHash(filepath.Join(modRoot, filepath.FromSlash(relative))); err == nil {
+			hashes[relative] = hash
+		}
+	}
+	return hashes
+}
+
+func modInstallRecordPath(serverKey, folder string) string {
+	return filepath.Join(modInstallStateRoot, serverKey, folder+".json")
+}
+
+func readModInstallRecord(serverKey, folder string) (modInstallRecord, bool) {
+	var record modInstallRecord
+	content, err := os.ReadFile(modInstallRecordPath(serverKey, folder))
+	if err != nil || json.Unmarshal(content, &record) != nil {
+		return modInstallRecord{}, false
+	}
+	return record, true
+}
+
+func writeModInstallRecord(serverKey, folder string, record modInstallRecord) error {
+	path := modInstallRecordPath(serverKey, folder)
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFileAtomically(path, append(content, '\n'), 0640)
+}
+
+// keepEditedModConfigs copies config files from the installed version over the
+// staged one when the user changed them after install. Without an install
+// record the shipped defaults are unknown, so every differing file is kept.
+func keepEditedModConfigs(installed, staged string, record modInstallRecord, haveRecord bool) ([]string, error) {
+	kept := []string{}
+	for _, relative := range findModConfigFiles(installed) {
+		source := filepath.Join(installed, filepath.FromSlash(relative))
+		current, err := fileHash(source)
+		if err != nil {
+			return nil, fmt.Errorf("read installed config %s: %w", relative, err)
+		}
+		if haveRecord && record.ConfigHashes[relative] == current {
+			continue
+		}
+		target := filepath.Join(staged, filepath.FromSlash(relative))
+		if shipped, err := fileHash(target); err == nil && shipped == current {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+			return nil, err
+		}
+		if err := copySaveFile(source, target, 0640); err != nil {
+			return nil, fmt.Errorf("keep edited config %s: %w", relative, err)
+		}
+		kept = append(kept, relative)
+	}
+	return kept, nil
+}
+
+type plannedModInstall struct {
+	result   modInstallResult
+	staged   string
+	replaced string
+	// aside is where the replaced folder currently is: the staging
+	// directory during the swap, then its backup.
+	aside  string
+	hashes map[string]string
+}
+
+// installModArchive installs every mod in a ZIP artifact into the Mods folder.
+// Existing folders, or folders whose ModInfo.xml carries the same name, are
+// upgrades: the replaced version is backed up outside the game tree. The swap
+// is all-or-nothing across the archive.
+func installModArchive(cfg *agent.InstanceConfig, override, archivePath, originalName string, options modInstallOptions) ([]modInstallResult, error) {
+	root, err := modsPath(cfg, override)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0750); err != nil {
+		return nil, fmt.Errorf("create mods directory: %w", err)
+	}
+	// An unreadable ZIP fails validation below with a better message.
+	if expanded, err := zipExpandedSize(archivePath); err == nil {
+		if err := checkDiskSpace("the mod install", root, expanded, options.Reserve); err != nil {
+			return nil, err
+		}
+	}
+	stagingRoot, folders, err := stageModArchive(archivePath, originalName, root)
+	if err != nil {
+		return nil, err
+	}
+	keepStaging := false
+	defer func() {
+		if !keepStaging {
+			_ = os.RemoveAll(stagingRoot)
+		}
+	}()
+	serverKey := modServerKey(cfg, root)
+
+	installed, err := listModsAt(root)
+	if err != nil {
+		return nil, err
+	}
+	byFolder := map[string]modInfo{}
+	byName := map[string]modInfo{}
+	for _, mod := range installed {
+		if strings.HasPrefix(mod.Folder, ".") {
+			continue
+		}
+		byFolder[mod.Folder] = mod
+		byName[strings.ToLower(mod.Name)] = mod
+	}
+
+	plans := make([]*plannedModInstall, 0, len(folders))
+	available := map[string]string{}
+	for _, mod := range byFolder {
+		available[strings.ToLower(mod.Name)] = mod.Version
+	}
+	for _, folder := range folders {
+		staged := filepath.Join(stagingRoot, folder)
+		values, err := readModInfo(filepath.Join(staged, "ModInfo.xml"))
+		if err != nil {
+			return nil, fmt.Errorf("read ModInfo.xml of %s: %w", folder, err)
+		}
+		dependencies, err := readModDependencies(filepath.Join(staged, "ModInfo.xml"))
+		if err != nil {
+			return nil, fmt.Errorf("read dependencies of %s: %w", folder, err)
+		}
+		plan := &plannedModInstall{staged: staged, result: modInstallResult{
+			Folder: folder, Name: values["name"], Version: values["version"], Dependencies: dependencies, Action: "installed",
+		}}
+		if plan.result.Name == "" {
+			plan.result.Name = folder
+		}
+		previous, exists := byFolder[folder]
+		if !exists {
+			previous, exists = byName[strings.ToLower(plan.result.Name)]
+		}
+		if exists {
+			if other, taken := byFolder[folder]; taken && other.Folder != previous.Folder {
+				return nil, fmt.Errorf("folder %s is already used by mod %s", folder, other.Name)
+			}
+			plan.replaced = previous.Folder
+			plan.result.ReplacedFolder = previous.Folder
+			plan.result.PreviousVersion = previous.Version
+			plan.result.Action = "reinstalled"
+			if plan.result.Version != "" && previous.Version != "" {
+				switch compareModVersions(plan.result.Version, previous.Version) {
+				case 1:
+					plan.result.Action = "upgraded"
+				case -1:
+					if !options.AllowDowngrade {
+						return nil, fmt.Errorf("%s %s is older than installed %s; set allow_downgrade to install it", plan.result.Name, plan.result.Version, previous.Version)
+					}
+					plan.result.Action = "downgraded"
+				}
+			}
+			delete(available, strings.ToLower(previous.Name))
+		}
+		plans = append(plans, plan)
+	}
+	for _, plan := range plans {
+		available[strings.ToLower(plan.result.Name)] = plan.result.Version
+	}
+	for _, plan := range plans {
+		for _, dependency := range plan.result.Dependencies {
+			version, ok := available[strings.ToLower(dependency.Name)]
+			if !ok {
+				return nil, fmt.Errorf("%s requires mod %s, which is not installed", plan.result.Name, dependency.Name)
+			}
+			if dependency.MinVersion != "" && compareModVersions(version, dependency.MinVersion) < 0 {
+				return nil, fmt.Errorf("%s requires %s %s or newer (installed: %s)", plan.result.Name, dependency.Name, dependency.MinVersion, version)
+			}
+		}
+	}
+
+	for _, plan := range plans {
+		plan.hashes = modConfigHashes(plan.staged)
+		if options.Update && plan.replaced != "" {
+			record, haveRecord := readModInstallRecord(serverKey, plan.replaced)
+			kept, err := keepEditedModConfigs(filepath.Join(root, plan.replaced), plan.staged, record, haveRecord)
+			if err != nil {
+				return nil, err
+			}
+			plan.result.KeptConfigFiles = kept
+		}
+		if err := normalizeModPermissions(plan.staged); err != nil {
+			return nil, fmt.Errorf("normalize mod %s: %w", plan.result.Folder, err)
+		}
+	}
+
+	// Replaced versions move into the staging directory first (same
+	// filesystem, so each rename is atomic) and only leave it once every new
+	// folder is in place.
+	replacedRoot := filepath.Join(stagingRoot, ".replaced")
+	if err := os.Mkdir(replacedRoot, 0750); err != nil {
+		return nil, fmt.Errorf("create replaced mods directory: %w", err)
+	}
+	var swapped []*plannedModInstall
+	restoreReplaced := func(plan *plannedModInstall) {
+		if plan.aside == "" {
+			return
+		}
+		if err := moveModTree(plan.aside, filepath.Join(root, plan.replaced)); err != nil {
+			keepStaging = true
+			return
+		}
+		plan.aside = ""
+	}
+	rollback := func() {
+		for i := len(swapped) - 1; i >= 0; i-- {
+			plan := swapped[i]
+			if err := os.Rename(filepath.Join(root, plan.result.Folder), plan.staged); err != nil {
+				keepStaging = true
+			}
+			restoreReplaced(plan)
+		}
+	}
+	for _, plan := range plans {
+		if plan.replaced != "" {
+			aside := filepath.Join(replacedRoot, plan.replaced)
+			if err := os.Rename(filepath.Join(root, plan.replaced), aside); err != nil {
+				rollback()
+				return nil, fmt.Errorf("move aside %s: %w", plan.replaced, err)
+			}
+			plan.aside = aside
+		}
+		if err := os.Rename(plan.staged, filepath.Join(root, plan.result.Folder)); err != nil {
+			restoreReplaced(plan)
+			rollback()
+			return nil, fmt.Errorf("install %s: %w", plan.result.Folder, err)
+		}
+		swapped = append(swapped, plan)
+	}
+
+	// Replaced versions leave staging only after every new folder is in
+	// place; a failed backup move puts them all back, including those
+	// already moved into the backup directory.
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	backupDir := filepath.Join(modBackupRoot, serverKey)
+	for _, plan := range plans {
+		if plan.replaced == "" {
+			continue
+		}
+		if err := os.MkdirAll(backupDir, 0750); err != nil {
+			rollback()
+			return nil, fmt.Errorf("create mod backup directory: %w", err)
+		}
+		backup := filepath.Join(backupDir, plan.replaced+"-"+stamp)
+		if err := moveModTree(plan.aside, backup); err != nil {
+			rollback()
+			return nil, fmt.Errorf("back up replaced mod %s: %w", plan.replaced, err)
+		}
+		plan.aside = backup
+		plan.result.BackupPath = backup
+	}
+
+	// Every install record is written before anything is pruned, so a failed
+	// write puts back the records already replaced along with the folders.
+	now := time.Now()
+	previousRecords := map[string][]byte{}
+	for _, plan := range plans {
+		path := modInstallRecordPath(serverKey, plan.result.Folder)
+		if content, err := os.ReadFile(path); err == nil || os.IsNotExist(err) {
+			previousRecords[path] = content
+		}
+		record := modInstallRecord{Name: plan.result.Name, Version: plan.result.Version, InstalledAt: now.UTC(), ConfigHashes: plan.hashes}
+		if err := writeModInstallRecord(serverKey, plan.result.Folder, record); err != nil {
+			for path, content := range previousRecords {
+				if content == nil {
+					_ = os.Remove(path)
+				} else {
+					_ = replaceFileAtomically(path, content, 0640)
+				}
+			}
+			rollback()
+			return nil, fmt.Errorf("record install of %s: %w", plan.result.Folder, err)
+		}
+	}
+
+	results := make([]modInstallResult, 0, len(plans))
+	for _, plan := range plans {
+		installedPath := filepath.Join(root, plan.result.Folder)
+		_ = os.Chtimes(installedPath, now, now)
+		if plan.replaced != "" && plan.replaced != plan.result.Folder {
+			_ = os.Remove(modInstallRecordPath(serverKey, plan.replaced))
+		}
+		if plan.replaced != "" {
+			pruneModBackups(filepath.Join(modBackupRoot, serverKey), plan.replaced)
+		}
+		results = append(results, plan.result)
+	}
+	return results, nil
+}
+
+// moveModTree renames source to destination and, when they are on different
+// filesystems, copies it next to destination, renames the copy into place and
+// then removes source, so destination never holds a partial tree.
+func moveModTree(source, destination string) error {
+	err := os.Rename(source, destination)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if _, err := os.Lstat(destination); err == nil {
+		return fmt.Errorf("%s already exists", destination)
+	}
+	staging := filepath.Join(filepath.Dir(destination), ".moving-"+filepath.Base(destination))
+	_ = os.RemoveAll(staging)
+	if err := copySaveTree(source, staging, false); err != nil {
+		_ = os.RemoveAll(staging)
+		return err
+	}
+	if err := os.Rename(staging, destination); err != nil {
+		_ = os.RemoveAll(staging)
+		return err
+	}
+	return os.RemoveAll(source)
+}
+
+// pruneModBackups keeps the newest modBackupRetention backups of folder.
+func pruneModBackups(backupDir, folder string) {
+	entries, err := os.ReadDir(backupDir)
+	if err != nil {
+		return
+	}
+	pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(folder) + `-\d{8}T\d{6}Z$`)
+	backups := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && pattern.MatchString(entry.Name()) {
+			backups = append(backups, entry.Name())
+		}
+	}
+	sort.Strings(backups)
+	for len(backups) > modBackupRetention {
+		_ = os.RemoveAll(filepath.Join(backupDir, backups[0]))
+		backups = backups[1:]
+	}
+}
+
+// modArchiveHash identifies an artifact in job output without exposing paths.
+func modArchiveHash(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	digest := sha256.New()
+	if _, err := io.Copy(digest, f); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(digest.Sum(nil))
+}
@@ -0,0 +1,244 @@
+package sevendtd
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+func writeModZip(t *testing.T, files map[string]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mod.zip")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := zip.NewWriter(f)
+	for name, content := range files {
+		entry, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entry.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func modInfoXML(name, version string, dependencies ...string) string {
+	var b strings.Builder
+	b.WriteString("<xml>\n\t<Name value=\"" + name + "\" />\n\t<Version value=\"" + version + "\" />\n")
+	for _, dependency := range dependencies {
+		b.WriteString("\t<Dependency value=\"" + dependency + "\" />\n")
+	}
+	b.WriteString("</xml>\n")
+	return b.String()
+}
+
+func setupModInstall(t *testing.T) (*agent.InstanceConfig, string) {
+	t.Helper()
+	backupRoot, stateRoot := modBackupRoot, modInstallStateRoot
+	t.Cleanup(func() { modBackupRoot, modInstallStateRoot = backupRoot, stateRoot })
+	modBackupRoot, modInstallStateRoot = t.TempDir(), t.TempDir()
+	install := t.TempDir()
+	return &agent.InstanceConfig{InstallPath: install, ServerInstanceID: "test"}, filepath.Join(install, "Mods")
+}
+
+func TestInstallModArchiveUpgradeKeepsEditedConfig(t *testing.T) {
+	cfg, mods := setupModInstall(t)
+	v1 := writeModZip(t, map[string]string{
+		"Zombies/ModInfo.xml":              modInfoXML("BetterZombies", "1.9"),
+		"Zombies/Config/entityclasses.xml": "<config>v1</config>",
+		"Zombies/Config/items.xml":         "<config>items v1</config>",
+	})
+	results, err := installModArchive(cfg, "", v1, "Zombies.zip", modInstallOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Action != "installed" {
+		t.Fatalf("results = %+v", results)
+	}
+	edited := filepath.Join(mods, "Zombies", "Config", "items.xml")
+	if err := os.WriteFile(edited, []byte("<config>user edit</config>"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	v2 := writeModZip(t, map[string]string{
+		"Zombies/ModInfo.xml":              modInfoXML("BetterZombies", "1.10"),
+		"Zombies/Config/entityclasses.xml": "<config>v2</config>",
+		"Zombies/Config/items.xml":         "<config>items v2</config>",
+	})
+	results, err = installModArchive(cfg, "", v2, "Zombies.zip", modInstallOptions{Update: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := results[0]
+	if result.Action != "upgraded" || result.PreviousVersion != "1.9" || result.BackupPath == "" {
+		t.Fatalf("result = %+v", result)
+	}
+	if strings.Join(result.KeptConfigFiles, ",") != "Config/items.xml" {
+		t.Fatalf("kept = %v, want only the edited file", result.KeptConfigFiles)
+	}
+	if content, _ := os.ReadFile(edited); string(content) != "<config>user edit</config>" {
+		t.Fatalf("edited config = %q", content)
+	}
+	if content, _ := os.ReadFile(filepath.Join(mods, "Zombies", "Config", "entityclasses.xml")); string(content) != "<config>v2</config>" {
+		t.Fatalf("unedited config = %q, want new version", content)
+	}
+	if content, _ := os.ReadFile(filepath.Join(result.BackupPath, "Config", "entityclasses.xml")); string(content) != "<config>v1</config>" {
+		t.Fatalf("backup config = %q, want replaced version", content)
+	}
+	entries, _ := os.ReadDir(mods)
+	if len(entries) != 1 {
+		t.Fatalf("Mods contains %d entries, want only the installed mod", len(entries))
+	}
+}
+
+func TestInstallModArchiveRefusesDowngradeAndMissingDependency(t *testing.T) {
+	cfg, _ := setupModInstall(t)
+	if _, err := installModArchive(cfg, "", writeModZip(t, map[string]string{"A/ModInfo.xml": modInfoXML("A", "2.0")}), "A.zip", modInstallOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := installModArchive(cfg, "", writeModZip(t, map[string]string{"A/ModInfo.xml": modInfoXML("A", "1.5")}), "A.zip", modInstallOptions{})
+	if err == nil || !strings.Contains(err.Error(), "allow_downgrade") {
+		t.Fatalf("downgrade err = %v", err)
+	}
+	_, err = installModArchive(cfg, "", writeModZip(t, map[string]string{"B/ModInfo.xml": modInfoXML("B", "1.0", "0-SCore")}), "B.zip", modInstallOptions{})
+	if err == nil || !strings.Contains(err.Error(), "0-SCore") {
+		t.Fatalf("dependency err = %v", err)
+	}
+	archive := writeModZip(t, map[string]string{
+		"B/ModInfo.xml":     modInfoXML("B", "1.0", "0-SCore"),
+		"SCore/ModInfo.xml": modInfoXML("0-SCore", "1.0"),
+	})
+	if _, err := installModArchive(cfg, "", archive, "bundle.zip", modInstallOptions{}); err != nil {
+		t.Fatalf("dependency shipped in the same archive: %v", err)
+	}
+}
+
+func TestInstallModArchiveRestoresBackedUpFoldersWhenALaterBackupFails(t *testing.T) {
+	cfg, mods := setupModInstall(t)
+	v1 := writeModZip(t, map[string]string{"A/ModInfo.xml": modInfoXML("A", "1.0"), "B/ModInfo.xml": modInfoXML("B", "1.0")})
+	if _, err := installModArchive(cfg, "", v1, "bundle.zip", modInstallOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// Occupy every backup name B could get in the next few seconds so its
+	// backup move fails after A's has already succeeded.
+	backupDir := filepath.Join(modBackupRoot, "test")
+	now := time.Now().UTC()
+	for i := 0; i < 5; i++ {
+		blocker := filepath.Join(backupDir, "B-"+now.Add(time.Duration(i)*time.Second).Format("20060102T150405Z"), "keep")
+		if err := os.MkdirAll(blocker, 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v2 := writeModZip(t, map[string]string{"A/ModInfo.xml": modInfoXML("A", "2.0"), "B/ModInfo.xml": modInfoXML("B", "2.0")})
+	if _, err := installModArchive(cfg, "", v2, "bundle.zip", modInstallOptions{}); err == nil {
+		t.Fatal("install succeeded although B could not be backed up")
+	}
+	for _, folder := range []string{"A", "B"} {
+		content, err := os.ReadFile(filepath.Join(mods, folder, "ModInfo.xml"))
+		if err != nil || !strings.Contains(string(content), `"1.0"`) {
+			t.Fatalf("%s after rollback = %q, %v; want version 1.0", folder, content, err)
+		}
+	}
+	entries, _ := os.ReadDir(backupDir)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "A-") {
+			t.Fatalf("A's backup %s was left behind instead of restored", entry.Name())
+		}
+	}
+}
+
+func TestInstallModArchiveRestoresFoldersWhenAnInstallRecordFails(t *testing.T) {
+	cfg, mods := setupModInstall(t)
+	v1 := writeModZip(t, map[string]string{"A/ModInfo.xml": modInfoXML("A", "1.0"), "B/ModInfo.xml": modInfoXML("B", "1.0")})
+	if _, err := installModArchive(cfg, "", v1, "bundle.zip", modInstallOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// A directory in place of B's record makes its write fail after A's
+	// record has already been replaced.
+	blocked := modInstallRecordPath("test", "B")
+	if err := os.Remove(blocked); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(blocked, "keep"), 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	v2 := writeModZip(t, map[string]string{"A/ModInfo.xml": modInfoXML("A", "2.0"), "B/ModInfo.xml": modInfoXML("B", "2.0")})
+	if _, err := installModArchive(cfg, "", v2, "bundle.zip", modInstallOptions{}); err == nil {
+		t.Fatal("install succeeded although B's record could not be written")
+	}
+	for _, folder := range []string{"A", "B"} {
+		content, err := os.ReadFile(filepath.Join(mods, folder, "ModInfo.xml"))
+		if err != nil || !strings.Contains(string(content), `"1.0"`) {
+			t.Fatalf("%s after rollback = %q, %v; want version 1.0", folder, content, err)
+		}
+	}
+	if record, ok := readModInstallRecord("test", "A"); !ok || record.Version != "1.0" {
+		t.Fatalf("A's record after rollback = %+v, %v; want version 1.0", record, ok)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(modBackupRoot, "test")); len(entries) != 0 {
+		t.Fatalf("backups left behind: %v", entries)
+	}
+}
+
+func TestMoveModTreeCopiesAcrossFilesystems(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "Mod")
+	if err := os.MkdirAll(filepath.Join(source, "Config"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "Config", "items.xml"), []byte("<config />"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	// /dev/shm is a separate tmpfs on Linux; skip where it is not.
+	parent, err := os.MkdirTemp("/dev/shm", "modtree-")
+	if err != nil {
+		t.Skip("no second filesystem available")
+	}
+	t.Cleanup(func() { os.RemoveAll(parent) })
+	destination := filepath.Join(parent, "Mod-backup")
+	if err := moveModTree(source, destination); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(destination, "Config", "items.xml")); string(content) != "<config />" {
+		t.Fatalf("moved config = %q", content)
+	}
+	if _, err := os.Lstat(source); !os.IsNotExist(err) {
+		t.Fatalf("source still exists: %v", err)
+	}
+	if entries, _ := os.ReadDir(parent); len(entries) != 1 {
+		t.Fatalf("destination parent = %v, want only the moved tree", entries)
+	}
+}
+
+func TestCompareModVersions(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"1.10", "1.9", 1},
+		{"1.0", "1.0.0", -1},
+		{"2.1b", "2.1a", 1},
+		{"3.0", "3.0", 0},
+	}
+	for _, c := range cases {
+		if got := compareModVersions(c.a, c.b); got != c.want {
+			t.Errorf("compareModVersions(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
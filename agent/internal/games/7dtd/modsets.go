@@ -0,0 +1,342 @@
+package sevendtd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+// modSetStateRoot holds one JSON file per server with its named mod sets.
+var modSetStateRoot = "/var/lib/mastermind-agent/mod-sets"
+
+var modSetNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 ._-]{0,63}$`)
+
+type modSetEntry struct {
+	Folder string `json:"folder"`
+	// Version pins the ModInfo.xml version; empty accepts any version.
+	Version string `json:"version,omitempty"`
+}
+
+type modSet struct {
+	Name      string        `json:"name"`
+	Mods      []modSetEntry `json:"mods"`
+	UpdatedAt time.Time     `json:"updatedAt"`
+}
+
+type modSetState struct {
+	Sets      map[string]modSet `json:"sets"`
+	Active    string            `json:"active,omitempty"`
+	AppliedAt time.Time         `json:"appliedAt,omitempty"`
+}
+
+type modSetMove struct {
+	Folder string `json:"folder"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+
+	source, destination string
+}
+
+type modSetApplyResult struct {
+	Name      string       `json:"name"`
+	Enabled   []string     `json:"enabled"`
+	Disabled  []string     `json:"disabled"`
+	Unchanged []string     `json:"unchanged"`
+	Moves     []modSetMove `json:"moves"`
+}
+
+func modSetStatePath(cfg *agent.InstanceConfig, root string) string {
+	return filepath.Join(modSetStateRoot, modServerKey(cfg, root)+".json")
+}
+
+func loadModSetState(path string) (modSetState, error) {
+	state := modSetState{Sets: map[string]modSet{}}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("read mod sets: %w", err)
+	}
+	if err := json.Unmarshal(content, &state); err != nil {
+		return state, fmt.Errorf("parse mod sets: %w", err)
+	}
+	if state.Sets == nil {
+		state.Sets = map[string]modSet{}
+	}
+	return state, nil
+}
+
+func saveModSetState(path string, state modSetState) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return fmt.Errorf("create mod set directory: %w", err)
+	}
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFileAtomically(path, append(content, '\n'), 0640)
+}
+
+// modSetEntriesFromPayload accepts mods as folder names or {folder, version}
+// objects.
+func modSetEntriesFromPayload(payload map[string]interface{}) ([]modSetEntry, error) {
+	raw, _ := payload["mods"].([]interface{})
+	entries := make([]modSetEntry, 0, len(raw))
+	seen := map[string]bool{}
+	for _, item := range raw {
+		var entry modSetEntry
+		switch value := item.(type) {
+		case string:
+			entry.Folder = value
+		case map[string]interface{}:
+			entry.Folder = getString(value, "folder", "")
+			entry.Version = strings.TrimSpace(getString(value, "version", ""))
+		}
+		if err := validateModFolder(entry.Folder); err != nil {
+			return nil, fmt.Errorf("invalid mod folder in set: %q", entry.Folder)
+		}
+		if seen[entry.Folder] {
+			return nil, fmt.Errorf("mod folder listed twice: %s", entry.Folder)
+		}
+		seen[entry.Folder] = true
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return strings.ToLower(entries[i].Folder) < strings.ToLower(entries[j].Folder) })
+	return entries, nil
+}
+
+// listModSets returns the stored sets and the active set name. Drifted is
+// true when the Mods folder no longer matches the active set.
+func listModSets(cfg *agent.InstanceConfig, override string) (map[string]interface{}, error) {
+	root, err := modsPath(cfg, override)
+	if err != nil {
+		return nil, err
+	}
+	state, err := loadModSetState(modSetStatePath(cfg, root))
+	if err != nil {
+		return nil, err
+	}
+	sets := make([]modSet, 0, len(state.Sets))
+	for _, set := range state.Sets {
+		sets = append(sets, set)
+	}
+	sort.Slice(sets, func(i, j int) bool { return strings.ToLower(sets[i].Name) < strings.ToLower(sets[j].Name) })
+	active, drifted := activeModSet(cfg, override)
+	result := map[string]interface{}{"modSets": sets, "activeModSet": active, "activeModSetDrifted": drifted}
+	if !state.AppliedAt.IsZero() {
+		result["activeModSetAppliedAt"] = state.AppliedAt
+	}
+	return result, nil
+}
+
+// activeModSet reports the recorded active set for MOD_LIST. It is best
+// effort: unreadable state reads as no active set.
+func activeModSet(cfg *agent.InstanceConfig, override string) (string, bool) {
+	root, err := modsPath(cfg, override)
+	if err != nil {
+		return "", false
+	}
+	state, err := loadModSetState(modSetStatePath(cfg, root))
+	if err != nil || state.Active == "" {
+		return "", false
+	}
+	set, ok := state.Sets[state.Active]
+	if !ok {
+		return state.Active, true
+	}
+	mods, err := modLoadOrder(root)
+	if err != nil {
+		return state.Active, false
+	}
+	if len(mods) != len(set.Mods) {
+		return state.Active, true
+	}
+	for i, mod := range mods {
+		if mod.Folder != set.Mods[i].Folder || (set.Mods[i].Version != "" && mod.Version != set.Mods[i].Version) {
+			return state.Active, true
+		}
+	}
+	return state.Active, false
+}
+
+// saveModSet creates or replaces a named set. With fromCurrent the set
+// captures the active Mods folder with its installed versions pinned.
+func saveModSet(cfg *agent.InstanceConfig, override, name string, entries []modSetEntry, fromCurrent bool) (modSet, error) {
+	if !modSetNamePattern.MatchString(name) {
+		return modSet{}, fmt.Errorf("mod set name must be 1-64 letters, digits, spaces, dots, dashes or underscores")
+	}
+	root, err := modsPath(cfg, override)
+	if err != nil {
+		return modSet{}, err
+	}
+	if fromCurrent {
+		mods, err := modLoadOrder(root)
+		if err != nil {
+			return modSet{}, err
+		}
+		entries = make([]modSetEntry, 0, len(mods))
+		for _, mod := range mods {
+			entries = append(entries, modSetEntry{Folder: mod.Folder, Version: mod.Version})
+		}
+	}
+	path := modSetStatePath(cfg, root)
+	state, err := loadModSetState(path)
+	if err != nil {
+		return modSet{}, err
+	}
+	set := modSet{Name: name, Mods: entries, UpdatedAt: time.Now().UTC()}
+	state.Sets[name] = set
+	return set, saveModSetState(path, state)
+}
+
+func deleteModSet(cfg *agent.InstanceConfig, override, name string) error {
+	root, err := modsPath(cfg, override)
+	if err != nil {
+		return err
+	}
+	path := modSetStatePath(cfg, root)
+	state, err := loadModSetState(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := state.Sets[name]; !ok {
+		return fmt.Errorf("mod set not found: %s", name)
+	}
+	delete(state.Sets, name)
+	if state.Active == name {
+		state.Active, state.AppliedAt = "", time.Time{}
+	}
+	return saveModSetState(path, state)
+}
+
+// ApplyModSet makes the Mods folder match a named set: listed mods are
+// restored from quarantine, unlisted ones are quarantined. Every folder and
+// pinned version is checked before anything moves, and a failed move undoes
+// the ones already made.
+func (a *Adapter) ApplyModSet(ctx context.Context, cfg *agent.InstanceConfig, override, name string) (modSetApplyResult, error) {
+	if a.instanceRunning(ctx, cfg) {
+		return modSetApplyResult{}, fmt.Errorf("server must be stopped before switching mod sets")
+	}
+	root, err := modsPath(cfg, override)
+	if err != nil {
+		return modSetApplyResult{}, err
+	}
+	quarantineRoot, err := quarantinePath(cfg, override)
+	if err != nil {
+		return modSetApplyResult{}, err
+	}
+	statePath := modSetStatePath(cfg, root)
+	state, err := loadModSetState(statePath)
+	if err != nil {
+		return modSetApplyResult{}, err
+	}
+	set, ok := state.Sets[name]
+	if !ok {
+		return modSetApplyResult{}, fmt.Errorf("mod set not found: %s", name)
+	}
+	active, err := modLoadOrder(root)
+	if err != nil {
+		return modSetApplyResult{}, err
+	}
+	quarantined, err := listModsAt(quarantineRoot)
+	if err != nil {
+		return modSetApplyResult{}, err
+	}
+	activeByFolder := map[string]modInfo{}
+	for _, mod := range active {
+		activeByFolder[mod.Folder] = mod
+	}
+	quarantinedByFolder := map[string]modInfo{}
+	for _, mod := range quarantined {
+		if !strings.HasPrefix(mod.Folder, ".") {
+			quarantinedByFolder[mod.Folder] = mod
+		}
+	}
+
+	result := modSetApplyResult{Name: name, Enabled: []string{}, Disabled: []string{}, Unchanged: []string{}, Moves: []modSetMove{}}
+	wanted := map[string]bool{}
+	for _, entry := range set.Mods {
+		wanted[entry.Folder] = true
+		mod, isActive := activeByFolder[entry.Folder]
+		if !isActive {
+			var found bool
+			if mod, found = quarantinedByFolder[entry.Folder]; !found {
+				return result, fmt.Errorf("mod %s is neither active nor quarantined", entry.Folder)
+			}
+		}
+		if entry.Version != "" && mod.Version != entry.Version {
+			return result, fmt.Errorf("mod %s is version %q, set pins %q", entry.Folder, mod.Version, entry.Version)
+		}
+		if isActive {
+			result.Unchanged = append(result.Unchanged, entry.Folder)
+			continue
+		}
+		if _, err := realModDirectory(quarantineRoot, entry.Folder); err != nil {
+			return result, fmt.Errorf("quarantined %w", err)
+		}
+		result.Moves = append(result.Moves, modSetMove{Folder: entry.Folder, From: "quarantine", To: "mods",
+			source: filepath.Join(quarantineRoot, entry.Folder), destination: filepath.Join(root, entry.Folder)})
+		result.Enabled = append(result.Enabled, entry.Folder)
+	}
+	for _, mod := range active {
+		if wanted[mod.Folder] {
+			continue
+		}
+		if _, exists := quarantinedByFolder[mod.Folder]; exists {
+			return result, fmt.Errorf("cannot quarantine %s: a quarantined copy already exists", mod.Folder)
+		}
+		if _, err := realModDirectory(root, mod.Folder); err != nil {
+			return result, err
+		}
+		result.Moves = append(result.Moves, modSetMove{Folder: mod.Folder, From: "mods", To: "quarantine",
+			source: filepath.Join(root, mod.Folder), destination: filepath.Join(quarantineRoot, mod.Folder)})
+		result.Disabled = append(result.Disabled, mod.Folder)
+	}
+	if len(result.Disabled) > 0 {
+		if err := os.MkdirAll(quarantineRoot, 0750); err != nil {
+			return result, fmt.Errorf("create quarantine directory: %w", err)
+		}
+	}
+
+	for index, move := range result.Moves {
+		err := ctx.Err()
+		if err == nil {
+			err = moveModTree(move.source, move.destination)
+		}
+		if err != nil {
+			var rollbackErrors []string
+			for i := index - 1; i >= 0; i-- {
+				undo := result.Moves[i]
+				if undoErr := moveModTree(undo.destination, undo.source); undoErr != nil {
+					rollbackErrors = append(rollbackErrors, undo.Folder+": "+undoErr.Error())
+				}
+			}
+			if len(rollbackErrors) > 0 {
+				return result, fmt.Errorf("move %s: %v; rollback failed: %s", move.Folder, err, strings.Join(rollbackErrors, "; "))
+			}
+			return result, fmt.Errorf("move %s: %w; earlier moves were rolled back", move.Folder, err)
+		}
+	}
+	now := time.Now()
+	for _, folder := range result.Enabled {
+		enabled := filepath.Join(root, folder)
+		if err := normalizeModPermissions(enabled); err != nil {
+			return result, fmt.Errorf("make restored mod readable by game server: %w", err)
+		}
+		_ = os.Chtimes(enabled, now, now)
+	}
+	state.Active, state.AppliedAt = name, now.UTC()
+	if err := saveModSetState(statePath, state); err != nil {
+		return result, fmt.Errorf("mods switched but the active set was not recorded: %w", err)
+	}
+	return result, nil
+}
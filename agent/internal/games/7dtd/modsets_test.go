@@ -0,0 +1,60 @@
+package sevendtd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+func TestApplyModSetSwitchesAndRecordsActiveSet(t *testing.T) {
+	stateRoot, quarantineRoot := modSetStateRoot, modQuarantineRoot
+	t.Cleanup(func() { modSetStateRoot, modQuarantineRoot = stateRoot, quarantineRoot })
+	modSetStateRoot, modQuarantineRoot = t.TempDir(), t.TempDir()
+	install := t.TempDir()
+	cfg := &agent.InstanceConfig{InstallPath: install, ServerInstanceID: "test"}
+	mods := filepath.Join(install, "Mods")
+	writeModlet(t, mods, "Base", map[string]string{})
+	writeModlet(t, mods, "Weekday", map[string]string{})
+	quarantine, err := quarantinePath(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeModlet(t, quarantine, "EventHorde", map[string]string{})
+
+	if _, err := saveModSet(cfg, "", "event", []modSetEntry{{Folder: "Base", Version: "1.0"}, {Folder: "EventHorde"}}, false); err != nil {
+		t.Fatal(err)
+	}
+	a := NewAdapter()
+	a.isRunning = func(context.Context) bool { return true }
+	if _, err := a.ApplyModSet(context.Background(), cfg, "", "event"); err == nil {
+		t.Fatal("mod set applied while the server was running")
+	}
+	a.isRunning = func(context.Context) bool { return false }
+	result, err := a.ApplyModSet(context.Background(), cfg, "", "event")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(result.Enabled, ",") != "EventHorde" || strings.Join(result.Disabled, ",") != "Weekday" {
+		t.Fatalf("result = %+v", result)
+	}
+	if _, err := os.Stat(filepath.Join(quarantine, "Weekday", "ModInfo.xml")); err != nil {
+		t.Fatalf("Weekday was not quarantined: %v", err)
+	}
+	if active, drifted := activeModSet(cfg, ""); active != "event" || drifted {
+		t.Fatalf("active = %q drifted = %v", active, drifted)
+	}
+
+	if _, err := saveModSet(cfg, "", "pinned", []modSetEntry{{Folder: "Base", Version: "2.0"}}, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.ApplyModSet(context.Background(), cfg, "", "pinned"); err == nil || !strings.Contains(err.Error(), "pins") {
+		t.Fatalf("pinned version mismatch err = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(mods, "EventHorde")); err != nil {
+		t.Fatalf("failed apply moved mods: %v", err)
+	}
+}
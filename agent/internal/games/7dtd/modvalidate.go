@@ -0,0 +1,351 @@
+package sevendtd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mastermind/agent/internal/agent"
+	"github.com/mastermind/agent/internal/xmltree"
+)
+
+type modValidationIssue struct {
+	Severity string `json:"severity"`
+	Folder   string `json:"folder"`
+	Mod      string `json:"mod,omitempty"`
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Op       string `json:"op,omitempty"`
+	XPath    string `json:"xpath,omitempty"`
+	Message  string `json:"message"`
+}
+
+type modValidationReport struct {
+	LoadOrder []string             `json:"loadOrder"`
+	Patches   int                  `json:"patches"`
+	Applied   int                  `json:"applied"`
+	Issues    []modValidationIssue `json:"issues"`
+	Summary   map[string]int       `json:"summary"`
+	Valid     bool                 `json:"valid"`
+}
+
+// modSource is a mod to validate and the directory its files are read from,
+// which for a quarantined mod is outside the Mods folder.
+type modSource struct {
+	info modInfo
+	root string
+}
+
+func (r *modValidationReport) add(issue modValidationIssue) {
+	r.Issues = append(r.Issues, issue)
+	r.Summary[issue.Severity]++
+	if issue.Severity == "error" {
+		r.Valid = false
+	}
+}
+
+// errorsFor returns the error-severity issues raised by one mod.
+func (r modValidationReport) errorsFor(folder string) []modValidationIssue {
+	var issues []modValidationIssue
+	for _, issue := range r.Issues {
+		if issue.Severity == "error" && issue.Folder == folder {
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
+
+// validateModPatches applies every mod's patches in load order to in-memory
+// copies of the vanilla Data/Config files, the way the game does at startup.
+// Malformed XML, invalid XPaths and failing operations are errors; patches
+// that match nothing are warnings because the game skips them.
+func validateModPatches(dataConfig string, mods []modSource, read func(string) ([]byte, error)) (modValidationReport, error) {
+	if info, err := os.Stat(dataConfig); err != nil || !info.IsDir() {
+		return modValidationReport{}, fmt.Errorf("vanilla config directory not found: %s", dataConfig)
+	}
+	report := modValidationReport{LoadOrder: []string{}, Issues: []modValidationIssue{}, Summary: map[string]int{"error": 0, "warning": 0}, Valid: true}
+	documents := map[string]*xmltree.Node{}
+	missing := map[string]bool{}
+	for index, mod := range mods {
+		report.LoadOrder = append(report.LoadOrder, mod.info.Folder)
+		patches, errors := readModPatches(mod.root, mod.info, index+1, read)
+		for _, patchErr := range errors {
+			report.add(modValidationIssue{Severity: "error", Folder: patchErr.Folder, Mod: mod.info.Name, File: patchErr.File, Line: patchErr.Line, Message: patchErr.Error})
+		}
+		reportedMissing := map[string]bool{}
+		for _, patch := range patches {
+			report.Patches++
+			issue := modValidationIssue{Folder: patch.Folder, Mod: patch.Mod, File: patch.File, Line: patch.Line, Op: patch.Op, XPath: patch.XPath}
+			key := strings.ToLower(patch.File)
+			document, loaded := documents[key]
+			if !loaded && !missing[key] {
+				content, err := os.ReadFile(filepath.Join(dataConfig, filepath.FromSlash(patch.File)))
+				if err == nil {
+					document, err = xmltree.ParseBytes(content)
+				}
+				if err != nil {
+					missing[key] = true
+				} else {
+					documents[key] = document
+				}
+			}
+			if document == nil {
+				if !reportedMissing[key] {
+					reportedMissing[key] = true
+					issue.Severity, issue.Message = "warning", "no readable vanilla Data/Config/"+patch.File+" to patch"
+					report.add(issue)
+				}
+				continue
+			}
+			matched, err := applyModPatch(document, patch)
+			switch {
+			case err != nil:
+				issue.Severity, issue.Message = "error", err.Error()
+				report.add(issue)
+			case matched == 0:
+				issue.Severity, issue.Message = "warning", "xpath matched no nodes"
+				report.add(issue)
+			default:
+				report.Applied++
+			}
+		}
+	}
+	return report, nil
+}
+
+// applyModPatch applies one operation and returns how many nodes it matched.
+func applyModPatch(document *xmltree.Node, patch modPatch) (int, error) {
+	xpath, err := xmltree.CompileXPath(patch.XPath)
+	if err != nil {
+		return 0, err
+	}
+	matches, err := xpath.Select(document)
+	if err != nil {
+		return 0, err
+	}
+	value := patch.node.Text()
+	for _, match := range matches {
+		element := match.Node
+		if match.Attr == "" && element.Kind != xmltree.ElementNode {
+			return 0, fmt.Errorf("%s target is not an element or attribute", patch.Op)
+		}
+		switch patch.Op {
+		case "set":
+			if match.Attr != "" {
+				element.SetAttr(match.Attr, value)
+			} else {
+				element.SetText(value)
+			}
+		case "setattribute", "removeattribute":
+			name := strings.TrimSpace(patch.node.AttrValue("name"))
+			if name == "" {
+				return 0, fmt.Errorf("%s requires a name attribute", patch.Op)
+			}
+			if match.Attr != "" {
+				return 0, fmt.Errorf("%s must target an element, not an attribute", patch.Op)
+			}
+			if patch.Op == "setattribute" {
+				element.SetAttr(name, value)
+			} else {
+				element.RemoveAttr(name)
+			}
+		case "append":
+			if match.Attr != "" {
+				element.SetAttr(match.Attr, match.Value()+value)
+				continue
+			}
+			for _, child := range patch.node.Elements("") {
+				element.AppendElement(child.Clone())
+			}
+		case "insertbefore", "insertafter":
+			if match.Attr != "" || element.Parent == nil || element.Parent.Kind != xmltree.ElementNode {
+				return 0, fmt.Errorf("%s must target an element below the root", patch.Op)
+			}
+			reference := element
+			for _, child := range patch.node.Elements("") {
+				clone := child.Clone()
+				if patch.Op == "insertbefore" {
+					element.Parent.InsertBefore(element, clone)
+				} else {
+					element.Parent.InsertAfter(reference, clone)
+					reference = clone
+				}
+			}
+		case "remove":
+			if match.Attr != "" {
+				element.RemoveAttr(match.Attr)
+			} else if element.Parent != nil {
+				element.Parent.RemoveChild(element)
+			}
+		case "csv":
+			if err := applyCSVPatch(match, patch); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(matches), nil
+}
+
+// applyCSVPatch adds or removes delimited items in an attribute value.
+func applyCSVPatch(match xmltree.Match, patch modPatch) error {
+	if match.Attr == "" {
+		return fmt.Errorf("csv must target an attribute")
+	}
+	delimiter := patch.node.AttrValue("delim")
+	if delimiter == "" {
+		delimiter = ","
+	}
+	split := func(value string) []string {
+		var items []string
+		for _, item := range strings.Split(value, delimiter) {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+	current := split(match.Value())
+	changes := split(patch.node.Text())
+	if len(changes) == 0 {
+		return fmt.Errorf("csv has no values")
+	}
+	switch strings.ToLower(patch.node.AttrValue("op")) {
+	case "add":
+		current = append(current, changes...)
+	case "remove":
+		for _, change := range changes {
+			index := -1
+			for i, item := range current {
+				if item == change {
+					index = i
+					break
+				}
+			}
+			if index < 0 {
+				return fmt.Errorf("csv remove: %q is not in %q", change, match.Value())
+			}
+			current = append(current[:index], current[index+1:]...)
+		}
+	default:
+		return fmt.Errorf("csv op must be add or remove")
+	}
+	match.Node.SetAttr(match.Attr, strings.Join(current, delimiter))
+	return nil
+}
+
+func vanillaConfigPath(cfg *agent.InstanceConfig) (string, error) {
+	if cfg.InstallPath == "" {
+		return "", fmt.Errorf("install_path required")
+	}
+	return filepath.Join(cfg.InstallPath, "Data", "Config"), nil
+}
+
+func activeModSources(root string) ([]modSource, error) {
+	mods, err := modLoadOrder(root)
+	if err != nil {
+		return nil, err
+	}
+	sources := make([]modSource, len(mods))
+	for i, mod := range mods {
+		sources[i] = modSource{info: mod, root: filepath.Join(root, mod.Folder)}
+	}
+	return sources, nil
+}
+
+func validateMods(cfg *agent.InstanceConfig, override string) (modValidationReport, error) {
+	root, err := modsPath(cfg, override)
+	if err != nil {
+		return modValidationReport{}, err
+	}
+	dataConfig, err := vanillaConfigPath(cfg)
+	if err != nil {
+		return modValidationReport{}, err
+	}
+	sources, err := activeModSources(root)
+	if err != nil {
+		return modValidationReport{}, err
+	}
+	return validateModPatches(dataConfig, sources, os.ReadFile)
+}
+
+// preflightError summarizes the errors a change would introduce in folder.
+func preflightError(report modValidationReport, folder string) error {
+	issues := report.errorsFor(folder)
+	if len(issues) == 0 {
+		return nil
+	}
+	first := issues[0]
+	return fmt.Errorf("mod validation failed with %d error(s) in %s; first: %s line %d: %s", len(issues), folder, first.File, first.Line, first.Message)
+}
+
+// preflightModRestore validates the active mods with a quarantined mod added
+// at its load-order position, before it is moved back into Mods.
+func preflightModRestore(cfg *agent.InstanceConfig, override, folder string) (modValidationReport, error) {
+	root, err := modsPath(cfg, override)
+	if err != nil {
+		return modValidationReport{}, err
+	}
+	quarantineRoot, err := quarantinePath(cfg, override)
+	if err != nil {
+		return modValidationReport{}, err
+	}
+	source, err := realModDirectory(quarantineRoot, folder)
+	if err != nil {
+		return modValidationReport{}, fmt.Errorf("quarantined %w", err)
+	}
+	dataConfig, err := vanillaConfigPath(cfg)
+	if err != nil {
+		return modValidationReport{}, err
+	}
+	sources, err := activeModSources(root)
+	if err != nil {
+		return modValidationReport{}, err
+	}
+	info := modInfo{Folder: folder, Name: folder}
+	if values, err := readModInfo(filepath.Join(source, "ModInfo.xml")); err == nil && values["name"] != "" {
+		info.Name = values["name"]
+	}
+	sources = append(sources, modSource{info: info, root: source})
+	sort.SliceStable(sources, func(i, j int) bool {
+		return strings.ToLower(sources[i].info.Folder) < strings.ToLower(sources[j].info.Folder)
+	})
+	report, err := validateModPatches(dataConfig, sources, os.ReadFile)
+	if err != nil {
+		return report, err
+	}
+	return report, preflightError(report, folder)
+}
+
+// preflightModConfigWrite validates the active mods as if relativePath in
+// folder already held content.
+func preflightModConfigWrite(cfg *agent.InstanceConfig, override, folder, relativePath, content string) (modValidationReport, error) {
+	root, err := modsPath(cfg, override)
+	if err != nil {
+		return modValidationReport{}, err
+	}
+	target, err := resolveModConfig(root, folder, relativePath)
+	if err != nil {
+		return modValidationReport{}, err
+	}
+	dataConfig, err := vanillaConfigPath(cfg)
+	if err != nil {
+		return modValidationReport{}, err
+	}
+	sources, err := activeModSources(root)
+	if err != nil {
+		return modValidationReport{}, err
+	}
+	read := func(path string) ([]byte, error) {
+		if path == target {
+			return []byte(content), nil
+		}
+		return os.ReadFile(path)
+	}
+	report, err := validateModPatches(dataConfig, sources, read)
+	if err != nil {
+		return report, err
+	}
+	return report, preflightError(report, folder)
+}
@@ -0,0 +1,65 @@
+package sevendtd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+const vanillaItems = `<items>
+	<item name="gunPistol">
+		<property name="Tags" value="weapon,ranged" />
+		<property name="Magazine" value="15" />
+	</item>
+</items>`
+
+func TestValidateModPatchesReportsFailuresWithLines(t *testing.T) {
+	install := t.TempDir()
+	dataConfig := filepath.Join(install, "Data", "Config")
+	if err := os.MkdirAll(dataConfig, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dataConfig, "items.xml"), []byte(vanillaItems), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mods := filepath.Join(install, "Mods")
+	writeModlet(t, mods, "A-Good", map[string]string{"Config/items.xml": `<configs>
+	<set xpath="/items/item[@name='gunPistol']/property[@name='Magazine']/@value">20</set>
+	<append xpath="/items"><item name="gunSMG" /></append>
+	<csv xpath="/items/item[@name='gunPistol']/property[@name='Tags']/@value" op="add">sidearm</csv>
+</configs>`})
+	writeModlet(t, mods, "B-Broken", map[string]string{"Config/items.xml": `<configs>
+	<set xpath="/items/item[@name='gunSMG']/@name">gunMP5</set>
+	<remove xpath="/items/item[@name='gunRifle']" />
+	<csv xpath="/items/item[@name='gunPistol']/property[@name='Tags']/@value" op="remove">melee</csv>
+</configs>`})
+	writeModlet(t, mods, "C-Malformed", map[string]string{"Config/items.xml": "<configs>\n\t<set xpath=\"/items\">\n</configs>"})
+
+	report, err := validateMods(&agent.InstanceConfig{InstallPath: install}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Patches != 6 || report.Applied != 4 || report.Valid {
+		t.Fatalf("report = %+v", report)
+	}
+	var got []string
+	for _, issue := range report.Issues {
+		got = append(got, fmt.Sprintf("%s %s:%d", issue.Severity, issue.Folder, issue.Line))
+	}
+	want := []string{"warning B-Broken:3", "error B-Broken:4", "error C-Malformed:3"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("issues = %v, want %v", got, want)
+	}
+
+	_, err = preflightModConfigWrite(&agent.InstanceConfig{InstallPath: install}, "", "A-Good", "Config/items.xml", `<configs><set xpath="/items/item[">1</set></configs>`)
+	if err == nil || !strings.Contains(err.Error(), "A-Good") {
+		t.Fatalf("preflight err = %v", err)
+	}
+	if _, err := preflightModConfigWrite(&agent.InstanceConfig{InstallPath: install}, "", "A-Good", "Config/items.xml", `<configs><set xpath="/items/item/@name">x</set></configs>`); err != nil {
+		t.Fatalf("valid edit rejected: %v", err)
+	}
+}
@@ -0,0 +1,307 @@
+package sevendtd
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+// maxPermissionIssues bounds the issues listed in a repair report; the
+// counts always cover the whole tree.
+const maxPermissionIssues = 500
+
+// permissionArea is one tree SAVE_PERMISSIONS_REPAIR audits. The game
+// account must be able to read (and for saves, write) it through the owner
+// or the shared group.
+type permissionArea struct {
+	Name string `json:"name"`
+	Root string `json:"root"`
+	// owners are the accepted uids, none skips the check; group is the
+	// expected gid, -1 skips the check.
+	owners []int
+	group  int
+	// ownerName and groupName are the account the helper assigns on
+	// repair; helperPath is what it is asked to chown, empty when the
+	// helper accepts no path in this area.
+	ownerName, groupName string
+	helperPath           string
+	// fileMode and dirMode are the permission bits every entry must have.
+	fileMode, dirMode os.FileMode
+}
+
+type permissionIssue struct {
+	Area     string   `json:"area"`
+	Path     string   `json:"path"`
+	Problems []string `json:"problems"`
+}
+
+type permissionAreaReport struct {
+	Name       string `json:"name"`
+	Root       string `json:"root"`
+	Checked    int    `json:"checked"`
+	Mismatched int    `json:"mismatched"`
+	Missing    bool   `json:"missing,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type permissionReport struct {
+	ServiceUser  string                 `json:"serviceUser"`
+	ServiceGroup string                 `json:"serviceGroup"`
+	DryRun       bool                   `json:"dryRun"`
+	Areas        []permissionAreaReport `json:"areas"`
+	Issues       []permissionIssue      `json:"issues"`
+	Truncated    bool                   `json:"truncated,omitempty"`
+	Mismatched   int                    `json:"mismatched"`
+	// Repaired and Remaining are filled in after a repair re-audits.
+	Repaired  int      `json:"repaired"`
+	Remaining int      `json:"remaining"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// permissionServiceAccount returns the configured game service user and
+// group, from the payload or the instance config (config.permissions).
+func permissionServiceAccount(payload map[string]interface{}) (string, string, error) {
+	config, _ := payload["config"].(map[string]interface{})
+	settings, _ := config["permissions"].(map[string]interface{})
+	userName := getString(payload, "service_user", getString(settings, "serviceUser", ""))
+	groupName := getString(payload, "service_group", getString(settings, "serviceGroup", ""))
+	if strings.TrimSpace(userName) == "" || strings.TrimSpace(groupName) == "" {
+		return "", "", fmt.Errorf("service_user and service_group are required")
+	}
+	return strings.TrimSpace(userName), strings.TrimSpace(groupName), nil
+}
+
+// permissionAreas lists the trees to audit for cfg; backups is the root of
+// its save backup namespace. Saves and Mods belong to the game account;
+// save backups are written by the agent, except Region Healer snapshots,
+// and profile staging is the agent's own state.
+func permissionAreas(cfg *agent.InstanceConfig, payload map[string]interface{}, backups, userName, groupName string) ([]permissionArea, error) {
+	uid, gid, err := lookupServiceAccount(userName, groupName)
+	if err != nil {
+		return nil, err
+	}
+	agentUser, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	agentGroup, err := user.LookupGroupId(agentUser.Gid)
+	if err != nil {
+		return nil, err
+	}
+	// The helper only hands the live save, not the whole Saves tree, to
+	// the game account.
+	liveSave, liveErr := resolveLiveSave(cfg, getString(payload, "server_config_path", ""))
+	if liveErr != nil {
+		liveSave = ""
+	}
+	savesRoot, err := configuredSavesPath(payload)
+	if err != nil {
+		if liveErr != nil {
+			return nil, fmt.Errorf("locate saves: %w", liveErr)
+		}
+		savesRoot = filepath.Dir(filepath.Dir(liveSave))
+	}
+	mods, err := modsPath(cfg, getString(payload, "mods_path", ""))
+	if err != nil {
+		return nil, err
+	}
+	return []permissionArea{
+		{Name: "saves", Root: savesRoot, owners: []int{uid}, group: gid, fileMode: 0660, dirMode: 0770,
+			ownerName: userName, groupName: groupName, helperPath: liveSave},
+		{Name: "backups", Root: backups, owners: []int{os.Getuid(), uid}, group: -1, fileMode: 0640, dirMode: 0750,
+			ownerName: userName, groupName: groupName, helperPath: backups},
+		{Name: "profile_staging", Root: profileStagingRoot, owners: []int{os.Getuid()}, group: -1, fileMode: 0600, dirMode: 0700,
+			ownerName: agentUser.Username, groupName: agentGroup.Name, helperPath: profileStagingRoot},
+		{Name: "mods", Root: mods, owners: []int{uid}, group: gid, fileMode: 0640, dirMode: 0750,
+			ownerName: userName, groupName: groupName, helperPath: mods},
+	}, nil
+}
+
+// ownedBy reports whether uid is one of the area's accepted owners.
+func (area permissionArea) ownedBy(uid int) bool {
+	if len(area.owners) == 0 {
+		return true
+	}
+	for _, owner := range area.owners {
+		if owner == uid {
+			return true
+		}
+	}
+	return false
+}
+
+func lookupServiceAccount(userName, groupName string) (int, int, error) {
+	account, err := user.Lookup(userName)
+	if err != nil {
+		return 0, 0, fmt.Errorf("service user %q: %w", userName, err)
+	}
+	group, err := user.LookupGroup(groupName)
+	if err != nil {
+		return 0, 0, fmt.Errorf("service group %q: %w", groupName, err)
+	}
+	uid, _ := strconv.Atoi(account.Uid)
+	gid, _ := strconv.Atoi(group.Gid)
+	return uid, gid, nil
+}
+
+// auditPermissionArea walks one area without following symlinks.
+func auditPermissionArea(area permissionArea, issues *[]permissionIssue, truncated *bool) permissionAreaReport {
+	report := permissionAreaReport{Name: area.Name, Root: area.Root}
+	if _, err := os.Lstat(area.Root); os.IsNotExist(err) {
+		report.Missing = true
+		return report
+	}
+	err := filepath.WalkDir(area.Root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		report.Checked++
+		problems := permissionProblems(area, entry)
+		if len(problems) == 0 {
+			return nil
+		}
+		report.Mismatched++
+		if len(*issues) < maxPermissionIssues {
+			*issues = append(*issues, permissionIssue{Area: area.Name, Path: path, Problems: problems})
+		} else {
+			*truncated = true
+		}
+		return nil
+	})
+	if err != nil {
+		report.Error = err.Error()
+	}
+	return report
+}
+
+func permissionProblems(area permissionArea, entry fs.DirEntry) []string {
+	if entry.Type()&os.ModeSymlink != 0 {
+		return []string{"symbolic link"}
+	}
+	info, err := entry.Info()
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var problems []string
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if !area.ownedBy(int(stat.Uid)) {
+			problems = append(problems, fmt.Sprintf("owner uid %d, want %s", stat.Uid, strings.Trim(fmt.Sprint(area.owners), "[]")))
+		}
+		if area.group >= 0 && int(stat.Gid) != area.group {
+			problems = append(problems, fmt.Sprintf("group gid %d, want %d", stat.Gid, area.group))
+		}
+	}
+	want := area.fileMode
+	if entry.IsDir() {
+		want = area.dirMode
+	}
+	if mode := info.Mode().Perm(); mode&want != want {
+		problems = append(problems, fmt.Sprintf("mode %04o, want at least %04o", mode, want))
+	}
+	return problems
+}
+
+// auditPermissions audits every area.
+func auditPermissions(areas []permissionArea) ([]permissionAreaReport, []permissionIssue, bool, int) {
+	var reports []permissionAreaReport
+	issues := []permissionIssue{}
+	truncated := false
+	mismatched := 0
+	for _, area := range areas {
+		report := auditPermissionArea(area, &issues, &truncated)
+		mismatched += report.Mismatched
+		reports = append(reports, report)
+	}
+	return reports, issues, truncated, mismatched
+}
+
+// repairPermissionArea adds missing mode bits on entries the agent owns and
+// hands the area to the privileged helper when ownership is wrong or an
+// entry belongs to another account.
+func repairPermissionArea(ctx context.Context, area permissionArea) error {
+	needsHelper := false
+	err := filepath.WalkDir(area.Root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		problems := permissionProblems(area, entry)
+		if len(problems) == 0 || entry.Type()&os.ModeSymlink != 0 {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		ownershipWrong := ok && (!area.ownedBy(int(stat.Uid)) || (area.group >= 0 && int(stat.Gid) != area.group))
+		if ownershipWrong || (ok && int(stat.Uid) != os.Getuid() && os.Getuid() != 0) {
+			needsHelper = true
+			return nil
+		}
+		want := area.fileMode
+		if entry.IsDir() {
+			want = area.dirMode
+		}
+		return os.Chmod(path, info.Mode().Perm()|want|info.Mode()&(os.ModeSetgid|os.ModeSetuid|os.ModeSticky))
+	})
+	if err != nil {
+		return err
+	}
+	if needsHelper {
+		if area.helperPath == "" {
+			return fmt.Errorf("ownership can only be repaired on the live save; no server config locates it")
+		}
+		// The helper assigns the owner its own configuration names for the
+		// root and refuses when that is not the requested account.
+		if err := privileged.ChownAs(ctx, area.helperPath, area.ownerName, area.groupName); err != nil {
+			return fmt.Errorf("assign %s to %s:%s: %w", area.Name, area.ownerName, area.groupName, err)
+		}
+	}
+	return nil
+}
+
+// RepairPermissions audits saves, save backups, profile staging and Mods
+// against the service account and, unless dryRun is set, repairs them.
+func (a *Adapter) RepairPermissions(ctx context.Context, cfg *agent.InstanceConfig, payload map[string]interface{}, dryRun bool) (permissionReport, error) {
+	userName, groupName, err := permissionServiceAccount(payload)
+	if err != nil {
+		return permissionReport{}, err
+	}
+	ns, err := a.saveNamespace(cfg)
+	if err != nil {
+		return permissionReport{}, err
+	}
+	areas, err := permissionAreas(cfg, payload, ns.root, userName, groupName)
+	if err != nil {
+		return permissionReport{}, err
+	}
+	report := permissionReport{ServiceUser: userName, ServiceGroup: groupName, DryRun: dryRun}
+	report.Areas, report.Issues, report.Truncated, report.Mismatched = auditPermissions(areas)
+	report.Remaining = report.Mismatched
+	if dryRun || report.Mismatched == 0 {
+		return report, nil
+	}
+	for i, area := range areas {
+		if report.Areas[i].Mismatched == 0 {
+			continue
+		}
+		agent.ReportProgress(ctx, "repair", fmt.Sprintf("Repairing %s permissions", area.Name))
+		if err := repairPermissionArea(ctx, area); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", area.Name, err))
+		}
+	}
+	// Re-audit so the report shows what is still wrong after the repair.
+	after, issues, truncated, remaining := auditPermissions(areas)
+	report.Areas, report.Issues, report.Truncated = after, issues, truncated
+	report.Remaining = remaining
+	report.Repaired = report.Mismatched - remaining
+	return report, nil
+}
@@ -0,0 +1,120 @@
+package sevendtd
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+func TestRepairPermissionsDryRunThenRepair(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := user.LookupGroupId(current.Gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	saves := filepath.Join(root, "Saves")
+	region := filepath.Join(saves, "Navezgane", "World", "Region")
+	mods := filepath.Join(root, "server", "Mods")
+	for _, dir := range []string{region, mods, filepath.Join(root, "backups"), filepath.Join(root, "staging")} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	regionFile := filepath.Join(region, "r.0.0.7rg")
+	if err := os.WriteFile(regionFile, []byte("region"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc", filepath.Join(saves, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	previousBackups, previousStaging := saveBackupRoot, profileStagingRoot
+	saveBackupRoot, profileStagingRoot = filepath.Join(root, "backups"), filepath.Join(root, "staging")
+	t.Cleanup(func() { saveBackupRoot, profileStagingRoot = previousBackups, previousStaging })
+
+	a := NewAdapter()
+	cfg := &agent.InstanceConfig{InstallPath: filepath.Join(root, "server")}
+	payload := map[string]interface{}{
+		"service_user":  current.Username,
+		"service_group": group.Name,
+		"config":        map[string]interface{}{"discovery": map[string]interface{}{"savesPath": saves}},
+	}
+
+	report, err := a.RepairPermissions(context.Background(), cfg, payload, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Saves (4 dirs, 1 file, 1 symlink), backups and mods are too strict;
+	// staging at 0700 is what the agent wants.
+	if report.Mismatched != 8 || report.Repaired != 0 {
+		t.Fatalf("dry run report = %+v", report)
+	}
+	if info, _ := os.Stat(regionFile); info.Mode().Perm() != 0600 {
+		t.Fatalf("dry run changed mode to %v", info.Mode().Perm())
+	}
+
+	report, err = a.RepairPermissions(context.Background(), cfg, payload, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Errors) > 0 || report.Repaired != 7 || report.Remaining != 1 {
+		t.Fatalf("repair report = %+v", report)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].Problems[0] != "symbolic link" {
+		t.Fatalf("remaining issues = %+v", report.Issues)
+	}
+	for path, want := range map[string]os.FileMode{regionFile: 0660, region: 0770, mods: 0750, profileStagingRoot: 0700} {
+		if info, _ := os.Stat(path); info.Mode().Perm() != want {
+			t.Fatalf("%s mode = %v, want %v", path, info.Mode().Perm(), want)
+		}
+	}
+}
+
+func TestAgentBackupsAreNotFlagged(t *testing.T) {
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("no nobody account")
+	}
+	group, err := user.LookupGroupId(nobody.Gid)
+	if err != nil {
+		t.Skip("no group for nobody")
+	}
+	root := t.TempDir()
+	backup := filepath.Join(root, "backups", "mastermind_2024-05-01_10-00-00")
+	saves := filepath.Join(root, "Saves")
+	for _, dir := range []string{backup, saves, filepath.Join(root, "server", "Mods")} {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(backup, saveMetadataName), []byte("{}"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	previousBackups := saveBackupRoot
+	saveBackupRoot = filepath.Join(root, "backups")
+	t.Cleanup(func() { saveBackupRoot = previousBackups })
+	payload := map[string]interface{}{
+		"service_user":  nobody.Username,
+		"service_group": group.Name,
+		"config":        map[string]interface{}{"discovery": map[string]interface{}{"savesPath": saves}},
+	}
+	report, err := NewAdapter().RepairPermissions(context.Background(), &agent.InstanceConfig{InstallPath: filepath.Join(root, "server")}, payload, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, area := range report.Areas {
+		if area.Name == "backups" && (area.Checked != 3 || area.Mismatched != 0) {
+			t.Fatalf("agent-written backups flagged: %+v", area)
+		}
+		if area.Name == "saves" && area.Mismatched != 1 {
+			t.Fatalf("saves owned by the agent not flagged: %+v", area)
+		}
+	}
+}
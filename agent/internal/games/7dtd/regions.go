@@ -0,0 +1,239 @@
+package sevendtd
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+// defaultLandClaimSize is the game's LandClaimSize when serverconfig.xml
+// does not set it.
+const defaultLandClaimSize = 41
+
+type landClaim struct {
+	PlayerID   string `json:"playerId"`
+	PlayerName string `json:"playerName,omitempty"`
+	X          int    `json:"x"`
+	Y          int    `json:"y"`
+	Z          int    `json:"z"`
+}
+
+type regionInfo struct {
+	Path       string      `json:"path"`
+	Region     regionCoord `json:"region"`
+	SizeBytes  int64       `json:"sizeBytes"`
+	ModifiedAt time.Time   `json:"modifiedAt"`
+	Claimed    bool        `json:"claimed"`
+	Claims     []landClaim `json:"claims,omitempty"`
+}
+
+type regionInventory struct {
+	Regions       []regionInfo `json:"regions"`
+	TotalBytes    int64        `json:"totalBytes"`
+	LandClaims    int          `json:"landClaims"`
+	LandClaimSize int          `json:"landClaimSize"`
+}
+
+// bounds returns the block columns the region covers, inclusive.
+func (c regionCoord) bounds() (minX, minZ, maxX, maxZ int) {
+	return c.X * regionBlocks, c.Z * regionBlocks, c.X*regionBlocks + regionBlocks - 1, c.Z*regionBlocks + regionBlocks - 1
+}
+
+// covers reports whether a claim protecting size blocks around its block
+// reaches into the region.
+func (c regionCoord) covers(claim landClaim, size int) bool {
+	minX, minZ, maxX, maxZ := c.bounds()
+	half := size / 2
+	return claim.X+half >= minX && claim.X-half <= maxX && claim.Z+half >= minZ && claim.Z-half <= maxZ
+}
+
+// readLandClaims returns the land claim blocks players.xml records in
+// saveDir. A save without players.xml has no claims yet.
+func readLandClaims(saveDir string) ([]landClaim, error) {
+	content, err := os.ReadFile(filepath.Join(saveDir, "players.xml"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read players.xml: %w", err)
+	}
+	var data persistentPlayerData
+	if err := xml.Unmarshal(content, &data); err != nil {
+		return nil, fmt.Errorf("parse players.xml: %w", err)
+	}
+	var claims []landClaim
+	for _, player := range data.Players {
+		for _, block := range player.LandClaims {
+			parts := strings.Split(block.Pos, ",")
+			if len(parts) != 3 {
+				return nil, fmt.Errorf("players.xml has an invalid land claim position %q", block.Pos)
+			}
+			var coords [3]int
+			for i, part := range parts {
+				value, err := strconv.Atoi(strings.TrimSpace(part))
+				if err != nil {
+					return nil, fmt.Errorf("players.xml has an invalid land claim position %q", block.Pos)
+				}
+				coords[i] = value
+			}
+			claims = append(claims, landClaim{PlayerID: player.UserID, PlayerName: player.PlayerName, X: coords[0], Y: coords[1], Z: coords[2]})
+		}
+	}
+	return claims, nil
+}
+
+// landClaimSize reads LandClaimSize from the server configuration.
+func landClaimSize(cfg *agent.InstanceConfig, configOverride string) int {
+	configPath := configOverride
+	if configPath == "" {
+		configPath = filepath.Join(filepath.Dir(cfg.InstallPath), "serverconfig.xml")
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return defaultLandClaimSize
+	}
+	var parsed serverConfiguration
+	if xml.Unmarshal(data, &parsed) != nil {
+		return defaultLandClaimSize
+	}
+	for _, property := range parsed.Properties {
+		if property.Name == "LandClaimSize" {
+			if size, err := strconv.Atoi(strings.TrimSpace(property.Value)); err == nil && size > 0 {
+				return size
+			}
+		}
+	}
+	return defaultLandClaimSize
+}
+
+// ListRegions lists the live world's region files with the land claims that
+// reach into each.
+func (a *Adapter) ListRegions(cfg *agent.InstanceConfig, configOverride string) (*regionInventory, error) {
+	live, err := resolveLiveSave(cfg, configOverride)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := readLandClaims(live)
+	if err != nil {
+		return nil, err
+	}
+	inventory := &regionInventory{Regions: []regionInfo{}, LandClaims: len(claims), LandClaimSize: landClaimSize(cfg, configOverride)}
+	entries, err := os.ReadDir(filepath.Join(live, "Region"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read regions: %w", err)
+	}
+	for _, entry := range entries {
+		coord, ok := parseRegionName(entry.Name())
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		region := regionInfo{Path: coord.path(), Region: coord, SizeBytes: info.Size(), ModifiedAt: info.ModTime().UTC()}
+		for _, claim := range claims {
+			if coord.covers(claim, inventory.LandClaimSize) {
+				region.Claims = append(region.Claims, claim)
+			}
+		}
+		region.Claimed = len(region.Claims) > 0
+		inventory.TotalBytes += region.SizeBytes
+		inventory.Regions = append(inventory.Regions, region)
+	}
+	sort.Slice(inventory.Regions, func(i, j int) bool {
+		ri, rj := inventory.Regions[i].Region, inventory.Regions[j].Region
+		if ri.X != rj.X {
+			return ri.X < rj.X
+		}
+		return ri.Z < rj.Z
+	})
+	return inventory, nil
+}
+
+type regionSkip struct {
+	Region regionCoord `json:"region"`
+	Reason string      `json:"reason"`
+}
+
+type regionReset struct {
+	DryRun     bool          `json:"dryRun"`
+	Deleted    []regionCoord `json:"deleted"`
+	Skipped    []regionSkip  `json:"skipped"`
+	FreedBytes int64         `json:"freedBytes"`
+	// Backup is the backup taken before deleting anything; restore regions
+	// from it with SAVE_RESTORE_REGIONS.
+	Backup *SaveRecord `json:"backup,omitempty"`
+}
+
+// ResetRegions deletes the selected region files so the game regenerates
+// them from the world. Regions a land claim reaches into, and regions not
+// generated yet, are skipped. Unless dryRun, the server must be stopped and
+// a full backup is taken first.
+func (a *Adapter) ResetRegions(ctx context.Context, cfg *agent.InstanceConfig, configOverride string, regions []regionCoord, dryRun bool, policy retentionPolicy, format string) (*regionReset, error) {
+	if !dryRun && a.instanceRunning(ctx, cfg) {
+		return nil, fmt.Errorf("server must be stopped before resetting regions")
+	}
+	inventory, err := a.ListRegions(cfg, configOverride)
+	if err != nil {
+		return nil, err
+	}
+	present := make(map[regionCoord]regionInfo, len(inventory.Regions))
+	for _, region := range inventory.Regions {
+		present[region.Region] = region
+	}
+	reset := &regionReset{DryRun: dryRun, Deleted: []regionCoord{}, Skipped: []regionSkip{}}
+	var selected []regionInfo
+	for _, coord := range regions {
+		region, ok := present[coord]
+		switch {
+		case !ok:
+			reset.Skipped = append(reset.Skipped, regionSkip{Region: coord, Reason: "not generated"})
+		case region.Claimed:
+			reset.Skipped = append(reset.Skipped, regionSkip{Region: coord, Reason: fmt.Sprintf("%d land claims", len(region.Claims))})
+		default:
+			selected = append(selected, region)
+			reset.FreedBytes += region.SizeBytes
+		}
+	}
+	if dryRun || len(selected) == 0 {
+		for _, region := range selected {
+			reset.Deleted = append(reset.Deleted, region.Region)
+		}
+		return reset, nil
+	}
+	if serviceActive(ctx, "regionhealer.service") {
+		if err := systemctlService(ctx, "stop", "regionhealer.service"); err != nil {
+			return nil, fmt.Errorf("stop Region Healer before resetting regions: %w", err)
+		}
+		defer func() { _ = systemctlService(context.Background(), "start", "regionhealer.service") }()
+	}
+	agent.ReportProgress(ctx, "backup", "Backing up the world before resetting regions")
+	backup, err := a.BackupSave(ctx, cfg, configOverride, policy, format)
+	if err != nil {
+		return nil, fmt.Errorf("backup before reset: %w", err)
+	}
+	reset.Backup = &backup
+	live, err := resolveLiveSave(cfg, configOverride)
+	if err != nil {
+		return reset, err
+	}
+	agent.ReportProgress(ctx, "reset", fmt.Sprintf("Deleting %d regions", len(selected)))
+	reset.FreedBytes = 0
+	for _, region := range selected {
+		if err := os.Remove(filepath.Join(live, filepath.FromSlash(region.Path))); err != nil && !os.IsNotExist(err) {
+			return reset, fmt.Errorf("delete %s: %w", region.Path, err)
+		}
+		reset.Deleted = append(reset.Deleted, region.Region)
+		reset.FreedBytes += region.SizeBytes
+	}
+	return reset, nil
+}
@@ -0,0 +1,157 @@
+package sevendtd
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mastermind/agent/internal/agent"
+	"github.com/mastermind/agent/internal/privhelper"
+)
+
+// fakeHelperUnits points the privileged client at a helper that accepts
+// every unit request, makes only regionhealer.service look active, and
+// returns the "action unit" requests received so far.
+func fakeHelperUnits(t *testing.T) func() []string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "helper.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousClient, previousActive := privileged, serviceActive
+	privileged = &privhelper.Client{Socket: socket}
+	serviceActive = func(_ context.Context, service string) bool { return service == "regionhealer.service" }
+	t.Cleanup(func() {
+		listener.Close()
+		privileged, serviceActive = previousClient, previousActive
+	})
+	var mu sync.Mutex
+	var calls []string
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			var request privhelper.Request
+			if err := json.NewDecoder(conn).Decode(&request); err == nil {
+				mu.Lock()
+				calls = append(calls, request.Action+" "+request.Unit)
+				mu.Unlock()
+				_ = json.NewEncoder(conn).Encode(privhelper.Response{OK: true})
+			}
+			conn.Close()
+		}
+	}()
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), calls...)
+	}
+}
+
+func TestListAndResetRegions(t *testing.T) {
+	previous := saveBackupRoot
+	saveBackupRoot = t.TempDir()
+	t.Cleanup(func() { saveBackupRoot = previous })
+	ctx := context.Background()
+	// A claim at x=520 protects 500..540 with the default size of 41, so it
+	// reaches back into region 0 as well.
+	players := `<persistentplayerdata>
+	<player userid="EOS_0002a1b2c3" playername="Alice"><lpblock pos="520, 40, 100"/></player>
+	<player userid="EOS_0002d4e5f6" playername="Bob"/>
+</persistentplayerdata>`
+	config, live := writeTestServer(t, map[string]string{
+		"players.xml":        players,
+		"Region/r.0.0.7rg":   "region 0,0",
+		"Region/r.1.0.7rg":   "region 1,0",
+		"Region/r.2.0.7rg":   "region 2,0",
+		"Region/r.-1.-1.7rg": "far away",
+		"Region/notes.txt":   "ignored",
+	})
+	a := NewAdapter()
+	cfg := &agent.InstanceConfig{}
+	inventory, err := a.ListRegions(cfg, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inventory.Regions) != 4 || inventory.LandClaims != 1 || inventory.LandClaimSize != defaultLandClaimSize {
+		t.Fatalf("inventory = %+v", inventory)
+	}
+	claimed := map[regionCoord]bool{}
+	for _, region := range inventory.Regions {
+		claimed[region.Region] = region.Claimed
+	}
+	if !claimed[regionCoord{0, 0}] || !claimed[regionCoord{1, 0}] || claimed[regionCoord{2, 0}] || claimed[regionCoord{-1, -1}] {
+		t.Fatalf("claimed = %v", claimed)
+	}
+	if inventory.Regions[0].Region != (regionCoord{-1, -1}) || inventory.Regions[0].SizeBytes != int64(len("far away")) {
+		t.Fatalf("first region = %+v", inventory.Regions[0])
+	}
+
+	selection := []regionCoord{{1, 0}, {2, 0}, {-1, -1}, {7, 7}}
+	plan, err := a.ResetRegions(ctx, cfg, config, selection, true, retentionPolicy{KeepLast: 10}, saveFormatManifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Deleted) != 2 || len(plan.Skipped) != 2 || plan.Backup != nil {
+		t.Fatalf("dry run = %+v", plan)
+	}
+	if _, err := os.Stat(filepath.Join(live, "Region", "r.2.0.7rg")); err != nil {
+		t.Fatal("dry run deleted a region")
+	}
+
+	reset, err := a.ResetRegions(ctx, cfg, config, selection, false, retentionPolicy{KeepLast: 10}, saveFormatManifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reset.Backup == nil || len(reset.Deleted) != 2 || reset.FreedBytes != int64(len("region 2,0")+len("far away")) {
+		t.Fatalf("reset = %+v", reset)
+	}
+	for name, want := range map[string]bool{"r.1.0.7rg": true, "r.2.0.7rg": false, "r.-1.-1.7rg": false} {
+		if _, err := os.Stat(filepath.Join(live, "Region", name)); (err == nil) != want {
+			t.Fatalf("%s exists = %v, want %v", name, err == nil, want)
+		}
+	}
+	// The automatic backup still has the deleted regions.
+	restore, err := a.RestoreSaveRegions(ctx, cfg, config, reset.Backup.ID, []regionCoord{{2, 0}}, false, nil)
+	if err != nil || len(restore.Restored) != 1 {
+		t.Fatalf("restore from the reset backup = %+v, %v", restore, err)
+	}
+}
+
+func TestReadLandClaimsRejectsBadPositions(t *testing.T) {
+	dir := t.TempDir()
+	if claims, err := readLandClaims(dir); err != nil || claims != nil {
+		t.Fatalf("save without players.xml = %v, %v", claims, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "players.xml"), []byte(`<persistentplayerdata><player userid="x"><lpblock pos="1,2"/></player></persistentplayerdata>`), 0640); err != nil {
+		t.Fatal(err)
+	}
+	// Resetting around a claim that cannot be read would risk a base.
+	if _, err := readLandClaims(dir); err == nil {
+		t.Fatal("invalid claim position accepted")
+	}
+}
+
+func TestResetRegionsRestartsRegionHealer(t *testing.T) {
+	previous := saveBackupRoot
+	saveBackupRoot = t.TempDir()
+	t.Cleanup(func() { saveBackupRoot = previous })
+	calls := fakeHelperUnits(t)
+	config, _ := writeTestServer(t, map[string]string{"Region/r.0.0.7rg": "region 0,0", "Region/r.1.0.7rg": "region 1,0"})
+
+	reset, err := NewAdapter().ResetRegions(context.Background(), &agent.InstanceConfig{}, config, []regionCoord{{1, 0}}, false, retentionPolicy{KeepLast: 10}, saveFormatManifest)
+	if err != nil || len(reset.Deleted) != 1 {
+		t.Fatalf("reset = %+v, %v", reset, err)
+	}
+	if got := strings.Join(calls(), ","); got != "stop regionhealer.service,start regionhealer.service" {
+		t.Fatalf("helper unit calls = %q, want Region Healer stopped and started again", got)
+	}
+}
@@ -0,0 +1,553 @@
+package sevendtd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+// Archive formats a full-world backup can be written in besides the
+// deduplicating blob store. An archive backup directory holds world.<format>,
+// the manifest with every file's SHA-256, and the metadata.
+const (
+	saveFormatTarGzip = "tar.gz"
+	saveFormatTarZstd = "tar.zst"
+)
+
+// zstdPath is a variable so tests can use whichever zstd is installed.
+var zstdPath = "/usr/bin/zstd"
+
+// maxVerifyEntries bounds the missing and corrupt paths listed per backup.
+const maxVerifyEntries = 200
+
+// saveBackupFormat returns the requested backup format from the payload
+// (archive_format) or the instance config (config.backups.archiveFormat).
+func saveBackupFormat(payload map[string]interface{}) (string, error) {
+	config, _ := payload["config"].(map[string]interface{})
+	backups, _ := config["backups"].(map[string]interface{})
+	format := strings.TrimSpace(getString(payload, "archive_format", getString(backups, "archiveFormat", "")))
+	switch format {
+	case "", saveFormatManifest:
+		return saveFormatManifest, nil
+	case saveFormatTarGzip, saveFormatTarZstd:
+		return format, nil
+	}
+	return "", fmt.Errorf("unsupported backup archive format %q", format)
+}
+
+// compressedWriter wraps w in the format's compressor. Close flushes the
+// compressor but leaves w open.
+func compressedWriter(ctx context.Context, w io.Writer, format string) (io.WriteCloser, error) {
+	if format == saveFormatTarGzip {
+		return gzip.NewWriter(w), nil
+	}
+	cmd := exec.CommandContext(ctx, zstdPath, "-q", "-c", "-T0")
+	cmd.Stdout = w
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start zstd: %w", err)
+	}
+	return &commandPipe{WriteCloser: stdin, cmd: cmd, stderr: &stderr}, nil
+}
+
+// compressedReader decompresses r in the format's decompressor.
+func compressedReader(ctx context.Context, r io.Reader, format string) (io.ReadCloser, error) {
+	switch format {
+	case saveFormatTarGzip:
+		return gzip.NewReader(r)
+	case saveFormatTarZstd:
+	default:
+		return nil, fmt.Errorf("unsupported backup archive format %q", format)
+	}
+	cmd := exec.CommandContext(ctx, zstdPath, "-q", "-d", "-c")
+	cmd.Stdin = r
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start zstd: %w", err)
+	}
+	return &commandOutput{ReadCloser: stdout, cmd: cmd, stderr: &stderr}, nil
+}
+
+type commandPipe struct {
+	io.WriteCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+func (p *commandPipe) Close() error {
+	closeErr := p.WriteCloser.Close()
+	if err := p.cmd.Wait(); err != nil {
+		return fmt.Errorf("zstd: %w: %s", err, strings.TrimSpace(p.stderr.String()))
+	}
+	return closeErr
+}
+
+type commandOutput struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+// Close drains the output so the command can exit and reports its failure,
+// which is how a truncated or corrupt zstd stream surfaces.
+func (o *commandOutput) Close() error {
+	_, _ = io.Copy(io.Discard, o.ReadCloser)
+	if err := o.cmd.Wait(); err != nil {
+		return fmt.Errorf("zstd: %w: %s", err, strings.TrimSpace(o.stderr.String()))
+	}
+	return nil
+}
+
+// writeSaveArchive archives source into destination/world.<format> and
+// writes the manifest. With a recipient the archive is encrypted to it and
+// named world.<format>.enc. It returns the manifest and the archive size.
+func writeSaveArchive(ctx context.Context, source, destination, format string, recipient *BackupRecipient) (*saveManifest, int64, error) {
+	if err := os.MkdirAll(destination, 0750); err != nil {
+		return nil, 0, err
+	}
+	name := "world." + format
+	var key []byte
+	var encryption *saveEncryption
+	if recipient != nil {
+		var err error
+		if key, encryption, err = recipient.newDataKey(); err != nil {
+			return nil, 0, fmt.Errorf("create backup key: %w", err)
+		}
+		name += saveEncryptedExt
+	}
+	file, err := os.OpenFile(filepath.Join(destination, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	archiveHash := sha256.New()
+	var sink io.Writer = io.MultiWriter(file, archiveHash)
+	encrypter := io.WriteCloser(nopWriteCloser{sink})
+	if key != nil {
+		if encrypter, err = newSaveEncrypter(sink, key); err != nil {
+			return nil, 0, err
+		}
+		sink = encrypter
+	}
+	compressor, err := compressedWriter(ctx, sink, format)
+	if err != nil {
+		return nil, 0, err
+	}
+	archive := tar.NewWriter(compressor)
+	manifest := &saveManifest{Version: 1, Archive: name}
+	walkErr := filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			manifest.Dirs = append(manifest.Dirs, saveManifestDir{Path: rel, Mode: info.Mode().Perm()})
+			if rel == "." {
+				return nil
+			}
+			return archive.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: rel + "/", Mode: int64(info.Mode().Perm()), ModTime: info.ModTime()})
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		if err := archive.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: rel, Size: info.Size(), Mode: int64(info.Mode().Perm()), ModTime: info.ModTime()}); err != nil {
+			return err
+		}
+		hasher := sha256.New()
+		// A file that changes size while it is archived fails here rather
+		// than producing an entry that disagrees with its header.
+		if _, err := io.CopyN(io.MultiWriter(archive, hasher), in, info.Size()); err != nil {
+			return fmt.Errorf("archive %s: %w", rel, err)
+		}
+		manifest.Files = append(manifest.Files, saveManifestFile{
+			Path: rel, Hash: hex.EncodeToString(hasher.Sum(nil)), Size: info.Size(), Mode: info.Mode().Perm(), ModTime: info.ModTime().UTC(),
+		})
+		return nil
+	})
+	tarErr := archive.Close()
+	compressErr := compressor.Close()
+	encryptErr := encrypter.Close()
+	for _, err := range []error{walkErr, tarErr, compressErr, encryptErr, file.Sync()} {
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	manifest.ArchiveSHA256 = hex.EncodeToString(archiveHash.Sum(nil))
+	if encryption != nil {
+		sealed, err := sealSaveFiles(key, manifest)
+		if err != nil {
+			return nil, 0, fmt.Errorf("seal backup manifest: %w", err)
+		}
+		manifest.Size = manifest.logicalSize()
+		manifest.Dirs, manifest.Files = nil, nil
+		manifest.Encryption, manifest.Sealed = encryption, sealed
+	}
+	data, _ := json.MarshalIndent(manifest, "", "  ")
+	if err := os.WriteFile(filepath.Join(destination, saveManifestName), data, 0640); err != nil {
+		return nil, 0, fmt.Errorf("write backup manifest: %w", err)
+	}
+	return manifest, info.Size(), nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// archiveFormat returns the format of an archive named world.<format> or
+// world.<format>.enc.
+func archiveFormat(name string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(name, "world."), saveEncryptedExt)
+}
+
+func validArchiveName(name string) bool {
+	return filepath.Base(name) == name && strings.HasPrefix(name, "world.")
+}
+
+// readSaveArchive calls visit for every regular file in the backup's archive
+// with a reader over its content; key is the data key of an encrypted
+// archive. It checks the archive against the recorded checksum as it goes
+// and reports a mismatch after the walk.
+func readSaveArchive(ctx context.Context, backupDir string, manifest *saveManifest, key []byte, visit func(header *tar.Header, content io.Reader) error) error {
+	if !validArchiveName(manifest.Archive) {
+		return fmt.Errorf("backup manifest names an invalid archive")
+	}
+	file, err := os.Open(filepath.Join(backupDir, manifest.Archive))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	archiveHash := sha256.New()
+	var compressed io.Reader = io.TeeReader(file, archiveHash)
+	var decrypter *saveDecrypter
+	if manifest.Encryption != nil {
+		if key == nil {
+			return fmt.Errorf("backup is encrypted; supply private_key")
+		}
+		if decrypter, err = newSaveDecrypter(compressed, key); err != nil {
+			return err
+		}
+		compressed = decrypter
+	}
+	decompressed, err := compressedReader(ctx, compressed, archiveFormat(manifest.Archive))
+	if err != nil {
+		return err
+	}
+	archive := tar.NewReader(decompressed)
+	var walkErr error
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			walkErr = fmt.Errorf("read archive: %w", err)
+			break
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := visit(header, archive); err != nil {
+			walkErr = err
+			break
+		}
+	}
+	closeErr := decompressed.Close()
+	if walkErr != nil {
+		return walkErr
+	}
+	if closeErr != nil {
+		return fmt.Errorf("read archive: %w", closeErr)
+	}
+	// Reaching the final chunk is what proves the stream was not cut short.
+	if decrypter != nil {
+		if _, err := io.Copy(io.Discard, decrypter); err != nil {
+			return err
+		}
+	}
+	// Hash whatever trails the compressed stream too.
+	if _, err := io.Copy(archiveHash, file); err != nil {
+		return err
+	}
+	if manifest.ArchiveSHA256 != "" && hex.EncodeToString(archiveHash.Sum(nil)) != manifest.ArchiveSHA256 {
+		return fmt.Errorf("archive checksum does not match the manifest")
+	}
+	return nil
+}
+
+type saveVerification struct {
+	ID     string `json:"id"`
+	Format string `json:"format"`
+	// Verified is false for backups written without a manifest, which
+	// cannot be checked.
+	Verified  bool     `json:"verified"`
+	OK        bool     `json:"ok"`
+	Checked   int      `json:"checked"`
+	Missing   []string `json:"missing"`
+	Corrupt   []string `json:"corrupt"`
+	Truncated bool     `json:"truncated,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	Encrypted bool     `json:"encrypted,omitempty"`
+	// Partial is set when an encrypted backup was checked without its
+	// private key: only the archive checksum could be verified.
+	Partial bool `json:"partial,omitempty"`
+}
+
+func (v *saveVerification) add(list *[]string, path string) {
+	v.OK = false
+	if len(v.Missing)+len(v.Corrupt) >= maxVerifyEntries {
+		v.Truncated = true
+		return
+	}
+	*list = append(*list, path)
+}
+
+// verifySaveBackup rechecks every file of the backup in backupDir against
+// its manifest. Encrypted backups are only checked in full with identity.
+func verifySaveBackup(ctx context.Context, backupDir string, identity *saveIdentity) saveVerification {
+	result := saveVerification{ID: filepath.Base(backupDir), Format: "directory", Missing: []string{}, Corrupt: []string{}, OK: true}
+	if !hasSaveManifest(backupDir) {
+		return result
+	}
+	manifest, err := readSaveManifest(backupDir)
+	if err != nil {
+		result.OK, result.Error = false, err.Error()
+		return result
+	}
+	result.Verified = true
+	if manifest.Archive == "" {
+		result.Format = saveFormatManifest
+		verifySaveBlobs(ctx, filepath.Dir(backupDir), manifest, &result)
+	} else {
+		result.Format = archiveFormat(manifest.Archive)
+		result.Encrypted = manifest.Encryption != nil
+		if result.Encrypted && identity == nil {
+			result.Partial = true
+			if err := checkSaveArchiveChecksum(backupDir, manifest); err != nil {
+				result.OK, result.Error = false, err.Error()
+			}
+			return result
+		}
+		key, err := openSaveManifest(manifest, identity)
+		if err != nil {
+			result.OK, result.Error = false, err.Error()
+			return result
+		}
+		verifySaveArchiveFiles(ctx, backupDir, manifest, key, &result)
+	}
+	sort.Strings(result.Missing)
+	sort.Strings(result.Corrupt)
+	return result
+}
+
+func verifySaveBlobs(ctx context.Context, root string, manifest *saveManifest, result *saveVerification) {
+	checked := make(map[string]string)
+	for _, file := range manifest.Files {
+		if err := ctx.Err(); err != nil {
+			result.OK, result.Error = false, err.Error()
+			return
+		}
+		result.Checked++
+		state, seen := checked[file.Hash]
+		if !seen {
+			state = checkSaveBlob(root, file.Hash, file.Size)
+			checked[file.Hash] = state
+		}
+		switch state {
+		case "missing":
+			result.add(&result.Missing, file.Path)
+		case "corrupt":
+			result.add(&result.Corrupt, file.Path)
+		}
+	}
+}
+
+func checkSaveBlob(root, hash string, size int64) string {
+	blob, err := os.Open(saveBlobPath(root, hash))
+	if err != nil {
+		return "missing"
+	}
+	defer blob.Close()
+	hasher := sha256.New()
+	n, err := io.Copy(hasher, blob)
+	if err != nil || n != size || hex.EncodeToString(hasher.Sum(nil)) != hash {
+		return "corrupt"
+	}
+	return "ok"
+}
+
+// checkSaveArchiveChecksum compares the archive file with the recorded
+// checksum without reading its content.
+func checkSaveArchiveChecksum(backupDir string, manifest *saveManifest) error {
+	if !validArchiveName(manifest.Archive) {
+		return fmt.Errorf("backup manifest names an invalid archive")
+	}
+	file, err := os.Open(filepath.Join(backupDir, manifest.Archive))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != manifest.ArchiveSHA256 {
+		return fmt.Errorf("archive checksum does not match the manifest")
+	}
+	return nil
+}
+
+func verifySaveArchiveFiles(ctx context.Context, backupDir string, manifest *saveManifest, key []byte, result *saveVerification) {
+	expected := make(map[string]saveManifestFile, len(manifest.Files))
+	for _, file := range manifest.Files {
+		expected[file.Path] = file
+	}
+	seen := make(map[string]bool, len(manifest.Files))
+	err := readSaveArchive(ctx, backupDir, manifest, key, func(header *tar.Header, content io.Reader) error {
+		file, ok := expected[header.Name]
+		if !ok || seen[header.Name] {
+			return nil
+		}
+		seen[header.Name] = true
+		result.Checked++
+		hasher := sha256.New()
+		n, err := io.Copy(hasher, content)
+		if err != nil {
+			return err
+		}
+		if n != file.Size || hex.EncodeToString(hasher.Sum(nil)) != file.Hash {
+			result.add(&result.Corrupt, file.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		result.OK, result.Error = false, err.Error()
+	}
+	for _, file := range manifest.Files {
+		if !seen[file.Path] {
+			result.add(&result.Missing, file.Path)
+		}
+	}
+}
+
+// extractSaveArchive restores the files listed in the manifest from the
+// backup's archive into destination; archive entries the manifest does not
+// list are ignored. With force, an unreadable tail of the archive only ends
+// the restore early.
+func extractSaveArchive(ctx context.Context, backupDir string, manifest *saveManifest, key []byte, destination string, force bool) error {
+	resolve, err := manifestTargets(manifest, destination)
+	if err != nil {
+		return err
+	}
+	expected := make(map[string]saveManifestFile, len(manifest.Files))
+	for _, file := range manifest.Files {
+		expected[file.Path] = file
+	}
+	var writeErr error
+	err = readSaveArchive(ctx, backupDir, manifest, key, func(header *tar.Header, content io.Reader) error {
+		file, ok := expected[header.Name]
+		if !ok {
+			return nil
+		}
+		target := resolve(file.Path)
+		if writeErr = os.MkdirAll(filepath.Dir(target), 0750); writeErr != nil {
+			return writeErr
+		}
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, file.Mode.Perm()|0600)
+		if err != nil {
+			writeErr = err
+			return err
+		}
+		_, copyErr := io.Copy(out, content)
+		closeErr := out.Close()
+		if copyErr != nil {
+			return fmt.Errorf("restore %s: %w", file.Path, copyErr)
+		}
+		if closeErr != nil {
+			writeErr = closeErr
+			return closeErr
+		}
+		_ = os.Chtimes(target, file.ModTime, file.ModTime)
+		return nil
+	})
+	if writeErr != nil || ctx.Err() != nil || (err != nil && !force) {
+		return err
+	}
+	return nil
+}
+
+func verificationDetail(verification saveVerification) string {
+	if verification.Error == "" {
+		return ""
+	}
+	return ": " + verification.Error
+}
+
+// VerifySaves verifies the backup id, or every backup when id is empty.
+// Encrypted backups are checked in full only when identity is given.
+func (a *Adapter) VerifySaves(ctx context.Context, cfg *agent.InstanceConfig, id string, identity *saveIdentity) ([]saveVerification, error) {
+	ns, err := a.saveNamespace(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	if id != "" {
+		path, err := ns.backupPath(id)
+		if err != nil {
+			return nil, err
+		}
+		if info, err := os.Lstat(path); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("save backup not found")
+		}
+		dirs = append(dirs, path)
+	} else if dirs, err = ns.backupDirs(); err != nil {
+		return nil, err
+	}
+	verifications := make([]saveVerification, 0, len(dirs))
+	for _, dir := range dirs {
+		agent.ReportProgress(ctx, "verify", "Verifying backup "+filepath.Base(dir))
+		verifications = append(verifications, verifySaveBackup(ctx, dir, identity))
+		if err := ctx.Err(); err != nil {
+			return verifications, err
+		}
+	}
+	return verifications, nil
+}
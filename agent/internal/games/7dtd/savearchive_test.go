@@ -0,0 +1,111 @@
+package sevendtd
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestWorld(t *testing.T, files map[string]string) string {
+	t.Helper()
+	live := filepath.Join(t.TempDir(), "World")
+	for rel, content := range files {
+		path := filepath.Join(live, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return live
+}
+
+func TestArchiveBackupsVerifyAndRestore(t *testing.T) {
+	if path, err := exec.LookPath("zstd"); err == nil {
+		previous := zstdPath
+		zstdPath = path
+		t.Cleanup(func() { zstdPath = previous })
+	}
+	files := map[string]string{"main.ttw": "world header", "Region/r.0.0.7rg": "region zero", "Player/76561.ttp": "player"}
+	live := writeTestWorld(t, files)
+	for _, format := range []string{saveFormatTarGzip, saveFormatTarZstd} {
+		t.Run(format, func(t *testing.T) {
+			if _, err := os.Stat(zstdPath); format == saveFormatTarZstd && err != nil {
+				t.Skip("zstd is not installed")
+			}
+			backup := filepath.Join(t.TempDir(), "mastermind_2024-05-01_10-00-00")
+			manifest, size, err := writeSaveArchive(context.Background(), live, backup, format, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(manifest.Files) != len(files) || size == 0 {
+				t.Fatalf("manifest %+v, archive %d bytes", manifest, size)
+			}
+			if result := verifySaveBackup(context.Background(), backup, nil); !result.OK || result.Checked != len(files) {
+				t.Fatalf("verification = %+v", result)
+			}
+			restored := filepath.Join(t.TempDir(), "World")
+			if err := restoreSaveBackup(context.Background(), backup, restored, nil, false); err != nil {
+				t.Fatal(err)
+			}
+			for rel, content := range files {
+				if data, err := os.ReadFile(filepath.Join(restored, rel)); err != nil || string(data) != content {
+					t.Fatalf("restored %s = %q, %v", rel, data, err)
+				}
+			}
+
+			// Truncating the archive is the partial copy the manifest exists
+			// to catch.
+			archive := filepath.Join(backup, "world."+format)
+			info, _ := os.Stat(archive)
+			if err := os.Truncate(archive, info.Size()/2); err != nil {
+				t.Fatal(err)
+			}
+			if result := verifySaveBackup(context.Background(), backup, nil); result.OK || result.Error == "" {
+				t.Fatalf("truncated archive verified: %+v", result)
+			}
+		})
+	}
+}
+
+func TestVerifyReportsMissingAndCorruptBlobs(t *testing.T) {
+	previous := saveBackupRoot
+	saveBackupRoot = t.TempDir()
+	t.Cleanup(func() { saveBackupRoot = previous })
+	live := writeTestWorld(t, map[string]string{"main.ttw": "world header", "Region/r.0.0.7rg": "region zero", "Region/r.0.1.7rg": "region one"})
+	backup := filepath.Join(saveBackupRoot, "mastermind_2024-05-01_10-00-00")
+	manifest, _, err := storeSaveTree(context.Background(), live, backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := verifySaveBackup(context.Background(), backup, nil); !result.OK {
+		t.Fatalf("fresh backup failed verification: %+v", result)
+	}
+	for _, file := range manifest.Files {
+		switch file.Path {
+		case "Region/r.0.0.7rg":
+			if err := os.Remove(saveBlobPath(saveBackupRoot, file.Hash)); err != nil {
+				t.Fatal(err)
+			}
+		case "Region/r.0.1.7rg":
+			if err := os.WriteFile(saveBlobPath(saveBackupRoot, file.Hash), []byte("region 0ne"), 0640); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	result := verifySaveBackup(context.Background(), backup, nil)
+	if result.OK || len(result.Missing) != 1 || result.Missing[0] != "Region/r.0.0.7rg" || len(result.Corrupt) != 1 || result.Corrupt[0] != "Region/r.0.1.7rg" {
+		t.Fatalf("verification = %+v", result)
+	}
+	// A forced restore brings back what is still readable.
+	restored := filepath.Join(t.TempDir(), "World")
+	if err := restoreSaveBackup(context.Background(), backup, restored, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(restored, "main.ttw")); string(data) != "world header" {
+		t.Fatalf("forced restore main.ttw = %q", data)
+	}
+}
@@ -0,0 +1,58 @@
+package sevendtd
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// ficlone is the Linux FICLONE ioctl, which makes the destination share the
+// source's extents on filesystems with reflinks (btrfs, XFS with reflink=1).
+const ficlone = 0x40049409
+
+// Copy modes reported for backups.
+const (
+	copyModeReflink = "reflink"
+	copyModeCopy    = "copy"
+	// copyModeMixed means some files were cloned and others copied, for
+	// example with the blob store on another filesystem than part of the
+	// world.
+	copyModeMixed = "mixed"
+	// copyModeUnchanged means every file was already in the blob store.
+	copyModeUnchanged = "unchanged"
+	copyModeArchive   = "archive"
+)
+
+// cloneFile is a variable so tests can simulate filesystems without
+// reflink support.
+var cloneFile = func(destination, source *os.File) error {
+	if runtime.GOOS != "linux" {
+		return errors.ErrUnsupported
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, destination.Fd(), ficlone, source.Fd())
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// saveCopyStats counts how the files of a manifest backup reached the blob
+// store.
+type saveCopyStats struct {
+	AddedBytes int64
+	Reflinked  int
+	Copied     int
+}
+
+func (s saveCopyStats) mode() string {
+	switch {
+	case s.Reflinked > 0 && s.Copied > 0:
+		return copyModeMixed
+	case s.Reflinked > 0:
+		return copyModeReflink
+	case s.Copied > 0:
+		return copyModeCopy
+	}
+	return copyModeUnchanged
+}
@@ -0,0 +1,87 @@
+package sevendtd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStoreSaveTreeReportsCopyMode(t *testing.T) {
+	previous := cloneFile
+	t.Cleanup(func() { cloneFile = previous })
+	live := t.TempDir()
+	for name, content := range map[string]string{"main.ttw": "world header", "Region/r.0.0.7rg": "region"} {
+		path := filepath.Join(live, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root := t.TempDir()
+	clones := 0
+	// A clone that shares nothing but behaves like FICLONE: the destination
+	// ends up with the source's content and both offsets stay at zero.
+	cloneFile = func(destination, source *os.File) error {
+		clones++
+		if _, err := io.Copy(destination, source); err != nil {
+			return err
+		}
+		if _, err := destination.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		_, err := source.Seek(0, io.SeekStart)
+		return err
+	}
+	_, stats, err := storeSaveTree(context.Background(), live, filepath.Join(root, "mastermind_2024-05-01_10-00-00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.mode() != copyModeReflink || clones != 2 || stats.AddedBytes != int64(len("world header")+len("region")) {
+		t.Fatalf("reflinked backup = %+v after %d clones", stats, clones)
+	}
+
+	cloneFile = func(*os.File, *os.File) error { return errors.ErrUnsupported }
+	if err := os.WriteFile(filepath.Join(live, "Region", "r.0.0.7rg"), []byte("region changed"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	manifest, stats, err := storeSaveTree(context.Background(), live, filepath.Join(root, "mastermind_2024-05-01_11-00-00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.mode() != copyModeCopy || stats.Copied != 1 {
+		t.Fatalf("copied backup = %+v", stats)
+	}
+	restored := filepath.Join(t.TempDir(), "World")
+	if err := restoreSaveBackup(context.Background(), filepath.Join(root, "mastermind_2024-05-01_11-00-00"), restored, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(restored, "Region", "r.0.0.7rg")); string(data) != "region changed" || len(manifest.Files) != 2 {
+		t.Fatalf("restored region %q", data)
+	}
+	if (saveCopyStats{}).mode() != copyModeUnchanged || (saveCopyStats{Reflinked: 1, Copied: 1}).mode() != copyModeMixed {
+		t.Fatal("copy mode summary")
+	}
+}
+
+func TestCopySaveFileFallsBackWithoutReflinks(t *testing.T) {
+	previous := cloneFile
+	t.Cleanup(func() { cloneFile = previous })
+	cloneFile = func(*os.File, *os.File) error { return errors.ErrUnsupported }
+	dir := t.TempDir()
+	source := filepath.Join(dir, "players.xml")
+	if err := os.WriteFile(source, []byte("<persistentplayerdata/>"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(dir, "copy.xml")
+	if err := copySaveFile(source, target, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "<persistentplayerdata/>" {
+		t.Fatalf("copy = %q", data)
+	}
+}
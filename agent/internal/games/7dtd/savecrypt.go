@@ -0,0 +1,414 @@
+package sevendtd
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Encrypted backups are archives sealed with a random AES-256-GCM data key
+// that is wrapped to a public key configured on the agent. Only the public
+// key is kept on the host; the private key is supplied with the job that
+// restores or fully verifies the backup. The manifest keeps its file list
+// sealed with the same key, since player file names carry platform IDs.
+const (
+	saveSchemeX25519 = "x25519-hkdf-sha256+aes-256-gcm"
+	saveSchemeRSA    = "rsa-oaep-sha256+aes-256-gcm"
+	saveEncryptedExt = ".enc"
+	// saveChunkSize is the plaintext size of each sealed archive chunk.
+	saveChunkSize = 64 << 10
+)
+
+// Nonces are the stream ID, a chunk counter and a final-chunk flag, so
+// chunks cannot be reordered, moved between streams or dropped at the end.
+const (
+	saveStreamArchive  = 1
+	saveStreamManifest = 2
+)
+
+var saveKeyInfo = []byte("mastermind save backup key")
+
+// saveEncryption records how a backup's data key was wrapped.
+type saveEncryption struct {
+	Scheme      string `json:"scheme"`
+	Fingerprint string `json:"fingerprint"`
+	// EphemeralKey is the sender's X25519 public key.
+	EphemeralKey string `json:"ephemeralKey,omitempty"`
+	WrappedKey   string `json:"wrappedKey"`
+}
+
+// sealedSaveFiles is the part of an encrypted backup's manifest kept sealed.
+type sealedSaveFiles struct {
+	Dirs  []saveManifestDir  `json:"dirs"`
+	Files []saveManifestFile `json:"files"`
+}
+
+// BackupRecipient is the public key new backups are encrypted to.
+type BackupRecipient struct {
+	Fingerprint string
+	x25519      *ecdh.PublicKey
+	rsa         *rsa.PublicKey
+}
+
+// LoadBackupRecipient reads a PEM public key (X25519, or RSA of at least
+// 2048 bits) from path.
+func LoadBackupRecipient(path string) (*BackupRecipient, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseBackupRecipient(data)
+}
+
+func parseBackupRecipient(data []byte) (*BackupRecipient, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("backup recipient is not a PEM public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse backup recipient: %w", err)
+	}
+	recipient := &BackupRecipient{Fingerprint: keyFingerprint(block.Bytes)}
+	switch key := key.(type) {
+	case *ecdh.PublicKey:
+		if key.Curve() != ecdh.X25519() {
+			return nil, fmt.Errorf("backup recipient must be an X25519 or RSA key")
+		}
+		recipient.x25519 = key
+	case *rsa.PublicKey:
+		if key.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("backup recipient RSA key is shorter than 2048 bits")
+		}
+		recipient.rsa = key
+	default:
+		return nil, fmt.Errorf("backup recipient must be an X25519 or RSA key")
+	}
+	return recipient, nil
+}
+
+// keyFingerprint identifies a key by the SHA-256 of its DER public key.
+func keyFingerprint(publicDER []byte) string {
+	sum := sha256.Sum256(publicDER)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// newDataKey returns a fresh data key and its wrapping for the recipient.
+func (r *BackupRecipient) newDataKey() ([]byte, *saveEncryption, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	encryption := &saveEncryption{Fingerprint: r.Fingerprint}
+	if r.rsa != nil {
+		wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, r.rsa, key, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		encryption.Scheme, encryption.WrappedKey = saveSchemeRSA, base64.StdEncoding.EncodeToString(wrapped)
+		return key, encryption, nil
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	shared, err := ephemeral.ECDH(r.x25519)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := newSaveAEAD(wrappingKey(shared, ephemeral.PublicKey().Bytes(), r.x25519.Bytes()))
+	if err != nil {
+		return nil, nil, err
+	}
+	encryption.Scheme = saveSchemeX25519
+	encryption.EphemeralKey = base64.StdEncoding.EncodeToString(ephemeral.PublicKey().Bytes())
+	encryption.WrappedKey = base64.StdEncoding.EncodeToString(aead.Seal(nil, make([]byte, aead.NonceSize()), key, nil))
+	return key, encryption, nil
+}
+
+// wrappingKey derives the key-encryption key with HKDF-SHA256 from the
+// X25519 shared secret, salted with both public keys.
+func wrappingKey(shared, ephemeral, recipient []byte) []byte {
+	extract := hmac.New(sha256.New, append(append([]byte{}, ephemeral...), recipient...))
+	extract.Write(shared)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(saveKeyInfo)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
+
+// saveIdentity is a private key supplied to open encrypted backups.
+type saveIdentity struct {
+	fingerprint string
+	x25519      *ecdh.PrivateKey
+	rsa         *rsa.PrivateKey
+}
+
+// parseSaveIdentity reads a PEM private key: PKCS#8 for X25519 or RSA, or
+// PKCS#1 for RSA. An empty string means no key was supplied.
+func parseSaveIdentity(text string) (*saveIdentity, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	block, _ := pem.Decode([]byte(text))
+	if block == nil {
+		return nil, fmt.Errorf("private_key is not a PEM private key")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("private_key is not a PEM private key")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse private_key: %w", err)
+	}
+	identity := &saveIdentity{}
+	var public interface{}
+	switch key := key.(type) {
+	case *ecdh.PrivateKey:
+		if key.Curve() != ecdh.X25519() {
+			return nil, fmt.Errorf("private_key must be an X25519 or RSA key")
+		}
+		identity.x25519, public = key, key.PublicKey()
+	case *rsa.PrivateKey:
+		identity.rsa, public = key, &key.PublicKey
+	default:
+		return nil, fmt.Errorf("private_key must be an X25519 or RSA key")
+	}
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+	identity.fingerprint = keyFingerprint(der)
+	return identity, nil
+}
+
+// dataKey unwraps the data key of a backup encrypted to this identity.
+func (id *saveIdentity) dataKey(encryption *saveEncryption) ([]byte, error) {
+	if id.fingerprint != encryption.Fingerprint {
+		return nil, fmt.Errorf("backup is encrypted to key %s, not %s", encryption.Fingerprint, id.fingerprint)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(encryption.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("backup has an invalid wrapped key")
+	}
+	switch {
+	case encryption.Scheme == saveSchemeRSA && id.rsa != nil:
+		key, err := rsa.DecryptOAEP(sha256.New(), nil, id.rsa, wrapped, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unwrap backup key: %w", err)
+		}
+		return key, nil
+	case encryption.Scheme == saveSchemeX25519 && id.x25519 != nil:
+		ephemeralBytes, err := base64.StdEncoding.DecodeString(encryption.EphemeralKey)
+		if err != nil {
+			return nil, fmt.Errorf("backup has an invalid ephemeral key")
+		}
+		ephemeral, err := ecdh.X25519().NewPublicKey(ephemeralBytes)
+		if err != nil {
+			return nil, fmt.Errorf("backup has an invalid ephemeral key")
+		}
+		shared, err := id.x25519.ECDH(ephemeral)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := newSaveAEAD(wrappingKey(shared, ephemeralBytes, id.x25519.PublicKey().Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		key, err := aead.Open(nil, make([]byte, aead.NonceSize()), wrapped, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unwrap backup key: %w", err)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported backup encryption scheme %q", encryption.Scheme)
+}
+
+func newSaveAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func saveNonce(stream byte, counter uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	nonce[0] = stream
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// sealSaveFiles encrypts the file list of an encrypted backup's manifest.
+func sealSaveFiles(key []byte, manifest *saveManifest) (string, error) {
+	aead, err := newSaveAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(sealedSaveFiles{Dirs: manifest.Dirs, Files: manifest.Files})
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nil, saveNonce(saveStreamManifest, 0, true), data, nil)), nil
+}
+
+// openSaveManifest unseals the file list of an encrypted manifest in place
+// and returns the data key. Unencrypted manifests return a nil key.
+func openSaveManifest(manifest *saveManifest, identity *saveIdentity) ([]byte, error) {
+	if manifest.Encryption == nil {
+		return nil, nil
+	}
+	if identity == nil {
+		return nil, fmt.Errorf("backup is encrypted to key %s; supply private_key", manifest.Encryption.Fingerprint)
+	}
+	key, err := identity.dataKey(manifest.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(manifest.Sealed)
+	if err != nil {
+		return nil, fmt.Errorf("backup manifest has an invalid sealed file list")
+	}
+	aead, err := newSaveAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := aead.Open(nil, saveNonce(saveStreamManifest, 0, true), sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open sealed file list: %w", err)
+	}
+	opened, err := parseSaveManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Dirs, manifest.Files = opened.Dirs, opened.Files
+	return key, nil
+}
+
+// saveEncrypter seals everything written to it in saveChunkSize chunks. A
+// chunk is only sealed once more data follows it, so Close can mark the
+// last one final.
+type saveEncrypter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+}
+
+func newSaveEncrypter(w io.Writer, key []byte) (*saveEncrypter, error) {
+	aead, err := newSaveAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &saveEncrypter{w: w, aead: aead, buf: make([]byte, 0, saveChunkSize)}, nil
+}
+
+func (e *saveEncrypter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(e.buf) == saveChunkSize {
+			if err := e.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):saveChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *saveEncrypter) flush(final bool) error {
+	sealed := e.aead.Seal(nil, saveNonce(saveStreamArchive, e.counter, final), e.buf, nil)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// Close seals the final chunk but leaves the underlying writer open.
+func (e *saveEncrypter) Close() error {
+	return e.flush(true)
+}
+
+// saveDecrypter opens the chunks written by saveEncrypter and fails on a
+// tampered, reordered or truncated stream.
+type saveDecrypter struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	chunk   []byte
+	plain   []byte
+	counter uint64
+	done    bool
+}
+
+func newSaveDecrypter(r io.Reader, key []byte) (*saveDecrypter, error) {
+	aead, err := newSaveAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &saveDecrypter{r: bufio.NewReaderSize(r, saveChunkSize+aead.Overhead()+1), aead: aead, chunk: make([]byte, saveChunkSize+aead.Overhead())}, nil
+}
+
+func (d *saveDecrypter) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *saveDecrypter) next() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	final := false
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF):
+		final = true
+	case err != nil:
+		return err
+	default:
+		if _, err := d.r.Peek(1); errors.Is(err, io.EOF) {
+			final = true
+		} else if err != nil {
+			return err
+		}
+	}
+	plain, err := d.aead.Open(d.chunk[:0], saveNonce(saveStreamArchive, d.counter, final), d.chunk[:n], nil)
+	if err != nil {
+		return fmt.Errorf("decrypt archive chunk %d: archive is corrupt, truncated or sealed with another key", d.counter)
+	}
+	d.counter++
+	d.plain, d.done = plain, final
+	return nil
+}
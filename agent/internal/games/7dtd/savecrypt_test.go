@@ -0,0 +1,137 @@
+package sevendtd
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testBackupKeys returns a recipient and the matching private key PEM.
+func testBackupKeys(t *testing.T, rsaKey bool) (*BackupRecipient, string) {
+	t.Helper()
+	var private, public interface{}
+	if rsaKey {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		private, public = key, &key.PublicKey
+	} else {
+		key, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		private, public = key, key.PublicKey()
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, err := parseBackupRecipient(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recipient, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}))
+}
+
+func TestEncryptedArchiveBackup(t *testing.T) {
+	noise := make([]byte, 3*saveChunkSize+100)
+	if _, err := rand.Read(noise); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{"main.ttw": "world header", "Region/r.0.0.7rg": string(noise), "Player/Steam_76561198000000000.ttp": "player"}
+	live := writeTestWorld(t, files)
+	for _, scheme := range []string{saveSchemeX25519, saveSchemeRSA} {
+		t.Run(scheme, func(t *testing.T) {
+			recipient, privatePEM := testBackupKeys(t, scheme == saveSchemeRSA)
+			identity, err := parseSaveIdentity(privatePEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+			backup := filepath.Join(t.TempDir(), "mastermind_2024-05-01_10-00-00")
+			manifest, _, err := writeSaveArchive(ctx, live, backup, saveFormatTarGzip, recipient)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if manifest.Encryption == nil || manifest.Encryption.Scheme != scheme || manifest.Encryption.Fingerprint != identity.fingerprint {
+				t.Fatalf("manifest encryption = %+v", manifest.Encryption)
+			}
+			if manifest.logicalSize() != int64(len(noise)+len("world header")+len("player")) {
+				t.Fatalf("logical size = %d", manifest.logicalSize())
+			}
+			// Player file names carry platform IDs and stay sealed.
+			onDisk, _ := os.ReadFile(filepath.Join(backup, saveManifestName))
+			if strings.Contains(string(onDisk), "76561198000000000") {
+				t.Fatal("manifest on disk names player files")
+			}
+
+			if result := verifySaveBackup(ctx, backup, nil); !result.OK || !result.Partial || !result.Encrypted {
+				t.Fatalf("verification without key = %+v", result)
+			}
+			if result := verifySaveBackup(ctx, backup, identity); !result.OK || result.Partial || result.Checked != len(files) {
+				t.Fatalf("verification with key = %+v", result)
+			}
+			restored := filepath.Join(t.TempDir(), "World")
+			if err := restoreSaveBackup(ctx, backup, restored, nil, false); err == nil || !strings.Contains(err.Error(), "private_key") {
+				t.Fatalf("restore without key: %v", err)
+			}
+			_, otherPEM := testBackupKeys(t, false)
+			other, _ := parseSaveIdentity(otherPEM)
+			if err := restoreSaveBackup(ctx, backup, restored, other, false); err == nil || !strings.Contains(err.Error(), "encrypted to key") {
+				t.Fatalf("restore with the wrong key: %v", err)
+			}
+			if err := restoreSaveBackup(ctx, backup, restored, identity, false); err != nil {
+				t.Fatal(err)
+			}
+			for rel, content := range files {
+				if data, err := os.ReadFile(filepath.Join(restored, rel)); err != nil || string(data) != content {
+					t.Fatalf("restored %s differs: %v", rel, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSaveDecrypterDetectsTruncationAtChunkBoundary(t *testing.T) {
+	key := make([]byte, 32)
+	plain := make([]byte, 3*saveChunkSize)
+	if _, err := rand.Read(plain); err != nil {
+		t.Fatal(err)
+	}
+	var sealed bytes.Buffer
+	encrypter, err := newSaveEncrypter(&sealed, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encrypter.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := encrypter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	decrypter, _ := newSaveDecrypter(bytes.NewReader(sealed.Bytes()), key)
+	if opened, err := io.ReadAll(decrypter); err != nil || !bytes.Equal(opened, plain) {
+		t.Fatalf("round trip: %v", err)
+	}
+	// Dropping the last whole chunk leaves every remaining chunk authentic;
+	// only the missing final flag gives the truncation away.
+	truncated := sealed.Bytes()[:2*(saveChunkSize+16)]
+	decrypter, _ = newSaveDecrypter(bytes.NewReader(truncated), key)
+	if _, err := io.ReadAll(decrypter); err == nil {
+		t.Fatal("truncated stream decrypted without error")
+	}
+}
@@ -0,0 +1,345 @@
+package sevendtd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+// diffFile is what a diff knows about one world file on one side.
+type diffFile struct {
+	size    int64
+	modTime time.Time
+	// hash is the recorded SHA-256 of manifest backups; files on disk are
+	// hashed only when size and mtime cannot settle the comparison.
+	hash string
+}
+
+// diffSide is one side of a save diff: the live world or a backup.
+type diffSide struct {
+	label string
+	files map[string]diffFile
+	// dir holds the files on disk for the live world and backups copied as
+	// plain directories; it is empty for manifest backups.
+	dir string
+	// names maps player IDs to names from the side's players.xml.
+	names map[string]string
+	// regionsOnly marks Region Healer snapshots, which hold nothing but
+	// the Region directory.
+	regionsOnly bool
+}
+
+func (s *diffSide) hashOf(ctx context.Context, rel string) (string, error) {
+	if file := s.files[rel]; file.hash != "" {
+		return file.hash, nil
+	}
+	if s.dir == "" {
+		return "", fmt.Errorf("no checksum for %s", rel)
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	in, err := os.Open(filepath.Join(s.dir, filepath.FromSlash(rel)))
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, in); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+type regionDiff struct {
+	Path      string      `json:"path"`
+	Region    regionCoord `json:"region"`
+	Change    string      `json:"change"`
+	FromBytes int64       `json:"fromBytes"`
+	ToBytes   int64       `json:"toBytes"`
+	DeltaSize int64       `json:"deltaBytes"`
+}
+
+type playerDiff struct {
+	ID         string   `json:"id"`
+	PlayerName string   `json:"playerName,omitempty"`
+	Change     string   `json:"change"`
+	Files      []string `json:"files"`
+	DeltaSize  int64    `json:"deltaBytes"`
+}
+
+type fileDiff struct {
+	Path      string `json:"path"`
+	Change    string `json:"change"`
+	FromBytes int64  `json:"fromBytes"`
+	ToBytes   int64  `json:"toBytes"`
+	DeltaSize int64  `json:"deltaBytes"`
+}
+
+type saveDiff struct {
+	From    string       `json:"from"`
+	To      string       `json:"to"`
+	Regions []regionDiff `json:"regions"`
+	Players []playerDiff `json:"players"`
+	Files   []fileDiff   `json:"files"`
+	// OtherChanged counts changed files in other directories.
+	OtherChanged int `json:"otherChanged"`
+	// RegionsOnly is set when a Region Healer snapshot limited the
+	// comparison to region files.
+	RegionsOnly bool           `json:"regionsOnly,omitempty"`
+	Summary     map[string]int `json:"summary"`
+	DeltaBytes  int64          `json:"deltaBytes"`
+}
+
+// DiffSaves compares backup id with the live world, or with backup otherID
+// when it is set. Changes read from id to the other side: "added" files
+// exist only in the live world or otherID.
+func (a *Adapter) DiffSaves(ctx context.Context, cfg *agent.InstanceConfig, configOverride, id, otherID string, identity *saveIdentity) (*saveDiff, error) {
+	ns, err := a.saveNamespace(cfg)
+	if err != nil {
+		return nil, err
+	}
+	from, err := backupDiffSide(ctx, ns, id, identity)
+	if err != nil {
+		return nil, err
+	}
+	var to *diffSide
+	if otherID != "" {
+		to, err = backupDiffSide(ctx, ns, otherID, identity)
+	} else {
+		to, err = liveDiffSide(cfg, configOverride)
+	}
+	if err != nil {
+		return nil, err
+	}
+	agent.ReportProgress(ctx, "diff", fmt.Sprintf("Comparing %s with %s", from.label, to.label))
+	return compareSaveSides(ctx, from, to)
+}
+
+func liveDiffSide(cfg *agent.InstanceConfig, configOverride string) (*diffSide, error) {
+	live, err := resolveLiveSave(cfg, configOverride)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(live); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("live save not found")
+	}
+	files, err := scanDiffTree(live)
+	if err != nil {
+		return nil, fmt.Errorf("scan live save: %w", err)
+	}
+	return &diffSide{label: "live", files: files, dir: live, names: profilePlayerNames(live)}, nil
+}
+
+func backupDiffSide(ctx context.Context, ns saveNamespace, id string, identity *saveIdentity) (*diffSide, error) {
+	dir, err := ns.backupPath(id)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Lstat(dir); err != nil || !info.IsDir() || info.Mode()&os.ModeSymlink != 0 {
+		return nil, fmt.Errorf("save backup %s not found", id)
+	}
+	side := &diffSide{label: id}
+	if !hasSaveManifest(dir) {
+		if side.files, err = scanDiffTree(dir); err != nil {
+			return nil, fmt.Errorf("scan backup %s: %w", id, err)
+		}
+		side.dir, side.names = dir, profilePlayerNames(dir)
+		_, metadataErr := readSaveMetadata(dir)
+		side.regionsOnly = os.IsNotExist(metadataErr)
+		return side, nil
+	}
+	manifest, err := readSaveManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := openSaveManifest(manifest, identity); err != nil {
+		return nil, err
+	}
+	side.files = make(map[string]diffFile, len(manifest.Files))
+	for _, file := range manifest.Files {
+		side.files[file.Path] = diffFile{size: file.Size, modTime: file.ModTime, hash: file.Hash}
+	}
+	side.names = map[string]string{}
+	if _, ok := side.files["players.xml"]; ok {
+		// Player names come from the backup's own players.xml, which may
+		// have to be read out of an archive.
+		tmp, err := os.MkdirTemp("", "mastermind-diff-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmp)
+		if _, err := extractBackupFiles(ctx, dir, map[string]bool{"players.xml": true}, tmp, identity); err != nil {
+			return nil, fmt.Errorf("read players.xml of %s: %w", id, err)
+		}
+		side.names = profilePlayerNames(tmp)
+	}
+	return side, nil
+}
+
+// scanDiffTree lists the regular files under root by slash-separated
+// relative path, without following symlinks.
+func scanDiffTree(root string) (map[string]diffFile, error) {
+	files := make(map[string]diffFile)
+	err := filepath.Walk(root, func(current string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, current)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = diffFile{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	return files, err
+}
+
+// playerProfileID returns the player ID of a Player/<id>.ttp profile or its
+// companion files (.ttp.bak, .map), or false for other files.
+func playerProfileID(rel string) (string, bool) {
+	dir, name := path.Split(rel)
+	if dir != "Player/" {
+		return "", false
+	}
+	for _, ext := range []string{".ttp.bak", ".ttp", ".map"} {
+		if strings.HasSuffix(strings.ToLower(name), ext) {
+			return name[:len(name)-len(ext)], true
+		}
+	}
+	return "", false
+}
+
+func compareSaveSides(ctx context.Context, from, to *diffSide) (*saveDiff, error) {
+	diff := &saveDiff{From: from.label, To: to.label, Regions: []regionDiff{}, Players: []playerDiff{}, Files: []fileDiff{}, Summary: map[string]int{}}
+	diff.RegionsOnly = from.regionsOnly || to.regionsOnly
+	paths := make(map[string]bool, len(from.files)+len(to.files))
+	for rel := range from.files {
+		paths[rel] = true
+	}
+	for rel := range to.files {
+		paths[rel] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for rel := range paths {
+		sorted = append(sorted, rel)
+	}
+	sort.Strings(sorted)
+	players := make(map[string]*playerDiff)
+	var playerOrder []string
+	for _, rel := range sorted {
+		region, isRegion := parseRegionName(strings.TrimPrefix(rel, "Region/"))
+		isRegion = isRegion && strings.HasPrefix(rel, "Region/")
+		if diff.RegionsOnly && !isRegion {
+			continue
+		}
+		change, err := diffChange(ctx, from, to, rel)
+		if err != nil {
+			return nil, fmt.Errorf("compare %s: %w", rel, err)
+		}
+		if change == "" {
+			continue
+		}
+		fromSize, toSize := from.files[rel].size, to.files[rel].size
+		delta := toSize - fromSize
+		diff.DeltaBytes += delta
+		if isRegion {
+			diff.Regions = append(diff.Regions, regionDiff{Path: rel, Region: region, Change: change, FromBytes: fromSize, ToBytes: toSize, DeltaSize: delta})
+			diff.Summary[summaryKey("regions", change)]++
+			continue
+		}
+		if id, ok := playerProfileID(rel); ok {
+			player := players[id]
+			if player == nil {
+				player = &playerDiff{ID: id, PlayerName: diffPlayerName(id, to.names, from.names), Change: change, Files: []string{}}
+				players[id] = player
+				playerOrder = append(playerOrder, id)
+			}
+			if player.Change != change {
+				player.Change = "modified"
+			}
+			player.Files = append(player.Files, rel)
+			player.DeltaSize += delta
+			continue
+		}
+		if !strings.Contains(rel, "/") {
+			diff.Files = append(diff.Files, fileDiff{Path: rel, Change: change, FromBytes: fromSize, ToBytes: toSize, DeltaSize: delta})
+			diff.Summary[summaryKey("files", change)]++
+			continue
+		}
+		diff.OtherChanged++
+	}
+	for _, id := range playerOrder {
+		diff.Players = append(diff.Players, *players[id])
+		diff.Summary[summaryKey("players", players[id].Change)]++
+	}
+	return diff, nil
+}
+
+// diffChange classifies rel as "added", "removed", "modified" or unchanged
+// (""). Matching size and mtime count as unchanged, as they do when a backup
+// decides which files to read again.
+func diffChange(ctx context.Context, from, to *diffSide, rel string) (string, error) {
+	a, inFrom := from.files[rel]
+	b, inTo := to.files[rel]
+	switch {
+	case !inFrom:
+		return "added", nil
+	case !inTo:
+		return "removed", nil
+	case a.size != b.size:
+		return "modified", nil
+	case a.hash != "" && b.hash != "":
+		if a.hash != b.hash {
+			return "modified", nil
+		}
+		return "", nil
+	case a.modTime.Equal(b.modTime):
+		return "", nil
+	}
+	hashA, err := from.hashOf(ctx, rel)
+	if err != nil {
+		return "", err
+	}
+	hashB, err := to.hashOf(ctx, rel)
+	if err != nil {
+		return "", err
+	}
+	if hashA != hashB {
+		return "modified", nil
+	}
+	return "", nil
+}
+
+// summaryKey names a summary count, such as regionsAdded.
+func summaryKey(category, change string) string {
+	return category + strings.ToUpper(change[:1]) + change[1:]
+}
+
+// diffPlayerName looks the profile's ID up as players.xml records it
+// (userid), then without its platform prefix the way profileOwner does
+// (nativeuserid).
+func diffPlayerName(id string, names ...map[string]string) string {
+	keys := []string{strings.ToLower(id), strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(id, "EOS_"), "Steam_"))}
+	for _, side := range names {
+		for _, key := range keys {
+			if name := side[key]; name != "" {
+				return name
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,98 @@
+package sevendtd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+func TestDiffSavesAgainstLiveAndBackups(t *testing.T) {
+	previous := saveBackupRoot
+	saveBackupRoot = t.TempDir()
+	t.Cleanup(func() { saveBackupRoot = previous })
+	ctx := context.Background()
+	players := `<persistentplayerdata><player userid="EOS_0002a1b2c3" nativeplatform="Steam" nativeuserid="76561198000000001" playername="Alice"/></persistentplayerdata>`
+	config, live := writeTestServer(t, map[string]string{
+		"main.ttw":                           "world header",
+		"players.xml":                        players,
+		"Region/r.0.0.7rg":                   "region 0,0",
+		"Region/r.1.0.7rg":                   "region 1,0",
+		"Region/r.2.0.7rg":                   "same bytes",
+		"Player/Steam_76561198000000001.ttp": "alice",
+		"Player/Steam_76561198000000001.map": "alice map",
+		"Player/Steam_76561198000000002.ttp": "bob",
+		"DynamicMeshes/0,0.mesh":             "mesh",
+	})
+	base := "mastermind_2024-05-01_10-00-00"
+	if _, _, err := writeSaveArchive(ctx, live, filepath.Join(saveBackupRoot, base), saveFormatTarGzip, nil); err != nil {
+		t.Fatal(err)
+	}
+	write := func(rel, content string) {
+		path := filepath.Join(live, filepath.FromSlash(rel))
+		if err := os.WriteFile(path, []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("Region/r.0.0.7rg", "region 0,0 griefed")
+	write("Region/r.3.3.7rg", "new region")
+	write("Player/Steam_76561198000000001.ttp", "alice levelled")
+	write("players.xml", players+" ")
+	write("DynamicMeshes/0,0.mesh", "mesh 2")
+	if err := os.Remove(filepath.Join(live, "Region", "r.1.0.7rg")); err != nil {
+		t.Fatal(err)
+	}
+	// Touched but identical content is not a change.
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(live, "Region", "r.2.0.7rg"), later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewAdapter()
+	cfg := &agent.InstanceConfig{}
+	diff, err := a.DiffSaves(ctx, cfg, config, base, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := map[string]regionDiff{}
+	for _, region := range diff.Regions {
+		changes[region.Path] = region
+	}
+	if len(changes) != 3 || changes["Region/r.0.0.7rg"].Change != "modified" || changes["Region/r.0.0.7rg"].DeltaSize != 8 ||
+		changes["Region/r.1.0.7rg"].Change != "removed" || changes["Region/r.3.3.7rg"].Change != "added" || changes["Region/r.3.3.7rg"].Region != (regionCoord{3, 3}) {
+		t.Fatalf("regions = %+v", diff.Regions)
+	}
+	if len(diff.Players) != 1 || diff.Players[0].PlayerName != "Alice" || diff.Players[0].Change != "modified" {
+		t.Fatalf("players = %+v", diff.Players)
+	}
+	if len(diff.Files) != 1 || diff.Files[0].Path != "players.xml" || diff.Files[0].DeltaSize != 1 {
+		t.Fatalf("files = %+v", diff.Files)
+	}
+	if diff.OtherChanged != 1 || diff.Summary["regionsAdded"] != 1 || diff.Summary["playersModified"] != 1 {
+		t.Fatalf("diff = %+v", diff)
+	}
+
+	// Between two backups, with the newer one stored as a manifest.
+	newer := "mastermind_2024-05-01_11-00-00"
+	if _, _, err := storeSaveTree(ctx, live, filepath.Join(saveBackupRoot, newer)); err != nil {
+		t.Fatal(err)
+	}
+	write("Region/r.0.0.7rg", "after both backups")
+	between, err := a.DiffSaves(ctx, cfg, config, base, newer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(between.Regions) != 3 || between.To != newer {
+		t.Fatalf("between backups = %+v", between.Regions)
+	}
+	since, err := a.DiffSaves(ctx, cfg, config, newer, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(since.Regions) != 1 || since.Regions[0].Path != "Region/r.0.0.7rg" || len(since.Players) != 0 || len(since.Files) != 0 {
+		t.Fatalf("since newer backup = %+v", since)
+	}
+}
@@ -0,0 +1,299 @@
+package sevendtd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/mastermind/agent/internal/agent"
+	"github.com/mastermind/agent/internal/remotestore"
+)
+
+// Each server instance keeps its backups in its own namespace,
+// <root>/.mastermind-instances/<server instance ID>, laid out like a flat
+// backup root with its own blob store. Region Healer keeps writing its
+// snapshots into saveBackupRoot itself; only the instance named as their
+// owner lists and restores them.
+const saveNamespaceDir = ".mastermind-instances"
+
+var instanceIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,127}$`)
+
+type saveNamespace struct {
+	// instanceID is empty for jobs without a server instance, which keep
+	// using the flat legacy root.
+	instanceID string
+	// root holds the instance's backups and their blob store.
+	root string
+	// snapshots is the directory with Region Healer snapshots the instance
+	// owns, or empty.
+	snapshots string
+}
+
+// saveNamespace resolves the backup namespace of cfg's server instance from
+// the agent configuration; job payloads cannot move it.
+func (a *Adapter) saveNamespace(cfg *agent.InstanceConfig) (saveNamespace, error) {
+	id := cfg.ServerInstanceID
+	if id == "" {
+		return saveNamespace{root: saveBackupRoot, snapshots: saveBackupRoot}, nil
+	}
+	if !instanceIDPattern.MatchString(id) {
+		return saveNamespace{}, fmt.Errorf("invalid server instance ID")
+	}
+	base := a.SaveBackupRoots[id]
+	if base == "" {
+		base = a.SaveBackupRoot
+	}
+	if base == "" {
+		base = saveBackupRoot
+	}
+	ns := saveNamespace{instanceID: id, root: filepath.Join(base, saveNamespaceDir, id)}
+	if a.RegionHealerInstance == id {
+		ns.snapshots = saveBackupRoot
+	}
+	return ns, nil
+}
+
+// backupPath returns the directory of backup id in the namespace.
+func (ns saveNamespace) backupPath(id string) (string, error) {
+	if !validSaveID(id) {
+		return "", fmt.Errorf("invalid save ID")
+	}
+	if !strings.HasPrefix(id, "mastermind_") && ns.snapshots != "" {
+		return filepath.Join(ns.snapshots, id), nil
+	}
+	return filepath.Join(ns.root, id), nil
+}
+
+// backupDirs lists the backup directories of the namespace: agent backups
+// in its root and the Region Healer snapshots it owns.
+func (ns saveNamespace) backupDirs() ([]string, error) {
+	var dirs []string
+	entries, err := os.ReadDir(ns.root)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read save backups: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && validSaveID(entry.Name()) {
+			dirs = append(dirs, filepath.Join(ns.root, entry.Name()))
+		}
+	}
+	if ns.snapshots != "" && ns.snapshots != ns.root {
+		entries, err := os.ReadDir(ns.snapshots)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("read Region Healer snapshots: %w", err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && validSaveID(entry.Name()) && !strings.HasPrefix(entry.Name(), "mastermind_") {
+				dirs = append(dirs, filepath.Join(ns.snapshots, entry.Name()))
+			}
+		}
+	}
+	return dirs, nil
+}
+
+// agentBackupIDs lists the mastermind_* backups in the namespace root.
+func (ns saveNamespace) agentBackupIDs() ([]string, error) {
+	entries, err := os.ReadDir(ns.root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read save backups: %w", err)
+	}
+	var ids []string
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), "mastermind_") && validSaveID(entry.Name()) {
+			ids = append(ids, entry.Name())
+		}
+	}
+	return ids, nil
+}
+
+// remoteStore scopes a backup target to the namespace: an instance's copies
+// live under instances/<server instance ID>/ on the target.
+func (ns saveNamespace) remoteStore(store remotestore.Store) remotestore.Store {
+	if ns.instanceID == "" {
+		return store
+	}
+	return prefixedStore{Store: store, prefix: "instances/" + ns.instanceID}
+}
+
+type prefixedStore struct {
+	remotestore.Store
+	prefix string
+}
+
+func (p prefixedStore) key(key string) string {
+	if key == "" {
+		return p.prefix
+	}
+	return p.prefix + "/" + key
+}
+
+func (p prefixedStore) Put(ctx context.Context, key, path string) error {
+	return p.Store.Put(ctx, p.key(key), path)
+}
+
+func (p prefixedStore) Get(ctx context.Context, key string, w io.Writer) error {
+	return p.Store.Get(ctx, p.key(key), w)
+}
+
+func (p prefixedStore) Size(ctx context.Context, key string) (int64, error) {
+	return p.Store.Size(ctx, p.key(key))
+}
+
+func (p prefixedStore) List(ctx context.Context, dir string) ([]string, error) {
+	return p.Store.List(ctx, p.key(dir))
+}
+
+func (p prefixedStore) Delete(ctx context.Context, key string) error {
+	return p.Store.Delete(ctx, p.key(key))
+}
+
+type saveMigration struct {
+	DryRun bool `json:"dryRun"`
+	// Unassigned lists the agent backups still in the flat legacy root.
+	Unassigned []string `json:"unassigned"`
+	Moved      []string `json:"moved"`
+}
+
+// MigrateSaves moves agent backups from the flat legacy root into cfg's
+// instance namespace: the listed ids, or every unassigned backup with all.
+// Backups from before namespaces do not record which server they came from,
+// so the caller decides; on a single-server host that is simply all.
+func (a *Adapter) MigrateSaves(ctx context.Context, cfg *agent.InstanceConfig, ids []string, all, dryRun bool) (*saveMigration, error) {
+	ns, err := a.saveNamespace(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if ns.instanceID == "" {
+		return nil, fmt.Errorf("save migration needs a server instance")
+	}
+	legacy := saveNamespace{root: saveBackupRoot}
+	unassigned, err := legacy.agentBackupIDs()
+	if err != nil {
+		return nil, err
+	}
+	migration := &saveMigration{DryRun: dryRun, Unassigned: unassigned, Moved: []string{}}
+	if migration.Unassigned == nil {
+		migration.Unassigned = []string{}
+	}
+	selected := unassigned
+	if !all {
+		known := make(map[string]bool, len(unassigned))
+		for _, id := range unassigned {
+			known[id] = true
+		}
+		for _, id := range ids {
+			if !known[id] {
+				return migration, fmt.Errorf("save backup %s is not in the legacy backup root", id)
+			}
+		}
+		selected = ids
+	}
+	if len(selected) == 0 && !dryRun && !all {
+		return migration, fmt.Errorf("choose save_ids or all")
+	}
+	if dryRun {
+		migration.Moved = append(migration.Moved, selected...)
+		return migration, nil
+	}
+	sort.Strings(selected)
+	for _, id := range selected {
+		agent.ReportProgress(ctx, "migrate", "Moving backup "+id)
+		if err := moveSaveBackup(ctx, saveBackupRoot, ns.root, id); err != nil {
+			return migration, fmt.Errorf("move %s: %w", id, err)
+		}
+		migration.Moved = append(migration.Moved, id)
+	}
+	if len(migration.Moved) > 0 {
+		if err := collectSaveBlobs(saveBackupRoot); err != nil {
+			return migration, fmt.Errorf("release migrated backup data: %w", err)
+		}
+	}
+	return migration, nil
+}
+
+// copySaveBlob copies blob from into the store under root as to. The copy is
+// written to the store's tmp directory and renamed into place, so an
+// interrupted move never leaves a truncated blob under its hash.
+func copySaveBlob(from, root, to string) error {
+	if err := os.MkdirAll(saveStorePath(root, "tmp"), 0750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(saveStorePath(root, "tmp"), "blob-*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := copySaveFile(from, tmp.Name(), 0640); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), to)
+}
+
+// moveSaveBackup moves backup id from one backup root to another, bringing
+// the blobs its manifest references into the destination store first.
+// Blobs are hard-linked where both roots share a filesystem.
+func moveSaveBackup(ctx context.Context, fromRoot, toRoot, id string) error {
+	saveStoreMu.Lock()
+	defer saveStoreMu.Unlock()
+	source, destination := filepath.Join(fromRoot, id), filepath.Join(toRoot, id)
+	if _, err := os.Lstat(destination); err == nil {
+		return fmt.Errorf("backup already exists in the namespace")
+	}
+	if err := os.MkdirAll(toRoot, 0770); err != nil {
+		return err
+	}
+	if hasSaveManifest(source) {
+		manifest, err := readSaveManifest(source)
+		if err != nil {
+			return err
+		}
+		for _, file := range manifest.Files {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if manifest.Archive != "" {
+				break
+			}
+			from, to := saveBlobPath(fromRoot, file.Hash), saveBlobPath(toRoot, file.Hash)
+			if _, err := os.Stat(to); err == nil {
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(to), 0750); err != nil {
+				return err
+			}
+			if err := os.Link(from, to); err != nil {
+				if err := copySaveBlob(from, toRoot, to); err != nil {
+					return fmt.Errorf("copy blob %s: %w", file.Hash, err)
+				}
+			}
+		}
+	}
+	err := os.Rename(source, destination)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	staging := filepath.Join(toRoot, ".migrate-"+id)
+	_ = os.RemoveAll(staging)
+	if err := copySaveTree(source, staging, false); err != nil {
+		_ = os.RemoveAll(staging)
+		return err
+	}
+	if err := os.Rename(staging, destination); err != nil {
+		return err
+	}
+	return os.RemoveAll(source)
+}
@@ -0,0 +1,186 @@
+package sevendtd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+func TestSaveNamespacesIsolateInstances(t *testing.T) {
+	previous := saveBackupRoot
+	saveBackupRoot = t.TempDir()
+	t.Cleanup(func() { saveBackupRoot = previous })
+	bulk := t.TempDir()
+	a := NewAdapter()
+	a.SaveBackupRoots = map[string]string{"srv-b": bulk}
+	a.RegionHealerInstance = "srv-a"
+	cfgA, cfgB := &agent.InstanceConfig{ServerInstanceID: "srv-a"}, &agent.InstanceConfig{ServerInstanceID: "srv-b"}
+	nsA, err := a.saveNamespace(cfgA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nsB, _ := a.saveNamespace(cfgB)
+	if nsA.root != filepath.Join(saveBackupRoot, saveNamespaceDir, "srv-a") || nsB.root != filepath.Join(bulk, saveNamespaceDir, "srv-b") {
+		t.Fatalf("roots %s, %s", nsA.root, nsB.root)
+	}
+	if _, err := a.saveNamespace(&agent.InstanceConfig{ServerInstanceID: "../srv-a"}); err == nil {
+		t.Fatal("path in a server instance ID accepted")
+	}
+
+	ctx := context.Background()
+	live := writeTestWorld(t, map[string]string{"main.ttw": "world header"})
+	id := "mastermind_2024-05-01_10-00-00"
+	for _, ns := range []saveNamespace{nsA, nsB} {
+		dir := filepath.Join(ns.root, id)
+		if _, _, err := storeSaveTree(ctx, live, dir); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, saveMetadataName), []byte(`{"kind":"full-world","format":"manifest"}`), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snapshot := filepath.Join(saveBackupRoot, "snap_2024-05-01_09-00-00")
+	if err := os.MkdirAll(snapshot, 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the Region Healer owner sees the snapshot.
+	dirsA, _ := nsA.backupDirs()
+	dirsB, _ := nsB.backupDirs()
+	sort.Strings(dirsA)
+	if len(dirsA) != 2 || dirsA[1] != snapshot || len(dirsB) != 1 || dirsB[0] != filepath.Join(nsB.root, id) {
+		t.Fatalf("srv-a sees %v, srv-b sees %v", dirsA, dirsB)
+	}
+	if err := a.DeleteSaveBackup(ctx, cfgB, "snap_2024-05-01_09-00-00"); err == nil {
+		t.Fatal("srv-b deleted a snapshot it does not own")
+	}
+	if err := a.DeleteSaveBackup(ctx, cfgB, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(nsA.root, id)); err != nil {
+		t.Fatalf("deleting srv-b's backup touched srv-a's: %v", err)
+	}
+	if result := verifySaveBackup(ctx, filepath.Join(nsA.root, id), nil); !result.OK {
+		t.Fatalf("srv-a backup lost blobs: %+v", result)
+	}
+}
+
+func TestMigrateSavesMovesLegacyBackups(t *testing.T) {
+	previous := saveBackupRoot
+	saveBackupRoot = t.TempDir()
+	t.Cleanup(func() { saveBackupRoot = previous })
+	ctx := context.Background()
+	live := writeTestWorld(t, map[string]string{"main.ttw": "world header", "Region/r.0.0.7rg": "region"})
+	ids := []string{"mastermind_2024-05-01_10-00-00", "mastermind_2024-05-01_11-00-00"}
+	for _, id := range ids {
+		dir := filepath.Join(saveBackupRoot, id)
+		if _, _, err := storeSaveTree(ctx, live, dir); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, saveMetadataName), []byte(`{"kind":"full-world","format":"manifest"}`), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := NewAdapter()
+	cfg := &agent.InstanceConfig{ServerInstanceID: "srv-a"}
+	if _, err := a.MigrateSaves(ctx, &agent.InstanceConfig{}, nil, true, false); err == nil {
+		t.Fatal("migration without a server instance accepted")
+	}
+	migration, err := a.MigrateSaves(ctx, cfg, nil, true, true)
+	if err != nil || strings.Join(migration.Moved, ",") != strings.Join(ids, ",") {
+		t.Fatalf("dry run = %+v, %v", migration, err)
+	}
+	if _, err := os.Stat(filepath.Join(saveBackupRoot, ids[0])); err != nil {
+		t.Fatal("dry run moved a backup")
+	}
+	if _, err := a.MigrateSaves(ctx, cfg, []string{"mastermind_2020-01-01_00-00-00"}, false, false); err == nil {
+		t.Fatal("unknown backup migrated")
+	}
+
+	if _, err := a.MigrateSaves(ctx, cfg, ids[:1], false, false); err != nil {
+		t.Fatal(err)
+	}
+	ns, _ := a.saveNamespace(cfg)
+	if result := verifySaveBackup(ctx, filepath.Join(ns.root, ids[0]), nil); !result.OK {
+		t.Fatalf("migrated backup = %+v", result)
+	}
+	// The backup left behind still has its blobs in the legacy store.
+	if result := verifySaveBackup(ctx, filepath.Join(saveBackupRoot, ids[1]), nil); !result.OK {
+		t.Fatalf("legacy backup = %+v", result)
+	}
+	if _, err := a.MigrateSaves(ctx, cfg, nil, true, false); err != nil {
+		t.Fatal(err)
+	}
+	migration, _ = a.MigrateSaves(ctx, cfg, nil, true, true)
+	if len(migration.Unassigned) != 0 {
+		t.Fatalf("unassigned after migrating all: %v", migration.Unassigned)
+	}
+	entries, _ := os.ReadDir(filepath.Join(saveBackupRoot, ".mastermind-store", "blobs"))
+	for _, entry := range entries {
+		if sub, _ := os.ReadDir(filepath.Join(saveBackupRoot, ".mastermind-store", "blobs", entry.Name())); len(sub) > 0 {
+			t.Fatalf("legacy store kept blobs after migrating everything")
+		}
+	}
+}
+
+func TestReplicationIsNamespacedOnTheTarget(t *testing.T) {
+	previous := saveBackupRoot
+	saveBackupRoot = t.TempDir()
+	t.Cleanup(func() { saveBackupRoot = previous })
+	ctx := context.Background()
+	store := &memoryStore{objects: map[string][]byte{}}
+	a := NewAdapter()
+	a.BackupTargets = map[string]BackupTarget{"offsite": {Store: store}}
+	cfg := &agent.InstanceConfig{ServerInstanceID: "srv-a"}
+	ns, _ := a.saveNamespace(cfg)
+	id := "mastermind_2024-05-01_10-00-00"
+	dir := filepath.Join(ns.root, id)
+	if _, _, err := storeSaveTree(ctx, writeTestWorld(t, map[string]string{"main.ttw": "world header"}), dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, saveMetadataName), []byte(`{"kind":"full-world","format":"manifest"}`), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.ReplicateSaves(ctx, cfg, "offsite", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.objects["instances/srv-a/"+remoteBackupKey(id, saveMetadataName)]; !ok {
+		t.Fatalf("replicated keys outside the namespace")
+	}
+	if saves, err := a.ListRemoteSaves(ctx, &agent.InstanceConfig{ServerInstanceID: "srv-b"}, "offsite"); err != nil || len(saves) != 0 {
+		t.Fatalf("srv-b lists %v, %v", saves, err)
+	}
+	if saves, err := a.ListRemoteSaves(ctx, cfg, "offsite"); err != nil || len(saves) != 1 {
+		t.Fatalf("srv-a lists %v, %v", saves, err)
+	}
+}
+
+func TestMoveSaveBackupCopiesBlobsAcrossFilesystems(t *testing.T) {
+	// /dev/shm is a separate tmpfs on Linux; skip where it is not.
+	toRoot, err := os.MkdirTemp("/dev/shm", "saves-")
+	if err != nil {
+		t.Skip("no second filesystem available")
+	}
+	t.Cleanup(func() { os.RemoveAll(toRoot) })
+	ctx := context.Background()
+	fromRoot := t.TempDir()
+	live := writeTestWorld(t, map[string]string{"main.ttw": "world header", "Region/r.0.0.7rg": "region"})
+	id := "mastermind_2024-05-01_10-00-00"
+	if _, _, err := storeSaveTree(ctx, live, filepath.Join(fromRoot, id)); err != nil {
+		t.Fatal(err)
+	}
+	if err := moveSaveBackup(ctx, fromRoot, toRoot, id); err != nil {
+		t.Fatal(err)
+	}
+	if result := verifySaveBackup(ctx, filepath.Join(toRoot, id), nil); !result.OK {
+		t.Fatalf("moved backup = %+v", result)
+	}
+	if leftovers, _ := os.ReadDir(saveStorePath(toRoot, "tmp")); len(leftovers) != 0 {
+		t.Fatalf("blob copies left in the store's tmp directory: %v", leftovers)
+	}
+}
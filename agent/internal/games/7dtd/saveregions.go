@@ -0,0 +1,439 @@
+package sevendtd
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+// A region file, Region/r.<x>.<z>.7rg, holds 32x32 chunks of 16x16 blocks.
+const (
+	regionBlocks = 512
+	// maxSelectedRegions bounds one region selection; a bigger area is a
+	// job for SAVE_RESTORE or a world wipe.
+	maxSelectedRegions = 1024
+	// decorationFile is the world-wide decoration and POI decoration state.
+	// It cannot be split by region, so restoring it rolls back every
+	// region's decorations.
+	decorationFile = "decoration.7dt"
+)
+
+var regionFilePattern = regexp.MustCompile(`^r\.(-?\d+)\.(-?\d+)\.7rg$`)
+
+type regionCoord struct {
+	X int `json:"x"`
+	Z int `json:"z"`
+}
+
+func (c regionCoord) path() string {
+	return fmt.Sprintf("Region/r.%d.%d.7rg", c.X, c.Z)
+}
+
+// parseRegionName returns the coordinates of a region file name.
+func parseRegionName(name string) (regionCoord, bool) {
+	match := regionFilePattern.FindStringSubmatch(name)
+	if match == nil {
+		return regionCoord{}, false
+	}
+	var c regionCoord
+	if _, err := fmt.Sscan(match[1], &c.X); err != nil {
+		return c, false
+	}
+	if _, err := fmt.Sscan(match[2], &c.Z); err != nil {
+		return c, false
+	}
+	return c, true
+}
+
+// blockRegion returns the region holding block column x, z.
+func blockRegion(x, z int) regionCoord {
+	floorDiv := func(v int) int {
+		if v < 0 {
+			return -((-v + regionBlocks - 1) / regionBlocks)
+		}
+		return v / regionBlocks
+	}
+	return regionCoord{X: floorDiv(x), Z: floorDiv(z)}
+}
+
+// regionSelection reads the selected regions from the payload:
+// "regions" as [[x, z], ...] or [{"x": x, "z": z}, ...], or "bbox" as block
+// coordinates {"min_x", "min_z", "max_x", "max_z"}.
+func regionSelection(payload map[string]interface{}) ([]regionCoord, error) {
+	selected := make(map[regionCoord]bool)
+	if raw, ok := payload["regions"].([]interface{}); ok {
+		for _, item := range raw {
+			switch value := item.(type) {
+			case []interface{}:
+				if len(value) != 2 {
+					return nil, fmt.Errorf("regions entries must be [x, z]")
+				}
+				x, xOK := value[0].(float64)
+				z, zOK := value[1].(float64)
+				if !xOK || !zOK || x != float64(int(x)) || z != float64(int(z)) {
+					return nil, fmt.Errorf("regions entries must be [x, z]")
+				}
+				selected[regionCoord{X: int(x), Z: int(z)}] = true
+			case map[string]interface{}:
+				if _, ok := value["x"]; !ok {
+					return nil, fmt.Errorf("regions entries need x and z")
+				}
+				if _, ok := value["z"]; !ok {
+					return nil, fmt.Errorf("regions entries need x and z")
+				}
+				selected[regionCoord{X: getInt(value, "x", 0), Z: getInt(value, "z", 0)}] = true
+			default:
+				return nil, fmt.Errorf("regions entries must be [x, z]")
+			}
+		}
+	}
+	if bbox, ok := payload["bbox"].(map[string]interface{}); ok {
+		for _, key := range []string{"min_x", "min_z", "max_x", "max_z"} {
+			if _, ok := bbox[key]; !ok {
+				return nil, fmt.Errorf("bbox needs min_x, min_z, max_x and max_z")
+			}
+		}
+		minX, minZ := getInt(bbox, "min_x", 0), getInt(bbox, "min_z", 0)
+		maxX, maxZ := getInt(bbox, "max_x", 0), getInt(bbox, "max_z", 0)
+		if minX > maxX || minZ > maxZ {
+			return nil, fmt.Errorf("bbox minimum exceeds its maximum")
+		}
+		from, to := blockRegion(minX, minZ), blockRegion(maxX, maxZ)
+		if (to.X-from.X+1)*(to.Z-from.Z+1) > maxSelectedRegions {
+			return nil, fmt.Errorf("bbox covers more than %d regions; select fewer", maxSelectedRegions)
+		}
+		for x := from.X; x <= to.X; x++ {
+			for z := from.Z; z <= to.Z; z++ {
+				selected[regionCoord{X: x, Z: z}] = true
+			}
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("regions or bbox required")
+	}
+	if len(selected) > maxSelectedRegions {
+		return nil, fmt.Errorf("more than %d regions selected; select fewer", maxSelectedRegions)
+	}
+	coords := make([]regionCoord, 0, len(selected))
+	for c := range selected {
+		coords = append(coords, c)
+	}
+	sort.Slice(coords, func(i, j int) bool {
+		if coords[i].X != coords[j].X {
+			return coords[i].X < coords[j].X
+		}
+		return coords[i].Z < coords[j].Z
+	})
+	return coords, nil
+}
+
+// regionRollback records what a selective restore changed in the live
+// world: files it replaced, kept in the rollback directory, and files it
+// created.
+type regionRollback struct {
+	SaveID    string    `json:"saveId"`
+	CreatedAt time.Time `json:"createdAt"`
+	Replaced  []string  `json:"replaced"`
+	Created   []string  `json:"created"`
+}
+
+const regionRollbackManifest = ".mastermind-rollback.json"
+
+type regionRestore struct {
+	SaveID   string        `json:"saveId"`
+	Regions  []regionCoord `json:"regions"`
+	Restored []string      `json:"restored"`
+	// NotInBackup lists selected files the backup does not have, such as
+	// regions nobody had visited yet; the live files stay as they are.
+	NotInBackup []string `json:"notInBackup"`
+	Rollback    string   `json:"rollback"`
+	// Decorations is set when the world-wide decoration file was restored.
+	Decorations bool `json:"decorations"`
+}
+
+// RestoreSaveRegions copies the selected region files, and with
+// includeDecorations the world-wide decoration file, from backup id into
+// the stopped live world. The files it replaces are moved to a rollback
+// directory beside the world, which UndoRegionRestore puts back; the
+// rollback of the previous selective restore is discarded.
+func (a *Adapter) RestoreSaveRegions(ctx context.Context, cfg *agent.InstanceConfig, configOverride, id string, regions []regionCoord, includeDecorations bool, identity *saveIdentity) (*regionRestore, error) {
+	if a.instanceRunning(ctx, cfg) {
+		return nil, fmt.Errorf("server must be stopped before restoring regions")
+	}
+	live, err := resolveLiveSave(cfg, configOverride)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(live); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("live save not found")
+	}
+	ns, err := a.saveNamespace(cfg)
+	if err != nil {
+		return nil, err
+	}
+	source, err := ns.backupPath(id)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Lstat(source); err != nil || !info.IsDir() || info.Mode()&os.ModeSymlink != 0 {
+		return nil, fmt.Errorf("save backup not found")
+	}
+	wanted := make(map[string]bool, len(regions)+1)
+	for _, c := range regions {
+		wanted[c.path()] = true
+	}
+	if includeDecorations {
+		wanted[decorationFile] = true
+	}
+	if serviceActive(ctx, "regionhealer.service") {
+		if err := systemctlService(ctx, "stop", "regionhealer.service"); err != nil {
+			return nil, fmt.Errorf("stop Region Healer before restore: %w", err)
+		}
+		defer func() { _ = systemctlService(context.Background(), "start", "regionhealer.service") }()
+	}
+
+	staging := live + ".mastermind-regions-staging"
+	_ = os.RemoveAll(staging)
+	if err := os.MkdirAll(staging, 0750); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+	agent.ReportProgress(ctx, "extract", fmt.Sprintf("Reading %d files from backup %s", len(wanted), id))
+	found, err := extractBackupFiles(ctx, source, wanted, staging, identity)
+	if err != nil {
+		return nil, err
+	}
+	result := &regionRestore{SaveID: id, Regions: regions, Restored: []string{}, NotInBackup: []string{}}
+	for rel := range wanted {
+		if !found[rel] {
+			result.NotInBackup = append(result.NotInBackup, rel)
+		}
+	}
+	sort.Strings(result.NotInBackup)
+	if len(found) == 0 {
+		return result, fmt.Errorf("backup has none of the selected files")
+	}
+
+	rollbackDir := live + ".mastermind-regions-rollback"
+	if err := os.RemoveAll(rollbackDir); err != nil {
+		return result, fmt.Errorf("discard previous region rollback: %w", err)
+	}
+	rollback := regionRollback{SaveID: id, CreatedAt: time.Now().UTC(), Replaced: []string{}, Created: []string{}}
+	restored := make([]string, 0, len(found))
+	for rel := range found {
+		restored = append(restored, rel)
+	}
+	sort.Strings(restored)
+	undo := func() {
+		_ = undoRegionChanges(live, rollbackDir, rollback)
+		_ = os.RemoveAll(rollbackDir)
+	}
+	for _, rel := range restored {
+		target := filepath.Join(live, filepath.FromSlash(rel))
+		if _, err := os.Lstat(target); err == nil {
+			kept := filepath.Join(rollbackDir, filepath.FromSlash(rel))
+			if err := os.MkdirAll(filepath.Dir(kept), 0750); err != nil {
+				undo()
+				return result, err
+			}
+			if err := os.Rename(target, kept); err != nil {
+				undo()
+				return result, fmt.Errorf("keep live %s: %w", rel, err)
+			}
+			rollback.Replaced = append(rollback.Replaced, rel)
+		} else {
+			rollback.Created = append(rollback.Created, rel)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0770); err != nil {
+			undo()
+			return result, err
+		}
+		if err := os.Rename(filepath.Join(staging, filepath.FromSlash(rel)), target); err != nil {
+			undo()
+			return result, fmt.Errorf("restore %s: %w", rel, err)
+		}
+		if err := makeTreeGroupWritable(target); err != nil {
+			undo()
+			return result, fmt.Errorf("set restored %s permissions: %w", rel, err)
+		}
+	}
+	data, _ := json.MarshalIndent(rollback, "", "  ")
+	if err := os.MkdirAll(rollbackDir, 0750); err != nil {
+		undo()
+		return result, err
+	}
+	if err := os.WriteFile(filepath.Join(rollbackDir, regionRollbackManifest), data, 0640); err != nil {
+		undo()
+		return result, fmt.Errorf("record region rollback: %w", err)
+	}
+	result.Restored, result.Rollback, result.Decorations = restored, rollbackDir, found[decorationFile]
+	return result, nil
+}
+
+// UndoRegionRestore puts back the live files the last selective restore
+// replaced and removes the ones it created.
+func (a *Adapter) UndoRegionRestore(ctx context.Context, cfg *agent.InstanceConfig, configOverride string) (*regionRollback, error) {
+	if a.instanceRunning(ctx, cfg) {
+		return nil, fmt.Errorf("server must be stopped before undoing a region restore")
+	}
+	live, err := resolveLiveSave(cfg, configOverride)
+	if err != nil {
+		return nil, err
+	}
+	rollbackDir := live + ".mastermind-regions-rollback"
+	data, err := os.ReadFile(filepath.Join(rollbackDir, regionRollbackManifest))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no region restore to undo")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rollback regionRollback
+	if err := json.Unmarshal(data, &rollback); err != nil {
+		return nil, fmt.Errorf("parse region rollback: %w", err)
+	}
+	if err := undoRegionChanges(live, rollbackDir, rollback); err != nil {
+		return nil, err
+	}
+	return &rollback, os.RemoveAll(rollbackDir)
+}
+
+func undoRegionChanges(live, rollbackDir string, rollback regionRollback) error {
+	for _, rel := range append(append([]string{}, rollback.Replaced...), rollback.Created...) {
+		if !validRestorePath(rel) {
+			return fmt.Errorf("region rollback names an invalid path %q", rel)
+		}
+	}
+	for _, rel := range rollback.Created {
+		if err := os.Remove(filepath.Join(live, filepath.FromSlash(rel))); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove restored %s: %w", rel, err)
+		}
+	}
+	for _, rel := range rollback.Replaced {
+		target := filepath.Join(live, filepath.FromSlash(rel))
+		if err := os.Rename(filepath.Join(rollbackDir, filepath.FromSlash(rel)), target); err != nil {
+			return fmt.Errorf("put back %s: %w", rel, err)
+		}
+	}
+	return nil
+}
+
+// validRestorePath accepts the files a selective restore may touch.
+func validRestorePath(rel string) bool {
+	if rel == decorationFile {
+		return true
+	}
+	dir, name := filepath.Split(filepath.FromSlash(rel))
+	_, ok := parseRegionName(name)
+	return ok && dir == "Region"+string(filepath.Separator)
+}
+
+// extractBackupFiles copies the wanted world-relative files that backup
+// dir holds into destination and reports which it found. Manifest backups
+// are checked against their recorded checksums; full copies and Region
+// Healer snapshots are copied as they are.
+func extractBackupFiles(ctx context.Context, dir string, wanted map[string]bool, destination string, identity *saveIdentity) (map[string]bool, error) {
+	found := make(map[string]bool)
+	stage := func(rel string) (string, error) {
+		target := filepath.Join(destination, filepath.FromSlash(rel))
+		return target, os.MkdirAll(filepath.Dir(target), 0750)
+	}
+	if !hasSaveManifest(dir) {
+		for rel := range wanted {
+			path := filepath.Join(dir, filepath.FromSlash(rel))
+			if info, err := os.Lstat(path); err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			target, err := stage(rel)
+			if err != nil {
+				return nil, err
+			}
+			if err := copySaveFile(path, target, 0660); err != nil {
+				return nil, fmt.Errorf("copy %s: %w", rel, err)
+			}
+			found[rel] = true
+		}
+		return found, nil
+	}
+	manifest, err := readSaveManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	key, err := openSaveManifest(manifest, identity)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]saveManifestFile)
+	for _, file := range manifest.Files {
+		if wanted[file.Path] {
+			files[file.Path] = file
+		}
+	}
+	if manifest.Archive == "" {
+		root := filepath.Dir(dir)
+		for rel, file := range files {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if problem := checkSaveBlob(root, file.Hash, file.Size); problem != "ok" {
+				return nil, fmt.Errorf("backup copy of %s is %s", rel, problem)
+			}
+			target, err := stage(rel)
+			if err != nil {
+				return nil, err
+			}
+			if err := copySaveFile(saveBlobPath(root, file.Hash), target, file.Mode.Perm()|0660); err != nil {
+				return nil, fmt.Errorf("copy %s: %w", rel, err)
+			}
+			_ = os.Chtimes(target, file.ModTime, file.ModTime)
+			found[rel] = true
+		}
+		return found, nil
+	}
+	// readSaveArchive only confirms the archive checksum after the walk, so
+	// nothing staged here is used unless the whole archive checks out.
+	err = readSaveArchive(ctx, dir, manifest, key, func(header *tar.Header, content io.Reader) error {
+		file, ok := files[header.Name]
+		if !ok {
+			return nil
+		}
+		target, err := stage(file.Path)
+		if err != nil {
+			return err
+		}
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, file.Mode.Perm()|0660)
+		if err != nil {
+			return err
+		}
+		hasher := sha256.New()
+		_, copyErr := io.Copy(io.MultiWriter(out, hasher), content)
+		closeErr := out.Close()
+		if copyErr != nil {
+			return fmt.Errorf("extract %s: %w", file.Path, copyErr)
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+		if hex.EncodeToString(hasher.Sum(nil)) != file.Hash {
+			return fmt.Errorf("backup copy of %s is corrupt", file.Path)
+		}
+		_ = os.Chtimes(target, file.ModTime, file.ModTime)
+		found[file.Path] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
@@ -0,0 +1,233 @@
+package sevendtd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+// writeTestServer lays out a server configuration whose live save holds
+// files and returns the configuration path and the live save.
+func writeTestServer(t *testing.T, files map[string]string) (string, string) {
+	t.Helper()
+	userData := t.TempDir()
+	live := filepath.Join(userData, "Saves", "Navezgane", "MyGame")
+	for rel, content := range files {
+		path := filepath.Join(live, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(live, 0750); err != nil {
+		t.Fatal(err)
+	}
+	config := filepath.Join(t.TempDir(), "serverconfig.xml")
+	xml := `<ServerSettings>
+	<property name="GameWorld" value="Navezgane"/>
+	<property name="GameName" value="MyGame"/>
+	<property name="UserDataFolder" value="` + userData + `"/>
+</ServerSettings>`
+	if err := os.WriteFile(config, []byte(xml), 0640); err != nil {
+		t.Fatal(err)
+	}
+	return config, live
+}
+
+func TestRegionSelection(t *testing.T) {
+	regions, err := regionSelection(map[string]interface{}{
+		"regions": []interface{}{[]interface{}{float64(0), float64(-1)}, map[string]interface{}{"x": float64(2), "z": float64(2)}},
+		"bbox":    map[string]interface{}{"min_x": float64(-1), "min_z": float64(-512), "max_x": float64(511), "max_z": float64(-1)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Block -1 lies in region -1 and block -512 in region -1 too.
+	want := []regionCoord{{-1, -1}, {0, -1}, {2, 2}}
+	if len(regions) != len(want) {
+		t.Fatalf("regions = %v, want %v", regions, want)
+	}
+	for i := range want {
+		if regions[i] != want[i] {
+			t.Fatalf("regions = %v, want %v", regions, want)
+		}
+	}
+	for _, payload := range []map[string]interface{}{
+		{},
+		{"regions": []interface{}{[]interface{}{float64(1)}}},
+		{"regions": []interface{}{[]interface{}{float64(1.5), float64(0)}}},
+		{"bbox": map[string]interface{}{"min_x": float64(10), "min_z": float64(0), "max_x": float64(0), "max_z": float64(0)}},
+		{"bbox": map[string]interface{}{"min_x": float64(0), "min_z": float64(0), "max_x": float64(100000), "max_z": float64(100000)}},
+	} {
+		if _, err := regionSelection(payload); err == nil {
+			t.Fatalf("selection %v accepted", payload)
+		}
+	}
+}
+
+func TestRestoreSaveRegionsKeepsRollback(t *testing.T) {
+	previous := saveBackupRoot
+	saveBackupRoot = t.TempDir()
+	t.Cleanup(func() { saveBackupRoot = previous })
+	ctx := context.Background()
+	world := map[string]string{
+		"main.ttw":           "world header",
+		"decoration.7dt":     "decorations then",
+		"Region/r.0.0.7rg":   "region 0,0 then",
+		"Region/r.-1.0.7rg":  "region -1,0 then",
+		"Region/r.1.1.7rg":   "region 1,1 then",
+		"Player/123.ttp":     "player then",
+		"Region/r.5.5.7rg":   "region 5,5 then",
+		"Region/r.-1.-1.7rg": "untouched",
+	}
+	config, live := writeTestServer(t, world)
+	for _, format := range []string{saveFormatManifest, saveFormatTarGzip} {
+		id := "mastermind_2024-05-01_10-00-00"
+		if format != saveFormatManifest {
+			id = "mastermind_2024-05-01_11-00-00"
+		}
+		dir := filepath.Join(saveBackupRoot, id)
+		var err error
+		if format == saveFormatManifest {
+			_, _, err = storeSaveTree(ctx, live, dir)
+		} else {
+			_, _, err = writeSaveArchive(ctx, live, dir, format, nil)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Both backups predate the grief.
+	for rel, content := range map[string]string{
+		"decoration.7dt":    "decorations now",
+		"Region/r.0.0.7rg":  "griefed",
+		"Region/r.-1.0.7rg": "griefed",
+		"Region/r.1.1.7rg":  "kept progress",
+		"Player/123.ttp":    "player now",
+	} {
+		if err := os.WriteFile(filepath.Join(live, filepath.FromSlash(rel)), []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Remove(filepath.Join(live, "Region", "r.5.5.7rg")); err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewAdapter()
+	cfg := &agent.InstanceConfig{}
+	read := func(rel string) string {
+		data, _ := os.ReadFile(filepath.Join(live, filepath.FromSlash(rel)))
+		return string(data)
+	}
+	for _, id := range []string{"mastermind_2024-05-01_10-00-00", "mastermind_2024-05-01_11-00-00"} {
+		t.Run(id, func(t *testing.T) {
+			restore, err := a.RestoreSaveRegions(ctx, cfg, config, id, []regionCoord{{-1, 0}, {0, 0}, {5, 5}, {9, 9}}, false, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(restore.Restored, ","); got != "Region/r.-1.0.7rg,Region/r.0.0.7rg,Region/r.5.5.7rg" {
+				t.Fatalf("restored %s", got)
+			}
+			if got := strings.Join(restore.NotInBackup, ","); got != "Region/r.9.9.7rg" {
+				t.Fatalf("not in backup %s", got)
+			}
+			for rel, want := range map[string]string{
+				"Region/r.0.0.7rg":  "region 0,0 then",
+				"Region/r.-1.0.7rg": "region -1,0 then",
+				"Region/r.5.5.7rg":  "region 5,5 then",
+				"Region/r.1.1.7rg":  "kept progress",
+				"Player/123.ttp":    "player now",
+				"decoration.7dt":    "decorations now",
+			} {
+				if got := read(rel); got != want {
+					t.Fatalf("%s = %q, want %q", rel, got, want)
+				}
+			}
+			if data, _ := os.ReadFile(filepath.Join(restore.Rollback, "Region", "r.0.0.7rg")); string(data) != "griefed" {
+				t.Fatalf("rollback kept %q", data)
+			}
+
+			rollback, err := a.UndoRegionRestore(ctx, cfg, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rollback.Replaced) != 2 || len(rollback.Created) != 1 {
+				t.Fatalf("rollback = %+v", rollback)
+			}
+			if read("Region/r.0.0.7rg") != "griefed" || read("Region/r.-1.0.7rg") != "griefed" {
+				t.Fatal("undo did not put the live regions back")
+			}
+			if _, err := os.Stat(filepath.Join(live, "Region", "r.5.5.7rg")); !os.IsNotExist(err) {
+				t.Fatal("undo kept a region the restore created")
+			}
+			if _, err := a.UndoRegionRestore(ctx, cfg, config); err == nil {
+				t.Fatal("second undo accepted")
+			}
+		})
+	}
+
+	restore, err := a.RestoreSaveRegions(ctx, cfg, config, "mastermind_2024-05-01_10-00-00", []regionCoord{{0, 0}}, true, nil)
+	if err != nil || !restore.Decorations || read("decoration.7dt") != "decorations then" {
+		t.Fatalf("restore with decorations = %+v, %v", restore, err)
+	}
+	if _, err := a.RestoreSaveRegions(ctx, cfg, config, "mastermind_2024-05-01_10-00-00", []regionCoord{{9, 9}}, false, nil); err == nil {
+		t.Fatal("restore of regions the backup lacks succeeded")
+	}
+}
+
+func TestRestoreSaveRegionsRejectsCorruptBlob(t *testing.T) {
+	previous := saveBackupRoot
+	saveBackupRoot = t.TempDir()
+	t.Cleanup(func() { saveBackupRoot = previous })
+	ctx := context.Background()
+	config, live := writeTestServer(t, map[string]string{"Region/r.0.0.7rg": "region then"})
+	id := "mastermind_2024-05-01_10-00-00"
+	manifest, _, err := storeSaveTree(ctx, live, filepath.Join(saveBackupRoot, id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(saveBlobPath(saveBackupRoot, manifest.Files[0].Hash), []byte("region th3n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(live, "Region", "r.0.0.7rg"), []byte("griefed"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	a := NewAdapter()
+	if _, err := a.RestoreSaveRegions(ctx, &agent.InstanceConfig{}, config, id, []regionCoord{{0, 0}}, false, nil); err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Fatalf("restore from a corrupt blob: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(live, "Region", "r.0.0.7rg")); string(data) != "griefed" {
+		t.Fatal("live region changed after a failed restore")
+	}
+}
+
+func TestRestoreSaveRegionsRestartsRegionHealer(t *testing.T) {
+	previous := saveBackupRoot
+	saveBackupRoot = t.TempDir()
+	t.Cleanup(func() { saveBackupRoot = previous })
+	calls := fakeHelperUnits(t)
+	ctx := context.Background()
+	config, live := writeTestServer(t, map[string]string{"Region/r.0.0.7rg": "region then"})
+	id := "mastermind_2024-05-01_10-00-00"
+	if _, _, err := storeSaveTree(ctx, live, filepath.Join(saveBackupRoot, id)); err != nil {
+		t.Fatal(err)
+	}
+	a := NewAdapter()
+	if _, err := a.RestoreSaveRegions(ctx, &agent.InstanceConfig{}, config, id, []regionCoord{{0, 0}}, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	// A failed restore must not leave Region Healer stopped either.
+	if _, err := a.RestoreSaveRegions(ctx, &agent.InstanceConfig{}, config, id, []regionCoord{{9, 9}}, false, nil); err == nil {
+		t.Fatal("restore of a region missing from the backup succeeded")
+	}
+	want := "stop regionhealer.service,start regionhealer.service,stop regionhealer.service,start regionhealer.service"
+	if got := strings.Join(calls(), ","); got != want {
+		t.Fatalf("helper unit calls = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,470 @@
+package sevendtd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mastermind/agent/internal/agent"
+	"github.com/mastermind/agent/internal/remotestore"
+)
+
+// BackupTarget is an off-host copy of the save backups, configured on the
+// agent. A target mirrors the local layout: blobs/<sha256> for the blob
+// store, and backups/<id>/ with the archive, the manifest and, uploaded
+// last, the metadata that marks the copy complete.
+type BackupTarget struct {
+	Store remotestore.Store
+	// Retention keeps this many newest backups on the target; 0 keeps all.
+	Retention int
+	// AfterBackup replicates each backup as soon as it is taken.
+	AfterBackup bool
+}
+
+// replicationMu serialises replication so remote garbage collection never
+// runs while another replication is uploading blobs.
+var replicationMu sync.Mutex
+
+type replicationResult struct {
+	Target          string   `json:"target"`
+	Replicated      []string `json:"replicated"`
+	AlreadyPresent  int      `json:"alreadyPresent"`
+	Skipped         []string `json:"skipped,omitempty"`
+	UploadedObjects int      `json:"uploadedObjects"`
+	UploadedBytes   int64    `json:"uploadedBytes"`
+	Pruned          []string `json:"pruned,omitempty"`
+	Error           string   `json:"error,omitempty"`
+}
+
+func remoteBackupKey(id, name string) string { return "backups/" + id + "/" + name }
+
+func remoteBlobKey(hash string) string { return "blobs/" + hash }
+
+// backupTargetNames resolves a payload target name; empty means every
+// configured target.
+func (a *Adapter) backupTargetNames(name string) ([]string, error) {
+	if len(a.BackupTargets) == 0 {
+		return nil, fmt.Errorf("no backup targets are configured on this agent")
+	}
+	if name != "" {
+		if _, ok := a.BackupTargets[name]; !ok {
+			return nil, fmt.Errorf("unknown backup target %q", name)
+		}
+		return []string{name}, nil
+	}
+	return sortedTargetNames(a.BackupTargets), nil
+}
+
+// ReplicateSaves copies the backup id, or every local manifest or archive
+// backup, to the named target or to all targets, then applies each target's
+// remote retention.
+func (a *Adapter) ReplicateSaves(ctx context.Context, cfg *agent.InstanceConfig, targetName, id string) ([]replicationResult, error) {
+	names, err := a.backupTargetNames(targetName)
+	if err != nil {
+		return nil, err
+	}
+	ns, err := a.saveNamespace(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	if id != "" {
+		path, err := ns.backupPath(id)
+		if err != nil {
+			return nil, err
+		}
+		if info, err := os.Lstat(path); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("save backup not found")
+		}
+		ids = []string{id}
+	} else if ids, err = ns.agentBackupIDs(); err != nil {
+		return nil, err
+	}
+	replicationMu.Lock()
+	defer replicationMu.Unlock()
+	results := make([]replicationResult, 0, len(names))
+	for _, name := range names {
+		target := a.BackupTargets[name]
+		target.Store = ns.remoteStore(target.Store)
+		result := replicationResult{Target: name, Replicated: []string{}}
+		if err := replicateToTarget(ctx, ns.root, name, target, ids, &result); err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+func replicateToTarget(ctx context.Context, root, name string, target BackupTarget, ids []string, result *replicationResult) error {
+	complete, err := remoteBackups(ctx, target.Store)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if complete[id] {
+			result.AlreadyPresent++
+			continue
+		}
+		dir := filepath.Join(root, id)
+		if !hasSaveManifest(dir) {
+			// Full directory copies from before manifests carry no
+			// checksums to verify a remote copy against.
+			result.Skipped = append(result.Skipped, id)
+			continue
+		}
+		agent.ReportProgress(ctx, "replicate", fmt.Sprintf("Replicating %s to %s", id, name))
+		if err := replicateBackup(ctx, target.Store, dir, result); err != nil {
+			return fmt.Errorf("replicate %s: %w", id, err)
+		}
+		result.Replicated = append(result.Replicated, id)
+	}
+	if target.Retention > 0 {
+		pruned, err := pruneRemoteBackups(ctx, target.Store, target.Retention)
+		result.Pruned = pruned
+		if err != nil {
+			return fmt.Errorf("remote retention: %w", err)
+		}
+	}
+	return nil
+}
+
+// replicateBackup uploads what the target is missing of one backup. Blobs
+// and archives already present with the right size are not sent again, and
+// the metadata goes last so an interrupted copy is never listed as complete.
+func replicateBackup(ctx context.Context, store remotestore.Store, dir string, result *replicationResult) error {
+	manifest, err := readSaveManifest(dir)
+	if err != nil {
+		return err
+	}
+	id := filepath.Base(dir)
+	upload := func(key, path string) error {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if size, err := store.Size(ctx, key); err == nil && size == info.Size() {
+			return nil
+		} else if err != nil && !errors.Is(err, remotestore.ErrNotFound) {
+			return err
+		}
+		if err := store.Put(ctx, key, path); err != nil {
+			return err
+		}
+		result.UploadedObjects++
+		result.UploadedBytes += info.Size()
+		return nil
+	}
+	if manifest.Archive != "" {
+		if err := upload(remoteBackupKey(id, manifest.Archive), filepath.Join(dir, manifest.Archive)); err != nil {
+			return err
+		}
+	} else {
+		seen := make(map[string]bool)
+		for _, file := range manifest.Files {
+			if seen[file.Hash] {
+				continue
+			}
+			seen[file.Hash] = true
+			if err := upload(remoteBlobKey(file.Hash), saveBlobPath(filepath.Dir(dir), file.Hash)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range []string{saveManifestName, saveMetadataName} {
+		if err := store.Put(ctx, remoteBackupKey(id, name), filepath.Join(dir, name)); err != nil {
+			return err
+		}
+		result.UploadedObjects++
+	}
+	return nil
+}
+
+// remoteBackups returns the backups on the target whose metadata, uploaded
+// last, is present.
+func remoteBackups(ctx context.Context, store remotestore.Store) (map[string]bool, error) {
+	names, err := store.List(ctx, "backups")
+	if err != nil {
+		return nil, err
+	}
+	complete := make(map[string]bool)
+	for _, name := range names {
+		if !validSaveID(name) {
+			continue
+		}
+		if _, err := store.Size(ctx, remoteBackupKey(name, saveMetadataName)); err == nil {
+			complete[name] = true
+		} else if !errors.Is(err, remotestore.ErrNotFound) {
+			return nil, err
+		}
+	}
+	return complete, nil
+}
+
+func fetchRemoteObject(ctx context.Context, store remotestore.Store, key string) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := store.Get(ctx, key, &buffer); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// pruneRemoteBackups keeps the newest retention agent backups on the target
+// and then deletes blobs no remaining remote manifest references.
+func pruneRemoteBackups(ctx context.Context, store remotestore.Store, retention int) ([]string, error) {
+	names, err := store.List(ctx, "backups")
+	if err != nil {
+		return nil, err
+	}
+	complete, err := remoteBackups(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for id := range complete {
+		if strings.HasPrefix(id, "mastermind_") {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	pruned := []string{}
+	removed := make(map[string]bool)
+	if len(ids) > retention {
+		for _, id := range ids[retention:] {
+			// The metadata goes first so a half-deleted copy is no longer
+			// listed as complete.
+			if err := store.Delete(ctx, remoteBackupKey(id, saveMetadataName)); err != nil {
+				return pruned, err
+			}
+			files, err := store.List(ctx, "backups/"+id)
+			if err != nil {
+				return pruned, err
+			}
+			for _, file := range files {
+				if err := store.Delete(ctx, remoteBackupKey(id, file)); err != nil {
+					return pruned, err
+				}
+			}
+			removed[id] = true
+			pruned = append(pruned, id)
+		}
+	}
+
+	referenced := make(map[string]bool)
+	for _, name := range names {
+		if !validSaveID(name) || removed[name] {
+			continue
+		}
+		data, err := fetchRemoteObject(ctx, store, remoteBackupKey(name, saveManifestName))
+		if errors.Is(err, remotestore.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return pruned, fmt.Errorf("read remote manifest of %s: %w", name, err)
+		}
+		manifest, err := parseSaveManifest(data)
+		if err != nil {
+			return pruned, fmt.Errorf("read remote manifest of %s: %w", name, err)
+		}
+		for _, file := range manifest.Files {
+			referenced[file.Hash] = true
+		}
+	}
+	blobs, err := store.List(ctx, "blobs")
+	if err != nil {
+		return pruned, err
+	}
+	for _, hash := range blobs {
+		if saveBlobHashPattern.MatchString(hash) && !referenced[hash] {
+			if err := store.Delete(ctx, remoteBlobKey(hash)); err != nil {
+				return pruned, err
+			}
+		}
+	}
+	return pruned, nil
+}
+
+// ListRemoteSaves lists the complete backups on a target.
+func (a *Adapter) ListRemoteSaves(ctx context.Context, cfg *agent.InstanceConfig, targetName string) ([]SaveRecord, error) {
+	if targetName == "" {
+		return nil, fmt.Errorf("backup target required")
+	}
+	names, err := a.backupTargetNames(targetName)
+	if err != nil {
+		return nil, err
+	}
+	ns, err := a.saveNamespace(cfg)
+	if err != nil {
+		return nil, err
+	}
+	store := ns.remoteStore(a.BackupTargets[names[0]].Store)
+	complete, err := remoteBackups(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	saves := make([]SaveRecord, 0, len(complete))
+	for id := range complete {
+		record := SaveRecord{ID: id, Kind: "full-world"}
+		if data, err := fetchRemoteObject(ctx, store, remoteBackupKey(id, saveMetadataName)); err == nil {
+			var metadata saveMetadata
+			if json.Unmarshal(data, &metadata) == nil {
+				record.CreatedAt, record.GameDay, record.Kind, record.AddedBytes = metadata.CreatedAt, metadata.GameDay, metadata.Kind, metadata.AddedBytes
+			}
+		}
+		if data, err := fetchRemoteObject(ctx, store, remoteBackupKey(id, saveManifestName)); err == nil {
+			if manifest, err := parseSaveManifest(data); err == nil {
+				record.SizeBytes = manifest.logicalSize()
+				record.setEncryption(manifest)
+			}
+		}
+		saves = append(saves, record)
+	}
+	sort.Slice(saves, func(i, j int) bool { return saves[i].ID > saves[j].ID })
+	return saves, nil
+}
+
+// FetchRemoteSave copies backup id from a target into the instance's backup
+// root so it can be verified and restored like any local backup. A backup
+// that already exists locally is left alone.
+func (a *Adapter) FetchRemoteSave(ctx context.Context, cfg *agent.InstanceConfig, targetName, id string) error {
+	if targetName == "" {
+		return fmt.Errorf("backup target required")
+	}
+	names, err := a.backupTargetNames(targetName)
+	if err != nil {
+		return err
+	}
+	ns, err := a.saveNamespace(cfg)
+	if err != nil {
+		return err
+	}
+	if !validSaveID(id) {
+		return fmt.Errorf("invalid save ID")
+	}
+	store := ns.remoteStore(a.BackupTargets[names[0]].Store)
+	destination := filepath.Join(ns.root, id)
+	if _, err := os.Lstat(destination); err == nil {
+		return nil
+	}
+	if _, err := store.Size(ctx, remoteBackupKey(id, saveMetadataName)); err != nil {
+		if errors.Is(err, remotestore.ErrNotFound) {
+			return fmt.Errorf("backup %s is not on target %s", id, targetName)
+		}
+		return err
+	}
+	// The store lock keeps garbage collection away from fetched blobs until
+	// the manifest that references them is in place.
+	saveStoreMu.Lock()
+	defer saveStoreMu.Unlock()
+	staging := filepath.Join(ns.root, ".fetch-"+id)
+	_ = os.RemoveAll(staging)
+	if err := os.MkdirAll(staging, 0750); err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	var manifest *saveManifest
+	for _, name := range []string{saveManifestName, saveMetadataName} {
+		data, err := fetchRemoteObject(ctx, store, remoteBackupKey(id, name))
+		if err != nil {
+			return fmt.Errorf("download %s: %w", name, err)
+		}
+		if name == saveManifestName {
+			if manifest, err = parseSaveManifest(data); err != nil {
+				return err
+			}
+		}
+		if err := os.WriteFile(filepath.Join(staging, name), data, 0640); err != nil {
+			return err
+		}
+	}
+	agent.ReportProgress(ctx, "download", fmt.Sprintf("Downloading %s from %s", id, targetName))
+	if manifest.Archive != "" {
+		if !validArchiveName(manifest.Archive) {
+			return fmt.Errorf("backup manifest names an invalid archive")
+		}
+		if err := downloadRemoteFile(ctx, store, remoteBackupKey(id, manifest.Archive), filepath.Join(staging, manifest.Archive), ""); err != nil {
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(saveStorePath(ns.root, "tmp"), 0750); err != nil {
+			return err
+		}
+		for _, file := range manifest.Files {
+			blob := saveBlobPath(ns.root, file.Hash)
+			if _, err := os.Stat(blob); err == nil {
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(blob), 0750); err != nil {
+				return err
+			}
+			if err := downloadRemoteFile(ctx, store, remoteBlobKey(file.Hash), blob, file.Hash); err != nil {
+				return err
+			}
+		}
+	}
+	return os.Rename(staging, destination)
+}
+
+// downloadRemoteFile downloads key to path through a temporary file. With a
+// hash, content that does not match is rejected instead of stored.
+func downloadRemoteFile(ctx context.Context, store remotestore.Store, key, path, hash string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	hasher := sha256.New()
+	getErr := store.Get(ctx, key, io.MultiWriter(tmp, hasher))
+	closeErr := tmp.Close()
+	if getErr != nil {
+		return getErr
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	if hash != "" && hex.EncodeToString(hasher.Sum(nil)) != hash {
+		return fmt.Errorf("downloaded %s does not match its checksum", key)
+	}
+	if err := os.Chmod(tmp.Name(), 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// replicateAfterBackup copies a new backup to the targets configured for
+// it. Failures are reported, not returned: the local backup stands.
+func (a *Adapter) replicateAfterBackup(ctx context.Context, cfg *agent.InstanceConfig, id string) []replicationResult {
+	var results []replicationResult
+	for _, name := range sortedTargetNames(a.BackupTargets) {
+		if !a.BackupTargets[name].AfterBackup {
+			continue
+		}
+		replicated, err := a.ReplicateSaves(ctx, cfg, name, id)
+		if err != nil && len(replicated) == 0 {
+			replicated = []replicationResult{{Target: name, Error: err.Error()}}
+		}
+		results = append(results, replicated...)
+	}
+	return results
+}
+
+func sortedTargetNames(targets map[string]BackupTarget) []string {
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,148 @@
+package sevendtd
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mastermind/agent/internal/agent"
+	"github.com/mastermind/agent/internal/remotestore"
+)
+
+// memoryStore is a remotestore.Store kept in memory.
+type memoryStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	puts    int
+}
+
+func (m *memoryStore) Put(_ context.Context, key, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = data
+	m.puts++
+	return nil
+}
+
+func (m *memoryStore) Get(_ context.Context, key string, w io.Writer) error {
+	m.mu.Lock()
+	data, ok := m.objects[key]
+	m.mu.Unlock()
+	if !ok {
+		return remotestore.ErrNotFound
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func (m *memoryStore) Size(_ context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[key]
+	if !ok {
+		return 0, remotestore.ErrNotFound
+	}
+	return int64(len(data)), nil
+}
+
+func (m *memoryStore) List(_ context.Context, dir string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seen := map[string]bool{}
+	for key := range m.objects {
+		if rest, ok := strings.CutPrefix(key, dir+"/"); ok {
+			seen[strings.SplitN(rest, "/", 2)[0]] = true
+		}
+	}
+	var names []string
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (m *memoryStore) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func TestReplicateWithRetentionAndRestoreFromRemote(t *testing.T) {
+	previous := saveBackupRoot
+	saveBackupRoot = t.TempDir()
+	t.Cleanup(func() { saveBackupRoot = previous })
+	remote := &memoryStore{objects: map[string][]byte{}}
+	a := NewAdapter()
+	a.BackupTargets = map[string]BackupTarget{"offsite": {Store: remote, Retention: 1}}
+	ctx := context.Background()
+
+	live := writeTestWorld(t, map[string]string{"main.ttw": "world header", "Region/r.0.0.7rg": "region zero"})
+	first := filepath.Join(saveBackupRoot, "mastermind_2024-05-01_10-00-00")
+	if _, _, err := storeSaveTree(ctx, live, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(first, saveMetadataName), []byte(`{"kind":"full-world"}`), 0640); err != nil {
+		t.Fatal(err)
+	}
+	results, err := a.ReplicateSaves(ctx, &agent.InstanceConfig{}, "", "")
+	if err != nil || len(results) != 1 || results[0].Error != "" || len(results[0].Replicated) != 1 {
+		t.Fatalf("first replication = %+v, %v", results, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(live, "Region", "r.0.0.7rg"), []byte("region zero, looted"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	second := filepath.Join(saveBackupRoot, "mastermind_2024-05-01_11-00-00")
+	if _, _, err := storeSaveTree(ctx, live, second); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(second, saveMetadataName), []byte(`{"kind":"full-world"}`), 0640); err != nil {
+		t.Fatal(err)
+	}
+	puts := remote.puts
+	results, err = a.ReplicateSaves(ctx, &agent.InstanceConfig{}, "offsite", "")
+	if err != nil || results[0].Error != "" {
+		t.Fatalf("second replication = %+v, %v", results, err)
+	}
+	// Only the changed region, the manifest and the metadata are sent.
+	if remote.puts-puts != 3 || results[0].AlreadyPresent != 1 {
+		t.Fatalf("second replication uploaded %d objects: %+v", remote.puts-puts, results[0])
+	}
+	if len(results[0].Pruned) != 1 || results[0].Pruned[0] != filepath.Base(first) {
+		t.Fatalf("remote retention pruned %v", results[0].Pruned)
+	}
+	if blobs, _ := remote.List(ctx, "blobs"); len(blobs) != 2 {
+		t.Fatalf("remote blobs after retention = %v", blobs)
+	}
+
+	// Lose the local disk, then bring the backup back from the target.
+	saveBackupRoot = t.TempDir()
+	if err := a.FetchRemoteSave(ctx, &agent.InstanceConfig{}, "offsite", filepath.Base(second)); err != nil {
+		t.Fatal(err)
+	}
+	fetched := filepath.Join(saveBackupRoot, filepath.Base(second))
+	if result := verifySaveBackup(ctx, fetched, nil); !result.OK || result.Checked != 2 {
+		t.Fatalf("fetched backup verification = %+v", result)
+	}
+	restored := filepath.Join(t.TempDir(), "World")
+	if err := restoreSaveBackup(ctx, fetched, restored, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(restored, "Region", "r.0.0.7rg")); string(data) != "region zero, looted" {
+		t.Fatalf("restored region = %q", data)
+	}
+	if err := a.FetchRemoteSave(ctx, &agent.InstanceConfig{}, "offsite", filepath.Base(first)); err == nil {
+		t.Fatal("fetched a backup pruned from the target")
+	}
+}
@@ -0,0 +1,314 @@
+package sevendtd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+// retentionPolicy decides which mastermind_* backups survive pruning. The
+// newest backup and pinned backups are always kept. Each tier keeps the
+// newest backup of that many distinct hours, days, ISO weeks or months;
+// KeepLast keeps the newest backups regardless of age.
+type retentionPolicy struct {
+	KeepLast  int `json:"keepLast"`
+	Hourly    int `json:"hourly"`
+	Daily     int `json:"daily"`
+	Weekly    int `json:"weekly"`
+	Monthly   int `json:"monthly"`
+	MinFreeMB int `json:"minFreeMB,omitempty"`
+	// PruneForSpace deletes the oldest unpinned backups before a backup
+	// that would not fit the backup filesystem, instead of failing right
+	// away.
+	PruneForSpace bool `json:"pruneForSpace,omitempty"`
+	// roomFor is the free space, in bytes, a pending backup needs; while
+	// it is short the oldest backups are deleted as for MinFreeMB.
+	roomFor int64
+}
+
+// saveRetention reads the retention policy from the payload (retention_count,
+// keep_hourly, keep_daily, keep_weekly, keep_monthly, min_free_mb,
+// prune_for_space) or the instance config (config.backups retentionCount,
+// keepHourly, keepDaily, keepWeekly, keepMonthly, minFreeMB, pruneForSpace).
+// Without any tier it keeps the newest ten backups, as before policies
+// existed.
+func saveRetention(payload map[string]interface{}) (retentionPolicy, error) {
+	config, _ := payload["config"].(map[string]interface{})
+	backups, _ := config["backups"].(map[string]interface{})
+	value := func(payloadKey, configKey string, def int) int {
+		return getInt(payload, payloadKey, getInt(backups, configKey, def))
+	}
+	policy := retentionPolicy{
+		Hourly:    value("keep_hourly", "keepHourly", 0),
+		Daily:     value("keep_daily", "keepDaily", 0),
+		Weekly:    value("keep_weekly", "keepWeekly", 0),
+		Monthly:   value("keep_monthly", "keepMonthly", 0),
+		MinFreeMB: value("min_free_mb", "minFreeMB", 0),
+	}
+	if prune, ok := payload["prune_for_space"].(bool); ok {
+		policy.PruneForSpace = prune
+	} else {
+		policy.PruneForSpace = getBool(backups, "pruneForSpace")
+	}
+	tiers := policy.Hourly + policy.Daily + policy.Weekly + policy.Monthly
+	defaultLast := 10
+	if tiers > 0 {
+		defaultLast = 0
+	}
+	policy.KeepLast = value("retention_count", "retentionCount", defaultLast)
+	if policy.KeepLast < 0 || policy.KeepLast > 100 {
+		return policy, fmt.Errorf("retention count must be between 0 and 100")
+	}
+	for _, count := range []int{policy.Hourly, policy.Daily, policy.Weekly, policy.Monthly} {
+		if count < 0 || count > 1000 {
+			return policy, fmt.Errorf("retention tiers must be between 0 and 1000")
+		}
+	}
+	if policy.MinFreeMB < 0 {
+		return policy, fmt.Errorf("min_free_mb cannot be negative")
+	}
+	if policy.KeepLast+tiers == 0 {
+		return policy, fmt.Errorf("retention policy must keep at least one backup")
+	}
+	return policy, nil
+}
+
+// saveDiskFree is a variable so tests can simulate a full disk.
+var saveDiskFree = func(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+type retentionDecision struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	Keep      bool      `json:"keep"`
+	Pinned    bool      `json:"pinned,omitempty"`
+	Reasons   []string  `json:"reasons"`
+	// FreedBytes is what deleting the backup releases, counting blobs no
+	// kept backup shares.
+	FreedBytes int64 `json:"freedBytes,omitempty"`
+}
+
+type retentionPlan struct {
+	DryRun     bool                `json:"dryRun"`
+	Policy     retentionPolicy     `json:"policy"`
+	Backups    []retentionDecision `json:"backups"`
+	Deleted    []string            `json:"deleted"`
+	FreedBytes int64               `json:"freedBytes"`
+	FreeBytes  int64               `json:"freeBytes"`
+	Warning    string              `json:"warning,omitempty"`
+}
+
+// retentionBackup is what planning needs to know about one backup.
+type retentionBackup struct {
+	id      string
+	created time.Time
+	pinned  bool
+	// own is the size of the backup directory itself; blobs are the store
+	// blobs its manifest references.
+	own   int64
+	blobs map[string]int64
+}
+
+func retentionCandidates(root string) ([]retentionBackup, error) {
+	ids, err := saveNamespace{root: root}.agentBackupIDs()
+	if err != nil {
+		return nil, err
+	}
+	var backups []retentionBackup
+	for _, id := range ids {
+		dir := filepath.Join(root, id)
+		backup := retentionBackup{id: id, own: directorySize(dir), blobs: map[string]int64{}}
+		backup.created, err = time.Parse("2006-01-02_15-04-05", strings.TrimPrefix(id, "mastermind_"))
+		metadata, metadataErr := readSaveMetadata(dir)
+		if metadataErr == nil {
+			backup.pinned = metadata.Pinned
+			if err != nil {
+				backup.created = metadata.CreatedAt
+			}
+		}
+		if manifest, err := readSaveManifest(dir); err == nil && manifest.Archive == "" {
+			for _, file := range manifest.Files {
+				backup.blobs[file.Hash] = file.Size
+			}
+		}
+		backups = append(backups, backup)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].created.After(backups[j].created) })
+	return backups, nil
+}
+
+// retentionTiers name each tier and the period a backup falls into.
+var retentionTiers = []struct {
+	name   string
+	count  func(retentionPolicy) int
+	period func(time.Time) string
+}{
+	{"hourly", func(p retentionPolicy) int { return p.Hourly }, func(t time.Time) string { return t.Format("2006-01-02 15:00") }},
+	{"daily", func(p retentionPolicy) int { return p.Daily }, func(t time.Time) string { return t.Format("2006-01-02") }},
+	{"weekly", func(p retentionPolicy) int { return p.Weekly }, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}},
+	{"monthly", func(p retentionPolicy) int { return p.Monthly }, func(t time.Time) string { return t.Format("2006-01") }},
+}
+
+// planRetention decides every backup's fate, newest first. freeBytes is the
+// free space on the backup filesystem; while it would stay below the
+// policy's minimum, the oldest backups the policy kept are deleted too.
+func planRetention(backups []retentionBackup, policy retentionPolicy, freeBytes int64) *retentionPlan {
+	plan := &retentionPlan{Policy: policy, FreeBytes: freeBytes, Deleted: []string{}, Backups: make([]retentionDecision, len(backups))}
+	refs := make(map[string]int)
+	for i, backup := range backups {
+		plan.Backups[i] = retentionDecision{ID: backup.id, CreatedAt: backup.created, Pinned: backup.pinned, Reasons: []string{}}
+		for hash := range backup.blobs {
+			refs[hash]++
+		}
+	}
+	keep := func(i int, reason string) {
+		plan.Backups[i].Keep = true
+		plan.Backups[i].Reasons = append(plan.Backups[i].Reasons, reason)
+	}
+	for i, backup := range backups {
+		if i == 0 {
+			keep(i, "newest backup")
+		}
+		if backup.pinned {
+			keep(i, "pinned")
+		}
+		if i < policy.KeepLast {
+			keep(i, fmt.Sprintf("one of the newest %d", policy.KeepLast))
+		}
+	}
+	for _, tier := range retentionTiers {
+		count, last, kept := tier.count(policy), "", 0
+		for i, backup := range backups {
+			if kept >= count {
+				break
+			}
+			if period := tier.period(backup.created); period != last {
+				last = period
+				kept++
+				keep(i, tier.name+" "+period)
+			}
+		}
+	}
+	release := func(i int) {
+		freed := backups[i].own
+		for hash, size := range backups[i].blobs {
+			if refs[hash]--; refs[hash] == 0 {
+				freed += size
+			}
+		}
+		plan.Backups[i].FreedBytes = freed
+		plan.FreedBytes += freed
+	}
+	for i := range backups {
+		if !plan.Backups[i].Keep {
+			plan.Backups[i].Reasons = append(plan.Backups[i].Reasons, "outside the retention policy")
+			release(i)
+		}
+	}
+	minFree, shortBy := int64(policy.MinFreeMB)<<20, fmt.Sprintf("free space below the %d MB minimum", policy.MinFreeMB)
+	if policy.roomFor > minFree {
+		minFree, shortBy = policy.roomFor, fmt.Sprintf("free space below the %d MB the new backup needs", megabytes(policy.roomFor))
+	}
+	if minFree > 0 {
+		for i := len(backups) - 1; i > 0 && freeBytes+plan.FreedBytes < minFree; i-- {
+			if !plan.Backups[i].Keep || backups[i].pinned {
+				continue
+			}
+			plan.Backups[i].Keep = false
+			plan.Backups[i].Reasons = append(plan.Backups[i].Reasons, shortBy)
+			release(i)
+		}
+		if freeBytes+plan.FreedBytes < minFree {
+			plan.Warning = shortBy + " remains; only pinned backups and the newest backup are left"
+		}
+	}
+	for _, decision := range plan.Backups {
+		if !decision.Keep {
+			plan.Deleted = append(plan.Deleted, decision.ID)
+		}
+	}
+	return plan
+}
+
+// applySaveRetention plans retention for the backups in root and, unless
+// dryRun, deletes what the plan does not keep.
+func applySaveRetention(root string, policy retentionPolicy, dryRun bool) (*retentionPlan, error) {
+	backups, err := retentionCandidates(root)
+	if err != nil {
+		return nil, err
+	}
+	var free int64
+	if policy.MinFreeMB > 0 || policy.roomFor > 0 {
+		if free, err = saveDiskFree(root); err != nil {
+			return nil, fmt.Errorf("check free disk space: %w", err)
+		}
+	}
+	plan := planRetention(backups, policy, free)
+	plan.DryRun = dryRun
+	if dryRun || len(plan.Deleted) == 0 {
+		return plan, nil
+	}
+	for _, id := range plan.Deleted {
+		if err := os.RemoveAll(filepath.Join(root, id)); err != nil {
+			return plan, err
+		}
+	}
+	return plan, collectSaveBlobs(root)
+}
+
+func readSaveMetadata(backupDir string) (saveMetadata, error) {
+	var metadata saveMetadata
+	data, err := os.ReadFile(filepath.Join(backupDir, saveMetadataName))
+	if err != nil {
+		return metadata, err
+	}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return metadata, fmt.Errorf("parse backup metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+// PinSave marks backup id as never deleted by retention or SAVE_DELETE, or
+// clears the mark.
+func (a *Adapter) PinSave(cfg *agent.InstanceConfig, id string, pinned bool) error {
+	ns, err := a.saveNamespace(cfg)
+	if err != nil {
+		return err
+	}
+	path, err := ns.backupPath(id)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Lstat(path); err != nil || !info.IsDir() {
+		return fmt.Errorf("save backup not found")
+	}
+	metadata, err := readSaveMetadata(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("only agent backups can be pinned; Region Healer snapshots are never pruned")
+	}
+	if err != nil {
+		return err
+	}
+	metadata.Pinned = pinned
+	data, _ := json.MarshalIndent(metadata, "", "  ")
+	tmp := filepath.Join(path, saveMetadataName+".tmp")
+	if err := os.WriteFile(tmp, data, 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(path, saveMetadataName))
+}
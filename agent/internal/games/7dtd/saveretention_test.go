@@ -0,0 +1,139 @@
+package sevendtd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+func TestPlanRetentionTiersPinsAndDiskGuard(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	backups := []retentionBackup{
+		{id: "A", created: at("2024-05-31 12:30"), own: 300 << 10, blobs: map[string]int64{"shared": 1000}},
+		{id: "B", created: at("2024-05-31 12:10"), own: 300 << 10, blobs: map[string]int64{"shared": 1000}},
+		{id: "C", created: at("2024-05-31 11:00"), own: 300 << 10},
+		{id: "D", created: at("2024-05-30 09:00"), own: 300 << 10},
+		{id: "E", created: at("2024-05-20 09:00"), own: 300 << 10},
+		{id: "F", created: at("2024-04-10 09:00"), own: 300 << 10, pinned: true},
+		{id: "G", created: at("2024-03-10 09:00"), own: 300 << 10, blobs: map[string]int64{"unique": 500}},
+	}
+	policy := retentionPolicy{Hourly: 2, Daily: 2, Monthly: 2}
+	plan := planRetention(backups, policy, 0)
+	if got := strings.Join(plan.Deleted, ","); got != "B,E,G" {
+		t.Fatalf("deleted %s, want B,E,G", got)
+	}
+	reasons := map[string]string{}
+	for _, decision := range plan.Backups {
+		reasons[decision.ID] = strings.Join(decision.Reasons, "; ")
+	}
+	for id, want := range map[string]string{
+		"A": "newest backup; hourly 2024-05-31 12:00; daily 2024-05-31; monthly 2024-05",
+		"B": "outside the retention policy",
+		"C": "hourly 2024-05-31 11:00",
+		"D": "daily 2024-05-30",
+		"F": "pinned; monthly 2024-04",
+	} {
+		if reasons[id] != want {
+			t.Fatalf("%s reasons %q, want %q", id, reasons[id], want)
+		}
+	}
+	// The blob B shares with A stays; G's own blob goes with it.
+	if plan.FreedBytes != 3*(300<<10)+500 {
+		t.Fatalf("freed %d bytes", plan.FreedBytes)
+	}
+
+	policy.MinFreeMB = 1
+	plan = planRetention(backups, policy, 0)
+	if got := strings.Join(plan.Deleted, ","); got != "B,D,E,G" || plan.Warning != "" {
+		t.Fatalf("disk guard deleted %s (warning %q), want B,D,E,G", got, plan.Warning)
+	}
+	policy.MinFreeMB = 100
+	plan = planRetention(backups, policy, 0)
+	if got := strings.Join(plan.Deleted, ","); got != "B,C,D,E,G" || plan.Warning == "" {
+		t.Fatalf("disk guard deleted %s (warning %q), want everything but A and F", got, plan.Warning)
+	}
+}
+
+func TestSaveRetentionPolicyFromPayload(t *testing.T) {
+	policy, err := saveRetention(map[string]interface{}{})
+	if err != nil || policy.KeepLast != 10 {
+		t.Fatalf("default policy = %+v, %v", policy, err)
+	}
+	payload := map[string]interface{}{
+		"keep_daily": float64(7),
+		"config":     map[string]interface{}{"backups": map[string]interface{}{"keepDaily": float64(3), "keepWeekly": float64(4), "minFreeMB": float64(2048)}},
+	}
+	policy, err = saveRetention(payload)
+	if err != nil || policy != (retentionPolicy{Daily: 7, Weekly: 4, MinFreeMB: 2048}) {
+		t.Fatalf("policy = %+v, %v", policy, err)
+	}
+	payload["config"].(map[string]interface{})["backups"].(map[string]interface{})["pruneForSpace"] = true
+	if policy, _ = saveRetention(payload); !policy.PruneForSpace {
+		t.Fatal("pruneForSpace from the instance config ignored")
+	}
+	payload["prune_for_space"] = false
+	if policy, _ = saveRetention(payload); policy.PruneForSpace {
+		t.Fatal("prune_for_space in the payload did not override the instance config")
+	}
+	if _, err := saveRetention(map[string]interface{}{"retention_count": float64(0)}); err == nil {
+		t.Fatal("policy keeping nothing accepted")
+	}
+}
+
+func TestApplyRetentionKeepsPinnedBackups(t *testing.T) {
+	previous := saveBackupRoot
+	saveBackupRoot = t.TempDir()
+	t.Cleanup(func() { saveBackupRoot = previous })
+	ctx := context.Background()
+	live := writeTestWorld(t, map[string]string{"main.ttw": "world header"})
+	ids := []string{"mastermind_2024-05-01_10-00-00", "mastermind_2024-05-01_11-00-00", "mastermind_2024-05-01_12-00-00"}
+	for _, id := range ids {
+		dir := filepath.Join(saveBackupRoot, id)
+		if _, _, err := storeSaveTree(ctx, live, dir); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, saveMetadataName), []byte(`{"kind":"full-world","format":"manifest"}`), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := NewAdapter()
+	if err := a.PinSave(&agent.InstanceConfig{}, ids[0], true); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := applySaveRetention(saveBackupRoot, retentionPolicy{KeepLast: 1}, true)
+	if err != nil || len(plan.Deleted) != 1 || plan.Deleted[0] != ids[1] {
+		t.Fatalf("dry run = %+v, %v", plan, err)
+	}
+	if _, err := os.Stat(filepath.Join(saveBackupRoot, ids[1])); err != nil {
+		t.Fatalf("dry run deleted a backup: %v", err)
+	}
+	if _, err := applySaveRetention(saveBackupRoot, retentionPolicy{KeepLast: 1}, false); err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range ids {
+		_, err := os.Stat(filepath.Join(saveBackupRoot, id))
+		if kept := err == nil; kept != (i != 1) {
+			t.Fatalf("%s kept = %v", id, kept)
+		}
+	}
+	if err := a.DeleteSaveBackup(ctx, &agent.InstanceConfig{}, ids[0]); err == nil || !strings.Contains(err.Error(), "pinned") {
+		t.Fatalf("deleting a pinned backup: %v", err)
+	}
+	if err := a.PinSave(&agent.InstanceConfig{}, ids[0], false); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.DeleteSaveBackup(ctx, &agent.InstanceConfig{}, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+}
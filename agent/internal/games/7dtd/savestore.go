@@ -0,0 +1,374 @@
+package sevendtd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Full-world backups are stored content-addressed: every file is kept once as
+// a blob named by its SHA-256 under <root>/.mastermind-store, and a backup
+// directory <root>/<id> holds only its metadata and a manifest of the tree.
+// The root is a server instance's backup namespace, so the store belongs to
+// the directory a backup sits in. The leading dot keeps the store out of
+// validSaveID and Region Healer's snapshot listing.
+const (
+	saveStoreDir     = ".mastermind-store"
+	saveManifestName = ".mastermind-manifest.json"
+	saveMetadataName = ".mastermind-save.json"
+	// saveFormatManifest marks backups stored as a manifest.
+	saveFormatManifest = "manifest"
+)
+
+// saveStoreMu serialises writing blobs and manifests with garbage
+// collection, so a backup in progress never loses a blob it just stored.
+var saveStoreMu sync.Mutex
+
+var saveBlobHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+type saveManifest struct {
+	Version int                `json:"version"`
+	Dirs    []saveManifestDir  `json:"dirs"`
+	Files   []saveManifestFile `json:"files"`
+	// Archive names the archive in the backup directory that holds the
+	// files; without it the files are blobs in the store.
+	Archive       string `json:"archive,omitempty"`
+	ArchiveSHA256 string `json:"archiveSha256,omitempty"`
+	// Encryption is set for encrypted archives. Their Dirs and Files are
+	// kept in Sealed until openSaveManifest is given the private key, and
+	// Size holds the logical size meanwhile.
+	Encryption *saveEncryption `json:"encryption,omitempty"`
+	Sealed     string          `json:"sealed,omitempty"`
+	Size       int64           `json:"size,omitempty"`
+}
+
+type saveManifestDir struct {
+	Path string      `json:"path"`
+	Mode os.FileMode `json:"mode"`
+}
+
+type saveManifestFile struct {
+	Path    string      `json:"path"`
+	Hash    string      `json:"sha256"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"modTime"`
+}
+
+func (m *saveManifest) logicalSize() int64 {
+	if m.Encryption != nil {
+		return m.Size
+	}
+	var total int64
+	for _, file := range m.Files {
+		total += file.Size
+	}
+	return total
+}
+
+func saveStorePath(root string, parts ...string) string {
+	return filepath.Join(append([]string{root, saveStoreDir}, parts...)...)
+}
+
+func saveBlobPath(root, hash string) string {
+	return saveStorePath(root, "blobs", hash[:2], hash)
+}
+
+func hasSaveManifest(backupDir string) bool {
+	info, err := os.Lstat(filepath.Join(backupDir, saveManifestName))
+	return err == nil && info.Mode().IsRegular()
+}
+
+func readSaveManifest(backupDir string) (*saveManifest, error) {
+	data, err := os.ReadFile(filepath.Join(backupDir, saveManifestName))
+	if err != nil {
+		return nil, err
+	}
+	return parseSaveManifest(data)
+}
+
+func parseSaveManifest(data []byte) (*saveManifest, error) {
+	var manifest saveManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("parse backup manifest: %w", err)
+	}
+	for _, file := range manifest.Files {
+		if !saveBlobHashPattern.MatchString(file.Hash) {
+			return nil, fmt.Errorf("backup manifest has an invalid hash for %s", file.Path)
+		}
+	}
+	return &manifest, nil
+}
+
+// latestSaveManifest returns the files of the newest manifest backup keyed by
+// path. Files whose size and modification time still match are not read
+// again, which is what keeps a backup of a mostly unchanged world cheap.
+func latestSaveManifest(root string) map[string]saveManifestFile {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		name := entries[i].Name()
+		if !entries[i].IsDir() || !strings.HasPrefix(name, "mastermind_") || !validSaveID(name) {
+			continue
+		}
+		manifest, err := readSaveManifest(filepath.Join(root, name))
+		if err != nil || manifest.Archive != "" {
+			continue
+		}
+		files := make(map[string]saveManifestFile, len(manifest.Files))
+		for _, file := range manifest.Files {
+			files[file.Path] = file
+		}
+		return files
+	}
+	return nil
+}
+
+// storeSaveTree stores every file under source in the blob store beside
+// destination and writes the manifest into destination. It returns the
+// manifest and how the files were stored, including the bytes of blobs that
+// were not already stored.
+func storeSaveTree(ctx context.Context, source, destination string) (*saveManifest, saveCopyStats, error) {
+	saveStoreMu.Lock()
+	defer saveStoreMu.Unlock()
+	root := filepath.Dir(destination)
+	var stats saveCopyStats
+	if err := os.MkdirAll(saveStorePath(root, "tmp"), 0750); err != nil {
+		return nil, stats, fmt.Errorf("create blob store: %w", err)
+	}
+	previous := latestSaveManifest(root)
+	manifest := &saveManifest{Version: 1}
+	err := filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			manifest.Dirs = append(manifest.Dirs, saveManifestDir{Path: rel, Mode: info.Mode().Perm()})
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file := saveManifestFile{Path: rel, Size: info.Size(), Mode: info.Mode().Perm(), ModTime: info.ModTime().UTC()}
+		if prior, ok := previous[rel]; ok && prior.Size == file.Size && prior.ModTime.Equal(file.ModTime) {
+			if _, err := os.Stat(saveBlobPath(root, prior.Hash)); err == nil {
+				file.Hash = prior.Hash
+				manifest.Files = append(manifest.Files, file)
+				return nil
+			}
+		}
+		hash, stored, cloned, err := storeSaveBlob(root, path)
+		if err != nil {
+			return fmt.Errorf("store %s: %w", rel, err)
+		}
+		file.Hash = hash
+		stats.AddedBytes += stored
+		if cloned {
+			stats.Reflinked++
+		} else {
+			stats.Copied++
+		}
+		manifest.Files = append(manifest.Files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, stats, err
+	}
+	if err := os.MkdirAll(destination, 0750); err != nil {
+		return nil, stats, err
+	}
+	data, _ := json.MarshalIndent(manifest, "", "  ")
+	if err := os.WriteFile(filepath.Join(destination, saveManifestName), data, 0640); err != nil {
+		return nil, stats, fmt.Errorf("write backup manifest: %w", err)
+	}
+	return manifest, stats, nil
+}
+
+// storeSaveBlob hashes path while copying it into the store and returns its
+// hash, the number of bytes added, which is zero when the content was
+// already stored, and whether the copy is a reflink clone. A clone is taken
+// first and hashed afterwards, so the blob matches its hash even if the
+// game writes to the file meanwhile.
+func storeSaveBlob(root, path string) (string, int64, bool, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", 0, false, err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(saveStorePath(root, "tmp"), "blob-*")
+	if err != nil {
+		return "", 0, false, err
+	}
+	defer os.Remove(tmp.Name())
+	hasher := sha256.New()
+	var size int64
+	var copyErr error
+	cloned := cloneFile(tmp, in) == nil
+	if cloned {
+		size, copyErr = io.Copy(hasher, tmp)
+	} else {
+		size, copyErr = io.Copy(io.MultiWriter(tmp, hasher), in)
+	}
+	closeErr := tmp.Close()
+	if copyErr != nil {
+		return "", 0, false, copyErr
+	}
+	if closeErr != nil {
+		return "", 0, false, closeErr
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	blob := saveBlobPath(root, hash)
+	if _, err := os.Stat(blob); err == nil {
+		return hash, 0, cloned, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(blob), 0750); err != nil {
+		return "", 0, false, err
+	}
+	if err := os.Chmod(tmp.Name(), 0640); err != nil {
+		return "", 0, false, err
+	}
+	if err := os.Rename(tmp.Name(), blob); err != nil {
+		return "", 0, false, err
+	}
+	return hash, size, cloned, nil
+}
+
+// manifestTargets checks that every manifest path stays inside
+// destination, creates the recorded directories and returns the mapping from
+// manifest paths to destination paths.
+func manifestTargets(manifest *saveManifest, destination string) (func(string) string, error) {
+	destination = filepath.Clean(destination)
+	resolve := func(rel string) string { return filepath.Join(destination, filepath.FromSlash(rel)) }
+	paths := make([]string, 0, len(manifest.Dirs)+len(manifest.Files))
+	for _, dir := range manifest.Dirs {
+		paths = append(paths, dir.Path)
+	}
+	for _, file := range manifest.Files {
+		paths = append(paths, file.Path)
+	}
+	for _, rel := range paths {
+		target := resolve(rel)
+		if target != destination && !strings.HasPrefix(target, destination+string(filepath.Separator)) {
+			return nil, fmt.Errorf("backup manifest path %q leaves the save", rel)
+		}
+	}
+	if err := os.MkdirAll(destination, 0750); err != nil {
+		return nil, err
+	}
+	for _, dir := range manifest.Dirs {
+		target := resolve(dir.Path)
+		if err := os.MkdirAll(target, 0750); err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(target, dir.Mode.Perm()|0700); err != nil {
+			return nil, err
+		}
+	}
+	return resolve, nil
+}
+
+// restoreSaveBackup rebuilds the tree recorded in backupDir's manifest at
+// destination, from the blob store or the backup's archive; identity opens
+// encrypted archives. With force, files that cannot be read back are skipped
+// instead of failing the restore.
+func restoreSaveBackup(ctx context.Context, backupDir, destination string, identity *saveIdentity, force bool) error {
+	manifest, err := readSaveManifest(backupDir)
+	if err != nil {
+		return err
+	}
+	key, err := openSaveManifest(manifest, identity)
+	if err != nil {
+		return err
+	}
+	if manifest.Archive != "" {
+		return extractSaveArchive(ctx, backupDir, manifest, key, destination, force)
+	}
+	resolve, err := manifestTargets(manifest, destination)
+	if err != nil {
+		return err
+	}
+	for _, file := range manifest.Files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		target := resolve(file.Path)
+		if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+			return err
+		}
+		blob := saveBlobPath(filepath.Dir(backupDir), file.Hash)
+		if _, err := os.Stat(blob); err != nil && force {
+			continue
+		}
+		if err := copySaveFile(blob, target, file.Mode.Perm()|0600); err != nil {
+			return fmt.Errorf("restore %s: %w", file.Path, err)
+		}
+		// Keeping the recorded mtime lets the next backup skip the file.
+		_ = os.Chtimes(target, file.ModTime, file.ModTime)
+	}
+	return nil
+}
+
+// collectSaveBlobs removes blobs no remaining manifest in root references. It stops
+// without deleting anything if a manifest cannot be read, because the blobs
+// it references would otherwise be lost.
+func collectSaveBlobs(root string) error {
+	saveStoreMu.Lock()
+	defer saveStoreMu.Unlock()
+	if _, err := os.Stat(saveStorePath(root)); os.IsNotExist(err) {
+		return nil
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return err
+	}
+	referenced := make(map[string]bool)
+	for _, entry := range entries {
+		dir := filepath.Join(root, entry.Name())
+		if !entry.IsDir() || !validSaveID(entry.Name()) || !hasSaveManifest(dir) {
+			continue
+		}
+		manifest, err := readSaveManifest(dir)
+		if err != nil {
+			return fmt.Errorf("read manifest of %s: %w", entry.Name(), err)
+		}
+		if manifest.Archive != "" {
+			continue
+		}
+		for _, file := range manifest.Files {
+			referenced[file.Hash] = true
+		}
+	}
+	_ = os.RemoveAll(saveStorePath(root, "tmp"))
+	return filepath.WalkDir(saveStorePath(root, "blobs"), func(path string, entry fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || referenced[entry.Name()] {
+			return nil
+		}
+		return os.Remove(path)
+	})
+}
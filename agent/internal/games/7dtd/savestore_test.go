@@ -0,0 +1,91 @@
+package sevendtd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestBackupsShareBlobsAndCollectGarbage(t *testing.T) {
+	previous := saveBackupRoot
+	saveBackupRoot = t.TempDir()
+	t.Cleanup(func() { saveBackupRoot = previous })
+
+	live := filepath.Join(t.TempDir(), "Navezgane", "World")
+	files := map[string]string{
+		"main.ttw":           "world header",
+		"Region/r.0.0.7rg":   "region zero",
+		"Region/r.0.1.7rg":   "region one",
+		"Player/76561.ttp":   "player",
+		"Region/r.9.9.7rg":   "region zero", // duplicate content is stored once
+		"decoration.7dt.bak": "decorations",
+	}
+	for rel, content := range files {
+		path := filepath.Join(live, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first := filepath.Join(saveBackupRoot, "mastermind_2024-05-01_10-00-00")
+	manifest, stats, err := storeSaveTree(context.Background(), live, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	duplicate := int64(len("region zero"))
+	if manifest.logicalSize() != stats.AddedBytes+duplicate {
+		t.Fatalf("first backup added %d of %d bytes", stats.AddedBytes, manifest.logicalSize())
+	}
+	var oldRegion string
+	for _, file := range manifest.Files {
+		if file.Path == "Region/r.0.1.7rg" {
+			oldRegion = file.Hash
+		}
+	}
+	if oldRegion == "" {
+		t.Fatalf("manifest misses the region: %+v", manifest.Files)
+	}
+
+	changed := "region one, explored"
+	if err := os.WriteFile(filepath.Join(live, "Region", "r.0.1.7rg"), []byte(changed), 0640); err != nil {
+		t.Fatal(err)
+	}
+	second := filepath.Join(saveBackupRoot, "mastermind_2024-05-01_11-00-00")
+	if _, stats, err = storeSaveTree(context.Background(), live, second); err != nil {
+		t.Fatal(err)
+	}
+	if stats.AddedBytes != int64(len(changed)) {
+		t.Fatalf("second backup stats.AddedBytes %d bytes, want only the changed region", stats.AddedBytes)
+	}
+
+	restored := filepath.Join(t.TempDir(), "World")
+	if err := restoreSaveBackup(context.Background(), first, restored, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	for rel, content := range files {
+		data, err := os.ReadFile(filepath.Join(restored, rel))
+		if err != nil || string(data) != content {
+			t.Fatalf("restored %s = %q, %v", rel, data, err)
+		}
+	}
+
+	if _, err := applySaveRetention(saveBackupRoot, retentionPolicy{KeepLast: 1}, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Fatalf("pruned backup still present: %v", err)
+	}
+	if _, err := os.Stat(saveBlobPath(saveBackupRoot, oldRegion)); !os.IsNotExist(err) {
+		t.Fatalf("unreferenced blob kept: %v", err)
+	}
+	restored = filepath.Join(t.TempDir(), "World")
+	if err := restoreSaveBackup(context.Background(), second, restored, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(restored, "Region", "r.0.1.7rg")); string(data) != changed {
+		t.Fatalf("restored changed region = %q", data)
+	}
+}
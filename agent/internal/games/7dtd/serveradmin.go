@@ -0,0 +1,570 @@
+package sevendtd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mastermind/agent/internal/agent"
+	"github.com/mastermind/agent/internal/xmltree"
+)
+
+const serverAdminBackupRetention = 20
+
+// serverAdminBackupRoot is a variable so tests can point it at a temp dir.
+var serverAdminBackupRoot = "/var/lib/mastermind-agent/serveradmin-backups"
+
+// serverAdminReflectTimeout bounds the wait for a running server to write a
+// console change to serveradmin.xml; a variable so tests can shorten it.
+var serverAdminReflectTimeout = 10 * time.Second
+
+// serverAdminDocument is the structured view of serveradmin.xml returned by
+// every SERVER_ADMIN_* job. API token secrets never leave the host.
+type serverAdminDocument struct {
+	Path       string                    `json:"path"`
+	ModifiedAt time.Time                 `json:"modifiedAt"`
+	Users      []serverAdmin             `json:"users"`
+	Whitelist  []serverAdminListEntry    `json:"whitelist"`
+	Blacklist  []serverAdminBan          `json:"blacklist"`
+	Commands   []serverCommandPermission `json:"commands"`
+	APITokens  []serverAPIToken          `json:"apiTokens"`
+}
+
+type serverAdminListEntry struct {
+	Platform string `json:"platform,omitempty"`
+	UserID   string `json:"userId"`
+	Name     string `json:"name,omitempty"`
+}
+
+type serverAdminBan struct {
+	Platform  string `json:"platform,omitempty"`
+	UserID    string `json:"userId"`
+	Name      string `json:"name,omitempty"`
+	UnbanDate string `json:"unbanDate,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+type serverCommandPermission struct {
+	Command         string `json:"command"`
+	PermissionLevel int    `json:"permissionLevel"`
+}
+
+type serverAPIToken struct {
+	Name            string `json:"name"`
+	PermissionLevel int    `json:"permissionLevel"`
+}
+
+// serverAdminSection describes where a section lives in serveradmin.xml and
+// which element represents one entry in it.
+type serverAdminSection struct {
+	container string
+	element   string
+}
+
+var serverAdminSections = map[string]serverAdminSection{
+	"users":     {container: "users", element: "user"},
+	"whitelist": {container: "whitelist", element: "user"},
+	"blacklist": {container: "blacklist", element: "blacklisted"},
+	"commands":  {container: "commands", element: "permission"},
+	"apitokens": {container: "apitokens", element: "token"},
+}
+
+// serverAdminChange is one validated add, update or remove request.
+type serverAdminChange struct {
+	Section         string
+	Action          string
+	Platform        string
+	UserID          string
+	Name            string
+	PermissionLevel int
+	Command         string
+	TokenName       string
+	Secret          string
+	Duration        string
+	Reason          string
+	// HasName, HasDuration and HasReason record which optional fields the
+	// payload carried; an update leaves the others as they are.
+	HasName     bool
+	HasDuration bool
+	HasReason   bool
+}
+
+var (
+	serverAdminIDPattern       = regexp.MustCompile(`^[A-Za-z0-9-]{1,64}$`)
+	serverAdminCommandPattern  = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+	serverAdminSecretPattern   = regexp.MustCompile(`^[A-Za-z0-9_-]{8,128}$`)
+	serverAdminDurationPattern = regexp.MustCompile(`^(\d{1,5})\s+(minute|hour|day|week|month|year)s?$`)
+)
+
+func configuredServerAdminPath(payload map[string]interface{}) (string, error) {
+	config, _ := payload["config"].(map[string]interface{})
+	discovery, _ := config["discovery"].(map[string]interface{})
+	path, _ := discovery["serverAdminPath"].(string)
+	path = filepath.Clean(strings.TrimSpace(path))
+	if path == "." || !filepath.IsAbs(path) || !strings.EqualFold(filepath.Base(path), "serveradmin.xml") {
+		return "", fmt.Errorf("configured serveradmin.xml path required")
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", fmt.Errorf("read serveradmin.xml: %w", err)
+	}
+	if !info.Mode().IsRegular() || info.Mode()&os.ModeSymlink != 0 {
+		return "", fmt.Errorf("serveradmin.xml must be a regular file")
+	}
+	return path, nil
+}
+
+func loadServerAdminTree(path string) (*xmltree.Node, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open serveradmin.xml: %w", err)
+	}
+	defer file.Close()
+	document, err := xmltree.Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("parse serveradmin.xml: %w", err)
+	}
+	if !strings.EqualFold(document.Root().Name, "adminTools") {
+		return nil, fmt.Errorf("serveradmin.xml root element must be adminTools")
+	}
+	return document, nil
+}
+
+func readServerAdminDocument(path string) (serverAdminDocument, error) {
+	document, err := loadServerAdminTree(path)
+	if err != nil {
+		return serverAdminDocument{}, err
+	}
+	result := serverAdminDocument{
+		Path:      path,
+		Users:     []serverAdmin{},
+		Whitelist: []serverAdminListEntry{},
+		Blacklist: []serverAdminBan{},
+		Commands:  []serverCommandPermission{},
+		APITokens: []serverAPIToken{},
+	}
+	if info, err := os.Stat(path); err == nil {
+		result.ModifiedAt = info.ModTime().UTC()
+	}
+	root := document.Root()
+	if users := root.Element("users"); users != nil {
+		for _, user := range users.Elements("user") {
+			result.Users = append(result.Users, serverAdmin{
+				Platform:        user.AttrValue("platform"),
+				UserID:          user.AttrValue("userid"),
+				Name:            user.AttrValue("name"),
+				PermissionLevel: attrInt(user, "permission_level"),
+			})
+		}
+	}
+	if whitelist := root.Element("whitelist"); whitelist != nil {
+		for _, user := range whitelist.Elements("user") {
+			result.Whitelist = append(result.Whitelist, serverAdminListEntry{Platform: user.AttrValue("platform"), UserID: user.AttrValue("userid"), Name: user.AttrValue("name")})
+		}
+	}
+	if blacklist := root.Element("blacklist"); blacklist != nil {
+		for _, ban := range blacklist.Elements("blacklisted") {
+			result.Blacklist = append(result.Blacklist, serverAdminBan{
+				Platform:  ban.AttrValue("platform"),
+				UserID:    ban.AttrValue("userid"),
+				Name:      ban.AttrValue("name"),
+				UnbanDate: ban.AttrValue("unbandate"),
+				Reason:    ban.AttrValue("reason"),
+			})
+		}
+	}
+	if commands := root.Element("commands"); commands != nil {
+		for _, permission := range commands.Elements("permission") {
+			result.Commands = append(result.Commands, serverCommandPermission{Command: permission.AttrValue("cmd"), PermissionLevel: attrInt(permission, "permission_level")})
+		}
+	}
+	if tokens := root.Element("apitokens"); tokens != nil {
+		for _, token := range tokens.Elements("token") {
+			result.APITokens = append(result.APITokens, serverAPIToken{Name: token.AttrValue("name"), PermissionLevel: attrInt(token, "permission_level")})
+		}
+	}
+	sort.SliceStable(result.Commands, func(i, j int) bool { return result.Commands[i].Command < result.Commands[j].Command })
+	return result, nil
+}
+
+func attrInt(node *xmltree.Node, name string) int {
+	value, _ := strconv.Atoi(strings.TrimSpace(node.AttrValue(name)))
+	return value
+}
+
+func parseServerAdminChange(payload map[string]interface{}, action string) (serverAdminChange, error) {
+	change := serverAdminChange{
+		Section: strings.ToLower(strings.TrimSpace(getString(payload, "section", ""))),
+		Action:  action,
+	}
+	if _, ok := serverAdminSections[change.Section]; !ok {
+		return change, fmt.Errorf("section must be users, whitelist, blacklist, commands or apitokens")
+	}
+	// Level 0 is full console access, so a missing level is never assumed.
+	switch change.Section {
+	case "users", "commands", "apitokens":
+		if action == "remove" {
+			break
+		}
+		var level float64
+		switch v := payload["permission_level"].(type) {
+		case float64:
+			level = v
+		case int:
+			level = float64(v)
+		default:
+			return change, fmt.Errorf("permission level is required")
+		}
+		if level != float64(int(level)) || level < 0 || level > 1000 {
+			return change, fmt.Errorf("permission level must be a whole number between 0 and 1000")
+		}
+		change.PermissionLevel = int(level)
+	}
+	switch change.Section {
+	case "users", "whitelist", "blacklist":
+		platform := getString(payload, "platform", "")
+		switch {
+		case strings.EqualFold(platform, "Steam"):
+			change.Platform = "Steam"
+		case strings.EqualFold(platform, "EOS"):
+			change.Platform = "EOS"
+		default:
+			return change, fmt.Errorf("player platform must be Steam or EOS")
+		}
+		change.UserID = strings.TrimSpace(getString(payload, "identifier", ""))
+		change.UserID = strings.TrimPrefix(strings.TrimPrefix(change.UserID, change.Platform+"_"), strings.ToLower(change.Platform)+"_")
+		if !serverAdminIDPattern.MatchString(change.UserID) {
+			return change, fmt.Errorf("player Steam/EOS ID required")
+		}
+		_, change.HasName = payload["name"].(string)
+		change.Name = consoleQuotedArg(getString(payload, "name", ""))
+	case "commands":
+		change.Command = strings.TrimSpace(getString(payload, "command", ""))
+		if !serverAdminCommandPattern.MatchString(change.Command) {
+			return change, fmt.Errorf("valid console command name required")
+		}
+	case "apitokens":
+		change.TokenName = strings.TrimSpace(getString(payload, "token_name", ""))
+		if !serverAdminCommandPattern.MatchString(change.TokenName) {
+			return change, fmt.Errorf("valid API token name required")
+		}
+		if action != "remove" {
+			change.Secret = getString(payload, "secret", "")
+			if !serverAdminSecretPattern.MatchString(change.Secret) {
+				return change, fmt.Errorf("API token secret must be 8-128 letters, digits, '-' or '_'")
+			}
+		}
+	}
+	if change.Section == "blacklist" && action != "remove" {
+		_, change.HasDuration = payload["duration"].(string)
+		_, change.HasReason = payload["reason"].(string)
+		change.Duration = strings.ToLower(strings.TrimSpace(getString(payload, "duration", "1 days")))
+		if !serverAdminDurationPattern.MatchString(change.Duration) {
+			return change, fmt.Errorf("ban duration must look like \"7 days\"")
+		}
+		change.Reason = consoleQuotedArg(getString(payload, "reason", "Banned by administrator"))
+	}
+	return change, nil
+}
+
+// consoleQuotedArg makes a free-text value safe inside a double-quoted
+// console argument and an XML attribute.
+func consoleQuotedArg(s string) string {
+	s = strings.TrimSpace(sanitizeRCONArg(s))
+	s = strings.ReplaceAll(s, `"`, "'")
+	if len(s) > 128 {
+		s = s[:128]
+	}
+	return s
+}
+
+func (c serverAdminChange) platformID() string {
+	return c.Platform + "_" + c.UserID
+}
+
+// matches reports whether node is the entry this change targets.
+func (c serverAdminChange) matches(node *xmltree.Node) bool {
+	switch c.Section {
+	case "commands":
+		return strings.EqualFold(node.AttrValue("cmd"), c.Command)
+	case "apitokens":
+		return node.AttrValue("name") == c.TokenName
+	default:
+		return strings.EqualFold(node.AttrValue("platform"), c.Platform) && strings.EqualFold(node.AttrValue("userid"), c.UserID)
+	}
+}
+
+func (c serverAdminChange) findIn(root *xmltree.Node) (*xmltree.Node, *xmltree.Node) {
+	section := serverAdminSections[c.Section]
+	container := root.Element(section.container)
+	if container == nil {
+		return nil, nil
+	}
+	for _, entry := range container.Elements(section.element) {
+		if c.matches(entry) {
+			return container, entry
+		}
+	}
+	return container, nil
+}
+
+// consoleCommand returns the telnet command that performs the change on a
+// running server. 7DTD persists each of these to serveradmin.xml itself.
+func (c serverAdminChange) consoleCommand() string {
+	switch c.Section {
+	case "users":
+		if c.Action == "remove" {
+			return "admin remove " + c.platformID()
+		}
+		return strings.TrimSpace(fmt.Sprintf("admin add %s %d %s", c.platformID(), c.PermissionLevel, quotedIfSet(c.Name)))
+	case "whitelist":
+		if c.Action == "remove" {
+			return "whitelist remove " + c.platformID()
+		}
+		return strings.TrimSpace(fmt.Sprintf("whitelist add %s %s", c.platformID(), quotedIfSet(c.Name)))
+	case "blacklist":
+		if c.Action == "remove" {
+			return "ban remove " + c.platformID()
+		}
+		match := serverAdminDurationPattern.FindStringSubmatch(c.Duration)
+		return strings.TrimSpace(fmt.Sprintf("ban add %s %s %ss %q %s", c.platformID(), match[1], match[2], c.Reason, quotedIfSet(c.Name)))
+	case "commands":
+		if c.Action == "remove" {
+			return "commandpermission remove " + c.Command
+		}
+		return fmt.Sprintf("commandpermission add %s %d", c.Command, c.PermissionLevel)
+	case "apitokens":
+		if c.Action == "remove" {
+			return "webtokens remove " + c.TokenName
+		}
+		return fmt.Sprintf("webtokens add %s %s %d", c.TokenName, c.Secret, c.PermissionLevel)
+	}
+	return ""
+}
+
+func quotedIfSet(s string) string {
+	if s == "" {
+		return ""
+	}
+	return strconv.Quote(s)
+}
+
+// inheritFrom fills the optional fields an update left out with the values
+// existing already has, so the console command does not clear them.
+func (c *serverAdminChange) inheritFrom(existing *xmltree.Node) {
+	if !c.HasName {
+		c.Name = existing.AttrValue("name")
+	}
+	if c.Section == "blacklist" && !c.HasReason {
+		c.Reason = existing.AttrValue("reason")
+	}
+}
+
+// applyTo edits the parsed document the same way the server would. An
+// update only sets the attributes the payload carried.
+
+func (c serverAdminChange) applyTo(root *xmltree.Node, now time.Time) error {
+	section := serverAdminSections[c.Section]
+	container, entry := c.findIn(root)
+	if c.Action == "remove" {
+		if entry == nil {
+			return fmt.Errorf("entry not found in %s", c.Section)
+		}
+		container.RemoveChild(entry)
+		return nil
+	}
+	if container == nil {
+		container = xmltree.NewElement(section.container)
+		root.AppendElement(container)
+	}
+	update := entry != nil
+	if entry == nil {
+		entry = xmltree.NewElement(section.element)
+		container.AppendElement(entry)
+	}
+	setName := func() {
+		if !update || c.HasName {
+			entry.SetAttr("name", c.Name)
+		}
+	}
+	switch c.Section {
+	case "users":
+		entry.SetAttr("platform", c.Platform)
+		entry.SetAttr("userid", c.UserID)
+		setName()
+		entry.SetAttr("permission_level", strconv.Itoa(c.PermissionLevel))
+	case "whitelist":
+		entry.SetAttr("platform", c.Platform)
+		entry.SetAttr("userid", c.UserID)
+		setName()
+	case "blacklist":
+		entry.SetAttr("platform", c.Platform)
+		entry.SetAttr("userid", c.UserID)
+		setName()
+		if !update || c.HasDuration {
+			entry.SetAttr("unbandate", unbanDate(now, c.Duration).Format("2006-01-02 15:04:05"))
+		}
+		if !update || c.HasReason {
+			entry.SetAttr("reason", c.Reason)
+		}
+	case "commands":
+		entry.SetAttr("cmd", c.Command)
+		entry.SetAttr("permission_level", strconv.Itoa(c.PermissionLevel))
+	case "apitokens":
+		entry.SetAttr("name", c.TokenName)
+		entry.SetAttr("secret", c.Secret)
+		entry.SetAttr("permission_level", strconv.Itoa(c.PermissionLevel))
+	}
+	return nil
+}
+
+func unbanDate(now time.Time, duration string) time.Time {
+	match := serverAdminDurationPattern.FindStringSubmatch(duration)
+	if len(match) != 3 {
+		return now
+	}
+	amount, _ := strconv.Atoi(match[1])
+	switch match[2] {
+	case "minute":
+		return now.Add(time.Duration(amount) * time.Minute)
+	case "hour":
+		return now.Add(time.Duration(amount) * time.Hour)
+	case "day":
+		return now.AddDate(0, 0, amount)
+	case "week":
+		return now.AddDate(0, 0, 7*amount)
+	case "month":
+		return now.AddDate(0, amount, 0)
+	default:
+		return now.AddDate(amount, 0, 0)
+	}
+}
+
+// reflectedIn reports whether the on-disk document already shows the change.
+func (c serverAdminChange) reflectedIn(path string) bool {
+	document, err := loadServerAdminTree(path)
+	if err != nil {
+		return false
+	}
+	_, entry := c.findIn(document.Root())
+	if c.Action == "remove" {
+		return entry == nil
+	}
+	if entry == nil {
+		return false
+	}
+	switch c.Section {
+	case "users", "commands", "apitokens":
+		return attrInt(entry, "permission_level") == c.PermissionLevel
+	}
+	return true
+}
+
+// ChangeServerAdmin applies one serveradmin.xml change. A running server is
+// changed through its console so in-memory permissions stay authoritative;
+// a stopped server's file is edited atomically after taking a backup.
+func (a *Adapter) ChangeServerAdmin(ctx context.Context, cfg *agent.InstanceConfig, payload map[string]interface{}, action string) (serverAdminDocument, bool, error) {
+	path, err := configuredServerAdminPath(payload)
+	if err != nil {
+		return serverAdminDocument{}, false, err
+	}
+	change, err := parseServerAdminChange(payload, action)
+	if err != nil {
+		return serverAdminDocument{}, false, err
+	}
+	document, err := loadServerAdminTree(path)
+	if err != nil {
+		return serverAdminDocument{}, false, err
+	}
+	_, existing := change.findIn(document.Root())
+	switch {
+	case action == "add" && existing != nil:
+		return serverAdminDocument{}, false, fmt.Errorf("entry already exists in %s; use update", change.Section)
+	case action != "add" && existing == nil:
+		return serverAdminDocument{}, false, fmt.Errorf("entry not found in %s", change.Section)
+	}
+
+	if a.instanceRunning(ctx, cfg) {
+		if action == "update" {
+			if change.Section == "blacklist" && !change.HasDuration {
+				return serverAdminDocument{}, true, fmt.Errorf("ban duration is required to update a ban while the server is running")
+			}
+			change.inheritFrom(existing)
+		}
+		out, err := a.SendCommand(ctx, cfg, change.consoleCommand())
+		if err != nil {
+			return serverAdminDocument{}, true, err
+		}
+		if consoleRejected(out) {
+			return serverAdminDocument{}, true, fmt.Errorf("7DTD rejected serveradmin command: %s", strings.TrimSpace(out))
+		}
+		// The server writes serveradmin.xml shortly after the command returns.
+		deadline := time.Now().Add(serverAdminReflectTimeout)
+		for !change.reflectedIn(path) {
+			if !time.Now().Before(deadline) {
+				return serverAdminDocument{}, true, fmt.Errorf("7DTD accepted the command but serveradmin.xml did not show the change within %s", serverAdminReflectTimeout)
+			}
+			select {
+			case <-ctx.Done():
+				return serverAdminDocument{}, true, ctx.Err()
+			case <-time.After(500 * time.Millisecond):
+			}
+		}
+		result, err := readServerAdminDocument(path)
+		return result, true, err
+	}
+
+	if err := change.applyTo(document.Root(), time.Now().UTC()); err != nil {
+		return serverAdminDocument{}, false, err
+	}
+	if err := backupServerAdmin(cfg, path); err != nil {
+		return serverAdminDocument{}, false, err
+	}
+	if err := replaceFileAtomically(path, document.Bytes(), 0640); err != nil {
+		return serverAdminDocument{}, false, fmt.Errorf("write serveradmin.xml: %w", err)
+	}
+	result, err := readServerAdminDocument(path)
+	return result, false, err
+}
+
+func backupServerAdmin(cfg *agent.InstanceConfig, path string) error {
+	serverKey := cfg.ServerInstanceID
+	if serverKey == "" {
+		serverKey = "default"
+	}
+	dir := filepath.Join(serverAdminBackupRoot, serverKey)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("create serveradmin backup directory: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("stat serveradmin.xml: %w", err)
+	}
+	name := "serveradmin-" + time.Now().UTC().Format("20060102T150405.000000000Z") + ".xml"
+	if err := copySaveFile(path, filepath.Join(dir, name), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("back up serveradmin.xml: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	backups := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), "serveradmin-") && filepath.Ext(entry.Name()) == ".xml" {
+			backups = append(backups, entry.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	if len(backups) > serverAdminBackupRetention {
+		for _, old := range backups[serverAdminBackupRetention:] {
+			_ = os.Remove(filepath.Join(dir, old))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package sevendtd
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+const testServerAdmin = `<?xml version="1.0" encoding="UTF-8"?>
+<adminTools>
+	<users>
+		<user platform="Steam" userid="76561198000000001" name="Owner" permission_level="0" />
+	</users>
+	<whitelist />
+	<blacklist />
+	<commands>
+		<permission cmd="kick" permission_level="10" />
+	</commands>
+	<apitokens />
+</adminTools>
+`
+
+// writeTestServerAdmin writes serveradmin.xml to a temp dir, points the
+// backup root at another and returns a payload that locates the file.
+func writeTestServerAdmin(t *testing.T) (string, map[string]interface{}) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "serveradmin.xml")
+	if err := os.WriteFile(path, []byte(testServerAdmin), 0640); err != nil {
+		t.Fatal(err)
+	}
+	previous := serverAdminBackupRoot
+	serverAdminBackupRoot = t.TempDir()
+	t.Cleanup(func() { serverAdminBackupRoot = previous })
+	payload := map[string]interface{}{
+		"config": map[string]interface{}{"discovery": map[string]interface{}{"serverAdminPath": path}},
+	}
+	return path, payload
+}
+
+// simulateServer makes 7dtd.service look active or stopped for the test.
+func simulateServer(t *testing.T, running bool) {
+	t.Helper()
+	previous := serviceActive
+	serviceActive = func(context.Context, string) bool { return running }
+	t.Cleanup(func() { serviceActive = previous })
+}
+
+// fakeAdminConsole accepts one console session, records the command and
+// calls handle with it before replying with reply.
+func fakeAdminConsole(t *testing.T, reply string, handle func(command string)) (*agent.InstanceConfig, <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	commands := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("*** Connected with 7DTD server.\r\n"))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.TrimSpace(line)
+		commands <- command
+		if handle != nil {
+			handle(command)
+		}
+		conn.Write([]byte(reply + "\r\n"))
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+	return &agent.InstanceConfig{TelnetHost: "127.0.0.1", TelnetPort: port}, commands
+}
+
+func TestParseServerAdminChange(t *testing.T) {
+	change, err := parseServerAdminChange(map[string]interface{}{
+		"section": "Users", "platform": "steam", "identifier": "Steam_76561198000000002",
+		"name": `Some "Player"`, "permission_level": float64(1),
+	}, "add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.Section != "users" || change.Platform != "Steam" || change.UserID != "76561198000000002" || change.Name != "Some 'Player'" || change.PermissionLevel != 1 {
+		t.Fatalf("change = %+v", change)
+	}
+	if got := change.consoleCommand(); got != `admin add Steam_76561198000000002 1 "Some 'Player'"` {
+		t.Fatalf("console command = %q", got)
+	}
+
+	ban, err := parseServerAdminChange(map[string]interface{}{
+		"section": "blacklist", "platform": "EOS", "identifier": "0002abcdef", "duration": "7 Days",
+	}, "add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ban.consoleCommand(); got != `ban add EOS_0002abcdef 7 days "Banned by administrator"` {
+		t.Fatalf("ban command = %q", got)
+	}
+
+	for name, payload := range map[string]map[string]interface{}{
+		"unknown section":     {"section": "mods"},
+		"level out of range":  {"section": "commands", "command": "kick", "permission_level": float64(1001)},
+		"unknown platform":    {"section": "users", "platform": "Xbox", "identifier": "1234"},
+		"bad identifier":      {"section": "whitelist", "platform": "Steam", "identifier": "1234; shutdown"},
+		"bad command":         {"section": "commands", "command": "kick all"},
+		"short token secret":  {"section": "apitokens", "token_name": "panel", "secret": "short"},
+		"token secret spaces": {"section": "apitokens", "token_name": "panel", "secret": "has spaces in it"},
+		"bad ban duration":    {"section": "blacklist", "platform": "Steam", "identifier": "1234", "duration": "forever"},
+		"missing user level":  {"section": "users", "platform": "Steam", "identifier": "1234"},
+		"missing token level": {"section": "apitokens", "token_name": "panel", "secret": "s3cret-token-value"},
+		"negative level":      {"section": "commands", "command": "kick", "permission_level": float64(-1)},
+		"fractional level":    {"section": "commands", "command": "kick", "permission_level": 0.5},
+	} {
+		if _, err := parseServerAdminChange(payload, "add"); err == nil {
+			t.Errorf("%s: change was accepted", name)
+		}
+	}
+	if _, err := parseServerAdminChange(map[string]interface{}{"section": "apitokens", "token_name": "panel"}, "remove"); err != nil {
+		t.Errorf("removing a token should not need its secret or level: %v", err)
+	}
+	if _, err := parseServerAdminChange(map[string]interface{}{"section": "whitelist", "platform": "Steam", "identifier": "1234"}, "add"); err != nil {
+		t.Errorf("whitelist entries carry no level: %v", err)
+	}
+}
+
+func TestChangeServerAdminUpdateKeepsOmittedAttributes(t *testing.T) {
+	path, payload := writeTestServerAdmin(t)
+	simulateServer(t, false)
+	payload["section"] = "users"
+	payload["platform"] = "Steam"
+	payload["identifier"] = "76561198000000001"
+	payload["permission_level"] = float64(5)
+
+	if _, _, err := (&Adapter{}).ChangeServerAdmin(context.Background(), &agent.InstanceConfig{}, payload, "update"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), `<user platform="Steam" userid="76561198000000001" name="Owner" permission_level="5" />`) {
+		t.Fatalf("update without a name cleared it:\n%s", data)
+	}
+
+	// On a running server the console command carries the existing name.
+	simulateServer(t, true)
+	payload["permission_level"] = float64(6)
+	cfg, commands := fakeAdminConsole(t, "Added user", func(string) {
+		os.WriteFile(path, []byte(strings.Replace(string(data), `permission_level="5"`, `permission_level="6"`, 1)), 0640)
+	})
+	if _, _, err := (&Adapter{}).ChangeServerAdmin(context.Background(), cfg, payload, "update"); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-commands; got != `admin add Steam_76561198000000001 6 "Owner"` {
+		t.Fatalf("console command = %q", got)
+	}
+}
+
+func TestChangeServerAdminEditsStoppedServerFile(t *testing.T) {
+	path, payload := writeTestServerAdmin(t)
+	simulateServer(t, false)
+	payload["section"] = "apitokens"
+	payload["token_name"] = "panel"
+	payload["secret"] = "s3cret-token-value"
+	payload["permission_level"] = float64(500)
+
+	document, viaConsole, err := (&Adapter{}).ChangeServerAdmin(context.Background(), &agent.InstanceConfig{}, payload, "add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if viaConsole {
+		t.Fatal("stopped server change reported as applied via console")
+	}
+	if len(document.APITokens) != 1 || document.APITokens[0].Name != "panel" || document.APITokens[0].PermissionLevel != 500 {
+		t.Fatalf("tokens = %+v", document.APITokens)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `<token name="panel" secret="s3cret-token-value" permission_level="500"`) {
+		t.Fatalf("serveradmin.xml was not edited:\n%s", data)
+	}
+	if !strings.Contains(string(data), `<permission cmd="kick" permission_level="10" />`) {
+		t.Fatalf("unrelated entries changed:\n%s", data)
+	}
+	backups, err := os.ReadDir(filepath.Join(serverAdminBackupRoot, "default"))
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("backups = %v, %v", backups, err)
+	}
+
+	if _, _, err := (&Adapter{}).ChangeServerAdmin(context.Background(), &agent.InstanceConfig{}, payload, "add"); err == nil {
+		t.Fatal("adding an existing token succeeded")
+	}
+	delete(payload, "secret")
+	payload["token_name"] = "missing"
+	if _, _, err := (&Adapter{}).ChangeServerAdmin(context.Background(), &agent.InstanceConfig{}, payload, "remove"); err == nil {
+		t.Fatal("removing an unknown token succeeded")
+	}
+}
+
+func TestChangeServerAdminUsesConsoleWhenRunning(t *testing.T) {
+	path, payload := writeTestServerAdmin(t)
+	simulateServer(t, true)
+	payload["section"] = "commands"
+	payload["command"] = "kick"
+	payload["permission_level"] = float64(2)
+	// The server persists the change itself once the command runs.
+	cfg, commands := fakeAdminConsole(t, "kick permission set to 2", func(string) {
+		edited := strings.Replace(testServerAdmin, `cmd="kick" permission_level="10"`, `cmd="kick" permission_level="2"`, 1)
+		os.WriteFile(path, []byte(edited), 0640)
+	})
+
+	document, viaConsole, err := (&Adapter{}).ChangeServerAdmin(context.Background(), cfg, payload, "update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !viaConsole {
+		t.Fatal("running server change was not applied via console")
+	}
+	if got := <-commands; got != "commandpermission add kick 2" {
+		t.Fatalf("console command = %q", got)
+	}
+	if len(document.Commands) != 1 || document.Commands[0].PermissionLevel != 2 {
+		t.Fatalf("commands = %+v", document.Commands)
+	}
+	if backups, _ := os.ReadDir(serverAdminBackupRoot); len(backups) != 0 {
+		t.Fatalf("console change backed up and rewrote the file: %v", backups)
+	}
+}
+
+func TestChangeServerAdminFailsWhenConsoleChangeIsNotSaved(t *testing.T) {
+	_, payload := writeTestServerAdmin(t)
+	simulateServer(t, true)
+	previous := serverAdminReflectTimeout
+	serverAdminReflectTimeout = time.Second
+	t.Cleanup(func() { serverAdminReflectTimeout = previous })
+	payload["section"] = "users"
+	payload["platform"] = "Steam"
+	payload["identifier"] = "76561198000000001"
+	cfg, _ := fakeAdminConsole(t, "Removed user", nil)
+
+	if _, viaConsole, err := (&Adapter{}).ChangeServerAdmin(context.Background(), cfg, payload, "remove"); err == nil || !viaConsole {
+		t.Fatalf("unsaved console change: viaConsole=%v err=%v", viaConsole, err)
+	}
+}
+
+func TestChangeServerAdminReportsConsoleRejection(t *testing.T) {
+	_, payload := writeTestServerAdmin(t)
+	simulateServer(t, true)
+	payload["section"] = "commands"
+	payload["command"] = "kick"
+	payload["permission_level"] = float64(2)
+	cfg, _ := fakeAdminConsole(t, "*** ERROR: Error executing command 'commandpermission'", nil)
+
+	_, _, err := (&Adapter{}).ChangeServerAdmin(context.Background(), cfg, payload, "update")
+	if err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -0,0 +1,615 @@
+package sevendtd
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mastermind/agent/internal/agent"
+	"github.com/mastermind/agent/internal/xmltree"
+)
+
+// defaultServerConfigBackupRoot keeps serverconfig.xml backups when the
+// adapter sets no ServerConfigBackupRoot.
+const defaultServerConfigBackupRoot = "/var/lib/mastermind-agent/serverconfig-backups"
+const serverConfigBackupRetention = 50
+const maxServerConfigBytes = 256 * 1024
+
+// configPropertySchema constrains one serverconfig.xml property. Ranges are
+// deliberately a little wider than the values the game UI offers so existing
+// community configurations keep validating.
+type configPropertySchema struct {
+	Type    string   `json:"type"` // string | int | bool | enum
+	Min     int      `json:"min,omitempty"`
+	Max     int      `json:"max,omitempty"`
+	Options []string `json:"options,omitempty"`
+	// Since is the first schema version that knows the property.
+	Since string `json:"-"`
+}
+
+func stringProp() configPropertySchema { return configPropertySchema{Type: "string"} }
+func boolProp() configPropertySchema   { return configPropertySchema{Type: "bool"} }
+func intProp(min, max int) configPropertySchema {
+	return configPropertySchema{Type: "int", Min: min, Max: max}
+}
+func enumProp(options ...string) configPropertySchema {
+	return configPropertySchema{Type: "enum", Options: options}
+}
+func v1(schema configPropertySchema) configPropertySchema { schema.Since = "v1"; return schema }
+
+// serverConfigSchemaVersions lists schema versions oldest first. "a21" covers
+// Alpha 21; "v1" covers the 1.x and 2.x releases.
+var serverConfigSchemaVersions = []string{"a21", "v1"}
+
+var serverConfigSchema = map[string]configPropertySchema{
+	"ServerName":                         stringProp(),
+	"ServerDescription":                  stringProp(),
+	"ServerWebsiteURL":                   stringProp(),
+	"ServerPassword":                     stringProp(),
+	"ServerLoginConfirmationText":        stringProp(),
+	"Region":                             enumProp("NorthAmericaEast", "NorthAmericaWest", "CentralAmerica", "SouthAmerica", "Europe", "Russia", "Asia", "MiddleEast", "Africa", "Oceania"),
+	"Language":                           stringProp(),
+	"ServerPort":                         intProp(1, 65535),
+	"ServerVisibility":                   intProp(0, 2),
+	"ServerDisabledNetworkProtocols":     stringProp(),
+	"ServerMaxWorldTransferSpeedKiBs":    intProp(64, 1300),
+	"ServerMaxPlayerCount":               intProp(1, 64),
+	"ServerReservedSlots":                intProp(0, 64),
+	"ServerReservedSlotsPermission":      intProp(0, 1000),
+	"ServerAdminSlots":                   intProp(0, 64),
+	"ServerAdminSlotsPermission":         intProp(0, 1000),
+	"WebDashboardEnabled":                boolProp(),
+	"WebDashboardPort":                   intProp(1, 65535),
+	"WebDashboardUrl":                    stringProp(),
+	"EnableMapRendering":                 boolProp(),
+	"TelnetEnabled":                      boolProp(),
+	"TelnetPort":                         intProp(1, 65535),
+	"TelnetPassword":                     stringProp(),
+	"TelnetFailedLoginLimit":             intProp(0, 100),
+	"TelnetFailedLoginsBlocktime":        intProp(0, 86400),
+	"TerminalWindowEnabled":              boolProp(),
+	"AdminFileName":                      stringProp(),
+	"ServerAllowCrossplay":               v1(boolProp()),
+	"EACEnabled":                         boolProp(),
+	"IgnoreEOSSanctions":                 v1(boolProp()),
+	"HideCommandExecutionLog":            intProp(0, 3),
+	"MaxUncoveredMapChunksPerPlayer":     intProp(0, 1000000),
+	"PersistentPlayerProfiles":           boolProp(),
+	"MaxChunkAge":                        intProp(-1, 10000),
+	"SaveDataLimit":                      intProp(-1, 1000000),
+	"GameWorld":                          stringProp(),
+	"WorldGenSeed":                       stringProp(),
+	"WorldGenSize":                       intProp(2048, 16384),
+	"GameName":                           stringProp(),
+	"GameMode":                           enumProp("GameModeSurvival"),
+	"GameDifficulty":                     intProp(0, 5),
+	"BlockDamagePlayer":                  intProp(0, 500),
+	"BlockDamageAI":                      intProp(0, 500),
+	"BlockDamageAIBM":                    intProp(0, 500),
+	"XPMultiplier":                       intProp(0, 1000),
+	"PlayerSafeZoneLevel":                intProp(0, 1000),
+	"PlayerSafeZoneHours":                intProp(0, 1000),
+	"BuildCreate":                        boolProp(),
+	"DayNightLength":                     intProp(10, 240),
+	"DayLightLength":                     intProp(0, 24),
+	"BiomeProgression":                   v1(boolProp()),
+	"StormFreq":                          v1(intProp(0, 500)),
+	"DeathPenalty":                       intProp(0, 3),
+	"DropOnDeath":                        intProp(0, 4),
+	"DropOnQuit":                         intProp(0, 3),
+	"BedrollDeadZoneSize":                intProp(0, 1000),
+	"BedrollExpiryTime":                  intProp(0, 1000),
+	"MaxSpawnedZombies":                  intProp(1, 200),
+	"MaxSpawnedAnimals":                  intProp(0, 200),
+	"ServerMaxAllowedViewDistance":       intProp(6, 12),
+	"MaxQueuedMeshLayers":                v1(intProp(0, 10000)),
+	"EnemySpawnMode":                     boolProp(),
+	"EnemyDifficulty":                    intProp(0, 1),
+	"ZombieFeralSense":                   intProp(0, 3),
+	"ZombieMove":                         intProp(0, 4),
+	"ZombieMoveNight":                    intProp(0, 4),
+	"ZombieFeralMove":                    intProp(0, 4),
+	"ZombieBMMove":                       intProp(0, 4),
+	"BloodMoonFrequency":                 intProp(0, 1000),
+	"BloodMoonRange":                     intProp(0, 1000),
+	"BloodMoonWarning":                   intProp(-1, 23),
+	"BloodMoonEnemyCount":                intProp(0, 64),
+	"LootAbundance":                      intProp(0, 1000),
+	"LootRespawnDays":                    intProp(-1, 1000),
+	"AirDropFrequency":                   intProp(0, 1000),
+	"AirDropMarker":                      boolProp(),
+	"PartySharedKillRange":               intProp(0, 100000),
+	"PlayerKillingMode":                  intProp(0, 3),
+	"LandClaimCount":                     intProp(0, 100),
+	"LandClaimSize":                      intProp(1, 1000),
+	"LandClaimDeadZone":                  intProp(0, 1000),
+	"LandClaimExpiryTime":                intProp(0, 10000),
+	"LandClaimDecayMode":                 intProp(0, 2),
+	"LandClaimOnlineDurabilityModifier":  intProp(0, 1000),
+	"LandClaimOfflineDurabilityModifier": intProp(0, 1000),
+	"LandClaimOfflineDelay":              intProp(0, 10000),
+	"DynamicMeshEnabled":                 boolProp(),
+	"DynamicMeshLandClaimOnly":           boolProp(),
+	"DynamicMeshLandClaimBuffer":         intProp(0, 100),
+	"DynamicMeshMaxItemCache":            intProp(0, 10000),
+	"TwitchServerPermission":             intProp(0, 1000),
+	"TwitchBloodMoonAllowed":             boolProp(),
+	"QuestProgressionDailyLimit":         v1(intProp(0, 100)),
+	"UserDataFolder":                     stringProp(),
+	"SaveGameFolder":                     stringProp(),
+}
+
+// schemaFor returns the schema entry for name in the given version. Lookups
+// are case-sensitive because 7DTD property names are.
+func schemaFor(version, name string) (configPropertySchema, bool) {
+	schema, ok := serverConfigSchema[name]
+	if !ok {
+		return configPropertySchema{}, false
+	}
+	if schema.Since != "" && versionIndex(version) < versionIndex(schema.Since) {
+		return configPropertySchema{}, false
+	}
+	return schema, true
+}
+
+func versionIndex(version string) int {
+	for index, known := range serverConfigSchemaVersions {
+		if known == version {
+			return index
+		}
+	}
+	return len(serverConfigSchemaVersions) - 1
+}
+
+// normalizeSchemaVersion maps a reported game version such as "V1.2 (b27)"
+// or "A21.2" to a schema version; unknown values use the newest schema.
+func normalizeSchemaVersion(gameVersion string) string {
+	v := strings.ToLower(strings.TrimSpace(gameVersion))
+	if strings.HasPrefix(v, "a") || strings.HasPrefix(v, "alpha") {
+		return "a21"
+	}
+	return serverConfigSchemaVersions[len(serverConfigSchemaVersions)-1]
+}
+
+// validate returns the canonical form of value or an error describing why
+// the game would reject it.
+func (s configPropertySchema) validate(name, value string) (string, error) {
+	switch s.Type {
+	case "int":
+		number, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return "", fmt.Errorf("%s must be a whole number", name)
+		}
+		if number < s.Min || number > s.Max {
+			return "", fmt.Errorf("%s must be between %d and %d", name, s.Min, s.Max)
+		}
+		return strconv.Itoa(number), nil
+	case "bool":
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "true":
+			return "true", nil
+		case "false":
+			return "false", nil
+		}
+		return "", fmt.Errorf("%s must be true or false", name)
+	case "enum":
+		for _, option := range s.Options {
+			if strings.EqualFold(option, strings.TrimSpace(value)) {
+				return option, nil
+			}
+		}
+		return "", fmt.Errorf("%s must be one of %s", name, strings.Join(s.Options, ", "))
+	}
+	if len(value) > 1024 || strings.ContainsAny(value, "\r\n") {
+		return "", fmt.Errorf("%s must be a single line of at most 1024 characters", name)
+	}
+	return value, nil
+}
+
+type serverConfigProperty struct {
+	Name   string                `json:"name"`
+	Value  string                `json:"value"`
+	Line   int                   `json:"line"`
+	Schema *configPropertySchema `json:"schema,omitempty"`
+	// Invalid explains why the current value fails validation, if it does.
+	Invalid string `json:"invalid,omitempty"`
+}
+
+type serverConfigChange struct {
+	Name   string `json:"name"`
+	Action string `json:"action"` // added | changed | removed
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+type serverConfigBackup struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	SizeBytes int64     `json:"sizeBytes"`
+}
+
+type serverConfigState struct {
+	Path          string                 `json:"path"`
+	SchemaVersion string                 `json:"schemaVersion"`
+	Hash          string                 `json:"hash"`
+	Properties    []serverConfigProperty `json:"properties"`
+	Backups       []serverConfigBackup   `json:"backups"`
+}
+
+func serverConfigPath(cfg *agent.InstanceConfig, override string) (string, error) {
+	path := strings.TrimSpace(override)
+	if path == "" {
+		if cfg.InstallPath == "" {
+			return "", fmt.Errorf("install_path or server_config_path required")
+		}
+		path = filepath.Join(filepath.Dir(cfg.InstallPath), "serverconfig.xml")
+	}
+	path = filepath.Clean(path)
+	if !filepath.IsAbs(path) || !strings.EqualFold(filepath.Ext(path), ".xml") {
+		return "", fmt.Errorf("server configuration must be an absolute .xml path")
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", fmt.Errorf("read server configuration: %w", err)
+	}
+	if !info.Mode().IsRegular() || info.Mode()&os.ModeSymlink != 0 {
+		return "", fmt.Errorf("server configuration must be a regular file")
+	}
+	if info.Size() > maxServerConfigBytes {
+		return "", fmt.Errorf("server configuration exceeds 256 KiB")
+	}
+	return path, nil
+}
+
+func parseServerConfigTree(content []byte) (*xmltree.Node, error) {
+	document, err := xmltree.ParseBytes(content)
+	if err != nil {
+		return nil, fmt.Errorf("parse server configuration: %w", err)
+	}
+	if !strings.EqualFold(document.Root().Name, "ServerSettings") {
+		return nil, fmt.Errorf("server configuration root element must be ServerSettings")
+	}
+	return document, nil
+}
+
+func serverConfigProperties(document *xmltree.Node, version string) []serverConfigProperty {
+	properties := []serverConfigProperty{}
+	for _, node := range document.Root().Elements("property") {
+		name := node.AttrValue("name")
+		if name == "" {
+			continue
+		}
+		property := serverConfigProperty{Name: name, Value: node.AttrValue("value"), Line: node.Line}
+		if schema, ok := schemaFor(version, name); ok {
+			property.Schema = &schema
+			if _, err := schema.validate(name, property.Value); err != nil {
+				property.Invalid = err.Error()
+			}
+		}
+		properties = append(properties, property)
+	}
+	return properties
+}
+
+func contentHash(content []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(content))
+}
+
+func (a *Adapter) ReadServerConfig(cfg *agent.InstanceConfig, override, gameVersion string) (serverConfigState, error) {
+	path, err := serverConfigPath(cfg, override)
+	if err != nil {
+		return serverConfigState{}, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return serverConfigState{}, fmt.Errorf("read server configuration: %w", err)
+	}
+	document, err := parseServerConfigTree(content)
+	if err != nil {
+		return serverConfigState{}, err
+	}
+	version := normalizeSchemaVersion(gameVersion)
+	return serverConfigState{
+		Path:          path,
+		SchemaVersion: version,
+		Hash:          contentHash(content),
+		Properties:    serverConfigProperties(document, version),
+		Backups:       a.listServerConfigBackups(cfg),
+	}, nil
+}
+
+// payloadConfigValue renders a JSON payload value the way serverconfig.xml
+// spells it.
+func payloadConfigValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		if v == float64(int64(v)) {
+			return strconv.FormatInt(int64(v), 10), true
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(v), true
+	}
+	return "", false
+}
+
+// applyServerConfigChanges edits document in place and returns the diff.
+// Properties unknown to the schema may be edited only when they already
+// exist, which keeps newer game builds editable without inviting typos.
+func applyServerConfigChanges(document *xmltree.Node, version string, set map[string]interface{}, remove []string) ([]serverConfigChange, error) {
+	root := document.Root()
+	existing := map[string][]*xmltree.Node{}
+	for _, node := range root.Elements("property") {
+		if name := node.AttrValue("name"); name != "" {
+			existing[name] = append(existing[name], node)
+		}
+	}
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	changes := []serverConfigChange{}
+	for _, name := range names {
+		raw, ok := payloadConfigValue(set[name])
+		if !ok {
+			return nil, fmt.Errorf("%s has an unsupported value type", name)
+		}
+		schema, known := schemaFor(version, name)
+		if !known {
+			if len(existing[name]) == 0 {
+				return nil, fmt.Errorf("unknown server property %q for schema %s", name, version)
+			}
+			schema = stringProp()
+		}
+		value, err := schema.validate(name, raw)
+		if err != nil {
+			return nil, err
+		}
+		nodes := existing[name]
+		if len(nodes) == 0 {
+			root.AppendElement(xmltree.NewElement("property", xmltree.Attr{Name: "name", Value: name}, xmltree.Attr{Name: "value", Value: value}))
+			changes = append(changes, serverConfigChange{Name: name, Action: "added", New: value})
+			continue
+		}
+		old := nodes[len(nodes)-1].AttrValue("value")
+		if old == value {
+			continue
+		}
+		for _, node := range nodes {
+			node.SetAttr("value", value)
+		}
+		changes = append(changes, serverConfigChange{Name: name, Action: "changed", Old: old, New: value})
+	}
+	for _, name := range remove {
+		nodes := existing[name]
+		if len(nodes) == 0 {
+			continue
+		}
+		if _, ok := set[name]; ok {
+			return nil, fmt.Errorf("%s cannot be both set and removed", name)
+		}
+		for _, node := range nodes {
+			root.RemoveChild(node)
+		}
+		changes = append(changes, serverConfigChange{Name: name, Action: "removed", Old: nodes[len(nodes)-1].AttrValue("value")})
+	}
+	return changes, nil
+}
+
+// WriteServerConfig validates and applies property changes. The previous file
+// is backed up first and the result says whether a restart is required,
+// because 7DTD only reads serverconfig.xml at startup.
+func (a *Adapter) WriteServerConfig(ctx context.Context, cfg *agent.InstanceConfig, payload map[string]interface{}) (map[string]interface{}, error) {
+	path, err := serverConfigPath(cfg, getString(payload, "server_config_path", ""))
+	if err != nil {
+		return nil, err
+	}
+	set, _ := payload["properties"].(map[string]interface{})
+	var remove []string
+	if values, ok := payload["remove"].([]interface{}); ok {
+		for _, value := range values {
+			if name, ok := value.(string); ok && strings.TrimSpace(name) != "" {
+				remove = append(remove, strings.TrimSpace(name))
+			}
+		}
+	}
+	if len(set) == 0 && len(remove) == 0 {
+		return nil, fmt.Errorf("no server property changes requested")
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read server configuration: %w", err)
+	}
+	if expected := getString(payload, "expected_hash", ""); expected != "" && expected != contentHash(content) {
+		return nil, fmt.Errorf("server configuration changed since it was read; reload and try again")
+	}
+	document, err := parseServerConfigTree(content)
+	if err != nil {
+		return nil, err
+	}
+	version := normalizeSchemaVersion(getString(payload, "game_version", ""))
+	changes, err := applyServerConfigChanges(document, version, set, remove)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{"path": path, "schemaVersion": version, "changes": changes, "restartRequired": false}
+	if len(changes) == 0 {
+		result["hash"] = contentHash(content)
+		return result, nil
+	}
+	backup, err := a.backupServerConfig(cfg, path)
+	if err != nil {
+		return nil, err
+	}
+	updated := document.Bytes()
+	if err := replaceFileAtomically(path, updated, 0640); err != nil {
+		return nil, fmt.Errorf("write server configuration: %w", err)
+	}
+	result["backup"] = backup
+	result["hash"] = contentHash(updated)
+	result["restartRequired"] = a.instanceRunning(ctx, cfg)
+	return result, nil
+}
+
+// RollbackServerConfig restores a previous backup. The file being replaced
+// is itself backed up, so a rollback can be undone.
+func (a *Adapter) RollbackServerConfig(ctx context.Context, cfg *agent.InstanceConfig, override, backupID string) (map[string]interface{}, error) {
+	path, err := serverConfigPath(cfg, override)
+	if err != nil {
+		return nil, err
+	}
+	source, err := a.serverConfigBackupPath(cfg, backupID)
+	if err != nil {
+		return nil, err
+	}
+	restored, err := os.ReadFile(source)
+	if err != nil {
+		return nil, fmt.Errorf("read server configuration backup: %w", err)
+	}
+	restoredDocument, err := parseServerConfigTree(restored)
+	if err != nil {
+		return nil, fmt.Errorf("backup %s: %w", backupID, err)
+	}
+	current, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read server configuration: %w", err)
+	}
+	currentDocument, err := parseServerConfigTree(current)
+	if err != nil {
+		return nil, err
+	}
+	changes := diffServerConfig(currentDocument, restoredDocument)
+	backup, err := a.backupServerConfig(cfg, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := replaceFileAtomically(path, restored, 0640); err != nil {
+		return nil, fmt.Errorf("restore server configuration: %w", err)
+	}
+	return map[string]interface{}{
+		"path":            path,
+		"restoredFrom":    backupID,
+		"backup":          backup,
+		"changes":         changes,
+		"hash":            contentHash(restored),
+		"restartRequired": len(changes) > 0 && a.instanceRunning(ctx, cfg),
+	}, nil
+}
+
+func diffServerConfig(before, after *xmltree.Node) []serverConfigChange {
+	values := func(document *xmltree.Node) map[string]string {
+		out := map[string]string{}
+		for _, node := range document.Root().Elements("property") {
+			if name := node.AttrValue("name"); name != "" {
+				out[name] = node.AttrValue("value")
+			}
+		}
+		return out
+	}
+	old, updated := values(before), values(after)
+	names := map[string]bool{}
+	for name := range old {
+		names[name] = true
+	}
+	for name := range updated {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	changes := []serverConfigChange{}
+	for _, name := range sorted {
+		oldValue, hadOld := old[name]
+		newValue, hasNew := updated[name]
+		switch {
+		case !hadOld:
+			changes = append(changes, serverConfigChange{Name: name, Action: "added", New: newValue})
+		case !hasNew:
+			changes = append(changes, serverConfigChange{Name: name, Action: "removed", Old: oldValue})
+		case oldValue != newValue:
+			changes = append(changes, serverConfigChange{Name: name, Action: "changed", Old: oldValue, New: newValue})
+		}
+	}
+	return changes
+}
+
+var serverConfigBackupIDPattern = regexp.MustCompile(`^serverconfig-\d{8}T\d{6}\.\d{9}Z$`)
+
+func (a *Adapter) serverConfigBackupDir(cfg *agent.InstanceConfig) string {
+	root := a.ServerConfigBackupRoot
+	if root == "" {
+		root = defaultServerConfigBackupRoot
+	}
+	serverKey := cfg.ServerInstanceID
+	if serverKey == "" {
+		serverKey = "default"
+	}
+	return filepath.Join(root, serverKey)
+}
+
+func (a *Adapter) serverConfigBackupPath(cfg *agent.InstanceConfig, id string) (string, error) {
+	if !serverConfigBackupIDPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid server configuration backup ID")
+	}
+	return filepath.Join(a.serverConfigBackupDir(cfg), id+".xml"), nil
+}
+
+func (a *Adapter) backupServerConfig(cfg *agent.InstanceConfig, path string) (serverConfigBackup, error) {
+	dir := a.serverConfigBackupDir(cfg)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return serverConfigBackup{}, fmt.Errorf("create server configuration backup directory: %w", err)
+	}
+	created := time.Now().UTC()
+	id := "serverconfig-" + created.Format("20060102T150405.000000000Z")
+	destination := filepath.Join(dir, id+".xml")
+	if err := copySaveFile(path, destination, 0640); err != nil {
+		return serverConfigBackup{}, fmt.Errorf("back up server configuration: %w", err)
+	}
+	backups := a.listServerConfigBackups(cfg)
+	for _, old := range backups[min(len(backups), serverConfigBackupRetention):] {
+		_ = os.Remove(filepath.Join(dir, old.ID+".xml"))
+	}
+	info, _ := os.Stat(destination)
+	record := serverConfigBackup{ID: id, CreatedAt: created}
+	if info != nil {
+		record.SizeBytes = info.Size()
+	}
+	return record, nil
+}
+
+// listServerConfigBackups returns backups newest first.
+func (a *Adapter) listServerConfigBackups(cfg *agent.InstanceConfig) []serverConfigBackup {
+	backups := []serverConfigBackup{}
+	entries, err := os.ReadDir(a.serverConfigBackupDir(cfg))
+	if err != nil {
+		return backups
+	}
+	for _, entry := range entries {
+		id := strings.TrimSuffix(entry.Name(), ".xml")
+		if entry.IsDir() || !serverConfigBackupIDPattern.MatchString(id) {
+			continue
+		}
+		created, _ := time.Parse("20060102T150405.000000000Z", strings.TrimPrefix(id, "serverconfig-"))
+		record := serverConfigBackup{ID: id, CreatedAt: created}
+		if info, err := entry.Info(); err == nil {
+			record.SizeBytes = info.Size()
+		}
+		backups = append(backups, record)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].ID > backups[j].ID })
+	return backups
+}
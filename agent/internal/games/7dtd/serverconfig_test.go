@@ -0,0 +1,121 @@
+package sevendtd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mastermind/agent/internal/agent"
+	"github.com/mastermind/agent/internal/xmltree"
+)
+
+const testServerConfig = `<?xml version="1.0"?>
+<ServerSettings>
+	<!-- GENERAL SERVER SETTINGS -->
+	<property name="ServerName" value="Test" />
+	<property name="MaxSpawnedZombies" value="64" /> <!-- per server -->
+	<property name="CustomModSetting" value="on" />
+</ServerSettings>
+`
+
+func TestApplyServerConfigChangesValidatesAndPreservesComments(t *testing.T) {
+	document, err := parseServerConfigTree([]byte(testServerConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := applyServerConfigChanges(document, "v1", map[string]interface{}{
+		"MaxSpawnedZombies":  float64(80),
+		"BloodMoonFrequency": "14",
+		"CustomModSetting":   "off",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 {
+		t.Fatalf("changes = %+v", changes)
+	}
+	out := string(document.Bytes())
+	for _, want := range []string{
+		`<!-- GENERAL SERVER SETTINGS -->`,
+		`<property name="MaxSpawnedZombies" value="80" /> <!-- per server -->`,
+		`<property name="CustomModSetting" value="off" />
+	<property name="BloodMoonFrequency" value="14" />`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("edited configuration missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestApplyServerConfigChangesRejectsInvalidValues(t *testing.T) {
+	for name, value := range map[string]interface{}{
+		"MaxSpawnedZombies": float64(5000),
+		"EACEnabled":        "maybe",
+		"NotARealProperty":  "1",
+	} {
+		document, _ := xmltree.ParseBytes([]byte(testServerConfig))
+		if _, err := applyServerConfigChanges(document, "v1", map[string]interface{}{name: value}, nil); err == nil {
+			t.Errorf("%s=%v was accepted", name, value)
+		}
+	}
+	document, _ := xmltree.ParseBytes([]byte(testServerConfig))
+	if _, err := applyServerConfigChanges(document, "a21", map[string]interface{}{"BiomeProgression": true}, nil); err == nil {
+		t.Error("v1-only property was accepted for the a21 schema")
+	}
+}
+
+func TestServerConfigWriteBackupAndRollback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "serverconfig.xml")
+	if err := os.WriteFile(path, []byte(testServerConfig), 0640); err != nil {
+		t.Fatal(err)
+	}
+	a := &Adapter{ServerConfigBackupRoot: t.TempDir(), isRunning: func(context.Context) bool { return false }}
+	cfg := &agent.InstanceConfig{ServerInstanceID: "srv-1"}
+	ctx := context.Background()
+
+	result, err := a.WriteServerConfig(ctx, cfg, map[string]interface{}{
+		"server_config_path": path,
+		"properties":         map[string]interface{}{"MaxSpawnedZombies": float64(80)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup, ok := result["backup"].(serverConfigBackup)
+	if !ok {
+		t.Fatalf("write result has no backup: %+v", result)
+	}
+	saved, err := os.ReadFile(filepath.Join(a.ServerConfigBackupRoot, "srv-1", backup.ID+".xml"))
+	if err != nil || string(saved) != testServerConfig {
+		t.Fatalf("backup = %q, %v; want the file before the write", saved, err)
+	}
+	if written, _ := os.ReadFile(path); !strings.Contains(string(written), `<property name="MaxSpawnedZombies" value="80" />`) {
+		t.Fatalf("write was not applied:\n%s", written)
+	}
+
+	missing := "serverconfig-20000101T000000.000000000Z"
+	if _, err := a.RollbackServerConfig(ctx, cfg, path, missing); err == nil {
+		t.Fatal("rollback to a missing backup succeeded")
+	}
+	if _, err := a.RollbackServerConfig(ctx, cfg, path, "../../etc/passwd"); err == nil {
+		t.Fatal("rollback to an invalid backup ID succeeded")
+	}
+	if written, _ := os.ReadFile(path); !strings.Contains(string(written), `value="80"`) {
+		t.Fatalf("failed rollback changed the file:\n%s", written)
+	}
+
+	result, err = a.RollbackServerConfig(ctx, cfg, path, backup.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored, _ := os.ReadFile(path); string(restored) != testServerConfig {
+		t.Fatalf("rollback did not restore the backup:\n%s", restored)
+	}
+	if changes, _ := result["changes"].([]serverConfigChange); len(changes) != 1 || changes[0].Name != "MaxSpawnedZombies" {
+		t.Fatalf("rollback changes = %+v", result["changes"])
+	}
+	if backups := a.listServerConfigBackups(cfg); len(backups) != 2 {
+		t.Fatalf("backups = %+v, want the original and the rolled-back file", backups)
+	}
+}
@@ -0,0 +1,251 @@
+package sevendtd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+// sevenDTDDedicatedServerAppID is the Steam app ID of the 7DTD dedicated server.
+const sevenDTDDedicatedServerAppID = "294420"
+
+type steamCMDOptions struct {
+	Branch       string
+	BetaPassword string
+	Validate     bool
+}
+
+type gameUpdateResult struct {
+	InstallPath string `json:"installPath"`
+	Branch      string `json:"branch"`
+	Validated   bool   `json:"validated"`
+	OldBuildID  string `json:"oldBuildId,omitempty"`
+	NewBuildID  string `json:"newBuildId,omitempty"`
+	Changed     bool   `json:"changed"`
+}
+
+var (
+	steamBranchPattern   = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+	steamPasswordPattern = regexp.MustCompile(`^[^\s"]{1,128}$`)
+	steamProgressPattern = regexp.MustCompile(`Update state \(0x[0-9a-fA-F]+\) ([a-z ]+), progress: (\d+(?:\.\d+)?)`)
+	steamBuildIDPattern  = regexp.MustCompile(`"buildid"\s+"(\d+)"`)
+)
+
+func steamCMDOptionsFromPayload(payload map[string]interface{}) (steamCMDOptions, error) {
+	options := steamCMDOptions{
+		Branch:       strings.TrimSpace(getString(payload, "branch", "public")),
+		BetaPassword: getString(payload, "beta_password", ""),
+		Validate:     getBool(payload, "validate"),
+	}
+	if options.Branch == "" {
+		options.Branch = "public"
+	}
+	if !steamBranchPattern.MatchString(options.Branch) {
+		return options, fmt.Errorf("invalid Steam branch name")
+	}
+	if options.BetaPassword != "" && !steamPasswordPattern.MatchString(options.BetaPassword) {
+		return options, fmt.Errorf("invalid Steam beta password")
+	}
+	return options, nil
+}
+
+// steamCMDScript builds the runscript steamcmd executes. The commands go in
+// a file rather than on the command line so a beta password never shows up
+// in the process list. force_install_dir must precede the login for
+// steamcmd to honour it.
+func steamCMDScript(installPath string, options steamCMDOptions) string {
+	update := "app_update " + sevenDTDDedicatedServerAppID
+	if options.Branch != "public" {
+		update += " -beta " + options.Branch
+		if options.BetaPassword != "" {
+			update += " -betapassword " + options.BetaPassword
+		}
+	}
+	if options.Validate {
+		update += " validate"
+	}
+	return strings.Join([]string{"force_install_dir \"" + installPath + "\"", "login anonymous", update, "quit"}, "\n") + "\n"
+}
+
+// writeSteamCMDScript writes script to a new temporary file, which
+// os.CreateTemp makes mode 0600, and returns its path; the caller removes it.
+func writeSteamCMDScript(script string) (string, error) {
+	file, err := os.CreateTemp("", "mastermind-steamcmd-*.txt")
+	if err != nil {
+		return "", fmt.Errorf("create steamcmd script: %w", err)
+	}
+	if _, err := file.WriteString(script); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", fmt.Errorf("write steamcmd script: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("write steamcmd script: %w", err)
+	}
+	return file.Name(), nil
+}
+
+func installedBuildID(installPath string) string {
+	content, err := os.ReadFile(filepath.Join(installPath, "steamapps", "appmanifest_"+sevenDTDDedicatedServerAppID+".acf"))
+	if err != nil {
+		return ""
+	}
+	if match := steamBuildIDPattern.FindSubmatch(content); len(match) == 2 {
+		return string(match[1])
+	}
+	return ""
+}
+
+// steamProgressReporter turns steamcmd "Update state" lines into progress
+// messages, reporting each phase and whole percent only once.
+type steamProgressReporter struct {
+	ctx     context.Context
+	phase   string
+	percent int
+}
+
+func (r *steamProgressReporter) line(line string) {
+	match := steamProgressPattern.FindStringSubmatch(line)
+	if len(match) != 3 {
+		return
+	}
+	value, err := strconv.ParseFloat(match[2], 64)
+	if err != nil {
+		return
+	}
+	phase, percent := strings.TrimSpace(match[1]), int(value)
+	if phase == r.phase && percent == r.percent {
+		return
+	}
+	r.phase, r.percent = phase, percent
+	agent.ReportProgress(r.ctx, "running", fmt.Sprintf("SteamCMD %s: %d%%", phase, percent))
+}
+
+// runSteamCMD runs script with steamcmd and feeds its output lines to the
+// progress reporter. steamcmd's exit status is unreliable, so success also
+// requires its explicit "Success!" line.
+func runSteamCMD(ctx context.Context, binary, script string) (string, error) {
+	scriptPath, err := writeSteamCMDScript(script)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(scriptPath)
+	cmd := exec.CommandContext(ctx, binary, "+runscript", scriptPath)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("start steamcmd: %w", err)
+	}
+	reporter := &steamProgressReporter{ctx: ctx, percent: -1}
+	var tail []string
+	success := false
+	scanner := bufio.NewScanner(stdout)
+	// steamcmd redraws progress with carriage returns rather than newlines.
+	scanner.Split(scanLinesOrCarriageReturns)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		reporter.line(line)
+		if strings.HasPrefix(line, "Success!") {
+			success = true
+		}
+		tail = append(tail, line)
+		if len(tail) > 20 {
+			tail = tail[len(tail)-20:]
+		}
+	}
+	_, _ = io.Copy(io.Discard, stdout)
+	waitErr := cmd.Wait()
+	output := strings.Join(tail, "\n")
+	if waitErr != nil {
+		return output, fmt.Errorf("steamcmd failed: %w", waitErr)
+	}
+	if !success {
+		return output, fmt.Errorf("steamcmd did not report success")
+	}
+	return output, nil
+}
+
+func scanLinesOrCarriageReturns(data []byte, atEOF bool) (int, []byte, error) {
+	for index, b := range data {
+		if b == '\n' || b == '\r' {
+			return index + 1, data[:index], nil
+		}
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// UpdateGame installs or updates the dedicated server with steamcmd. It
+// refuses to touch the files of a running server.
+func (a *Adapter) UpdateGame(ctx context.Context, cfg *agent.InstanceConfig, options steamCMDOptions, install bool) (gameUpdateResult, string, error) {
+	if a.SteamCMDPath == "" {
+		return gameUpdateResult{}, "", fmt.Errorf("steamcmd_path is not configured on this agent")
+	}
+	if info, err := os.Stat(a.SteamCMDPath); err != nil || info.IsDir() || info.Mode().Perm()&0111 == 0 {
+		return gameUpdateResult{}, "", fmt.Errorf("configured steamcmd is not an executable file")
+	}
+	installPath := filepath.Clean(cfg.InstallPath)
+	if cfg.InstallPath == "" || !filepath.IsAbs(installPath) || strings.ContainsAny(installPath, "\"\r\n") {
+		return gameUpdateResult{}, "", fmt.Errorf("absolute install_path required")
+	}
+	if a.instanceRunning(ctx, cfg) {
+		return gameUpdateResult{}, "", fmt.Errorf("server must be stopped before updating game files")
+	}
+	oldBuild := installedBuildID(installPath)
+	if install {
+		if oldBuild != "" {
+			return gameUpdateResult{}, "", fmt.Errorf("7DTD is already installed at %s (build %s); use GAME_UPDATE", installPath, oldBuild)
+		}
+		if err := os.MkdirAll(installPath, 0750); err != nil {
+			return gameUpdateResult{}, "", fmt.Errorf("create install directory: %w", err)
+		}
+	} else if oldBuild == "" {
+		return gameUpdateResult{}, "", fmt.Errorf("no SteamCMD app manifest under %s; use GAME_INSTALL", installPath)
+	}
+	agent.ReportProgress(ctx, "running", "Starting SteamCMD")
+	output, err := runSteamCMD(ctx, a.SteamCMDPath, steamCMDScript(installPath, options))
+	if err != nil {
+		return gameUpdateResult{}, output, err
+	}
+	newBuild := installedBuildID(installPath)
+	return gameUpdateResult{
+		InstallPath: installPath,
+		Branch:      options.Branch,
+		Validated:   options.Validate,
+		OldBuildID:  oldBuild,
+		NewBuildID:  newBuild,
+		Changed:     oldBuild != newBuild,
+	}, output, nil
+}
+
+// serverRunning reports whether the instance's server runs natively under
+// the process supervisor or in the 7DTD systemd unit. Every "server must be
+// stopped" check goes through it; isRunning lets tests avoid the host's
+// systemd.
+func (a *Adapter) serverRunning(ctx context.Context, cfg *agent.InstanceConfig) bool {
+	if a.isRunning != nil {
+		return a.isRunning(ctx)
+	}
+	if _, running := processes.Process(cfg.ServerInstanceID).Running(); running {
+		return true
+	}
+	return serviceActive(ctx, "7dtd.service") || serviceHasMainPID(ctx)
+}
@@ -0,0 +1,135 @@
+package sevendtd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mastermind/agent/internal/agent"
+	"github.com/mastermind/agent/internal/procsup"
+)
+
+// stubSteamCMD records its arguments and runscript, prints the progress
+// lines real steamcmd emits and rewrites the app manifest with a new build ID.
+const stubSteamCMD = `#!/bin/sh
+script="$2"
+install=$(sed -n 's/^force_install_dir "\(.*\)"$/\1/p' "$script")
+echo "$@" > "$install/args.log"
+stat -c %a "$script" > "$install/script.mode"
+cat "$script" > "$install/script.log"
+printf ' Update state (0x3) reconfiguring, progress: 0.00 (0 / 0)\n'
+printf ' Update state (0x61) downloading, progress: 12.50 (1 / 8)\r'
+printf ' Update state (0x61) downloading, progress: 12.90 (1 / 8)\r'
+printf ' Update state (0x61) downloading, progress: 100.00 (8 / 8)\n'
+mkdir -p "$install/steamapps"
+printf '"AppState"\n{\n\t"appid"\t\t"294420"\n\t"buildid"\t\t"200"\n}\n' > "$install/steamapps/appmanifest_294420.acf"
+echo "Success! App '294420' fully installed."
+`
+
+func writeStubSteamCMD(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "steamcmd.sh")
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestUpdateGameReportsProgressAndBuildIDs(t *testing.T) {
+	install := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(install, "steamapps"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(install, "steamapps", "appmanifest_294420.acf"), []byte("\"AppState\"\n{\n\t\"buildid\"\t\t\"100\"\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a := NewAdapter()
+	a.SteamCMDPath = writeStubSteamCMD(t, stubSteamCMD)
+	a.isRunning = func(context.Context) bool { return false }
+	var messages []string
+	ctx := agent.WithProgressReporter(context.Background(), func(_, message string) { messages = append(messages, message) })
+
+	result, _, err := a.UpdateGame(ctx, &agent.InstanceConfig{InstallPath: install}, steamCMDOptions{Branch: "latest_experimental", BetaPassword: "hunter2", Validate: true}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.OldBuildID != "100" || result.NewBuildID != "200" || !result.Changed {
+		t.Fatalf("result = %+v", result)
+	}
+	want := []string{"Starting SteamCMD", "SteamCMD reconfiguring: 0%", "SteamCMD downloading: 12%", "SteamCMD downloading: 100%"}
+	if strings.Join(messages, "|") != strings.Join(want, "|") {
+		t.Fatalf("progress = %q, want %q", messages, want)
+	}
+	script, _ := os.ReadFile(filepath.Join(install, "script.log"))
+	for _, expected := range []string{"force_install_dir \"" + install + "\"\nlogin anonymous\n", "app_update 294420 -beta latest_experimental -betapassword hunter2 validate\nquit\n"} {
+		if !strings.Contains(string(script), expected) {
+			t.Errorf("steamcmd script missing %q:\n%s", expected, script)
+		}
+	}
+	args, _ := os.ReadFile(filepath.Join(install, "args.log"))
+	if !strings.HasPrefix(string(args), "+runscript ") || strings.Contains(string(args), "hunter2") {
+		t.Errorf("steamcmd arguments = %q, want only the runscript", args)
+	}
+	if mode, _ := os.ReadFile(filepath.Join(install, "script.mode")); strings.TrimSpace(string(mode)) != "600" {
+		t.Errorf("runscript mode = %q, want 600", mode)
+	}
+}
+
+func TestUpdateGameRefusesRunningServer(t *testing.T) {
+	a := NewAdapter()
+	a.SteamCMDPath = writeStubSteamCMD(t, stubSteamCMD)
+	a.isRunning = func(context.Context) bool { return true }
+	_, _, err := a.UpdateGame(context.Background(), &agent.InstanceConfig{InstallPath: t.TempDir()}, steamCMDOptions{Branch: "public"}, true)
+	if err == nil || !strings.Contains(err.Error(), "stopped") {
+		t.Fatalf("err = %v, want refusal while running", err)
+	}
+}
+
+func TestUpdateGameRequiresSuccessLine(t *testing.T) {
+	install := t.TempDir()
+	a := NewAdapter()
+	a.SteamCMDPath = writeStubSteamCMD(t, "#!/bin/sh\necho \"ERROR! Failed to install app '294420' (No subscription)\"\n")
+	a.isRunning = func(context.Context) bool { return false }
+	_, output, err := a.UpdateGame(context.Background(), &agent.InstanceConfig{InstallPath: install}, steamCMDOptions{Branch: "public"}, true)
+	if err == nil || !strings.Contains(output, "No subscription") {
+		t.Fatalf("err = %v output = %q, want failure with steamcmd output", err, output)
+	}
+}
+
+// startNativeServer runs a stand-in server process for cfg under a private
+// process supervisor.
+func startNativeServer(t *testing.T, cfg *agent.InstanceConfig) {
+	t.Helper()
+	previous := processes
+	processes = procsup.NewManager(t.TempDir())
+	t.Cleanup(func() { processes = previous })
+	proc := processes.Process(cfg.ServerInstanceID)
+	if _, err := proc.Start(procsup.Spec{Dir: t.TempDir(), Argv: []string{"/bin/sleep", "30"}}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = proc.Kill(context.Background()) })
+}
+
+func TestNativeServerCountsAsRunning(t *testing.T) {
+	previous := saveBackupRoot
+	saveBackupRoot = t.TempDir()
+	t.Cleanup(func() { saveBackupRoot = previous })
+	config, _ := writeTestServer(t, map[string]string{"Region/r.0.0.7rg": "region"})
+	cfg := &agent.InstanceConfig{ServerInstanceID: "native", InstallPath: t.TempDir()}
+	startNativeServer(t, cfg)
+	a := NewAdapter()
+	a.SteamCMDPath = writeStubSteamCMD(t, stubSteamCMD)
+	ctx := context.Background()
+
+	if _, _, err := a.UpdateGame(ctx, cfg, steamCMDOptions{Branch: "public"}, true); err == nil || !strings.Contains(err.Error(), "stopped") {
+		t.Errorf("game update: %v, want refusal while the native server runs", err)
+	}
+	if _, err := a.ResetRegions(ctx, cfg, config, []regionCoord{{0, 0}}, false, retentionPolicy{KeepLast: 10}, saveFormatManifest); err == nil || !strings.Contains(err.Error(), "stopped") {
+		t.Errorf("region reset: %v, want refusal while the native server runs", err)
+	}
+	if _, err := a.RestoreSave(ctx, cfg, config, "mastermind_2024-05-01_10-00-00", nil, false); err == nil || !strings.Contains(err.Error(), "stopped") {
+		t.Errorf("save restore: %v, want refusal while the native server runs", err)
+	}
+}
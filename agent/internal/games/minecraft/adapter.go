@@ -11,10 +11,18 @@ import (
 	"time"
 
 	"github.com/mastermind/agent/internal/agent"
+	"github.com/mastermind/agent/internal/docker"
+	"github.com/mastermind/agent/internal/procsup"
 )
 
 const gameSlug = "minecraft"
 
+// processes supervises servers the adapter starts itself; tests replace it.
+var processes = procsup.Default
+
+// containers runs servers configured with config.docker.container.
+var containers = docker.Default
+
 // Adapter implements agent.GameAdapter for Minecraft (RCON + process control). Mod management not supported.
 type Adapter struct {
 	rconTimeout time.Duration
@@ -61,6 +69,15 @@ func (a *Adapter) Execute(ctx context.Context, job agent.Job) (agent.JobResult,
 			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
 		}
 		return agent.JobResult{Status: "success", Result: map[string]interface{}{"status": st}}, nil
+	case "SERVER_KILL":
+		return resultOrErr(a.Kill(ctx, cfg))
+	case "SERVER_LOGS":
+		lines, _ := job.Payload["lines"].(float64)
+		out, err := a.serverLogs(ctx, cfg, int(lines))
+		if err != nil {
+			return agent.JobResult{Status: "failed", Error: err.Error()}, nil
+		}
+		return agent.JobResult{Status: "success", Output: out}, nil
 	case "RCON", "SEND_COMMAND":
 		cmd := getString(job.Payload, "command", "")
 		out, err := a.SendCommand(ctx, cfg, cmd)
@@ -117,6 +134,11 @@ func payloadToConfig(p map[string]interface{}) *agent.InstanceConfig {
 	if v, ok := p["telnet_password"].(string); ok {
 		cfg.TelnetPassword = v
 	}
+	config, _ := p["config"].(map[string]interface{})
+	settings, _ := config["docker"].(map[string]interface{})
+	if v, ok := settings["container"].(string); ok {
+		cfg.Container = strings.TrimSpace(v)
+	}
 	return cfg
 }
 
@@ -143,20 +165,18 @@ func (a *Adapter) withRCON(ctx context.Context, cfg *agent.InstanceConfig, fn fu
 	return fn(client)
 }
 
-// startAndCheck runs cmd.Start(), then waits briefly; if the process exits within that window, returns an error.
-func (a *Adapter) startAndCheck(ctx context.Context, cmd *exec.Cmd) error {
-	if err := cmd.Start(); err != nil {
+// startAndCheck launches spec as a supervised native process, then waits
+// briefly; if the process exits within that window, returns an error.
+func (a *Adapter) startAndCheck(ctx context.Context, cfg *agent.InstanceConfig, spec procsup.Spec) error {
+	proc := processes.Process(cfg.ServerInstanceID)
+	if _, err := proc.Start(spec); err != nil {
 		return err
 	}
-	done := make(chan error, 1)
-	go func() { done <- cmd.Wait() }()
 	startupWindow := 2 * time.Second
 	select {
-	case err := <-done:
-		if err != nil {
-			return fmt.Errorf("process exited immediately: %w", err)
-		}
-		return fmt.Errorf("process exited immediately with code 0")
+	case <-proc.Done():
+		observation, _ := proc.Observe(ctx)
+		return fmt.Errorf("process exited immediately: %s (see %s)", observation.Detail, proc.LogPath())
 	case <-time.After(startupWindow):
 		return nil
 	case <-ctx.Done():
@@ -165,6 +185,9 @@ func (a *Adapter) startAndCheck(ctx context.Context, cmd *exec.Cmd) error {
 }
 
 func (a *Adapter) Start(ctx context.Context, cfg *agent.InstanceConfig) error {
+	if cfg.Container != "" {
+		return containers.Start(ctx, cfg.Container)
+	}
 	if cfg.InstallPath == "" {
 		return fmt.Errorf("install_path required")
 	}
@@ -173,18 +196,14 @@ func (a *Adapter) Start(ctx context.Context, cfg *agent.InstanceConfig) error {
 		if len(parts) == 0 {
 			return fmt.Errorf("empty start_command")
 		}
-		cmd := exec.CommandContext(ctx, parts[0], parts[1:]...)
-		cmd.Dir = cfg.InstallPath
-		return a.startAndCheck(ctx, cmd)
+		return a.startAndCheck(ctx, cfg, procsup.Spec{Dir: cfg.InstallPath, Argv: parts})
 	}
 	// Default: java -jar server.jar (or common jar name)
 	jar := filepath.Join(cfg.InstallPath, "server.jar")
 	if _, err := os.Stat(jar); err != nil {
 		return fmt.Errorf("no start_command and server.jar not found in %q", cfg.InstallPath)
 	}
-	cmd := exec.CommandContext(ctx, "java", "-jar", "server.jar")
-	cmd.Dir = cfg.InstallPath
-	return a.startAndCheck(ctx, cmd)
+	return a.startAndCheck(ctx, cfg, procsup.Spec{Dir: cfg.InstallPath, Argv: []string{"java", "-jar", "server.jar"}})
 }
 
 func (a *Adapter) Stop(ctx context.Context, cfg *agent.InstanceConfig) error {
@@ -193,15 +212,47 @@ func (a *Adapter) Stop(ctx context.Context, cfg *agent.InstanceConfig) error {
 		if len(parts) == 0 {
 			return fmt.Errorf("empty stop_command")
 		}
+		if cfg.Container != "" {
+			return a.execInContainer(ctx, cfg, parts)
+		}
 		cmd := exec.CommandContext(ctx, parts[0], parts[1:]...)
 		cmd.Dir = cfg.InstallPath
 		return cmd.Run()
 	}
 	// Graceful: RCON "stop"
-	return a.withRCON(ctx, cfg, func(c *Client) error {
-		_, err := c.Exec("stop")
+	rconStop := func(ctx context.Context) error {
+		return a.withRCON(ctx, cfg, func(c *Client) error {
+			_, err := c.Exec("stop")
+			return err
+		})
+	}
+	if cfg.Container != "" {
+		// Try RCON first so the world is saved even if the image's stop
+		// signal is not SIGTERM; docker stop then waits for the exit.
+		if cfg.TelnetPassword != "" {
+			_ = rconStop(ctx)
+		}
+		return containers.Stop(ctx, cfg.Container, a.stopTimeout)
+	}
+	proc := processes.Process(cfg.ServerInstanceID)
+	if _, running := proc.Running(); running {
+		// The server saves the world on SIGTERM too, so a failed RCON stop
+		// falls back to the signal before the SIGKILL deadline.
+		return proc.Stop(ctx, procsup.StopOptions{Graceful: rconStop, Timeout: a.stopTimeout})
+	}
+	return rconStop(ctx)
+}
+
+// execInContainer runs a stop command inside the server's container.
+func (a *Adapter) execInContainer(ctx context.Context, cfg *agent.InstanceConfig, argv []string) error {
+	result, err := containers.Exec(ctx, cfg.Container, argv)
+	if err != nil {
 		return err
-	})
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("%s exited with code %d in container %s: %s", argv[0], result.ExitCode, cfg.Container, strings.TrimSpace(result.Output))
+	}
+	return containers.Stop(ctx, cfg.Container, a.stopTimeout)
 }
 
 func (a *Adapter) Restart(ctx context.Context, cfg *agent.InstanceConfig) error {
@@ -217,6 +268,19 @@ func (a *Adapter) Restart(ctx context.Context, cfg *agent.InstanceConfig) error
 }
 
 func (a *Adapter) Status(ctx context.Context, cfg *agent.InstanceConfig) (string, error) {
+	if cfg.Container != "" {
+		state, err := containers.Inspect(ctx, cfg.Container)
+		if err != nil {
+			return "unknown", err
+		}
+		if state.Running {
+			return "running", nil
+		}
+		return "stopped", nil
+	}
+	if _, running := processes.Process(cfg.ServerInstanceID).Running(); running {
+		return "running", nil
+	}
 	err := a.withRCON(ctx, cfg, func(c *Client) error {
 		_, err := c.Exec("list")
 		return err
@@ -227,6 +291,38 @@ func (a *Adapter) Status(ctx context.Context, cfg *agent.InstanceConfig) (string
 	return "running", nil
 }
 
+// Kill terminates the server container or the agent-started process group
+// without a graceful shutdown.
+func (a *Adapter) Kill(ctx context.Context, cfg *agent.InstanceConfig) error {
+	if cfg.Container != "" {
+		return containers.Kill(ctx, cfg.Container, "SIGKILL")
+	}
+	return processes.Process(cfg.ServerInstanceID).Kill(ctx)
+}
+
+// serverLogs returns the last lines of the container log, or of the
+// console log captured for an agent-started server.
+func (a *Adapter) serverLogs(ctx context.Context, cfg *agent.InstanceConfig, lines int) (string, error) {
+	if lines <= 0 {
+		lines = 200
+	}
+	if lines > 5000 {
+		lines = 5000
+	}
+	if cfg.Container != "" {
+		return containers.Logs(ctx, cfg.Container, lines)
+	}
+	content, err := os.ReadFile(processes.Process(cfg.ServerInstanceID).LogPath())
+	if err != nil {
+		return "", fmt.Errorf("read server console log: %w", err)
+	}
+	all := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	return strings.Join(all, "\n"), nil
+}
+
 func (a *Adapter) SendCommand(ctx context.Context, cfg *agent.InstanceConfig, command string) (string, error) {
 	var out string
 	err := a.withRCON(ctx, cfg, func(c *Client) error {
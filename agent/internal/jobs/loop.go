@@ -157,7 +157,7 @@ func (l *executionLimiter) mutationIsBusy() bool { return l.mutationBusy.Load()
 // game state and therefore must pass through the serialized mutation gate.
 func isReadOnly(jobType string) bool {
 	switch jobType {
-	case "MOD_LIST", "MOD_QUARANTINE_LIST", "MOD_CONFIG_READ", "PROFILE_LIST", "PROFILE_READ", "PLAYER_LIST_SYNC", "PLAYER_ADMIN_LIST", "SAVE_LIST":
+	case "MOD_LIST", "MOD_QUARANTINE_LIST", "MOD_CONFIG_READ", "PROFILE_LIST", "PROFILE_READ", "PLAYER_LIST_SYNC", "PLAYER_ADMIN_LIST", "SERVER_ADMIN_LIST", "SERVER_CONFIG_READ", "MOD_CONFLICT_SCAN", "MOD_VALIDATE", "MOD_SET_LIST", "MOD_CONFIG_HISTORY", "MOD_CONFIG_DIFF", "SERVER_LOGS", "SAVE_VERIFY", "SAVE_DIFF", "REGION_LIST", "SAVE_LIST":
 		return true
 	default:
 		return false
@@ -177,6 +177,12 @@ func runOne(ctx context.Context, c client.Client, hostID string, j client.Job, e
 	maxDuration := 15 * time.Minute
 	if j.Type == "SERVER_SAFE_RESTART" || j.Type == "SERVER_RESTART" {
 		maxDuration = 24 * time.Hour
+	} else if j.Type == "SERVER_START" {
+		// A start can run a health check, roll back and check again.
+		maxDuration = 30 * time.Minute
+	} else if j.Type == "GAME_INSTALL" || j.Type == "GAME_UPDATE" {
+		// A full validate of the dedicated server can take well over the default.
+		maxDuration = 2 * time.Hour
 	} else if isReadOnly(j.Type) {
 		maxDuration = 3 * time.Minute
 	}
@@ -188,7 +194,7 @@ func runOne(ctx context.Context, c client.Client, hostID string, j client.Job, e
 		}
 	})
 	var downloadedArchive string
-	if j.Type == "MOD_UPLOAD_QUARANTINE" {
+	if j.Type == "MOD_UPLOAD_QUARANTINE" || j.Type == "MOD_INSTALL" {
 		_ = c.SubmitJobProgress(ctx, hostID, j.ID, "downloading", "Downloading uploaded mod archive")
 		temporary, err := os.CreateTemp("", "mastermind-mod-upload-*.zip")
 		if err != nil {
@@ -99,12 +99,12 @@ func TestCancelledWaitDoesNotDeadlock(t *testing.T) {
 }
 
 func TestReadOnlyClassification(t *testing.T) {
-	for _, jobType := range []string{"MOD_LIST", "MOD_QUARANTINE_LIST", "MOD_CONFIG_READ", "PROFILE_LIST", "PROFILE_READ", "PLAYER_LIST_SYNC", "PLAYER_ADMIN_LIST", "SAVE_LIST"} {
+	for _, jobType := range []string{"MOD_LIST", "MOD_QUARANTINE_LIST", "MOD_CONFIG_READ", "PROFILE_LIST", "PROFILE_READ", "PLAYER_LIST_SYNC", "PLAYER_ADMIN_LIST", "SERVER_ADMIN_LIST", "SERVER_CONFIG_READ", "MOD_CONFLICT_SCAN", "MOD_VALIDATE", "MOD_SET_LIST", "MOD_CONFIG_HISTORY", "MOD_CONFIG_DIFF", "SERVER_LOGS", "SAVE_VERIFY", "SAVE_DIFF", "REGION_LIST", "SAVE_LIST"} {
 		if !isReadOnly(jobType) {
 			t.Errorf("%s should be read-only", jobType)
 		}
 	}
-	for _, jobType := range []string{"RCON", "SEND_COMMAND", "SERVER_RESTART", "PLAYER_KICK", "MOD_DELETE"} {
+	for _, jobType := range []string{"RCON", "SEND_COMMAND", "SERVER_RESTART", "PLAYER_KICK", "MOD_DELETE", "SERVER_ADMIN_ADD"} {
 		if isReadOnly(jobType) {
 			t.Errorf("%s must be serialized", jobType)
 		}
@@ -0,0 +1,79 @@
+package privhelper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// callTimeout bounds one helper call; stopping a game server that saves its
+// world on shutdown is the slowest operation.
+const callTimeout = 5 * time.Minute
+
+// Default is the client used by the adapters and the supervisor. main points
+// Socket at the configured helper socket.
+var Default = &Client{Socket: DefaultSocket}
+
+// Client calls the helper over its unix socket.
+type Client struct {
+	Socket string
+}
+
+// Unit starts, stops or kills an allowlisted systemd unit.
+func (c *Client) Unit(ctx context.Context, action, unit string) error {
+	_, err := c.call(ctx, Request{Op: OpUnit, Action: action, Unit: unit})
+	if err != nil {
+		return fmt.Errorf("systemctl %s %s: %w", action, unit, err)
+	}
+	return nil
+}
+
+// Chown assigns path, recursively, to the owner and group configured for the
+// allowlisted root that contains it.
+func (c *Client) Chown(ctx context.Context, path string) error {
+	_, err := c.call(ctx, Request{Op: OpChown, Path: path})
+	return err
+}
+
+// ChownAs is Chown that fails unless the root assigns owner and group.
+func (c *Client) ChownAs(ctx context.Context, path, owner, group string) error {
+	_, err := c.call(ctx, Request{Op: OpChown, Path: path, Owner: owner, Group: group})
+	return err
+}
+
+// Delete removes path, recursively, from inside an allowlisted root.
+func (c *Client) Delete(ctx context.Context, path string) error {
+	_, err := c.call(ctx, Request{Op: OpDelete, Path: path})
+	return err
+}
+
+func (c *Client) call(ctx context.Context, request Request) (Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", c.Socket)
+	if err != nil {
+		return Response{}, fmt.Errorf("privileged helper unavailable: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return Response{}, fmt.Errorf("send helper request: %w", err)
+	}
+	var response Response
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return Response{}, fmt.Errorf("read helper response: %w", err)
+	}
+	if !response.OK {
+		if response.Error == "" {
+			response.Error = "request refused"
+		}
+		return response, errors.New(response.Error)
+	}
+	return response, nil
+}
@@ -0,0 +1,100 @@
+package privhelper
+
+import (
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// openat2 and its RESOLVE_* flags are not in the syscall package. The
+// syscall number is shared by every architecture since Linux 5.6.
+const (
+	sysOpenat2        = 437
+	resolveNoSymlinks = 0x04
+	resolveBeneath    = 0x08
+)
+
+// oPath is O_PATH on the architectures the agent ships for (amd64, arm64,
+// 386, arm); the syscall package does not define it.
+const oPath = 0x200000
+
+type openHow struct {
+	flags   uint64
+	mode    uint64
+	resolve uint64
+}
+
+// openBeneath opens the slash-separated rel below dirfd without following
+// any symlink and without leaving dirfd's tree. Kernels without openat2
+// get the same guarantee by opening one component at a time with
+// O_NOFOLLOW.
+func openBeneath(dirfd int, rel string, flags int) (int, error) {
+	name, err := syscall.BytePtrFromString(rel)
+	if err != nil {
+		return -1, err
+	}
+	how := openHow{flags: uint64(flags | syscall.O_CLOEXEC), resolve: resolveBeneath | resolveNoSymlinks}
+	fd, _, errno := syscall.Syscall6(sysOpenat2, uintptr(dirfd), uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(&how)), unsafe.Sizeof(how), 0, 0)
+	if errno == 0 {
+		return int(fd), nil
+	}
+	if errno != syscall.ENOSYS {
+		return -1, errno
+	}
+	return openComponents(dirfd, rel, flags)
+}
+
+// openComponents is openBeneath for kernels before 5.6.
+func openComponents(dirfd int, rel string, flags int) (int, error) {
+	parts := strings.Split(rel, "/")
+	current := dirfd
+	for i, part := range parts {
+		if part == ".." {
+			if current != dirfd {
+				syscall.Close(current)
+			}
+			return -1, syscall.EXDEV
+		}
+		componentFlags := syscall.O_RDONLY | syscall.O_DIRECTORY
+		if i == len(parts)-1 {
+			componentFlags = flags
+		}
+		next, err := syscall.Openat(current, part, componentFlags|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+		if current != dirfd {
+			syscall.Close(current)
+		}
+		if err != nil {
+			return -1, err
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// lstatAt stats name in dirfd without following a symlink.
+func lstatAt(dirfd int, name string) (syscall.Stat_t, error) {
+	var st syscall.Stat_t
+	fd, err := syscall.Openat(dirfd, name, oPath|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return st, err
+	}
+	defer syscall.Close(fd)
+	return st, syscall.Fstat(fd, &st)
+}
+
+// atRemoveDir is AT_REMOVEDIR, also missing from the syscall package.
+const atRemoveDir = 0x200
+
+// unlinkat removes name from dirfd; flags may be atRemoveDir. The syscall
+// package's Unlinkat takes no flags.
+func unlinkat(dirfd int, name string, flags int) error {
+	path, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_UNLINKAT, uintptr(dirfd), uintptr(unsafe.Pointer(path)), uintptr(flags))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+// Package privhelper is the privilege-separated root helper. The helper runs
+// as root, listens on a unix socket and performs a small, audited set of
+// operations for the agent: starting, stopping or killing allowlisted systemd
+// units, and fixing ownership of or deleting paths inside allowlisted roots.
+// The agent itself runs unprivileged and reaches the helper through Client.
+package privhelper
+
+// DefaultSocket is where the helper listens unless configured otherwise.
+const DefaultSocket = "/run/mastermind-helper/helper.sock"
+
+// Operations accepted by the helper.
+const (
+	OpUnit   = "unit"
+	OpChown  = "chown"
+	OpDelete = "delete"
+)
+
+// Unit actions accepted by OpUnit.
+const (
+	ActionStart = "start"
+	ActionStop  = "stop"
+	// ActionKill SIGKILLs the unit's main process, then stops the unit and
+	// clears its failed state so Restart= does not respawn it.
+	ActionKill = "kill"
+)
+
+// Request is one helper call; each connection carries exactly one.
+type Request struct {
+	Op     string `json:"op"`
+	Action string `json:"action,omitempty"`
+	Unit   string `json:"unit,omitempty"`
+	Path   string `json:"path,omitempty"`
+	// Owner and Group, when set, must match what the root assigns; chown
+	// never takes its account from the agent.
+	Owner string `json:"owner,omitempty"`
+	Group string `json:"group,omitempty"`
+}
+
+// Response is the helper's answer to a Request.
+type Response struct {
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+	Output string `json:"output,omitempty"`
+}
@@ -0,0 +1,307 @@
+package privhelper
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// startHelper serves cfg on a temporary socket for the current user.
+func startHelper(t *testing.T, cfg *Config) *Client {
+	t.Helper()
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := user.LookupGroupId(current.Gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Socket = filepath.Join(t.TempDir(), "helper.sock")
+	cfg.AllowedUsers = []string{current.Username}
+	for i := range cfg.Roots {
+		if cfg.Roots[i].Owner == "" {
+			cfg.Roots[i].Owner, cfg.Roots[i].Group = current.Username, group.Name
+		}
+	}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := server.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go server.Serve(ctx, listener)
+	return &Client{Socket: cfg.Socket}
+}
+
+func fakeSystemctl(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	log := filepath.Join(dir, "calls")
+	script := filepath.Join(dir, "systemctl")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$*\" >> "+log+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	previous := systemctlPath
+	t.Cleanup(func() { systemctlPath = previous })
+	systemctlPath = script
+	return log
+}
+
+func TestUnitActionsAreAllowlisted(t *testing.T) {
+	calls := fakeSystemctl(t)
+	client := startHelper(t, &Config{Units: []string{"7dtd.service"}})
+	ctx := context.Background()
+	for _, action := range []string{ActionStart, ActionStop, ActionKill} {
+		if err := client.Unit(ctx, action, "7dtd.service"); err != nil {
+			t.Fatalf("%s: %v", action, err)
+		}
+	}
+	if err := client.Unit(ctx, ActionStart, "sshd.service"); err == nil || !strings.Contains(err.Error(), "not allowlisted") {
+		t.Fatalf("unlisted unit: %v", err)
+	}
+	if err := client.Unit(ctx, "restart", "7dtd.service"); err == nil {
+		t.Fatal("unsupported action accepted")
+	}
+	content, _ := os.ReadFile(calls)
+	want := strings.Join([]string{
+		"reset-failed 7dtd.service", "start 7dtd.service", "stop 7dtd.service",
+		"kill --kill-who=main --signal=SIGKILL 7dtd.service", "stop 7dtd.service", "reset-failed 7dtd.service",
+	}, "\n") + "\n"
+	if string(content) != want {
+		t.Fatalf("systemctl calls:\n%s", content)
+	}
+}
+
+func TestDeleteStaysInsideRoots(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "Saves")
+	outside := t.TempDir()
+	for _, dir := range []string{filepath.Join(root, "World", "Game"), filepath.Join(outside, "keep")} {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	client := startHelper(t, &Config{Roots: []Root{{Path: root}}})
+	ctx := context.Background()
+
+	for _, path := range []string{root, filepath.Join(root, "escape"), filepath.Join(root, "escape", "keep"), filepath.Join(root, "..", filepath.Base(outside)), "World"} {
+		if err := client.Delete(ctx, path); err == nil {
+			t.Fatalf("delete %s succeeded", path)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outside, "keep")); err != nil {
+		t.Fatalf("delete escaped the root: %v", err)
+	}
+	if err := client.Delete(ctx, filepath.Join(root, "World", "Game")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "World", "Game")); !os.IsNotExist(err) {
+		t.Fatalf("save still present: %v", err)
+	}
+}
+
+func TestChownMakesTreeGroupWritable(t *testing.T) {
+	root := t.TempDir()
+	save := filepath.Join(root, "World", "Game")
+	if err := os.MkdirAll(filepath.Join(save, "Region"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(save, "Region", "r.0.0.7rg")
+	if err := os.WriteFile(file, []byte("region"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	client := startHelper(t, &Config{Roots: []Root{{Path: root}}})
+	if err := client.Chown(context.Background(), save); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]os.FileMode{save: 0770, filepath.Join(save, "Region"): 0770, file: 0660} {
+		if info, _ := os.Stat(path); info.Mode().Perm() != want {
+			t.Fatalf("%s mode = %v, want %v", path, info.Mode().Perm(), want)
+		}
+	}
+
+	if err := client.ChownAs(context.Background(), save, "someone-else", "nobody"); err == nil || !strings.Contains(err.Error(), "is assigned to") {
+		t.Fatalf("chown to another account: %v", err)
+	}
+
+	if err := os.Symlink("/etc", filepath.Join(save, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Chown(context.Background(), save); err == nil || !strings.Contains(err.Error(), "symbolic link") {
+		t.Fatalf("chown through symlink: %v", err)
+	}
+}
+
+func TestChownRefusesHardLinkedFiles(t *testing.T) {
+	root := t.TempDir()
+	save := filepath.Join(root, "Game")
+	if err := os.MkdirAll(save, 0700); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "shadow")
+	if err := os.WriteFile(outside, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(outside, filepath.Join(save, "main.ttw")); err != nil {
+		t.Fatal(err)
+	}
+	client := startHelper(t, &Config{Roots: []Root{{Path: root}}})
+	if err := client.Chown(context.Background(), save); err == nil || !strings.Contains(err.Error(), "hard-linked") {
+		t.Fatalf("chown of hard-linked file: %v", err)
+	}
+	if info, _ := os.Stat(outside); info.Mode().Perm() != 0600 {
+		t.Fatalf("linked file mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestRefusesCallersOutsideAllowlist(t *testing.T) {
+	client := startHelper(t, &Config{Units: []string{"7dtd.service"}})
+	// Rebuild the server with an allowlist that excludes the test user.
+	server, err := NewServer(&Config{Socket: client.Socket + "2", AllowedUsers: []string{"root"}, Units: []string{"7dtd.service"}})
+	if err != nil {
+		t.Skip("no root account to build the allowlist from")
+	}
+	server.allowedUIDs = map[uint32]string{uint32(os.Getuid()) + 1: "someone-else"}
+	listener, err := server.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx, listener)
+	refused := &Client{Socket: client.Socket + "2"}
+	if err := refused.Unit(ctx, ActionStop, "7dtd.service"); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Fatalf("unlisted caller: %v", err)
+	}
+}
+
+func TestChownRefusesSymlinkedParents(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret")
+	if err := os.WriteFile(secret, []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "World")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "tool"), []byte("#!/bin/sh\n"), 0o4755); err != nil {
+		t.Fatal(err)
+	}
+	client := startHelper(t, &Config{Roots: []Root{{Path: root}}})
+	ctx := context.Background()
+	for _, path := range []string{filepath.Join(root, "World"), secret, filepath.Join(root, "World", "secret")} {
+		if err := client.Chown(ctx, path); err == nil {
+			t.Fatalf("chown %s succeeded", path)
+		}
+	}
+	if info, _ := os.Stat(secret); info.Mode().Perm() != 0600 {
+		t.Fatalf("chown escaped the root: %v", info.Mode())
+	}
+	if err := client.Chown(ctx, filepath.Join(root, "tool")); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(filepath.Join(root, "tool")); info.Mode()&os.ModeSetuid != 0 || info.Mode().Perm() != 0775 {
+		t.Fatalf("tool mode = %v, want 0775 without setuid", info.Mode())
+	}
+}
+
+func TestServerConfigRootAllowsOnlyTheLiveSave(t *testing.T) {
+	userData := t.TempDir()
+	saves := filepath.Join(userData, "Saves")
+	live := filepath.Join(saves, "Navezgane", "MyGame")
+	other := filepath.Join(saves, "Navezgane", "Other")
+	for _, dir := range []string{live, live + restoreRollbackSuffix, other} {
+		if err := os.MkdirAll(filepath.Join(dir, "Region"), 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := filepath.Join(t.TempDir(), "serverconfig.xml")
+	xml := `<ServerSettings>
+	<property name="GameWorld" value="Navezgane"/>
+	<property name="GameName" value="MyGame"/>
+	<property name="UserDataFolder" value="` + userData + `"/>
+</ServerSettings>`
+	if err := os.WriteFile(config, []byte(xml), 0640); err != nil {
+		t.Fatal(err)
+	}
+	client := startHelper(t, &Config{Roots: []Root{{Path: saves, ServerConfig: config}}})
+	ctx := context.Background()
+	for _, path := range []string{other, filepath.Join(live, "Region"), filepath.Join(saves, "Navezgane")} {
+		if err := client.Delete(ctx, path); err == nil || !strings.Contains(err.Error(), "live save") {
+			t.Fatalf("delete %s: %v", path, err)
+		}
+	}
+	if err := client.Chown(ctx, saves); err == nil {
+		t.Fatal("chown of the whole Saves tree accepted")
+	}
+	if err := client.Chown(ctx, live+restoreRollbackSuffix); err == nil {
+		t.Fatal("chown of the restore rollback accepted")
+	}
+	if err := client.Chown(ctx, live); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{live + restoreRollbackSuffix, live} {
+		if err := client.Delete(ctx, path); err != nil {
+			t.Fatalf("delete %s: %v", path, err)
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Fatalf("other save removed: %v", err)
+	}
+}
+
+func TestChildrenOnlyRoot(t *testing.T) {
+	mods := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(mods, "MyMod", "Config"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	client := startHelper(t, &Config{Roots: []Root{{Path: mods, ChildrenOnly: true}}})
+	ctx := context.Background()
+	if err := client.Delete(ctx, filepath.Join(mods, "MyMod", "Config")); err == nil {
+		t.Fatal("delete below a mod folder accepted")
+	}
+	if err := client.Chown(ctx, mods); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Delete(ctx, filepath.Join(mods, "MyMod")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenComponentsRefusesSymlinks(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "a", "b"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "a"), filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	rootFD, err := syscall.Open(root, syscall.O_RDONLY|syscall.O_DIRECTORY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(rootFD)
+	fd, err := openComponents(rootFD, "a/b", syscall.O_RDONLY|syscall.O_DIRECTORY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syscall.Close(fd)
+	for _, rel := range []string{"link/b", "link", "a/../a"} {
+		if fd, err := openComponents(rootFD, rel, syscall.O_RDONLY); err == nil {
+			syscall.Close(fd)
+			t.Fatalf("opened %s", rel)
+		}
+	}
+}
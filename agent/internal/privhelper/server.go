@@ -0,0 +1,549 @@
+package privhelper
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	pathpkg "path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// requestTimeout bounds reading a request from a connected client.
+const requestTimeout = 10 * time.Second
+
+// systemctlPath is a variable so tests can substitute a fake systemctl.
+var systemctlPath = "/usr/bin/systemctl"
+
+// Config is the helper's configuration, owned by root and never writable by
+// the agent.
+type Config struct {
+	// Socket is the unix socket to listen on.
+	Socket string `yaml:"socket"`
+	// SocketGroup may connect to the socket; the socket is mode 0660.
+	SocketGroup string `yaml:"socket_group"`
+	// AllowedUsers are the only accounts whose requests are served,
+	// checked with SO_PEERCRED.
+	AllowedUsers []string `yaml:"allowed_users"`
+	// Units may be started, stopped and killed.
+	Units []string `yaml:"units"`
+	// Roots bound chown and delete requests.
+	Roots []Root `yaml:"roots"`
+}
+
+// Root is a directory tree the helper may modify. Owner and Group are what
+// chown assigns; a root without them accepts only deletes.
+type Root struct {
+	Path  string `yaml:"path"`
+	Owner string `yaml:"owner"`
+	Group string `yaml:"group"`
+	// ServerConfig limits a Saves root to the live save this 7DTD
+	// serverconfig.xml names (GameWorld/GameName) and, for deletes, its
+	// restore rollback directory.
+	ServerConfig string `yaml:"server_config"`
+	// ChildrenOnly limits requests to the root itself (chown) and its
+	// direct entries, such as one mod folder.
+	ChildrenOnly bool `yaml:"children_only"`
+}
+
+// restoreRollbackSuffix names the directory a save restore moves the live
+// save to until the restore succeeds.
+const restoreRollbackSuffix = ".mastermind-restore-old"
+
+// LoadConfig reads a YAML helper config.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := new(Config)
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Socket == "" {
+		cfg.Socket = DefaultSocket
+	}
+	return cfg, nil
+}
+
+// Server serves helper requests.
+type Server struct {
+	cfg         *Config
+	allowedUIDs map[uint32]string
+	units       map[string]bool
+}
+
+// NewServer resolves the allowlisted users and validates the roots.
+func NewServer(cfg *Config) (*Server, error) {
+	s := &Server{cfg: cfg, allowedUIDs: map[uint32]string{}, units: map[string]bool{}}
+	for _, name := range cfg.AllowedUsers {
+		account, err := user.Lookup(name)
+		if err != nil {
+			return nil, fmt.Errorf("allowed user %q: %w", name, err)
+		}
+		uid, err := strconv.ParseUint(account.Uid, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("allowed user %q: %w", name, err)
+		}
+		s.allowedUIDs[uint32(uid)] = name
+	}
+	if len(s.allowedUIDs) == 0 {
+		return nil, fmt.Errorf("allowed_users must name at least one account")
+	}
+	for _, unit := range cfg.Units {
+		s.units[unit] = true
+	}
+	for _, root := range cfg.Roots {
+		if !filepath.IsAbs(root.Path) || filepath.Clean(root.Path) == "/" {
+			return nil, fmt.Errorf("root %q must be an absolute path below /", root.Path)
+		}
+		if root.ServerConfig != "" && !filepath.IsAbs(root.ServerConfig) {
+			return nil, fmt.Errorf("root %q: server_config must be an absolute path", root.Path)
+		}
+	}
+	return s, nil
+}
+
+// Listen creates the helper socket, replacing a stale one, and restricts it
+// to root and SocketGroup.
+func (s *Server) Listen() (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(s.cfg.Socket), 0755); err != nil {
+		return nil, err
+	}
+	_ = os.Remove(s.cfg.Socket)
+	listener, err := net.Listen("unix", s.cfg.Socket)
+	if err != nil {
+		return nil, err
+	}
+	gid := -1
+	if s.cfg.SocketGroup != "" {
+		group, err := user.LookupGroup(s.cfg.SocketGroup)
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("socket group %q: %w", s.cfg.SocketGroup, err)
+		}
+		gid, _ = strconv.Atoi(group.Gid)
+	}
+	if err := os.Chown(s.cfg.Socket, -1, gid); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if err := os.Chmod(s.cfg.Socket, 0660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Serve handles connections until ctx is cancelled.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.handle(ctx, conn)
+	}
+}
+
+func (s *Server) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return
+	}
+	cred, err := peerCredentials(unixConn)
+	if err != nil {
+		slog.Warn("helper peer credentials unavailable", "err", err)
+		return
+	}
+	name, allowed := s.allowedUIDs[cred.Uid]
+	if !allowed {
+		slog.Warn("helper refused caller", "uid", cred.Uid, "pid", cred.Pid)
+		_ = json.NewEncoder(conn).Encode(Response{Error: "caller is not allowed"})
+		return
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	var request Request
+	if err := json.NewDecoder(conn).Decode(&request); err != nil {
+		_ = json.NewEncoder(conn).Encode(Response{Error: "malformed request"})
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	response := s.Handle(ctx, request)
+	// Every request is audited with the caller it came from.
+	slog.Info("helper request", "user", name, "uid", cred.Uid, "pid", cred.Pid,
+		"op", request.Op, "action", request.Action, "unit", request.Unit, "path", request.Path, "owner", request.Owner,
+		"ok", response.OK, "err", response.Error)
+	_ = json.NewEncoder(conn).Encode(response)
+}
+
+func peerCredentials(conn *net.UnixConn) (*syscall.Ucred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	return cred, credErr
+}
+
+// Handle performs one already-authenticated request.
+func (s *Server) Handle(ctx context.Context, request Request) Response {
+	var output string
+	var err error
+	switch request.Op {
+	case OpUnit:
+		output, err = s.unit(ctx, request.Action, request.Unit)
+	case OpChown:
+		err = s.chown(request.Path, request.Owner, request.Group)
+	case OpDelete:
+		err = s.delete(request.Path)
+	default:
+		err = fmt.Errorf("unsupported operation %q", request.Op)
+	}
+	if err != nil {
+		return Response{Error: err.Error(), Output: output}
+	}
+	return Response{OK: true, Output: output}
+}
+
+func (s *Server) unit(ctx context.Context, action, unit string) (string, error) {
+	if !s.units[unit] {
+		return "", fmt.Errorf("unit %q is not allowlisted", unit)
+	}
+	var steps [][]string
+	switch action {
+	case ActionStart:
+		// reset-failed is a no-op on units that are not failed and lets a
+		// crashed unit start again.
+		steps = [][]string{{"reset-failed", unit}, {"start", unit}}
+	case ActionStop:
+		steps = [][]string{{"stop", unit}}
+	case ActionKill:
+		steps = [][]string{{"kill", "--kill-who=main", "--signal=SIGKILL", unit}, {"stop", unit}, {"reset-failed", unit}}
+	default:
+		return "", fmt.Errorf("unsupported unit action %q", action)
+	}
+	var output strings.Builder
+	for i, args := range steps {
+		out, err := exec.CommandContext(ctx, systemctlPath, args...).CombinedOutput()
+		output.Write(out)
+		// reset-failed before start may fail harmlessly on older systemd.
+		if err != nil && !(action == ActionStart && i == 0) {
+			return strings.TrimSpace(output.String()), fmt.Errorf("systemctl %s: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+		}
+	}
+	return strings.TrimSpace(output.String()), nil
+}
+
+// target is a request path inside an allowlisted root.
+type target struct {
+	root     Root
+	realRoot string
+	// rel is the slash-separated path below realRoot, "." for the root.
+	rel string
+}
+
+// resolve maps path to the root that contains it and applies the root's
+// target restrictions for op. It only compares paths; the filesystem is
+// reached through target.open, which never follows a symlink below the
+// root.
+func (s *Server) resolve(path, op string) (target, error) {
+	if !filepath.IsAbs(path) {
+		return target{}, fmt.Errorf("path must be absolute")
+	}
+	path = filepath.Clean(path)
+	for _, root := range s.cfg.Roots {
+		realRoot, err := filepath.EvalSymlinks(root.Path)
+		if err != nil {
+			continue
+		}
+		for _, base := range []string{filepath.Clean(root.Path), realRoot} {
+			t := target{root: root, realRoot: realRoot}
+			switch {
+			case path == base:
+				t.rel = "."
+			case strings.HasPrefix(path, base+string(filepath.Separator)):
+				t.rel = filepath.ToSlash(path[len(base)+1:])
+			default:
+				continue
+			}
+			return t, t.permits(op)
+		}
+	}
+	return target{}, fmt.Errorf("path %q is outside the allowlisted roots", path)
+}
+
+// permits applies the root's server_config or children_only restriction.
+func (t target) permits(op string) error {
+	if t.rel == "." && op == OpDelete {
+		return fmt.Errorf("an allowlisted root cannot be deleted")
+	}
+	switch {
+	case t.root.ServerConfig != "":
+		live, err := liveSave(t.root.ServerConfig, t.realRoot)
+		if err != nil {
+			return err
+		}
+		if t.rel == live || (op == OpDelete && t.rel == live+restoreRollbackSuffix) {
+			return nil
+		}
+		return fmt.Errorf("path is not the live save %s configures", t.root.ServerConfig)
+	case t.root.ChildrenOnly:
+		if t.rel == "." || !strings.Contains(t.rel, "/") {
+			return nil
+		}
+		return fmt.Errorf("path must be %s or one of its entries", t.root.Path)
+	}
+	return nil
+}
+
+// liveSave returns GameWorld/GameName from a 7DTD serverconfig.xml, checking
+// that its Saves directory is savesRoot.
+func liveSave(configPath, savesRoot string) (string, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return "", fmt.Errorf("read server config: %w", err)
+	}
+	var config struct {
+		Properties []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:"value,attr"`
+		} `xml:"property"`
+	}
+	if err := xml.Unmarshal(data, &config); err != nil {
+		return "", fmt.Errorf("parse server config: %w", err)
+	}
+	properties := map[string]string{}
+	for _, property := range config.Properties {
+		properties[property.Name] = strings.TrimSpace(property.Value)
+	}
+	world, game := properties["GameWorld"], properties["GameName"]
+	for _, name := range []string{world, game} {
+		if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+			return "", fmt.Errorf("server config has an unsafe GameWorld or GameName")
+		}
+	}
+	userData := properties["UserDataFolder"]
+	if userData == "" {
+		userData = filepath.Join(filepath.Dir(configPath), "userdata")
+	}
+	saves, err := filepath.EvalSymlinks(filepath.Join(userData, "Saves"))
+	if err != nil || saves != savesRoot {
+		return "", fmt.Errorf("server config does not keep its saves in this root")
+	}
+	return world + "/" + game, nil
+}
+
+// open opens rel below the root with flags. Neither the root itself nor any
+// component below it may be a symlink, so a directory swapped for a symlink
+// after resolve cannot redirect the request.
+func (t target) open(rel string, flags int) (int, error) {
+	rootFD, err := syscall.Open(t.realRoot, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, fmt.Errorf("open root %s: %w", t.realRoot, err)
+	}
+	if rel == "." {
+		return rootFD, nil
+	}
+	defer syscall.Close(rootFD)
+	return openBeneath(rootFD, rel, flags)
+}
+
+func (s *Server) delete(path string) error {
+	t, err := s.resolve(path, OpDelete)
+	if err != nil {
+		return err
+	}
+	parent, err := t.open(pathpkg.Dir(t.rel), syscall.O_RDONLY|syscall.O_DIRECTORY)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(parent)
+	name := pathpkg.Base(t.rel)
+	st, err := lstatAt(parent, name)
+	if err != nil {
+		return err
+	}
+	if st.Mode&syscall.S_IFMT == syscall.S_IFLNK {
+		return fmt.Errorf("path must not be a symlink")
+	}
+	if err := removeAt(parent, name); err != nil {
+		return err
+	}
+	if _, err := lstatAt(parent, name); err != syscall.ENOENT {
+		return fmt.Errorf("path still exists after delete")
+	}
+	return nil
+}
+
+// removeAt deletes name in the directory dirfd and, for a directory,
+// everything below it. Entries are unlinked by name and directories are
+// opened with O_NOFOLLOW, so nothing is resolved through a symlink.
+func removeAt(dirfd int, name string) error {
+	st, err := lstatAt(dirfd, name)
+	if err == syscall.ENOENT {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		return unlinkat(dirfd, name, 0)
+	}
+	fd, err := syscall.Openat(dirfd, name, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", name, err)
+	}
+	dir := os.NewFile(uintptr(fd), name)
+	names, err := dir.Readdirnames(-1)
+	for _, child := range names {
+		if err != nil {
+			break
+		}
+		err = removeAt(fd, child)
+	}
+	dir.Close()
+	if err != nil {
+		return err
+	}
+	return unlinkat(dirfd, name, atRemoveDir)
+}
+
+// chown assigns the tree to the root's owner and group and keeps it group
+// read-write, the same access the game service and agent share today. A
+// request naming another owner or group is refused. Symlinks and
+// hard-linked files inside the tree are refused rather than followed.
+func (s *Server) chown(path, owner, group string) error {
+	t, err := s.resolve(path, OpChown)
+	if err != nil {
+		return err
+	}
+	if t.root.Owner == "" || t.root.Group == "" {
+		return fmt.Errorf("root %q has no owner and group to assign", t.root.Path)
+	}
+	if owner != "" && (owner != t.root.Owner || group != t.root.Group) {
+		return fmt.Errorf("root %q is assigned to %s:%s, not %s:%s", t.root.Path, t.root.Owner, t.root.Group, owner, group)
+	}
+	uid, gid, err := lookupOwner(t.root.Owner, t.root.Group)
+	if err != nil {
+		return err
+	}
+	fd, err := t.open(t.rel, chownOpenFlags)
+	if err == syscall.ELOOP {
+		return fmt.Errorf("path must not be a symlink")
+	}
+	if err != nil {
+		return err
+	}
+	return chownTree(fd, path, uid, gid)
+}
+
+// chownOpenFlags open any entry a save may hold without side effects and
+// without following a symlink.
+const chownOpenFlags = syscall.O_RDONLY | syscall.O_NOFOLLOW | syscall.O_NONBLOCK | syscall.O_NOCTTY | syscall.O_CLOEXEC
+
+// chownTree changes the open entry fd, and for a directory everything below
+// it, through file descriptors: entries are opened relative to their
+// directory with O_NOFOLLOW, so an entry swapped for a symlink mid-walk
+// fails instead of redirecting the change. It closes fd.
+func chownTree(fd int, path string, uid, gid int) error {
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	kind := st.Mode & syscall.S_IFMT
+	if kind != syscall.S_IFDIR && kind != syscall.S_IFREG {
+		syscall.Close(fd)
+		return fmt.Errorf("tree contains a special file: %s", path)
+	}
+	// A second link may name a file outside the tree, such as one the
+	// agent planted to have the helper hand it over.
+	if kind == syscall.S_IFREG && st.Nlink > 1 {
+		syscall.Close(fd)
+		return fmt.Errorf("refusing to chown hard-linked file: %s", path)
+	}
+	// Set-id bits survive only on directories; on a file they would let
+	// the agent run code as the owner.
+	mode := st.Mode&0o777 | 0o660
+	if kind == syscall.S_IFDIR {
+		mode |= 0o110 | st.Mode&(syscall.S_ISGID|syscall.S_ISVTX)
+	}
+	err := syscall.Fchown(fd, uid, gid)
+	if err == nil {
+		err = syscall.Fchmod(fd, mode)
+	}
+	if err != nil || kind != syscall.S_IFDIR {
+		syscall.Close(fd)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return nil
+	}
+	dir := os.NewFile(uintptr(fd), path)
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		child := filepath.Join(path, name)
+		childFD, err := syscall.Openat(fd, name, chownOpenFlags, 0)
+		if err == syscall.ELOOP {
+			return fmt.Errorf("tree contains a symbolic link: %s", child)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", child, err)
+		}
+		if err := chownTree(childFD, child, uid, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func lookupOwner(owner, group string) (int, int, error) {
+	account, err := user.Lookup(owner)
+	if err != nil {
+		return 0, 0, fmt.Errorf("owner %q: %w", owner, err)
+	}
+	grp, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, 0, fmt.Errorf("group %q: %w", group, err)
+	}
+	uid, err := strconv.Atoi(account.Uid)
+	if err != nil {
+		return 0, 0, err
+	}
+	gid, err := strconv.Atoi(grp.Gid)
+	if err != nil {
+		return 0, 0, errors.New("invalid group id")
+	}
+	return uid, gid, nil
+}
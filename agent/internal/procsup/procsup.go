@@ -0,0 +1,514 @@
+// Package procsup runs game servers that are not managed by systemd as agent
+// children. Each process gets its own process group, a pidfile and a console
+// log that is rotated in place, and is reattached when the agent restarts.
+package procsup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mastermind/agent/internal/supervisor"
+)
+
+const (
+	defaultRoot        = "/var/lib/mastermind-agent/processes"
+	defaultMaxLogBytes = 50 * 1024 * 1024
+	defaultLogFiles    = 5
+	defaultStopTimeout = 30 * time.Second
+	// killGrace bounds the wait for a SIGKILLed process group to disappear.
+	killGrace = 10 * time.Second
+)
+
+// pollInterval and rotateInterval are variables so tests can run faster.
+var (
+	pollInterval   = 200 * time.Millisecond
+	rotateInterval = 30 * time.Second
+)
+
+// Default is the manager used by the game adapters and main.
+var Default = NewManager(defaultRoot)
+
+// Manager owns the native processes recorded under Root.
+type Manager struct {
+	// Root holds <name>.pid, <name>.json and <name>.log for each process.
+	Root string
+	// MaxLogBytes is the console log size that triggers a rotation.
+	MaxLogBytes int64
+	// LogFiles is how many rotated logs (<name>.log.1 …) are kept.
+	LogFiles int
+
+	mu    sync.Mutex
+	procs map[string]*Process
+}
+
+// NewManager returns a manager storing its state under root.
+func NewManager(root string) *Manager {
+	return &Manager{Root: root, MaxLogBytes: defaultMaxLogBytes, LogFiles: defaultLogFiles, procs: map[string]*Process{}}
+}
+
+// Process returns the process slot for name, usually a server instance ID.
+func (m *Manager) Process(name string) *Process {
+	if name == "" {
+		name = "default"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p, ok := m.procs[name]; ok {
+		return p
+	}
+	p := &Process{m: m, name: name}
+	m.procs[name] = p
+	return p
+}
+
+// Reattach adopts every recorded process that is still running and returns
+// their names. Records of processes that exited while the agent was down are
+// kept as failed exits so a supervisor still sees the crash.
+func (m *Manager) Reattach() ([]string, error) {
+	entries, err := os.ReadDir(m.Root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".pid")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		p := m.Process(name)
+		if pid, running := p.Running(); running {
+			slog.Info("reattached native server process", "name", name, "pid", pid)
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// Spec is the command line of a native process.
+type Spec struct {
+	Dir  string   `json:"dir"`
+	Argv []string `json:"argv"`
+	Env  []string `json:"env,omitempty"`
+}
+
+// state is persisted next to the pidfile so a reattached process can be
+// restarted with the same command.
+type state struct {
+	PID       int       `json:"pid"`
+	Spec      Spec      `json:"spec"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+type exitStatus struct {
+	failed bool
+	detail string
+}
+
+// Process is one native server process.
+type Process struct {
+	m    *Manager
+	name string
+
+	mu      sync.Mutex
+	loaded  bool
+	pid     int
+	spec    Spec
+	child   bool
+	done    chan struct{}
+	stopped bool
+	exit    *exitStatus
+}
+
+// LogPath is the console log that captures stdout and stderr.
+func (p *Process) LogPath() string { return filepath.Join(p.m.Root, p.name+".log") }
+
+// PIDPath is the pidfile of the process.
+func (p *Process) PIDPath() string { return filepath.Join(p.m.Root, p.name+".pid") }
+
+func (p *Process) statePath() string { return filepath.Join(p.m.Root, p.name+".json") }
+
+// Start launches spec in a new process group with stdout and stderr appended
+// to the console log. The process is not tied to ctx or to the job that
+// started it.
+func (p *Process) Start(spec Spec) (int, error) {
+	if len(spec.Argv) == 0 {
+		return 0, fmt.Errorf("empty command")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refresh()
+	if p.pid > 0 {
+		return 0, fmt.Errorf("%s is already running as pid %d", p.name, p.pid)
+	}
+	if err := os.MkdirAll(p.m.Root, 0750); err != nil {
+		return 0, fmt.Errorf("create process state directory: %w", err)
+	}
+	logFile, err := os.OpenFile(p.LogPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return 0, fmt.Errorf("open console log: %w", err)
+	}
+	defer logFile.Close()
+	fmt.Fprintf(logFile, "--- %s starting %s\n", time.Now().UTC().Format(time.RFC3339), strings.Join(spec.Argv, " "))
+
+	cmd := exec.Command(spec.Argv[0], spec.Argv[1:]...)
+	cmd.Dir = spec.Dir
+	if len(spec.Env) > 0 {
+		cmd.Env = append(os.Environ(), spec.Env...)
+	}
+	// The log file is passed straight to the child, so the server keeps
+	// writing to it while the agent is restarting.
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	pid := cmd.Process.Pid
+	p.pid, p.spec, p.child, p.stopped, p.exit, p.loaded = pid, spec, true, false, nil, true
+	p.done = make(chan struct{})
+	if err := p.writeState(state{PID: pid, Spec: spec, StartedAt: time.Now().UTC()}); err != nil {
+		slog.Warn("write native process state failed", "name", p.name, "err", err)
+	}
+	go p.wait(cmd, p.done)
+	go p.rotateLoop(p.done)
+	return pid, nil
+}
+
+// Restart starts the process again with its last command line. It is the
+// restart hook for a supervisor.
+func (p *Process) Restart(ctx context.Context) error {
+	p.mu.Lock()
+	p.refresh()
+	spec := p.spec
+	p.mu.Unlock()
+	if len(spec.Argv) == 0 {
+		return fmt.Errorf("%s has no recorded command to restart", p.name)
+	}
+	_, err := p.Start(spec)
+	return err
+}
+
+func (p *Process) wait(cmd *exec.Cmd, done chan struct{}) {
+	err := cmd.Wait()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done != done {
+		return
+	}
+	status := &exitStatus{detail: "exited normally"}
+	if err != nil {
+		status.detail = err.Error()
+		// Anything other than a clean exit counts as a crash unless the
+		// agent asked the process to stop.
+		status.failed = !p.stopped
+	}
+	p.finish(status)
+}
+
+// finish records how the current process ended. Callers hold p.mu.
+func (p *Process) finish(status *exitStatus) {
+	p.exit = status
+	p.pid = 0
+	p.child = false
+	_ = os.Remove(p.PIDPath())
+	if p.done != nil {
+		close(p.done)
+		p.done = nil
+	}
+}
+
+// refresh loads the recorded process on first use and notices exits of
+// reattached processes, which the agent cannot wait for. Callers hold p.mu.
+func (p *Process) refresh() {
+	if !p.loaded {
+		p.loaded = true
+		if st, err := p.readState(); err == nil {
+			p.spec = st.Spec
+		}
+		if pid, err := readPIDFile(p.PIDPath()); err == nil && pid > 0 {
+			p.pid = pid
+			p.done = make(chan struct{})
+			if alive(pid) {
+				go p.rotateLoop(p.done)
+			}
+		}
+	}
+	if p.pid == 0 || p.child || alive(p.pid) {
+		return
+	}
+	status := &exitStatus{detail: fmt.Sprintf("process %d is no longer running", p.pid)}
+	status.failed = !p.stopped
+	p.finish(status)
+}
+
+// Running returns the pid of the live process.
+func (p *Process) Running() (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refresh()
+	return p.pid, p.pid > 0
+}
+
+// Done is closed when the current process exits; it is nil when no process
+// is running.
+func (p *Process) Done() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refresh()
+	return p.done
+}
+
+// Observe implements supervisor.Probe. An exit the agent did not request with
+// a non-zero status, a signal, or while the agent was away is a failure.
+func (p *Process) Observe(context.Context) (supervisor.Observation, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refresh()
+	if p.pid > 0 {
+		return supervisor.Observation{Running: true, PID: p.pid}, nil
+	}
+	if p.exit != nil {
+		return supervisor.Observation{Failed: p.exit.failed, Detail: p.exit.detail}, nil
+	}
+	return supervisor.Observation{}, nil
+}
+
+// StopOptions controls a graceful stop.
+type StopOptions struct {
+	// Graceful asks the server to shut down, for example with an RCON
+	// "stop". When nil or failing, Signal is sent to the process group.
+	Graceful func(ctx context.Context) error
+	// Signal defaults to SIGTERM.
+	Signal syscall.Signal
+	// Timeout is how long to wait before SIGKILL; defaults to 30s.
+	Timeout time.Duration
+}
+
+// Stop shuts the process down and kills its process group if it is still
+// running after the timeout. Stopping a process that is not running succeeds.
+func (p *Process) Stop(ctx context.Context, opts StopOptions) error {
+	pid, ok := p.markStopping()
+	if !ok {
+		return nil
+	}
+	if opts.Signal == 0 {
+		opts.Signal = syscall.SIGTERM
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultStopTimeout
+	}
+	signalled := false
+	if opts.Graceful != nil {
+		if err := opts.Graceful(ctx); err != nil {
+			slog.Warn("graceful stop failed; signalling process group", "name", p.name, "err", err)
+		} else {
+			signalled = true
+		}
+	}
+	if !signalled {
+		if err := signalGroup(pid, opts.Signal); err != nil {
+			return fmt.Errorf("signal %s: %w", p.name, err)
+		}
+	}
+	if p.waitExit(ctx, opts.Timeout) {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	slog.Warn("native server did not stop in time; killing", "name", p.name, "pid", pid, "timeout", opts.Timeout)
+	return p.kill(ctx, pid)
+}
+
+// Kill sends SIGKILL to the process group.
+func (p *Process) Kill(ctx context.Context) error {
+	pid, ok := p.markStopping()
+	if !ok {
+		return nil
+	}
+	return p.kill(ctx, pid)
+}
+
+func (p *Process) kill(ctx context.Context, pid int) error {
+	if err := signalGroup(pid, syscall.SIGKILL); err != nil {
+		return fmt.Errorf("kill %s: %w", p.name, err)
+	}
+	if !p.waitExit(ctx, killGrace) {
+		return fmt.Errorf("%s (pid %d) still running after SIGKILL", p.name, pid)
+	}
+	return nil
+}
+
+func (p *Process) markStopping() (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refresh()
+	if p.pid == 0 {
+		return 0, false
+	}
+	p.stopped = true
+	return p.pid, true
+}
+
+// waitExit waits up to timeout for the process to exit.
+func (p *Process) waitExit(ctx context.Context, timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		done := p.Done()
+		if done == nil {
+			return true
+		}
+		select {
+		case <-done:
+			return true
+		case <-ticker.C:
+		case <-deadline.C:
+			_, running := p.Running()
+			return !running
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+func (p *Process) rotateLoop(done <-chan struct{}) {
+	ticker := time.NewTicker(rotateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := p.RotateLog(); err != nil {
+				slog.Warn("rotate native server log failed", "name", p.name, "err", err)
+			}
+		}
+	}
+}
+
+// RotateLog rotates the console log once it exceeds MaxLogBytes. The child
+// holds the log open in append mode, so the log is copied and truncated in
+// place; logtail follows the truncation. Lines written between the copy and
+// the truncate are lost, as with logrotate's copytruncate.
+func (p *Process) RotateLog() error {
+	path := p.LogPath()
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if p.m.MaxLogBytes <= 0 || info.Size() < p.m.MaxLogBytes {
+		return nil
+	}
+	keep := p.m.LogFiles
+	if keep <= 0 {
+		keep = 1
+	}
+	_ = os.Remove(fmt.Sprintf("%s.%d", path, keep))
+	for i := keep - 1; i >= 1; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := copyFile(path, path+".1"); err != nil {
+		return err
+	}
+	return os.Truncate(path, 0)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	if _, err := out.ReadFrom(in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (p *Process) writeState(st state) error {
+	content, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(p.statePath(), content); err != nil {
+		return err
+	}
+	return writeFileAtomic(p.PIDPath(), []byte(strconv.Itoa(st.PID)+"\n"))
+}
+
+func (p *Process) readState() (state, error) {
+	var st state
+	content, err := os.ReadFile(p.statePath())
+	if err != nil {
+		return st, err
+	}
+	return st, json.Unmarshal(content, &st)
+}
+
+func writeFileAtomic(path string, content []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readPIDFile(path string) (int, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(content)))
+}
+
+// alive reports whether pid is a live process group leader. Processes are
+// started with Setpgid, so a reused pid that leads no group is not ours.
+func alive(pid int) bool {
+	if err := syscall.Kill(pid, 0); err != nil && !errors.Is(err, syscall.EPERM) {
+		return false
+	}
+	if pgid, err := syscall.Getpgid(pid); err != nil || pgid != pid {
+		return false
+	}
+	// A zombie still answers signal 0 until its parent reaps it.
+	if stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid)); err == nil {
+		if end := strings.LastIndexByte(string(stat), ')'); end >= 0 && end+2 < len(stat) && stat[end+2] == 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func signalGroup(pid int, sig syscall.Signal) error {
+	err := syscall.Kill(-pid, sig)
+	if errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
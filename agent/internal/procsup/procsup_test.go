@@ -0,0 +1,176 @@
+package procsup
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func useFastPolling(t *testing.T) {
+	interval := pollInterval
+	t.Cleanup(func() { pollInterval = interval })
+	pollInterval = 10 * time.Millisecond
+}
+
+func startShell(t *testing.T, m *Manager, name, script string) *Process {
+	t.Helper()
+	p := m.Process(name)
+	if _, err := p.Start(Spec{Dir: t.TempDir(), Argv: []string{"/bin/sh", "-c", script}}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = p.Kill(context.Background()) })
+	return p
+}
+
+func waitForLog(t *testing.T, p *Process, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if content, _ := os.ReadFile(p.LogPath()); strings.Contains(string(content), want) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("log never contained %q", want)
+}
+
+func TestStartCapturesOutputAndStopsGracefully(t *testing.T) {
+	useFastPolling(t)
+	m := NewManager(t.TempDir())
+	p := startShell(t, m, "srv", "echo ready; echo oops >&2; exec sleep 30")
+	pid, running := p.Running()
+	if !running {
+		t.Fatal("process not running after start")
+	}
+	if recorded, err := readPIDFile(p.PIDPath()); err != nil || recorded != pid {
+		t.Fatalf("pidfile = %d, %v; want %d", recorded, err, pid)
+	}
+	waitForLog(t, p, "ready")
+	waitForLog(t, p, "oops")
+	if _, err := p.Start(Spec{Argv: []string{"/bin/true"}}); err == nil {
+		t.Fatal("second start of a running process succeeded")
+	}
+
+	if err := p.Stop(context.Background(), StopOptions{Timeout: 5 * time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	observation, _ := p.Observe(context.Background())
+	if observation.Running || observation.Failed {
+		t.Fatalf("requested stop observed as %+v", observation)
+	}
+	if _, err := os.Stat(p.PIDPath()); !os.IsNotExist(err) {
+		t.Fatalf("pidfile left after stop: %v", err)
+	}
+}
+
+func TestStopKillsProcessGroupAfterTimeout(t *testing.T) {
+	useFastPolling(t)
+	m := NewManager(t.TempDir())
+	p := startShell(t, m, "stubborn", `trap "" TERM; echo trapped; while :; do sleep 1; done`)
+	waitForLog(t, p, "trapped")
+	start := time.Now()
+	if err := p.Stop(context.Background(), StopOptions{Timeout: 200 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if _, running := p.Running(); running || time.Since(start) > 5*time.Second {
+		t.Fatalf("running = %v after %s", running, time.Since(start))
+	}
+}
+
+func TestUnexpectedExitIsFailedAndRestartable(t *testing.T) {
+	useFastPolling(t)
+	m := NewManager(t.TempDir())
+	p := startShell(t, m, "crashy", "echo booted; exit 3")
+	select {
+	case <-p.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("process did not exit")
+	}
+	observation, _ := p.Observe(context.Background())
+	if !observation.Failed || !strings.Contains(observation.Detail, "exit status 3") {
+		t.Fatalf("observation = %+v", observation)
+	}
+	if err := p.Restart(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-p.Done()
+	content, _ := os.ReadFile(p.LogPath())
+	if strings.Count(string(content), "\nbooted\n") != 2 {
+		t.Fatalf("log after restart = %q", content)
+	}
+}
+
+func TestReattachAdoptsRunningProcess(t *testing.T) {
+	useFastPolling(t)
+	root := t.TempDir()
+	first := startShell(t, NewManager(root), "srv", "exec sleep 30")
+	pid, _ := first.Running()
+
+	// A new manager stands in for the restarted agent.
+	m := NewManager(root)
+	names, err := m.Reattach()
+	if err != nil || len(names) != 1 || names[0] != "srv" {
+		t.Fatalf("reattach = %v, %v", names, err)
+	}
+	p := m.Process("srv")
+	if got, running := p.Running(); !running || got != pid {
+		t.Fatalf("reattached pid = %d running = %v, want %d", got, running, pid)
+	}
+	if err := p.Stop(context.Background(), StopOptions{Timeout: 5 * time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	if observation, _ := p.Observe(context.Background()); observation.Running || observation.Failed {
+		t.Fatalf("observation after stop = %+v", observation)
+	}
+}
+
+func TestReattachReportsProcessThatDiedWhileAgentWasDown(t *testing.T) {
+	root := t.TempDir()
+	exited := exec.Command("/bin/true")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewManager(root).Process("gone").writeState(state{PID: exited.Process.Pid, Spec: Spec{Argv: []string{"/bin/true"}}}); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(root)
+	names, err := m.Reattach()
+	if err != nil || len(names) != 0 {
+		t.Fatalf("reattach = %v, %v", names, err)
+	}
+	observation, _ := m.Process("gone").Observe(context.Background())
+	if observation.Running || !observation.Failed {
+		t.Fatalf("observation = %+v", observation)
+	}
+	if _, err := os.Stat(m.Process("gone").PIDPath()); !os.IsNotExist(err) {
+		t.Fatalf("stale pidfile kept: %v", err)
+	}
+}
+
+func TestRotateLogCopiesAndTruncates(t *testing.T) {
+	m := NewManager(t.TempDir())
+	m.MaxLogBytes, m.LogFiles = 10, 2
+	p := m.Process("srv")
+	for _, content := range []string{"first run output\n", "second run output\n", "third run output\n"} {
+		if err := os.WriteFile(p.LogPath(), []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.RotateLog(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if info, _ := os.Stat(p.LogPath()); info.Size() != 0 {
+		t.Fatalf("active log size = %d", info.Size())
+	}
+	for suffix, want := range map[string]string{".1": "third run output\n", ".2": "second run output\n"} {
+		if content, _ := os.ReadFile(p.LogPath() + suffix); string(content) != want {
+			t.Fatalf("%s = %q, want %q", suffix, content, want)
+		}
+	}
+	if _, err := os.Stat(p.LogPath() + ".3"); !os.IsNotExist(err) {
+		t.Fatal("rotation kept more than LogFiles logs")
+	}
+}
@@ -0,0 +1,97 @@
+// Package remotestore copies backups off the host. A Store keeps files under
+// slash-separated keys on an S3-compatible object store or an SFTP server;
+// uploads of large files resume after an interruption.
+package remotestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrNotFound is returned for keys that do not exist on the store.
+var ErrNotFound = errors.New("object not found")
+
+// Store is one off-host backup target.
+type Store interface {
+	// Put uploads the local file at path to key, resuming an earlier
+	// interrupted upload of the same file when the backend supports it.
+	Put(ctx context.Context, key, path string) error
+	// Get writes the object at key to w.
+	Get(ctx context.Context, key string, w io.Writer) error
+	// Size returns the size of the object at key, or ErrNotFound.
+	Size(ctx context.Context, key string) (int64, error)
+	// List returns the names directly below the directory prefix dir, both
+	// objects and sub-directories, without dir itself.
+	List(ctx context.Context, dir string) ([]string, error)
+	// Delete removes the object at key; deleting a missing key succeeds.
+	Delete(ctx context.Context, key string) error
+}
+
+// Config describes one backup target from the agent configuration.
+type Config struct {
+	Name string
+	// Type is "s3" or "sftp".
+	Type string
+	// Prefix is prepended to every key: a key prefix in the bucket, or the
+	// remote directory for SFTP.
+	Prefix string
+
+	// S3-compatible stores.
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses the bucket in the path, as MinIO expects.
+	PathStyle bool
+	// PartSize is the multipart chunk size; files up to one part are sent
+	// in a single PUT.
+	PartSize int64
+	// StateDir keeps in-progress multipart uploads so they can resume.
+	StateDir string
+
+	// SFTP servers, reached with the OpenSSH sftp client.
+	Host           string
+	Port           int
+	User           string
+	IdentityFile   string
+	KnownHostsFile string
+}
+
+// New returns the Store described by cfg.
+func New(cfg Config) (Store, error) {
+	switch cfg.Type {
+	case "s3":
+		return NewS3(cfg)
+	case "sftp":
+		return NewSFTP(cfg)
+	}
+	return nil, fmt.Errorf("backup target %q: unsupported type %q", cfg.Name, cfg.Type)
+}
+
+// joinKey joins key parts with single slashes.
+func joinKey(parts ...string) string {
+	var kept []string
+	for _, part := range parts {
+		if part = strings.Trim(part, "/"); part != "" {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, "/")
+}
+
+// validKey rejects keys that could address something outside the prefix.
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid key %q", key)
+		}
+	}
+	return nil
+}
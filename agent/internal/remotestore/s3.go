@@ -0,0 +1,523 @@
+package remotestore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPartSize = 16 << 20
+	// minPartSize is the smallest part S3 accepts for all but the last part.
+	minPartSize = 5 << 20
+	// maxErrorBody bounds error responses read into memory.
+	maxErrorBody = 64 << 10
+)
+
+// S3 stores objects in a bucket of an S3-compatible service, signing
+// requests with AWS Signature Version 4.
+type S3 struct {
+	cfg      Config
+	endpoint *url.URL
+	http     *http.Client
+	// now is the signing clock; tests pin it.
+	now func() time.Time
+}
+
+// S3Error is an error response from the object store.
+type S3Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *S3Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("s3: %s (HTTP %d)", e.Code, e.StatusCode)
+	}
+	return fmt.Sprintf("s3: %s: %s (HTTP %d)", e.Code, e.Message, e.StatusCode)
+}
+
+// Unwrap lets errors.Is match ErrNotFound for missing keys.
+func (e *S3Error) Unwrap() error {
+	if e.StatusCode == http.StatusNotFound && e.Code != "NoSuchBucket" && e.Code != "NoSuchUpload" {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// NewS3 returns an S3 store for cfg.
+func NewS3(cfg Config) (*S3, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || (endpoint.Scheme != "https" && endpoint.Scheme != "http") || endpoint.Host == "" {
+		return nil, fmt.Errorf("backup target %q: endpoint must be an http(s) URL", cfg.Name)
+	}
+	if cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("backup target %q: bucket, access key and secret key are required", cfg.Name)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.PartSize == 0 {
+		cfg.PartSize = defaultPartSize
+	}
+	if cfg.PartSize < minPartSize {
+		cfg.PartSize = minPartSize
+	}
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/")
+	return &S3{cfg: cfg, endpoint: endpoint, http: &http.Client{Timeout: 30 * time.Minute}, now: time.Now}, nil
+}
+
+// objectURL addresses key (the bucket itself when key is empty) with the
+// given query.
+func (s *S3) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	path := u.Path + "/"
+	if s.cfg.PathStyle {
+		path += s.cfg.Bucket + "/"
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	path += key
+	u.Path = path
+	u.RawPath = uriEncode(path, false)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+func (s *S3) fullKey(key string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	return joinKey(s.cfg.Prefix, key), nil
+}
+
+// do sends a signed request and returns the response of a successful call;
+// any other status becomes an *S3Error.
+func (s *S3) do(ctx context.Context, method string, u *url.URL, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.URL = u
+	req.ContentLength = int64(len(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	payload := sha256.Sum256(body)
+	s.sign(req, hex.EncodeToString(payload[:]))
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, readS3Error(resp)
+	}
+	return resp, nil
+}
+
+func readS3Error(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	apiErr := &S3Error{StatusCode: resp.StatusCode, Code: http.StatusText(resp.StatusCode)}
+	var parsed struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(data, &parsed) == nil && parsed.Code != "" {
+		apiErr.Code, apiErr.Message = parsed.Code, parsed.Message
+	}
+	return apiErr
+}
+
+// sign adds AWS Signature Version 4 headers to req.
+func (s *S3) sign(req *http.Request, payloadHash string) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signed := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "range" || lower == "content-md5" || lower == "content-type" {
+			signed[lower] = strings.Join(values, ",")
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(signed[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method, req.URL.EscapedPath(), req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), date)
+	for _, part := range []string{s.cfg.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// uriEncode percent-encodes everything but the unreserved characters, and
+// slashes unless encodeSlash is set, as Signature Version 4 requires.
+func uriEncode(value string, encodeSlash bool) string {
+	var out strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			out.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			out.WriteByte(c)
+		default:
+			fmt.Fprintf(&out, "%%%02X", c)
+		}
+	}
+	return out.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// Put uploads path to key, in a single request or, above one part, as a
+// multipart upload that resumes from the parts already stored.
+func (s *S3) Put(ctx context.Context, key, path string) error {
+	full, err := s.fullKey(key)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() <= s.cfg.PartSize {
+		body, err := io.ReadAll(file)
+		if err != nil {
+			return err
+		}
+		resp, err := s.do(ctx, http.MethodPut, s.objectURL(full, nil), body, nil)
+		if err != nil {
+			return fmt.Errorf("upload %s: %w", key, err)
+		}
+		resp.Body.Close()
+		return nil
+	}
+	return s.putMultipart(ctx, full, file, info)
+}
+
+// uploadState is an in-progress multipart upload, kept in StateDir.
+type uploadState struct {
+	UploadID string    `json:"uploadId"`
+	Key      string    `json:"key"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	PartSize int64     `json:"partSize"`
+}
+
+type uploadedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+	Size       int64  `xml:"Size"`
+}
+
+func (s *S3) statePath(full string) string {
+	if s.cfg.StateDir == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(s.cfg.Endpoint + "\x00" + s.cfg.Bucket + "\x00" + full))
+	return filepath.Join(s.cfg.StateDir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (s *S3) putMultipart(ctx context.Context, full string, file *os.File, info os.FileInfo) error {
+	statePath := s.statePath(full)
+	state := uploadState{Key: full, Size: info.Size(), ModTime: info.ModTime().UTC(), PartSize: s.cfg.PartSize}
+	existing := map[int]uploadedPart{}
+	if statePath != "" {
+		var saved uploadState
+		if data, err := os.ReadFile(statePath); err == nil && json.Unmarshal(data, &saved) == nil &&
+			saved.Key == full && saved.Size == state.Size && saved.ModTime.Equal(state.ModTime) && saved.PartSize == state.PartSize {
+			parts, err := s.listParts(ctx, full, saved.UploadID)
+			if err == nil {
+				state.UploadID = saved.UploadID
+				for _, part := range parts {
+					existing[part.PartNumber] = part
+				}
+			} else if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+	}
+	if state.UploadID == "" {
+		uploadID, err := s.createMultipart(ctx, full)
+		if err != nil {
+			return err
+		}
+		state.UploadID = uploadID
+		if statePath != "" {
+			if err := os.MkdirAll(filepath.Dir(statePath), 0750); err != nil {
+				return err
+			}
+			data, _ := json.Marshal(state)
+			if err := os.WriteFile(statePath, data, 0640); err != nil {
+				return err
+			}
+		}
+	}
+
+	var completed []uploadedPart
+	buffer := make([]byte, s.cfg.PartSize)
+	for number, offset := 1, int64(0); offset < state.Size; number, offset = number+1, offset+s.cfg.PartSize {
+		length := s.cfg.PartSize
+		if remaining := state.Size - offset; remaining < length {
+			length = remaining
+		}
+		if part, ok := existing[number]; ok && part.Size == length {
+			completed = append(completed, part)
+			continue
+		}
+		chunk := buffer[:length]
+		if _, err := file.ReadAt(chunk, offset); err != nil {
+			return err
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {state.UploadID}}
+		resp, err := s.do(ctx, http.MethodPut, s.objectURL(full, query), chunk, nil)
+		if err != nil {
+			return fmt.Errorf("upload part %d of %s: %w", number, full, err)
+		}
+		resp.Body.Close()
+		completed = append(completed, uploadedPart{PartNumber: number, ETag: resp.Header.Get("ETag"), Size: length})
+	}
+	if err := s.completeMultipart(ctx, full, state.UploadID, completed); err != nil {
+		return err
+	}
+	if statePath != "" {
+		_ = os.Remove(statePath)
+	}
+	return nil
+}
+
+func (s *S3) createMultipart(ctx context.Context, full string) (string, error) {
+	resp, err := s.do(ctx, http.MethodPost, s.objectURL(full, url.Values{"uploads": {""}}), nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("start multipart upload of %s: %w", full, err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("start multipart upload of %s: no upload ID in response", full)
+	}
+	return result.UploadID, nil
+}
+
+func (s *S3) listParts(ctx context.Context, full, uploadID string) ([]uploadedPart, error) {
+	var parts []uploadedPart
+	marker := ""
+	for {
+		query := url.Values{"uploadId": {uploadID}}
+		if marker != "" {
+			query.Set("part-number-marker", marker)
+		}
+		resp, err := s.do(ctx, http.MethodGet, s.objectURL(full, query), nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Parts       []uploadedPart `xml:"Part"`
+			IsTruncated bool           `xml:"IsTruncated"`
+			NextMarker  string         `xml:"NextPartNumberMarker"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("list uploaded parts: %w", err)
+		}
+		parts = append(parts, result.Parts...)
+		if !result.IsTruncated || result.NextMarker == "" {
+			return parts, nil
+		}
+		marker = result.NextMarker
+	}
+}
+
+func (s *S3) completeMultipart(ctx context.Context, full, uploadID string, parts []uploadedPart) error {
+	type completePart struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	request := struct {
+		XMLName xml.Name       `xml:"CompleteMultipartUpload"`
+		Parts   []completePart `xml:"Part"`
+	}{}
+	for _, part := range parts {
+		request.Parts = append(request.Parts, completePart{PartNumber: part.PartNumber, ETag: part.ETag})
+	}
+	body, _ := xml.Marshal(request)
+	resp, err := s.do(ctx, http.MethodPost, s.objectURL(full, url.Values{"uploadId": {uploadID}}), body, nil)
+	if err != nil {
+		return fmt.Errorf("complete multipart upload of %s: %w", full, err)
+	}
+	defer resp.Body.Close()
+	// S3 can report a failed completion in the body of a 200 response.
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	var failure struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(data, &failure) == nil && failure.XMLName.Local == "Error" {
+		return fmt.Errorf("complete multipart upload of %s: %w", full, &S3Error{StatusCode: resp.StatusCode, Code: failure.Code, Message: failure.Message})
+	}
+	return nil
+}
+
+// Get streams the object at key into w.
+func (s *S3) Get(ctx context.Context, key string, w io.Writer) error {
+	full, err := s.fullKey(key)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(full, nil), nil, nil)
+	if err != nil {
+		return fmt.Errorf("download %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("download %s: %w", key, err)
+	}
+	return nil
+}
+
+// Size returns the object's size from a HEAD request.
+func (s *S3) Size(ctx context.Context, key string) (int64, error) {
+	full, err := s.fullKey(key)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := s.do(ctx, http.MethodHead, s.objectURL(full, nil), nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+// List returns the objects and common prefixes directly below dir.
+func (s *S3) List(ctx context.Context, dir string) ([]string, error) {
+	prefix := joinKey(s.cfg.Prefix, dir)
+	if prefix != "" {
+		prefix += "/"
+	}
+	var names []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, s.objectURL("", query), nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %w", dir, err)
+		}
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			CommonPrefixes []struct {
+				Prefix string `xml:"Prefix"`
+			} `xml:"CommonPrefixes"`
+			IsTruncated bool   `xml:"IsTruncated"`
+			NextToken   string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %w", dir, err)
+		}
+		for _, object := range result.Contents {
+			if name := strings.TrimPrefix(object.Key, prefix); name != "" {
+				names = append(names, name)
+			}
+		}
+		for _, common := range result.CommonPrefixes {
+			if name := strings.TrimSuffix(strings.TrimPrefix(common.Prefix, prefix), "/"); name != "" {
+				names = append(names, name)
+			}
+		}
+		if !result.IsTruncated || result.NextToken == "" {
+			sort.Strings(names)
+			return names, nil
+		}
+		token = result.NextToken
+	}
+}
+
+// Delete removes the object at key.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	full, err := s.fullKey(key)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(full, nil), nil, nil)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("delete %s: %w", key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,241 @@
+package remotestore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sftpPath is a variable so tests can substitute a fake sftp client.
+var sftpPath = "/usr/bin/sftp"
+
+// SFTP stores files below a directory on an SFTP server. It drives the
+// OpenSSH sftp client in batch mode with key authentication and a pinned
+// known_hosts file, so it never prompts.
+type SFTP struct {
+	cfg Config
+}
+
+// NewSFTP returns an SFTP store for cfg.
+func NewSFTP(cfg Config) (*SFTP, error) {
+	if cfg.Host == "" || cfg.User == "" || cfg.IdentityFile == "" || cfg.KnownHostsFile == "" {
+		return nil, fmt.Errorf("backup target %q: host, user, identity file and known hosts file are required", cfg.Name)
+	}
+	if !strings.HasPrefix(cfg.Prefix, "/") {
+		return nil, fmt.Errorf("backup target %q: prefix must be an absolute remote directory", cfg.Name)
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 22
+	}
+	return &SFTP{cfg: cfg}, nil
+}
+
+func (s *SFTP) remotePath(key string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	return path.Join(s.cfg.Prefix, key), nil
+}
+
+// run executes a batch of sftp commands and returns the client's output.
+// Commands prefixed with "-" may fail without aborting the batch.
+func (s *SFTP) run(ctx context.Context, commands ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, sftpPath,
+		"-b", "-",
+		"-P", strconv.Itoa(s.cfg.Port),
+		"-i", s.cfg.IdentityFile,
+		"-o", "BatchMode=yes",
+		"-o", "IdentitiesOnly=yes",
+		"-o", "StrictHostKeyChecking=yes",
+		"-o", "UserKnownHostsFile="+s.cfg.KnownHostsFile,
+		s.cfg.User+"@"+s.cfg.Host,
+	)
+	cmd.Stdin = strings.NewReader(strings.Join(commands, "\n") + "\n")
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	err := cmd.Run()
+	if err != nil {
+		return output.String(), fmt.Errorf("sftp: %w: %s", err, lastLine(output.String()))
+	}
+	return output.String(), nil
+}
+
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// quoteSFTP quotes an argument for an sftp batch file.
+func quoteSFTP(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// mkdirCommands creates every directory above remote, ignoring ones that
+// already exist.
+func (s *SFTP) mkdirCommands(remote string) []string {
+	var commands []string
+	dir := path.Dir(remote)
+	var parents []string
+	for dir != "/" && dir != "." && strings.HasPrefix(dir, s.cfg.Prefix) {
+		parents = append(parents, dir)
+		dir = path.Dir(dir)
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		commands = append(commands, "-mkdir "+quoteSFTP(parents[i]))
+	}
+	return commands
+}
+
+// Put uploads path to key through key.partial, resuming a partial upload
+// left by an earlier attempt, then renames it into place.
+func (s *SFTP) Put(ctx context.Context, key, local string) error {
+	remote, err := s.remotePath(key)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+	partial := remote + ".partial"
+	upload := "put"
+	if size, err := s.size(ctx, partial); err == nil && size < info.Size() {
+		upload = "reput"
+	}
+	commands := append(s.mkdirCommands(remote),
+		upload+" "+quoteSFTP(local)+" "+quoteSFTP(partial),
+		"-rm "+quoteSFTP(remote),
+		"rename "+quoteSFTP(partial)+" "+quoteSFTP(remote),
+	)
+	if _, err := s.run(ctx, commands...); err != nil {
+		return fmt.Errorf("upload %s: %w", key, err)
+	}
+	return nil
+}
+
+// Get downloads key through a temporary file and copies it to w.
+func (s *SFTP) Get(ctx context.Context, key string, w io.Writer) error {
+	remote, err := s.remotePath(key)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp("", "mastermind-sftp-*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if _, err := s.size(ctx, remote); err != nil {
+		return err
+	}
+	if _, err := s.run(ctx, "get "+quoteSFTP(remote)+" "+quoteSFTP(tmp.Name())); err != nil {
+		return fmt.Errorf("download %s: %w", key, err)
+	}
+	file, err := os.Open(tmp.Name())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// Size returns the size of the remote file.
+func (s *SFTP) Size(ctx context.Context, key string) (int64, error) {
+	remote, err := s.remotePath(key)
+	if err != nil {
+		return 0, err
+	}
+	return s.size(ctx, remote)
+}
+
+func (s *SFTP) size(ctx context.Context, remote string) (int64, error) {
+	output, err := s.run(ctx, "ls -ln "+quoteSFTP(remote))
+	if err != nil {
+		if strings.Contains(output, "not found") || strings.Contains(output, "No such file") {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+	for _, entry := range parseSFTPListing(output) {
+		if entry.name == remote || entry.name == path.Base(remote) {
+			return entry.size, nil
+		}
+	}
+	return 0, ErrNotFound
+}
+
+// List returns the names in the remote directory dir.
+func (s *SFTP) List(ctx context.Context, dir string) ([]string, error) {
+	remote := s.cfg.Prefix
+	if dir != "" {
+		var err error
+		if remote, err = s.remotePath(dir); err != nil {
+			return nil, err
+		}
+	}
+	output, err := s.run(ctx, "ls -ln "+quoteSFTP(remote))
+	if err != nil {
+		if strings.Contains(output, "not found") || strings.Contains(output, "No such file") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("list %s: %w", dir, err)
+	}
+	var names []string
+	for _, entry := range parseSFTPListing(output) {
+		name := path.Base(entry.name)
+		if name != "." && name != ".." && !strings.HasSuffix(name, ".partial") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Delete removes key and then its directory if that is now empty.
+func (s *SFTP) Delete(ctx context.Context, key string) error {
+	remote, err := s.remotePath(key)
+	if err != nil {
+		return err
+	}
+	if _, err := s.size(ctx, remote); err == ErrNotFound {
+		return nil
+	}
+	if _, err := s.run(ctx, "rm "+quoteSFTP(remote), "-rmdir "+quoteSFTP(path.Dir(remote))); err != nil {
+		return fmt.Errorf("delete %s: %w", key, err)
+	}
+	return nil
+}
+
+type sftpEntry struct {
+	name string
+	size int64
+}
+
+// parseSFTPListing reads `ls -ln` output: permissions, links, uid, gid,
+// size, three date fields, then the name. The batch echo lines ("sftp> ...")
+// are skipped.
+func parseSFTPListing(output string) []sftpEntry {
+	var entries []sftpEntry
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 9 || strings.HasPrefix(line, "sftp>") || len(fields[0]) != 10 {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			continue
+		}
+		// Names may contain spaces; keep everything after the date.
+		name := strings.Join(fields[8:], " ")
+		entries = append(entries, sftpEntry{name: name, size: size})
+	}
+	return entries
+}
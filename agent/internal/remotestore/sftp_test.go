@@ -0,0 +1,86 @@
+package remotestore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSFTP installs an sftp client that records each batch and answers ls
+// with the content of the returned listing file, or "not found" when it
+// does not exist.
+func fakeSFTP(t *testing.T) (batches, listing string) {
+	t.Helper()
+	dir := t.TempDir()
+	batches, listing = filepath.Join(dir, "batches"), filepath.Join(dir, "listing")
+	script := filepath.Join(dir, "sftp")
+	content := `#!/bin/sh
+batch=$(cat)
+printf '%s\n---\n' "$batch" >> ` + batches + `
+case "$batch" in
+ls*)
+	if [ -f ` + listing + ` ]; then cat ` + listing + `; else echo 'Can'"'"'t ls: "x" not found'; exit 1; fi ;;
+esac
+`
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	previous := sftpPath
+	sftpPath = script
+	t.Cleanup(func() { sftpPath = previous })
+	return batches, listing
+}
+
+func TestSFTPPutResumesPartialUpload(t *testing.T) {
+	batches, listing := fakeSFTP(t)
+	store, err := NewSFTP(Config{Name: "nas", Host: "nas.example", User: "backup", IdentityFile: "/etc/key", KnownHostsFile: "/etc/known_hosts", Prefix: "/srv/backups"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := filepath.Join(t.TempDir(), "world.tar.gz")
+	if err := os.WriteFile(local, []byte(strings.Repeat("x", 100)), 0640); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := store.Put(ctx, "backups/id/world.tar.gz", local); err != nil {
+		t.Fatal(err)
+	}
+	// An earlier attempt left 40 bytes behind.
+	partial := "-rw-r--r--    1 1000     1000           40 May  1 10:00 /srv/backups/backups/id/world.tar.gz.partial\n"
+	if err := os.WriteFile(listing, []byte(partial), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, "backups/id/world.tar.gz", local); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(batches)
+	log := string(data)
+	for _, want := range []string{
+		`-mkdir "/srv/backups/backups/id"`,
+		`put "` + local + `" "/srv/backups/backups/id/world.tar.gz.partial"`,
+		`reput "` + local + `" "/srv/backups/backups/id/world.tar.gz.partial"`,
+		`rename "/srv/backups/backups/id/world.tar.gz.partial" "/srv/backups/backups/id/world.tar.gz"`,
+	} {
+		if !strings.Contains(log, want) {
+			t.Fatalf("batches miss %q:\n%s", want, log)
+		}
+	}
+	if size, err := store.Size(ctx, "backups/id/world.tar.gz.partial"); err != nil || size != 40 {
+		t.Fatalf("Size = %d, %v", size, err)
+	}
+}
+
+func TestParseSFTPListing(t *testing.T) {
+	output := "sftp> ls -ln \"/srv/backups/backups\"\n" +
+		"drwxr-x---    2 1000     1000         4096 May  1 10:00 /srv/backups/backups/mastermind_2024-05-01_10-00-00\n" +
+		"-rw-r-----    1 1000     1000          123 May  1 10:00 /srv/backups/backups/name with space\n"
+	entries := parseSFTPListing(output)
+	if len(entries) != 2 || entries[0].size != 4096 || entries[1].name != "/srv/backups/backups/name with space" || entries[1].size != 123 {
+		t.Fatalf("entries = %+v", entries)
+	}
+	if quoteSFTP(`a "b" \c`) != `"a \"b\" \\c"` {
+		t.Fatalf("quoteSFTP = %s", quoteSFTP(`a "b" \c`))
+	}
+}
@@ -0,0 +1,45 @@
+package supervisor
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mastermind/agent/internal/agent"
+)
+
+// lifecycleJobs stop, start or replace the server process on purpose.
+var lifecycleJobs = map[string]bool{
+	"SERVER_START":        true,
+	"SERVER_STOP":         true,
+	"SERVER_KILL":         true,
+	"SERVER_RESTART":      true,
+	"SERVER_SAFE_RESTART": true,
+	"SERVER_WIPE_SAVE":    true,
+	"GAME_INSTALL":        true,
+	"GAME_UPDATE":         true,
+	"SAVE_RESTORE":        true,
+}
+
+// GuardedExecutor pauses the matching supervisor while a lifecycle job runs,
+// so stops and restarts requested through the control plane are not treated
+// as crashes.
+type GuardedExecutor struct {
+	Next        agent.JobExecutor
+	Supervisors []*Supervisor
+}
+
+func (g *GuardedExecutor) Execute(ctx context.Context, job agent.Job) (agent.JobResult, error) {
+	if lifecycleJobs[strings.ToUpper(job.Type)] {
+		instanceID := job.ServerInstanceID
+		if instanceID == "" {
+			instanceID, _ = job.Payload["server_instance_id"].(string)
+		}
+		for _, supervisor := range g.Supervisors {
+			if supervisor.ServerInstanceID() == instanceID {
+				resume := supervisor.Pause()
+				defer resume()
+			}
+		}
+	}
+	return g.Next.Execute(ctx, job)
+}
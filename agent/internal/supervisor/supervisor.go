@@ -0,0 +1,336 @@
+// Package supervisor watches game server processes for crashes and restarts
+// them with backoff, giving up when a server keeps crashing.
+package supervisor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mastermind/agent/internal/backoff"
+	"github.com/mastermind/agent/internal/client"
+)
+
+const (
+	defaultPollInterval = 5 * time.Second
+	defaultLogLines     = 100
+	defaultMaxCrashes   = 3
+	defaultCrashWindow  = 15 * time.Minute
+	// maxLogTailBytes bounds how much of the log a crash report reads.
+	maxLogTailBytes = 256 * 1024
+)
+
+// Event types reported to the control plane.
+const (
+	EventCrashed       = "server_crashed"
+	EventRestarted     = "server_restarted"
+	EventRestartFailed = "server_restart_failed"
+	EventCrashLoop     = "server_crash_loop"
+)
+
+// Observation is one look at a server process.
+type Observation struct {
+	Running bool
+	PID     int
+	// Failed means the unit entered a failed state or the child exited
+	// abnormally, as opposed to a clean stop.
+	Failed bool
+	Detail string
+}
+
+// Probe observes a server process.
+type Probe interface {
+	Observe(ctx context.Context) (Observation, error)
+}
+
+// Journal is implemented by probes that can supply log lines when no log
+// file is configured.
+type Journal interface {
+	Journal(ctx context.Context, lines int) (string, error)
+}
+
+// Reporter is the subset of the control-plane client used for crash events.
+type Reporter interface {
+	ReportServerEvent(ctx context.Context, hostID, serverInstanceID string, event *client.ServerEvent) error
+}
+
+// Config describes one supervised server instance.
+type Config struct {
+	ServerInstanceID string
+	Probe            Probe
+	// Restart brings a crashed server back up.
+	Restart func(ctx context.Context) error
+	// LogPath is read for the crash report; when empty a Journal probe is
+	// used instead.
+	LogPath      string
+	LogLines     int
+	MaxCrashes   int
+	CrashWindow  time.Duration
+	PollInterval time.Duration
+	Backoff      backoff.Config
+}
+
+// Supervisor polls one server and restarts it after unexpected exits.
+type Supervisor struct {
+	cfg      Config
+	reporter Reporter
+	hostID   string
+	retry    *backoff.Backoff
+
+	mu     sync.Mutex
+	paused int
+	// rebaseline discards the last observation after a pause, so state
+	// changes made by agent jobs are never mistaken for crashes.
+	rebaseline bool
+
+	last    *Observation
+	crashes []time.Time
+	gaveUp  bool
+	// restartPending retries a restart that failed on the next poll.
+	restartPending bool
+}
+
+// New returns a supervisor for cfg, applying defaults to unset limits.
+func New(cfg Config, reporter Reporter, hostID string) *Supervisor {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	if cfg.LogLines <= 0 {
+		cfg.LogLines = defaultLogLines
+	}
+	if cfg.MaxCrashes <= 0 {
+		cfg.MaxCrashes = defaultMaxCrashes
+	}
+	if cfg.CrashWindow <= 0 {
+		cfg.CrashWindow = defaultCrashWindow
+	}
+	return &Supervisor{cfg: cfg, reporter: reporter, hostID: hostID, retry: backoff.New(cfg.Backoff)}
+}
+
+// ServerInstanceID returns the supervised instance.
+func (s *Supervisor) ServerInstanceID() string { return s.cfg.ServerInstanceID }
+
+// Pause suspends crash detection until the returned function is called.
+// Agent jobs that intentionally stop or restart the server hold a pause for
+// their whole run.
+func (s *Supervisor) Pause() (resume func()) {
+	s.mu.Lock()
+	s.paused++
+	s.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			s.paused--
+			s.rebaseline = true
+			s.mu.Unlock()
+		})
+	}
+}
+
+// Run supervises the server until ctx is cancelled.
+func (s *Supervisor) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		s.step(ctx, time.Now())
+		timer.Reset(s.cfg.PollInterval)
+	}
+}
+
+func (s *Supervisor) step(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	if s.paused > 0 || s.rebaseline {
+		// A job that stops or starts the server is a manual intervention,
+		// so it also ends a crash loop and any pending restart.
+		s.last = nil
+		s.gaveUp, s.restartPending, s.crashes = false, false, nil
+		s.rebaseline = s.paused > 0
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+
+	current, err := s.cfg.Probe.Observe(ctx)
+	if err != nil {
+		slog.Warn("supervisor probe failed", "server_instance_id", s.cfg.ServerInstanceID, "err", err)
+		return
+	}
+	previous := s.last
+	s.last = &current
+	if s.restartPending && !s.gaveUp {
+		if current.Running {
+			s.restartPending = false
+		} else {
+			s.restart(ctx, now)
+		}
+		return
+	}
+	if previous == nil {
+		return
+	}
+	if s.gaveUp {
+		// A server brought back by hand after a crash loop starts with a
+		// clean crash history.
+		if current.Running && !previous.Running {
+			s.gaveUp = false
+			s.restartPending = false
+			s.crashes = nil
+			s.retry.Reset()
+			slog.Info("supervisor resumed after manual start", "server_instance_id", s.cfg.ServerInstanceID)
+		}
+		return
+	}
+
+	respawned := previous.Running && current.Running && previous.PID > 0 && current.PID > 0 && previous.PID != current.PID
+	failed := current.Failed && !previous.Failed
+	if !respawned && !failed {
+		if previous.Running && !current.Running {
+			slog.Info("supervised server stopped cleanly", "server_instance_id", s.cfg.ServerInstanceID, "detail", current.Detail)
+		}
+		return
+	}
+	s.handleCrash(ctx, now, *previous, current, respawned)
+}
+
+// recordCrash adds a crash to the window and returns how many it holds.
+func (s *Supervisor) recordCrash(now time.Time) int {
+	recent := s.crashes[:0]
+	for _, at := range s.crashes {
+		if now.Sub(at) < s.cfg.CrashWindow {
+			recent = append(recent, at)
+		}
+	}
+	s.crashes = append(recent, now)
+	if len(s.crashes) == 1 {
+		s.retry.Reset()
+	}
+	return len(s.crashes)
+}
+
+// giveUpIfLooping stops automatic restarts once the window holds MaxCrashes.
+func (s *Supervisor) giveUpIfLooping(ctx context.Context, crashes int) bool {
+	if crashes < s.cfg.MaxCrashes {
+		return false
+	}
+	s.gaveUp = true
+	s.restartPending = false
+	message := fmt.Sprintf("%d crashes within %s; automatic restarts stopped until the server is started manually", crashes, s.cfg.CrashWindow)
+	slog.Error("supervised server is crash looping", "server_instance_id", s.cfg.ServerInstanceID, "crashes", crashes)
+	s.emit(ctx, &client.ServerEvent{Type: EventCrashLoop, Message: message, CrashCount: crashes, WindowSeconds: int(s.cfg.CrashWindow / time.Second), OccurredAt: time.Now().UTC()})
+	return true
+}
+
+func (s *Supervisor) handleCrash(ctx context.Context, now time.Time, previous, current Observation, respawned bool) {
+	crashes := s.recordCrash(now)
+	message := fmt.Sprintf("server process %d exited unexpectedly", previous.PID)
+	if respawned {
+		message = fmt.Sprintf("server process %d was replaced by %d", previous.PID, current.PID)
+	}
+	if current.Detail != "" {
+		message += " (" + current.Detail + ")"
+	}
+	slog.Warn("supervised server crashed", "server_instance_id", s.cfg.ServerInstanceID, "crashes", crashes, "detail", message)
+	s.emit(ctx, &client.ServerEvent{
+		Type: EventCrashed, Message: message, PreviousPID: previous.PID, PID: current.PID,
+		CrashCount: crashes, WindowSeconds: int(s.cfg.CrashWindow / time.Second),
+		LogTail: s.logTail(ctx), OccurredAt: now.UTC(),
+	})
+	if s.giveUpIfLooping(ctx, crashes) || respawned || s.cfg.Restart == nil {
+		// A respawned process was already brought up by the service manager.
+		return
+	}
+	s.restart(ctx, now)
+}
+
+// restart waits out the backoff and restarts the server. A failed restart
+// counts toward the crash window and is retried on the next poll.
+func (s *Supervisor) restart(ctx context.Context, now time.Time) {
+	if err := s.retry.Wait(ctx); err != nil {
+		return
+	}
+	s.last = nil
+	if err := s.cfg.Restart(ctx); err != nil {
+		slog.Warn("supervised restart failed", "server_instance_id", s.cfg.ServerInstanceID, "err", err)
+		s.restartPending = true
+		crashes := s.recordCrash(now)
+		s.emit(ctx, &client.ServerEvent{Type: EventRestartFailed, Message: err.Error(), CrashCount: crashes, WindowSeconds: int(s.cfg.CrashWindow / time.Second), OccurredAt: time.Now().UTC()})
+		s.giveUpIfLooping(ctx, crashes)
+		return
+	}
+	s.restartPending = false
+	slog.Info("supervised server restarted", "server_instance_id", s.cfg.ServerInstanceID, "crashes", len(s.crashes))
+	s.emit(ctx, &client.ServerEvent{Type: EventRestarted, Message: "server restarted after crash", CrashCount: len(s.crashes), WindowSeconds: int(s.cfg.CrashWindow / time.Second), OccurredAt: time.Now().UTC()})
+}
+
+func (s *Supervisor) emit(ctx context.Context, event *client.ServerEvent) {
+	if s.reporter == nil {
+		return
+	}
+	if err := s.reporter.ReportServerEvent(ctx, s.hostID, s.cfg.ServerInstanceID, event); err != nil {
+		slog.Warn("report server event failed", "server_instance_id", s.cfg.ServerInstanceID, "type", event.Type, "err", err)
+	}
+}
+
+// logTail returns the last LogLines lines of the server log.
+func (s *Supervisor) logTail(ctx context.Context) string {
+	if s.cfg.LogPath == "" {
+		if journal, ok := s.cfg.Probe.(Journal); ok {
+			output, err := journal.Journal(ctx, s.cfg.LogLines)
+			if err == nil {
+				return output
+			}
+			slog.Warn("read crash journal failed", "server_instance_id", s.cfg.ServerInstanceID, "err", err)
+		}
+		return ""
+	}
+	content, err := readTail(s.cfg.LogPath, maxLogTailBytes)
+	if err != nil {
+		slog.Warn("read crash log failed", "path", s.cfg.LogPath, "err", err)
+		return ""
+	}
+	return lastLines(content, s.cfg.LogLines)
+}
+
+func readTail(path string, limit int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size() - limit
+	if offset < 0 {
+		offset = 0
+	}
+	content := make([]byte, info.Size()-offset)
+	n, err := file.ReadAt(content, offset)
+	if err != nil && n < len(content) {
+		return nil, err
+	}
+	return content[:n], nil
+}
+
+func lastLines(content []byte, lines int) string {
+	content = bytes.TrimRight(content, "\r\n")
+	index := len(content)
+	for count := 0; count < lines; count++ {
+		index = bytes.LastIndexByte(content[:index], '\n')
+		if index < 0 {
+			return string(content)
+		}
+	}
+	return string(content[index+1:])
+}
@@ -0,0 +1,207 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mastermind/agent/internal/agent"
+	"github.com/mastermind/agent/internal/backoff"
+	"github.com/mastermind/agent/internal/client"
+)
+
+type scriptedProbe struct {
+	observations []Observation
+}
+
+func (p *scriptedProbe) Observe(context.Context) (Observation, error) {
+	if len(p.observations) == 0 {
+		return Observation{}, errors.New("no more observations")
+	}
+	next := p.observations[0]
+	if len(p.observations) > 1 {
+		p.observations = p.observations[1:]
+	}
+	return next, nil
+}
+
+type recordingReporter struct {
+	mu     sync.Mutex
+	events []client.ServerEvent
+}
+
+func (r *recordingReporter) ReportServerEvent(_ context.Context, _, _ string, event *client.ServerEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, *event)
+	return nil
+}
+
+func (r *recordingReporter) types() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var types []string
+	for _, event := range r.events {
+		types = append(types, event.Type)
+	}
+	return strings.Join(types, ",")
+}
+
+var running = Observation{Running: true, PID: 100}
+var failed = Observation{Failed: true, Detail: "result=signal"}
+
+func newTestSupervisor(probe Probe, reporter Reporter, restart func(context.Context) error, logPath string) *Supervisor {
+	return New(Config{
+		ServerInstanceID: "srv",
+		Probe:            probe,
+		Restart:          restart,
+		LogPath:          logPath,
+		LogLines:         2,
+		MaxCrashes:       2,
+		CrashWindow:      time.Hour,
+		Backoff:          backoff.Config{Initial: time.Millisecond, Maximum: time.Millisecond, DisableJitter: true},
+	}, reporter, "host")
+}
+
+func TestSupervisorRestartsFailedUnitWithCrashReport(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "output_log.txt")
+	if err := os.WriteFile(logPath, []byte("loading\nworld ready\nSegmentation fault\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	reporter := &recordingReporter{}
+	restarts := 0
+	probe := &scriptedProbe{observations: []Observation{running, failed}}
+	s := newTestSupervisor(probe, reporter, func(context.Context) error { restarts++; return nil }, logPath)
+	ctx := context.Background()
+	s.step(ctx, time.Now())
+	s.step(ctx, time.Now())
+	if restarts != 1 || reporter.types() != EventCrashed+","+EventRestarted {
+		t.Fatalf("restarts = %d, events = %s", restarts, reporter.types())
+	}
+	crash := reporter.events[0]
+	if crash.LogTail != "world ready\nSegmentation fault" || crash.PreviousPID != 100 || crash.CrashCount != 1 {
+		t.Fatalf("crash event = %+v", crash)
+	}
+}
+
+func TestSupervisorIgnoresCleanStopAndPausedJobs(t *testing.T) {
+	reporter := &recordingReporter{}
+	probe := &scriptedProbe{observations: []Observation{running, {}, running, failed}}
+	s := newTestSupervisor(probe, reporter, func(context.Context) error { return nil }, "")
+	ctx := context.Background()
+	s.step(ctx, time.Now())
+	s.step(ctx, time.Now()) // clean stop
+	s.step(ctx, time.Now())
+	resume := s.Pause()
+	s.step(ctx, time.Now()) // a stop job is running
+	resume()
+	s.step(ctx, time.Now())
+	if reporter.types() != "" {
+		t.Fatalf("events = %s", reporter.types())
+	}
+}
+
+func TestSupervisorReportsRespawnWithoutRestarting(t *testing.T) {
+	reporter := &recordingReporter{}
+	restarts := 0
+	probe := &scriptedProbe{observations: []Observation{running, {Running: true, PID: 200}}}
+	s := newTestSupervisor(probe, reporter, func(context.Context) error { restarts++; return nil }, "")
+	s.step(context.Background(), time.Now())
+	s.step(context.Background(), time.Now())
+	if restarts != 0 || reporter.types() != EventCrashed || reporter.events[0].PID != 200 {
+		t.Fatalf("restarts = %d, events = %+v", restarts, reporter.events)
+	}
+}
+
+func TestSupervisorStopsAfterCrashLoop(t *testing.T) {
+	reporter := &recordingReporter{}
+	restarts := 0
+	probe := &scriptedProbe{observations: []Observation{running, failed, running, failed}}
+	s := newTestSupervisor(probe, reporter, func(context.Context) error { restarts++; return nil }, "")
+	ctx := context.Background()
+	for i := 0; i < 8; i++ {
+		s.step(ctx, time.Now())
+	}
+	if restarts != 1 {
+		t.Fatalf("restarts = %d, want 1", restarts)
+	}
+	want := strings.Join([]string{EventCrashed, EventRestarted, EventCrashed, EventCrashLoop}, ",")
+	if reporter.types() != want {
+		t.Fatalf("events = %s, want %s", reporter.types(), want)
+	}
+
+	// Starting the server by hand ends the crash loop.
+	probe.observations = []Observation{running}
+	s.step(ctx, time.Now())
+	if s.gaveUp || len(s.crashes) != 0 {
+		t.Fatalf("crash loop not cleared: gaveUp = %v crashes = %d", s.gaveUp, len(s.crashes))
+	}
+}
+
+func TestSupervisorRetriesFailedRestart(t *testing.T) {
+	reporter := &recordingReporter{}
+	attempts := 0
+	probe := &scriptedProbe{observations: []Observation{running, failed, failed, running}}
+	s := newTestSupervisor(probe, reporter, func(context.Context) error {
+		attempts++
+		if attempts == 1 {
+			return errors.New("unit start failed")
+		}
+		return nil
+	}, "")
+	s.cfg.MaxCrashes = 5
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		s.step(ctx, time.Now())
+	}
+	if attempts != 2 || reporter.types() != strings.Join([]string{EventCrashed, EventRestartFailed, EventRestarted}, ",") {
+		t.Fatalf("attempts = %d, events = %s", attempts, reporter.types())
+	}
+}
+
+func TestParseSystemdShow(t *testing.T) {
+	observation := parseSystemdShow("MainPID=0\nActiveState=failed\nSubState=failed\nResult=signal\n")
+	if observation.Running || !observation.Failed || !strings.Contains(observation.Detail, "result=signal") {
+		t.Fatalf("failed unit = %+v", observation)
+	}
+	observation = parseSystemdShow("MainPID=4242\nActiveState=active\nSubState=running\nResult=success\n")
+	if !observation.Running || observation.Failed || observation.PID != 4242 {
+		t.Fatalf("running unit = %+v", observation)
+	}
+	observation = parseSystemdShow("MainPID=0\nActiveState=inactive\nSubState=dead\nResult=success\n")
+	if observation.Running || observation.Failed {
+		t.Fatalf("stopped unit = %+v", observation)
+	}
+}
+
+type recordingExecutor struct{ paused func() bool }
+
+func (e recordingExecutor) Execute(context.Context, agent.Job) (agent.JobResult, error) {
+	if e.paused() {
+		return agent.JobResult{Status: "success", Output: "paused"}, nil
+	}
+	return agent.JobResult{Status: "success"}, nil
+}
+
+func TestGuardedExecutorPausesLifecycleJobs(t *testing.T) {
+	s := newTestSupervisor(&scriptedProbe{}, nil, nil, "")
+	paused := func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.paused > 0
+	}
+	guard := &GuardedExecutor{Next: recordingExecutor{paused: paused}, Supervisors: []*Supervisor{s}}
+	result, _ := guard.Execute(context.Background(), agent.Job{Type: "SERVER_RESTART", ServerInstanceID: "srv"})
+	if result.Output != "paused" || paused() {
+		t.Fatalf("restart job output = %q, paused after = %v", result.Output, paused())
+	}
+	result, _ = guard.Execute(context.Background(), agent.Job{Type: "MOD_LIST", ServerInstanceID: "srv"})
+	if result.Output == "paused" {
+		t.Fatal("read job paused the supervisor")
+	}
+}
@@ -0,0 +1,64 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/mastermind/agent/internal/privhelper"
+)
+
+// SystemdProbe observes a systemd unit through its MainPID, ActiveState and
+// Result properties, the same state `systemctl is-failed` reports.
+type SystemdProbe struct {
+	Unit string
+}
+
+func (p SystemdProbe) Observe(ctx context.Context) (Observation, error) {
+	output, err := exec.CommandContext(ctx, "/usr/bin/systemctl", "show", "--property=MainPID,ActiveState,SubState,Result", p.Unit).Output()
+	if err != nil {
+		return Observation{}, fmt.Errorf("systemctl show %s: %w", p.Unit, err)
+	}
+	return parseSystemdShow(string(output)), nil
+}
+
+func parseSystemdShow(output string) Observation {
+	properties := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			properties[key] = value
+		}
+	}
+	pid, _ := strconv.Atoi(properties["MainPID"])
+	active, result := properties["ActiveState"], properties["Result"]
+	observation := Observation{Running: active == "active" && pid > 0, PID: pid}
+	// A unit waiting in auto-restart is "activating" with a non-success
+	// Result; treat it like failed so the crash is still reported.
+	observation.Failed = active == "failed" || (active != "active" && result != "" && result != "success")
+	if result != "" && result != "success" {
+		observation.Detail = "result=" + result
+	}
+	if sub := properties["SubState"]; sub != "" && observation.Failed {
+		observation.Detail = strings.TrimSpace(observation.Detail + " state=" + active + "/" + sub)
+	}
+	return observation
+}
+
+// Journal returns the unit's most recent journal lines.
+func (p SystemdProbe) Journal(ctx context.Context, lines int) (string, error) {
+	output, err := exec.CommandContext(ctx, "/usr/bin/journalctl", "-u", p.Unit, "-n", strconv.Itoa(lines), "--no-pager", "-o", "short-iso").Output()
+	if err != nil {
+		return "", fmt.Errorf("journalctl %s: %w", p.Unit, err)
+	}
+	return strings.TrimRight(string(output), "\n"), nil
+}
+
+// SystemdRestart clears a failed unit and starts it again through the
+// privileged helper.
+func SystemdRestart(unit string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		return privhelper.Default.Unit(ctx, privhelper.ActionStart, unit)
+	}
+}
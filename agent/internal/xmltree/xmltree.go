@@ -0,0 +1,416 @@
+// Package xmltree is a small mutable XML document model for editing game
+// configuration files in place. Unlike encoding/xml struct decoding it keeps
+// comments, processing instructions, attribute order and the original
+// whitespace between elements, so an edited file differs from the original
+// only where a value actually changed.
+package xmltree
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Kind identifies the type of a Node.
+type Kind int
+
+const (
+	DocumentNode Kind = iota
+	ElementNode
+	TextNode
+	CommentNode
+	ProcInstNode
+	DirectiveNode
+)
+
+// Attr is a single attribute. Names keep their original prefix, if any.
+type Attr struct {
+	Name  string
+	Value string
+}
+
+// Node is one node of a parsed document. Element nodes use Name, Attrs and
+// Children; text, comment, processing-instruction and directive nodes keep
+// their raw content in Data (Name holds the processing-instruction target).
+type Node struct {
+	Kind     Kind
+	Name     string
+	Attrs    []Attr
+	Data     string
+	Children []*Node
+	Parent   *Node
+	// Line is the 1-based source line where the node started, or 0 for nodes
+	// created after parsing.
+	Line int
+}
+
+// Parse reads a complete document. Start and end tags must match; the
+// returned node is always a DocumentNode.
+func Parse(r io.Reader) (*Node, error) {
+	decoder := xml.NewDecoder(r)
+	document := &Node{Kind: DocumentNode}
+	current := document
+	for {
+		line, _ := decoder.InputPos()
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			element := &Node{Kind: ElementNode, Name: qualifiedName(t.Name), Line: line}
+			for _, attribute := range t.Attr {
+				element.Attrs = append(element.Attrs, Attr{Name: qualifiedName(attribute.Name), Value: attribute.Value})
+			}
+			current.appendRaw(element)
+			current = element
+		case xml.EndElement:
+			if current.Kind != ElementNode || current.Name != qualifiedName(t.Name) {
+				return nil, &SyntaxError{Line: line, Msg: fmt.Sprintf("unexpected end element </%s>", qualifiedName(t.Name))}
+			}
+			current = current.Parent
+		case xml.CharData:
+			current.appendRaw(&Node{Kind: TextNode, Data: string(t), Line: line})
+		case xml.Comment:
+			current.appendRaw(&Node{Kind: CommentNode, Data: string(t), Line: line})
+		case xml.ProcInst:
+			current.appendRaw(&Node{Kind: ProcInstNode, Name: t.Target, Data: string(t.Inst), Line: line})
+		case xml.Directive:
+			current.appendRaw(&Node{Kind: DirectiveNode, Data: string(t), Line: line})
+		}
+	}
+	if current != document {
+		line, _ := decoder.InputPos()
+		return nil, &SyntaxError{Line: line, Msg: fmt.Sprintf("element <%s> is never closed", current.Name)}
+	}
+	if document.Root() == nil {
+		return nil, &SyntaxError{Line: 1, Msg: "document has no root element"}
+	}
+	return document, nil
+}
+
+// ParseBytes is Parse for an in-memory document.
+func ParseBytes(data []byte) (*Node, error) {
+	return Parse(bytes.NewReader(data))
+}
+
+// SyntaxError reports a structural problem that encoding/xml accepts in raw
+// token mode, such as mismatched end tags.
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("XML syntax error on line %d: %s", e.Line, e.Msg)
+}
+
+func qualifiedName(name xml.Name) string {
+	if name.Space != "" {
+		return name.Space + ":" + name.Local
+	}
+	return name.Local
+}
+
+func (n *Node) appendRaw(child *Node) {
+	child.Parent = n
+	n.Children = append(n.Children, child)
+}
+
+// Root returns the document element of a DocumentNode, or nil.
+func (n *Node) Root() *Node {
+	for _, child := range n.Children {
+		if child.Kind == ElementNode {
+			return child
+		}
+	}
+	return nil
+}
+
+// Elements returns the element children of n, optionally filtered by
+// case-insensitive name. An empty name returns every child element.
+func (n *Node) Elements(name string) []*Node {
+	elements := []*Node{}
+	for _, child := range n.Children {
+		if child.Kind == ElementNode && (name == "" || strings.EqualFold(child.Name, name)) {
+			elements = append(elements, child)
+		}
+	}
+	return elements
+}
+
+// Element returns the first child element with the case-insensitive name.
+func (n *Node) Element(name string) *Node {
+	for _, child := range n.Children {
+		if child.Kind == ElementNode && strings.EqualFold(child.Name, name) {
+			return child
+		}
+	}
+	return nil
+}
+
+// Attr returns an attribute value using a case-insensitive name match.
+func (n *Node) Attr(name string) (string, bool) {
+	for _, attribute := range n.Attrs {
+		if strings.EqualFold(attribute.Name, name) {
+			return attribute.Value, true
+		}
+	}
+	return "", false
+}
+
+// AttrValue is Attr without the presence flag.
+func (n *Node) AttrValue(name string) string {
+	value, _ := n.Attr(name)
+	return value
+}
+
+// SetAttr replaces an existing attribute in place, preserving attribute
+// order, or appends a new one.
+func (n *Node) SetAttr(name, value string) {
+	for index := range n.Attrs {
+		if strings.EqualFold(n.Attrs[index].Name, name) {
+			n.Attrs[index].Value = value
+			return
+		}
+	}
+	n.Attrs = append(n.Attrs, Attr{Name: name, Value: value})
+}
+
+// RemoveAttr deletes an attribute and reports whether it was present.
+func (n *Node) RemoveAttr(name string) bool {
+	for index := range n.Attrs {
+		if strings.EqualFold(n.Attrs[index].Name, name) {
+			n.Attrs = append(n.Attrs[:index], n.Attrs[index+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Text returns the concatenated character data directly inside n.
+func (n *Node) Text() string {
+	var b strings.Builder
+	for _, child := range n.Children {
+		if child.Kind == TextNode {
+			b.WriteString(child.Data)
+		}
+	}
+	return b.String()
+}
+
+// SetText replaces all children of n with a single text node.
+func (n *Node) SetText(text string) {
+	for _, child := range n.Children {
+		child.Parent = nil
+	}
+	n.Children = nil
+	n.appendRaw(&Node{Kind: TextNode, Data: text})
+}
+
+// NewElement returns a detached element.
+func NewElement(name string, attrs ...Attr) *Node {
+	return &Node{Kind: ElementNode, Name: name, Attrs: attrs}
+}
+
+// Clone returns a deep, detached copy of n.
+func (n *Node) Clone() *Node {
+	clone := &Node{Kind: n.Kind, Name: n.Name, Data: n.Data, Line: n.Line}
+	clone.Attrs = append([]Attr(nil), n.Attrs...)
+	for _, child := range n.Children {
+		clone.appendRaw(child.Clone())
+	}
+	return clone
+}
+
+// Index returns the position of child in n.Children, or -1.
+func (n *Node) Index(child *Node) int {
+	for index, candidate := range n.Children {
+		if candidate == child {
+			return index
+		}
+	}
+	return -1
+}
+
+func (n *Node) insertAt(index int, nodes ...*Node) {
+	for _, node := range nodes {
+		node.Parent = n
+	}
+	tail := append([]*Node(nil), n.Children[index:]...)
+	n.Children = append(append(n.Children[:index], nodes...), tail...)
+}
+
+// indentOf returns the whitespace-only text immediately before child, which
+// is how hand-formatted configuration files express an element's indentation.
+func (n *Node) indentOf(child *Node) (string, bool) {
+	index := n.Index(child)
+	if index <= 0 {
+		return "", false
+	}
+	previous := n.Children[index-1]
+	if previous.Kind != TextNode || strings.TrimSpace(previous.Data) != "" {
+		return "", false
+	}
+	return previous.Data, true
+}
+
+// AppendElement adds child as the last element of n, copying the
+// indentation used by existing siblings so the file stays readable.
+func (n *Node) AppendElement(child *Node) {
+	elements := n.Elements("")
+	if len(elements) == 0 {
+		outer := "\n"
+		if n.Parent != nil {
+			if indent, ok := n.Parent.indentOf(n); ok {
+				outer = indent
+			}
+		}
+		if len(n.Children) == 1 && n.Children[0].Kind == TextNode && strings.TrimSpace(n.Children[0].Data) == "" {
+			n.Children[0].Parent = nil
+			n.Children = nil
+		}
+		if len(n.Children) == 0 {
+			n.appendRaw(&Node{Kind: TextNode, Data: outer + "\t"})
+			n.appendRaw(child)
+			n.appendRaw(&Node{Kind: TextNode, Data: outer})
+			return
+		}
+		n.appendRaw(child)
+		return
+	}
+	last := elements[len(elements)-1]
+	n.InsertAfter(last, child)
+}
+
+// InsertBefore places child immediately before reference, which must be a
+// child of n, duplicating the reference's indentation.
+func (n *Node) InsertBefore(reference, child *Node) {
+	index := n.Index(reference)
+	if index < 0 {
+		n.AppendElement(child)
+		return
+	}
+	if indent, ok := n.indentOf(reference); ok {
+		n.insertAt(index, child, &Node{Kind: TextNode, Data: indent})
+		return
+	}
+	n.insertAt(index, child)
+}
+
+// InsertAfter places child immediately after reference, which must be a
+// child of n, duplicating the reference's indentation.
+func (n *Node) InsertAfter(reference, child *Node) {
+	index := n.Index(reference)
+	if index < 0 {
+		n.AppendElement(child)
+		return
+	}
+	if indent, ok := n.indentOf(reference); ok {
+		n.insertAt(index+1, &Node{Kind: TextNode, Data: indent}, child)
+		return
+	}
+	n.insertAt(index+1, child)
+}
+
+// RemoveChild detaches child together with the indentation text before it.
+func (n *Node) RemoveChild(child *Node) bool {
+	index := n.Index(child)
+	if index < 0 {
+		return false
+	}
+	start := index
+	if _, ok := n.indentOf(child); ok {
+		start = index - 1
+	}
+	for _, removed := range n.Children[start : index+1] {
+		removed.Parent = nil
+	}
+	n.Children = append(n.Children[:start], n.Children[index+1:]...)
+	return true
+}
+
+// Walk visits n and its descendants in document order until visit returns
+// false for a node, which skips that node's children.
+func (n *Node) Walk(visit func(*Node) bool) {
+	if !visit(n) {
+		return
+	}
+	for _, child := range append([]*Node(nil), n.Children...) {
+		child.Walk(visit)
+	}
+}
+
+// Encode writes n and its descendants. Element nodes without children are
+// written in the self-closing form used by 7DTD configuration files.
+func (n *Node) Encode(w io.Writer) error {
+	bw := &errWriter{w: w}
+	n.encode(bw)
+	return bw.err
+}
+
+// Bytes encodes n into memory.
+func (n *Node) Bytes() []byte {
+	var buffer bytes.Buffer
+	_ = n.Encode(&buffer)
+	return buffer.Bytes()
+}
+
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *errWriter) WriteString(s string) {
+	if w.err == nil {
+		_, w.err = io.WriteString(w.w, s)
+	}
+}
+
+func (n *Node) encode(w *errWriter) {
+	switch n.Kind {
+	case DocumentNode:
+		for _, child := range n.Children {
+			child.encode(w)
+		}
+	case ElementNode:
+		w.WriteString("<" + n.Name)
+		for _, attribute := range n.Attrs {
+			w.WriteString(" " + attribute.Name + `="` + escapeAttr(attribute.Value) + `"`)
+		}
+		if len(n.Children) == 0 {
+			w.WriteString(" />")
+			return
+		}
+		w.WriteString(">")
+		for _, child := range n.Children {
+			child.encode(w)
+		}
+		w.WriteString("</" + n.Name + ">")
+	case TextNode:
+		w.WriteString(escapeText(n.Data))
+	case CommentNode:
+		w.WriteString("<!--" + n.Data + "-->")
+	case ProcInstNode:
+		if n.Data == "" {
+			w.WriteString("<?" + n.Name + "?>")
+			return
+		}
+		w.WriteString("<?" + n.Name + " " + n.Data + "?>")
+	case DirectiveNode:
+		w.WriteString("<!" + n.Data + ">")
+	}
+}
+
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+var attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "\n", "&#xA;", "\r", "&#xD;", "\t", "&#x9;")
+
+func escapeText(s string) string { return textEscaper.Replace(s) }
+
+func escapeAttr(s string) string { return attrEscaper.Replace(s) }
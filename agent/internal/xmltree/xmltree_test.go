@@ -0,0 +1,74 @@
+package xmltree
+
+import (
+	"strings"
+	"testing"
+)
+
+const sample = `<?xml version="1.0" encoding="UTF-8"?>
+<!-- server settings -->
+<ServerSettings>
+	<property name="ServerName" value="My &amp; Server" />
+	<!-- zombies -->
+	<property name="MaxSpawnedZombies" value="64" />
+</ServerSettings>
+`
+
+func TestRoundTripPreservesCommentsAndLayout(t *testing.T) {
+	document, err := ParseBytes([]byte(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(document.Bytes()); got != sample {
+		t.Fatalf("round trip changed document:\n%s", got)
+	}
+}
+
+func TestEditKeepsSiblingIndentation(t *testing.T) {
+	document, err := ParseBytes([]byte(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := document.Root()
+	properties := root.Elements("property")
+	if len(properties) != 2 || properties[1].Line != 6 {
+		t.Fatalf("unexpected properties: %d (line %d)", len(properties), properties[len(properties)-1].Line)
+	}
+	properties[1].SetAttr("value", "80")
+	root.AppendElement(NewElement("property", Attr{Name: "name", Value: "BloodMoonFrequency"}, Attr{Name: "value", Value: "7"}))
+	root.RemoveChild(properties[0])
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<!-- server settings -->
+<ServerSettings>
+	<!-- zombies -->
+	<property name="MaxSpawnedZombies" value="80" />
+	<property name="BloodMoonFrequency" value="7" />
+</ServerSettings>
+`
+	if got := string(document.Bytes()); got != want {
+		t.Fatalf("edited document:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestAppendToEmptyElementIndents(t *testing.T) {
+	document, err := ParseBytes([]byte("<adminTools>\n\t<users />\n</adminTools>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := document.Root().Element("users")
+	users.AppendElement(NewElement("user", Attr{Name: "userid", Value: "1"}))
+	want := "<adminTools>\n\t<users>\n\t\t<user userid=\"1\" />\n\t</users>\n</adminTools>"
+	if got := string(document.Bytes()); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestParseRejectsMismatchedTags(t *testing.T) {
+	_, err := Parse(strings.NewReader("<a>\n<b></a>"))
+	if err == nil {
+		t.Fatal("mismatched end tag was accepted")
+	}
+	if syntax, ok := err.(*SyntaxError); !ok || syntax.Line != 2 {
+		t.Fatalf("error = %#v, want SyntaxError on line 2", err)
+	}
+}
@@ -0,0 +1,791 @@
+package xmltree
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// XPath is a compiled expression in the XPath 1.0 subset used by 7DTD
+// modlets: child, descendant (//), parent (..), self (.) and attribute (@)
+// steps with name, *, text() and node() tests; predicates with =, !=, <, <=,
+// >, >=, and, or, positions and the common string functions.
+type XPath struct {
+	source string
+	expr   xpExpr
+}
+
+// Match is one node selected by an XPath. Attr is set when the match is an
+// attribute of Node rather than Node itself.
+type Match struct {
+	Node *Node
+	Attr string
+}
+
+// Value returns the string value of the match.
+func (m Match) Value() string {
+	if m.Attr != "" {
+		value, _ := exactAttr(m.Node, m.Attr)
+		return value
+	}
+	return stringValue(m.Node)
+}
+
+// CompileXPath parses an expression.
+func CompileXPath(source string) (*XPath, error) {
+	tokens, err := tokenizeXPath(source)
+	if err != nil {
+		return nil, err
+	}
+	parser := &xpParser{tokens: tokens}
+	expr, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !parser.done() {
+		return nil, fmt.Errorf("xpath: unexpected %q", parser.peek().text)
+	}
+	return &XPath{source: source, expr: expr}, nil
+}
+
+// String returns the source expression.
+func (x *XPath) String() string { return x.source }
+
+// Select evaluates the expression with context as the context node and
+// returns the selected nodes. Expressions that do not produce a node-set are
+// an error.
+func (x *XPath) Select(context *Node) ([]Match, error) {
+	value, err := x.expr.eval(xpContext{node: xpNode{node: context}, position: 1, size: 1})
+	if err != nil {
+		return nil, err
+	}
+	nodes, ok := value.([]xpNode)
+	if !ok {
+		return nil, fmt.Errorf("xpath: expression does not select nodes")
+	}
+	matches := make([]Match, len(nodes))
+	for i, node := range nodes {
+		matches[i] = Match{Node: node.node, Attr: node.attr}
+	}
+	return matches, nil
+}
+
+type xpNode struct {
+	node *Node
+	attr string
+}
+
+type xpContext struct {
+	node     xpNode
+	position int
+	size     int
+}
+
+// xpExpr values are []xpNode, string, float64 or bool.
+type xpExpr interface {
+	eval(xpContext) (interface{}, error)
+}
+
+type xpToken struct {
+	kind string // "op", "name", "literal", "number"
+	text string
+}
+
+func tokenizeXPath(source string) ([]xpToken, error) {
+	var tokens []xpToken
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'' || r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("xpath: unterminated string literal")
+			}
+			tokens = append(tokens, xpToken{"literal", string(runes[i+1 : end])})
+			i = end + 1
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			end := i
+			for end < len(runes) && (unicode.IsDigit(runes[end]) || runes[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, xpToken{"number", string(runes[i:end])})
+			i = end
+		case r == '/' || r == '.' || r == '!' || r == '<' || r == '>':
+			two := ""
+			if i+1 < len(runes) {
+				two = string(runes[i : i+2])
+			}
+			if two == "//" || two == ".." || two == "!=" || two == "<=" || two == ">=" {
+				tokens = append(tokens, xpToken{"op", two})
+				i += 2
+				continue
+			}
+			if r == '!' {
+				return nil, fmt.Errorf("xpath: unexpected '!'")
+			}
+			tokens = append(tokens, xpToken{"op", string(r)})
+			i++
+		case strings.ContainsRune("[]()@,|=*", r):
+			tokens = append(tokens, xpToken{"op", string(r)})
+			i++
+		case unicode.IsLetter(r) || r == '_':
+			end := i
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) || strings.ContainsRune("_-.:", runes[end])) {
+				end++
+			}
+			tokens = append(tokens, xpToken{"name", string(runes[i:end])})
+			i = end
+		default:
+			return nil, fmt.Errorf("xpath: unexpected %q", string(r))
+		}
+	}
+	return tokens, nil
+}
+
+type xpParser struct {
+	tokens []xpToken
+	pos    int
+}
+
+func (p *xpParser) done() bool { return p.pos >= len(p.tokens) }
+
+func (p *xpParser) peek() xpToken {
+	if p.done() {
+		return xpToken{}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *xpParser) peekAt(offset int) xpToken {
+	if p.pos+offset >= len(p.tokens) {
+		return xpToken{}
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *xpParser) accept(kind, text string) bool {
+	token := p.peek()
+	if token.kind == kind && token.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *xpParser) expect(text string) error {
+	if !p.accept("op", text) {
+		if p.done() {
+			return fmt.Errorf("xpath: expected %q at end of expression", text)
+		}
+		return fmt.Errorf("xpath: expected %q, found %q", text, p.peek().text)
+	}
+	return nil
+}
+
+func (p *xpParser) parseOr() (xpExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("name", "or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &xpLogical{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *xpParser) parseAnd() (xpExpr, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("name", "and") {
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		left = &xpLogical{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *xpParser) parseComparison() (xpExpr, error) {
+	left, err := p.parseUnion()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		token := p.peek()
+		if token.kind != "op" || !(token.text == "=" || token.text == "!=" || token.text == "<" || token.text == "<=" || token.text == ">" || token.text == ">=") {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseUnion()
+		if err != nil {
+			return nil, err
+		}
+		left = &xpCompare{op: token.text, left: left, right: right}
+	}
+}
+
+func (p *xpParser) parseUnion() (xpExpr, error) {
+	left, err := p.parsePrimaryOrPath()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("op", "|") {
+		right, err := p.parsePrimaryOrPath()
+		if err != nil {
+			return nil, err
+		}
+		left = &xpUnion{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *xpParser) parsePrimaryOrPath() (xpExpr, error) {
+	token := p.peek()
+	switch {
+	case token.kind == "literal":
+		p.pos++
+		return xpLiteral{value: token.text}, nil
+	case token.kind == "number":
+		p.pos++
+		value, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("xpath: invalid number %q", token.text)
+		}
+		return xpLiteral{value: value}, nil
+	case token.kind == "op" && token.text == "(":
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expect(")")
+	case token.kind == "name" && p.peekAt(1).text == "(" && token.text != "text" && token.text != "node":
+		return p.parseFunction()
+	case token.kind == "":
+		return nil, fmt.Errorf("xpath: unexpected end of expression")
+	}
+	return p.parsePath()
+}
+
+func (p *xpParser) parseFunction() (xpExpr, error) {
+	name := p.peek().text
+	p.pos += 2
+	call := &xpFunction{name: name}
+	if !p.accept("op", ")") {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			if p.accept("op", ")") {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := call.check(); err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+func (p *xpParser) parsePath() (xpExpr, error) {
+	path := &xpPath{}
+	if p.accept("op", "/") {
+		path.absolute = true
+		if !p.startsStep() {
+			return path, nil
+		}
+	} else if p.accept("op", "//") {
+		path.absolute = true
+		path.steps = append(path.steps, xpStep{axis: "descendant-or-self", test: "node()"})
+	}
+	for {
+		step, err := p.parseStep()
+		if err != nil {
+			return nil, err
+		}
+		path.steps = append(path.steps, step)
+		if p.accept("op", "//") {
+			path.steps = append(path.steps, xpStep{axis: "descendant-or-self", test: "node()"})
+			continue
+		}
+		if !p.accept("op", "/") {
+			return path, nil
+		}
+	}
+}
+
+func (p *xpParser) startsStep() bool {
+	token := p.peek()
+	return token.kind == "name" || (token.kind == "op" && (token.text == "*" || token.text == "@" || token.text == "." || token.text == ".."))
+}
+
+func (p *xpParser) parseStep() (xpStep, error) {
+	var step xpStep
+	switch {
+	case p.accept("op", "."):
+		step = xpStep{axis: "self", test: "node()"}
+	case p.accept("op", ".."):
+		step = xpStep{axis: "parent", test: "node()"}
+	default:
+		step.axis = "child"
+		if p.accept("op", "@") {
+			step.axis = "attribute"
+		}
+		token := p.peek()
+		switch {
+		case token.kind == "op" && token.text == "*":
+			p.pos++
+			step.test = "*"
+		case token.kind == "name" && (token.text == "text" || token.text == "node") && p.peekAt(1).text == "(":
+			p.pos += 2
+			if err := p.expect(")"); err != nil {
+				return step, err
+			}
+			step.test = token.text + "()"
+		case token.kind == "name":
+			p.pos++
+			step.test = token.text
+		default:
+			if token.kind == "" {
+				return step, fmt.Errorf("xpath: expected a step at end of expression")
+			}
+			return step, fmt.Errorf("xpath: expected a step, found %q", token.text)
+		}
+	}
+	for p.accept("op", "[") {
+		predicate, err := p.parseOr()
+		if err != nil {
+			return step, err
+		}
+		if err := p.expect("]"); err != nil {
+			return step, err
+		}
+		step.predicates = append(step.predicates, predicate)
+	}
+	return step, nil
+}
+
+type xpLiteral struct{ value interface{} }
+
+func (l xpLiteral) eval(xpContext) (interface{}, error) { return l.value, nil }
+
+type xpLogical struct {
+	and         bool
+	left, right xpExpr
+}
+
+func (l *xpLogical) eval(ctx xpContext) (interface{}, error) {
+	left, err := l.left.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if toBool(left) != l.and {
+		return !l.and, nil
+	}
+	right, err := l.right.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return toBool(right), nil
+}
+
+type xpUnion struct{ left, right xpExpr }
+
+func (u *xpUnion) eval(ctx xpContext) (interface{}, error) {
+	left, err := u.left.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	right, err := u.right.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	leftNodes, leftOK := left.([]xpNode)
+	rightNodes, rightOK := right.([]xpNode)
+	if !leftOK || !rightOK {
+		return nil, fmt.Errorf("xpath: '|' requires node-sets")
+	}
+	return dedupe(append(append([]xpNode(nil), leftNodes...), rightNodes...)), nil
+}
+
+type xpCompare struct {
+	op          string
+	left, right xpExpr
+}
+
+func (c *xpCompare) eval(ctx xpContext) (interface{}, error) {
+	left, err := c.left.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.right.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return compareValues(c.op, left, right), nil
+}
+
+// compareValues follows XPath 1.0: a node-set compares true when any of its
+// members does.
+func compareValues(op string, left, right interface{}) bool {
+	if nodes, ok := left.([]xpNode); ok {
+		for _, node := range nodes {
+			if compareValues(op, node.value(), right) {
+				return true
+			}
+		}
+		return false
+	}
+	if nodes, ok := right.([]xpNode); ok {
+		for _, node := range nodes {
+			if compareValues(op, left, node.value()) {
+				return true
+			}
+		}
+		return false
+	}
+	if op == "=" || op == "!=" {
+		var equal bool
+		switch {
+		case isBool(left) || isBool(right):
+			equal = toBool(left) == toBool(right)
+		case isNumber(left) || isNumber(right):
+			equal = toNumber(left) == toNumber(right)
+		default:
+			equal = toString(left) == toString(right)
+		}
+		return equal == (op == "=")
+	}
+	a, b := toNumber(left), toNumber(right)
+	switch op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	default:
+		return a >= b
+	}
+}
+
+type xpFunction struct {
+	name string
+	args []xpExpr
+}
+
+var xpFunctionArity = map[string][2]int{
+	"starts-with": {2, 2}, "ends-with": {2, 2}, "contains": {2, 2}, "not": {1, 1},
+	"true": {0, 0}, "false": {0, 0}, "count": {1, 1}, "last": {0, 0}, "position": {0, 0},
+	"name": {0, 1}, "local-name": {0, 1}, "string": {0, 1}, "number": {0, 1},
+	"normalize-space": {0, 1}, "string-length": {0, 1}, "concat": {2, 32},
+}
+
+func (f *xpFunction) check() error {
+	arity, ok := xpFunctionArity[f.name]
+	if !ok {
+		return fmt.Errorf("xpath: unsupported function %s()", f.name)
+	}
+	if len(f.args) < arity[0] || len(f.args) > arity[1] {
+		return fmt.Errorf("xpath: wrong number of arguments to %s()", f.name)
+	}
+	return nil
+}
+
+func (f *xpFunction) eval(ctx xpContext) (interface{}, error) {
+	args := make([]interface{}, len(f.args))
+	for i, arg := range f.args {
+		value, err := arg.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	// Functions with an optional argument default to the context node.
+	contextArg := func() interface{} {
+		if len(args) == 0 {
+			return []xpNode{ctx.node}
+		}
+		return args[0]
+	}
+	switch f.name {
+	case "starts-with":
+		return strings.HasPrefix(toString(args[0]), toString(args[1])), nil
+	case "ends-with":
+		return strings.HasSuffix(toString(args[0]), toString(args[1])), nil
+	case "contains":
+		return strings.Contains(toString(args[0]), toString(args[1])), nil
+	case "not":
+		return !toBool(args[0]), nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "count":
+		nodes, ok := args[0].([]xpNode)
+		if !ok {
+			return nil, fmt.Errorf("xpath: count() requires a node-set")
+		}
+		return float64(len(nodes)), nil
+	case "last":
+		return float64(ctx.size), nil
+	case "position":
+		return float64(ctx.position), nil
+	case "name", "local-name":
+		nodes, ok := contextArg().([]xpNode)
+		if !ok {
+			return nil, fmt.Errorf("xpath: %s() requires a node-set", f.name)
+		}
+		if len(nodes) == 0 {
+			return "", nil
+		}
+		name := nodes[0].node.Name
+		if nodes[0].attr != "" {
+			name = nodes[0].attr
+		}
+		if f.name == "local-name" {
+			if colon := strings.LastIndex(name, ":"); colon >= 0 {
+				name = name[colon+1:]
+			}
+		}
+		return name, nil
+	case "string":
+		return toString(contextArg()), nil
+	case "number":
+		return toNumber(contextArg()), nil
+	case "normalize-space":
+		return strings.Join(strings.Fields(toString(contextArg())), " "), nil
+	case "string-length":
+		return float64(len([]rune(toString(contextArg())))), nil
+	default: // concat
+		var b strings.Builder
+		for _, arg := range args {
+			b.WriteString(toString(arg))
+		}
+		return b.String(), nil
+	}
+}
+
+type xpStep struct {
+	axis       string
+	test       string
+	predicates []xpExpr
+}
+
+type xpPath struct {
+	absolute bool
+	steps    []xpStep
+}
+
+func (p *xpPath) eval(ctx xpContext) (interface{}, error) {
+	current := []xpNode{ctx.node}
+	if p.absolute {
+		top := ctx.node.node
+		for top.Parent != nil {
+			top = top.Parent
+		}
+		current = []xpNode{{node: top}}
+	}
+	for _, step := range p.steps {
+		var next []xpNode
+		for _, context := range current {
+			candidates := step.candidates(context)
+			for _, predicate := range step.predicates {
+				filtered := candidates[:0:0]
+				for index, candidate := range candidates {
+					value, err := predicate.eval(xpContext{node: candidate, position: index + 1, size: len(candidates)})
+					if err != nil {
+						return nil, err
+					}
+					keep := toBool(value)
+					if number, ok := value.(float64); ok {
+						keep = number == float64(index+1)
+					}
+					if keep {
+						filtered = append(filtered, candidate)
+					}
+				}
+				candidates = filtered
+			}
+			next = append(next, candidates...)
+		}
+		current = dedupe(next)
+	}
+	return current, nil
+}
+
+func (s xpStep) candidates(context xpNode) []xpNode {
+	var nodes []xpNode
+	switch s.axis {
+	case "self":
+		return []xpNode{context}
+	case "parent":
+		if context.attr != "" {
+			return []xpNode{{node: context.node}}
+		}
+		if context.node.Parent != nil {
+			return []xpNode{{node: context.node.Parent}}
+		}
+		return nil
+	case "attribute":
+		if context.attr != "" || context.node.Kind != ElementNode {
+			return nil
+		}
+		for _, attribute := range context.node.Attrs {
+			if s.test == "*" || s.test == "node()" || attribute.Name == s.test {
+				nodes = append(nodes, xpNode{node: context.node, attr: attribute.Name})
+			}
+		}
+		return nodes
+	case "descendant-or-self":
+		if context.attr != "" {
+			return []xpNode{context}
+		}
+		context.node.Walk(func(node *Node) bool {
+			if node.Kind == ElementNode || node.Kind == DocumentNode || node.Kind == TextNode {
+				nodes = append(nodes, xpNode{node: node})
+			}
+			return true
+		})
+		return nodes
+	}
+	if context.attr != "" {
+		return nil
+	}
+	for _, child := range context.node.Children {
+		if s.matches(child) {
+			nodes = append(nodes, xpNode{node: child})
+		}
+	}
+	return nodes
+}
+
+func (s xpStep) matches(node *Node) bool {
+	switch s.test {
+	case "node()":
+		return node.Kind == ElementNode || node.Kind == TextNode || node.Kind == CommentNode
+	case "text()":
+		return node.Kind == TextNode
+	case "*":
+		return node.Kind == ElementNode
+	}
+	return node.Kind == ElementNode && node.Name == s.test
+}
+
+func dedupe(nodes []xpNode) []xpNode {
+	seen := make(map[xpNode]bool, len(nodes))
+	unique := nodes[:0:0]
+	for _, node := range nodes {
+		if !seen[node] {
+			seen[node] = true
+			unique = append(unique, node)
+		}
+	}
+	return unique
+}
+
+func (n xpNode) value() string {
+	if n.attr != "" {
+		value, _ := exactAttr(n.node, n.attr)
+		return value
+	}
+	return stringValue(n.node)
+}
+
+// exactAttr is the case-sensitive attribute lookup XPath requires.
+func exactAttr(node *Node, name string) (string, bool) {
+	for _, attribute := range node.Attrs {
+		if attribute.Name == name {
+			return attribute.Value, true
+		}
+	}
+	return "", false
+}
+
+func stringValue(node *Node) string {
+	if node.Kind == TextNode || node.Kind == CommentNode {
+		return node.Data
+	}
+	var b strings.Builder
+	node.Walk(func(n *Node) bool {
+		if n.Kind == TextNode {
+			b.WriteString(n.Data)
+		}
+		return true
+	})
+	return b.String()
+}
+
+func isBool(value interface{}) bool   { _, ok := value.(bool); return ok }
+func isNumber(value interface{}) bool { _, ok := value.(float64); return ok }
+
+func toBool(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case string:
+		return v != ""
+	case []xpNode:
+		return len(v) > 0
+	}
+	return false
+}
+
+func toNumber(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	}
+	number, err := strconv.ParseFloat(strings.TrimSpace(toString(value)), 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return number
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return strconv.FormatInt(int64(v), 10)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []xpNode:
+		if len(v) == 0 {
+			return ""
+		}
+		return v[0].value()
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+package xmltree
+
+import "testing"
+
+const items = `<items>
+	<item name="gunPistol">
+		<property name="Tags" value="weapon,ranged" />
+		<property name="Magazine" value="15" />
+	</item>
+	<item name="gunRifle">
+		<property name="Magazine" value="30" />
+	</item>
+	<item name="meleeClub" />
+</items>`
+
+func TestXPathSelect(t *testing.T) {
+	document, err := ParseBytes([]byte(items))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		xpath string
+		want  []string
+	}{
+		{`/items/item[@name='gunPistol']/property[@name="Magazine"]/@value`, []string{"15"}},
+		{`//item[starts-with(@name, 'gun')]/@name`, []string{"gunPistol", "gunRifle"}},
+		{`/items/item[property[@name='Tags' and contains(@value, 'ranged')]]/@name`, []string{"gunPistol"}},
+		{`/items/item[not(property)]/@name`, []string{"meleeClub"}},
+		{`/items/item[last()]/@name`, []string{"meleeClub"}},
+		{`/items/item[2]/property/@value`, []string{"30"}},
+		{`//property[@value > 20]/../@name`, []string{"gunRifle"}},
+		{`/items/item[@name='gunRifle' or @name='meleeClub']/@name`, []string{"gunRifle", "meleeClub"}},
+		{`/items/item[@name='missing']`, nil},
+	}
+	for _, c := range cases {
+		xpath, err := CompileXPath(c.xpath)
+		if err != nil {
+			t.Fatalf("compile %s: %v", c.xpath, err)
+		}
+		matches, err := xpath.Select(document)
+		if err != nil {
+			t.Fatalf("select %s: %v", c.xpath, err)
+		}
+		var got []string
+		for _, match := range matches {
+			got = append(got, match.Value())
+		}
+		if len(got) != len(c.want) {
+			t.Fatalf("%s = %q, want %q", c.xpath, got, c.want)
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Fatalf("%s = %q, want %q", c.xpath, got, c.want)
+			}
+		}
+	}
+}
+
+func TestCompileXPathRejectsBadSyntax(t *testing.T) {
+	for _, source := range []string{`/items/item[@name='x'`, `/items/item[@name='x]`, `/items/bogus-fn()`, `/items/`} {
+		if _, err := CompileXPath(source); err == nil {
+			t.Errorf("CompileXPath(%q) succeeded", source)
+		}
+	}
+}
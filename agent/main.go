@@ -16,6 +16,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/mastermind/agent/internal/backoff"
 	"github.com/mastermind/agent/internal/client"
 	"github.com/mastermind/agent/internal/config"
 	"github.com/mastermind/agent/internal/discovery"
@@ -27,11 +28,17 @@ import (
 	"github.com/mastermind/agent/internal/jobs"
 	"github.com/mastermind/agent/internal/logtail"
 	"github.com/mastermind/agent/internal/pairing"
+	"github.com/mastermind/agent/internal/privhelper"
+	"github.com/mastermind/agent/internal/procsup"
+	"github.com/mastermind/agent/internal/remotestore"
+	"github.com/mastermind/agent/internal/supervisor"
 )
 
 var (
 	configPath = flag.String("config", "/etc/mastermind-agent/config.yaml", "Config file (YAML or JSON)")
 	logLevel   = flag.String("log", "info", "Log level: debug, info, warn, error")
+	helperMode = flag.Bool("helper", false, "Run the privileged root helper instead of the agent")
+	helperPath = flag.String("helper-config", "/etc/mastermind-agent/helper.yaml", "Privileged helper config file (YAML)")
 )
 
 // version is overridden in release builds with:
@@ -41,6 +48,10 @@ var version = "dev"
 func main() {
 	flag.Parse()
 	setupLog(*logLevel)
+	if *helperMode {
+		runHelper(*helperPath)
+		return
+	}
 
 	cfg, err := config.Load(*configPath)
 	if err != nil {
@@ -54,6 +65,7 @@ func main() {
 	}
 	cfg.Defaults()
 	cfg.Env() // env vars always override file values
+	privhelper.Default.Socket = cfg.HelperSocket
 
 	// Resolve agent key and host ID: if no key file yet, require pairing token and pair first
 	var agentKey, hostID string
@@ -119,9 +131,66 @@ func main() {
 	go heartbeat.Run(ctx, cl, hostID, cfg.Host.Name, interval, version, gameProbe)
 
 	registry := games.NewRegistry()
-	registry.Register(sevendtd.NewAdapter())
+	sevenDTD := sevendtd.NewAdapter()
+	sevenDTD.SteamCMDPath = cfg.Discovery.SevenDTD.SteamCMDPath
+	sevenDTD.BackupTargets = backupTargets(cfg.BackupTargets)
+	sevenDTD.SaveBackupRoot = cfg.SaveBackups.Root
+	sevenDTD.SaveBackupRoots = cfg.SaveBackups.InstanceRoots
+	sevenDTD.RegionHealerInstance = cfg.SaveBackups.RegionHealerInstance
+	sevenDTD.ServerConfigBackupRoot = cfg.ServerConfigBackupRoot
+	sevenDTD.DiskReserveMB = cfg.DiskReserveMB
+	if cfg.BackupRecipientFile != "" {
+		// Falling back to plaintext backups would defeat the setting.
+		recipient, err := sevendtd.LoadBackupRecipient(cfg.BackupRecipientFile)
+		if err != nil {
+			slog.Error("load backup recipient", "path", cfg.BackupRecipientFile, "err", err)
+			os.Exit(1)
+		}
+		sevenDTD.BackupRecipient = recipient
+		slog.Info("save backups are encrypted", "fingerprint", recipient.Fingerprint)
+	}
+	registry.Register(sevenDTD)
 	registry.Register(minecraft.NewAdapter())
-	exec := &execute.RegistryExecutor{Registry: registry}
+	// Servers started natively by a previous agent run keep running across
+	// agent restarts; adopt them before any job or supervisor looks at them.
+	if names, err := procsup.Default.Reattach(); err != nil {
+		slog.Warn("reattach native server processes failed", "err", err)
+	} else if len(names) > 0 {
+		slog.Info("reattached native server processes", "servers", names)
+	}
+	var supervisors []*supervisor.Supervisor
+	for _, sc := range cfg.Supervisors {
+		if sc.ServerInstanceID == "" {
+			slog.Warn("supervisor entry without server_instance_id ignored", "unit", sc.Unit)
+			continue
+		}
+		var probe supervisor.Probe = supervisor.SystemdProbe{Unit: sc.Unit}
+		restart, logPath := supervisor.SystemdRestart(sc.Unit), sc.LogPath
+		if sc.Mode == "native" {
+			proc := procsup.Default.Process(sc.ServerInstanceID)
+			probe, restart = proc, proc.Restart
+			if logPath == "" {
+				logPath = proc.LogPath()
+			}
+		}
+		sv := supervisor.New(supervisor.Config{
+			ServerInstanceID: sc.ServerInstanceID,
+			Probe:            probe,
+			Restart:          restart,
+			LogPath:          logPath,
+			LogLines:         sc.LogLines,
+			MaxCrashes:       sc.MaxCrashes,
+			CrashWindow:      time.Duration(sc.CrashWindowSec) * time.Second,
+			PollInterval:     time.Duration(sc.PollIntervalSec) * time.Second,
+			Backoff: backoff.Config{
+				Initial: time.Duration(sc.BackoffInitialSec) * time.Second,
+				Maximum: time.Duration(sc.BackoffMaxSec) * time.Second,
+			},
+		}, cl, hostID)
+		supervisors = append(supervisors, sv)
+		go sv.Run(ctx)
+	}
+	exec := &supervisor.GuardedExecutor{Next: &execute.RegistryExecutor{Registry: registry}, Supervisors: supervisors}
 
 	// Job polling loop (long-poll if configured)
 	go jobs.Loop(ctx, cl, hostID, cfg.Jobs.PollIntervalSec, cfg.Jobs.LongPollSec, exec, cfg.Jobs.MaxConcurrentReads)
@@ -133,6 +202,68 @@ func main() {
 	slog.Info("shutting down")
 }
 
+// backupTargets builds the configured off-host backup targets, skipping
+// invalid entries with a warning.
+func backupTargets(entries []config.BackupTargetCfg) map[string]sevendtd.BackupTarget {
+	targets := make(map[string]sevendtd.BackupTarget)
+	for _, entry := range entries {
+		if entry.Name == "" {
+			slog.Warn("backup target without name ignored", "type", entry.Type)
+			continue
+		}
+		store, err := remotestore.New(remotestore.Config{
+			Name:           entry.Name,
+			Type:           entry.Type,
+			Prefix:         entry.Prefix,
+			Endpoint:       entry.Endpoint,
+			Region:         entry.Region,
+			Bucket:         entry.Bucket,
+			AccessKey:      entry.AccessKey,
+			SecretKey:      entry.SecretKey,
+			PathStyle:      entry.PathStyle,
+			PartSize:       int64(entry.PartSizeMB) << 20,
+			StateDir:       filepath.Join("/var/lib/mastermind-agent/replication", entry.Name),
+			Host:           entry.Host,
+			Port:           entry.Port,
+			User:           entry.User,
+			IdentityFile:   entry.IdentityFile,
+			KnownHostsFile: entry.KnownHostsFile,
+		})
+		if err != nil {
+			slog.Warn("backup target ignored", "name", entry.Name, "err", err)
+			continue
+		}
+		targets[entry.Name] = sevendtd.BackupTarget{Store: store, Retention: entry.RetentionCount, AfterBackup: entry.ReplicateAfterBackup}
+	}
+	return targets
+}
+
+// runHelper serves the privileged helper socket until SIGINT or SIGTERM.
+func runHelper(path string) {
+	helperCfg, err := privhelper.LoadConfig(path)
+	if err != nil {
+		slog.Error("load helper config", "path", path, "err", err)
+		os.Exit(1)
+	}
+	server, err := privhelper.NewServer(helperCfg)
+	if err != nil {
+		slog.Error("helper config", "err", err)
+		os.Exit(1)
+	}
+	listener, err := server.Listen()
+	if err != nil {
+		slog.Error("helper listen", "socket", helperCfg.Socket, "err", err)
+		os.Exit(1)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	slog.Info("privileged helper listening", "socket", helperCfg.Socket, "units", helperCfg.Units, "roots", len(helperCfg.Roots))
+	if err := server.Serve(ctx, listener); err != nil {
+		slog.Error("helper serve", "err", err)
+		os.Exit(1)
+	}
+}
+
 func setupLog(level string) {
 	var lvl slog.Level
 	switch level {
@@ -1,6 +1,6 @@
 import { Controller, Post, Param, Body, UseGuards, Req } from '@nestjs/common';
-import { IsInt, IsObject, IsOptional, IsString } from 'class-validator';
-import { HostsService, HeartbeatMetrics } from './hosts.service';
+import { IsIn, IsInt, IsISO8601, IsObject, IsOptional, IsString, MaxLength } from 'class-validator';
+import { AGENT_SERVER_EVENT_TYPES, AgentServerEvent, HostsService, HeartbeatMetrics } from './hosts.service';
 import { AgentAuthGuard, RequestWithAgent } from '../pairing/agent-auth.guard';
 import { ServerInstancesService } from '../server-instances/server-instances.service';
 
@@ -40,6 +40,42 @@ class Discover7DtdServerDto {
   config?: Record<string, unknown>;
 }
 
+class ServerEventDto {
+  @IsIn(AGENT_SERVER_EVENT_TYPES)
+  type!: AgentServerEvent['type'];
+
+  @IsOptional()
+  @IsString()
+  @MaxLength(2000)
+  message?: string;
+
+  @IsOptional()
+  @IsInt()
+  pid?: number;
+
+  @IsOptional()
+  @IsInt()
+  previousPid?: number;
+
+  @IsOptional()
+  @IsInt()
+  crashCount?: number;
+
+  @IsOptional()
+  @IsInt()
+  windowSeconds?: number;
+
+  // Crash reports carry the last log lines; the agent reads at most 256 KiB.
+  @IsOptional()
+  @IsString()
+  @MaxLength(256 * 1024)
+  logTail?: string;
+
+  @IsOptional()
+  @IsISO8601()
+  occurredAt?: string;
+}
+
 @Controller('api/agent/hosts')
 export class AgentHostsController {
   constructor(
@@ -81,4 +117,16 @@ export class AgentHostsController {
     const hostId = req.agentHostId!;
     return this.serverInstancesService.upsertDiscovered7DtdInstance(hostId, dto);
   }
+
+  /** Crash supervisor events; the instance must belong to the calling host. */
+  @Post(':hostId/server-instances/:serverInstanceId/events')
+  @UseGuards(AgentAuthGuard)
+  async serverEvent(
+    @Param('hostId') _pathHostId: string,
+    @Param('serverInstanceId') serverInstanceId: string,
+    @Body() dto: ServerEventDto,
+    @Req() req: RequestWithAgent,
+  ) {
+    return this.hostsService.recordServerEvent(req.agentHostId!, serverInstanceId, dto);
+  }
 }
@@ -7,11 +7,13 @@ import { PrismaService } from '../prisma.service';
 import { PairingModule } from '../pairing/pairing.module';
 import { OrgMemberGuard } from '../server-instances/guards/org-member.guard';
 import { ServerInstancesModule } from '../server-instances/server-instances.module';
+import { AlertsModule } from '../alerts/alerts.module';
 
 @Module({
   imports: [
     PairingModule, // exports AgentAuthGuard and PairingService
     ServerInstancesModule,
+    AlertsModule,
     JwtModule.register({
       secret: process.env.JWT_SECRET || 'change-me-user-secret',
       signOptions: { expiresIn: '7d' },
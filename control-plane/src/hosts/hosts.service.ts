@@ -1,6 +1,7 @@
 import { BadRequestException, Injectable, NotFoundException } from '@nestjs/common';
 import type { Prisma } from '@prisma/client';
 import { PrismaService } from '../prisma.service';
+import { AlertsService } from '../alerts/alerts.service';
 
 export interface HeartbeatMetrics {
   cpu?: number;
@@ -12,10 +13,23 @@ export interface HeartbeatMetrics {
   agentVersion?: string;
 }
 
+export const AGENT_SERVER_EVENT_TYPES = ['server_crashed', 'server_restarted', 'server_restart_failed', 'server_crash_loop'] as const;
+
+export interface AgentServerEvent {
+  type: (typeof AGENT_SERVER_EVENT_TYPES)[number];
+  message?: string;
+  pid?: number;
+  previousPid?: number;
+  crashCount?: number;
+  windowSeconds?: number;
+  logTail?: string;
+  occurredAt?: string;
+}
+
 @Injectable()
 export class HostsService {
   private readonly lastHealthSample = new Map<string, number>();
-  constructor(private readonly prisma: PrismaService) {}
+  constructor(private readonly prisma: PrismaService, private readonly alerts: AlertsService) {}
 
   /** List all hosts in the org with their server instance count. */
   async findAll(orgId: string) {
@@ -155,6 +169,47 @@ export class HostsService {
     await this.recordHeartbeat(hostId, host.orgId, metrics);
   }
 
+  /**
+   * Record a crash supervisor event reported by the agent on hostId and alert
+   * on crashes, crash loops and automatic restarts.
+   */
+  async recordServerEvent(hostId: string, serverInstanceId: string, event: AgentServerEvent) {
+    const instance = await this.prisma.serverInstance.findFirst({
+      where: { id: serverInstanceId, hostId },
+      select: { id: true, name: true, orgId: true, host: { select: { name: true } } },
+    });
+    if (!instance) {
+      throw new NotFoundException('Server instance not found on this host');
+    }
+    const created = await this.prisma.event.create({
+      data: {
+        orgId: instance.orgId,
+        sourceType: 'server_instance',
+        sourceId: instance.id,
+        eventType: event.type,
+        payload: {
+          message: event.message ?? null,
+          pid: event.pid ?? null,
+          previousPid: event.previousPid ?? null,
+          crashCount: event.crashCount ?? null,
+          windowSeconds: event.windowSeconds ?? null,
+          logTail: event.logTail ?? null,
+          occurredAt: event.occurredAt ?? new Date().toISOString(),
+        },
+      },
+    });
+    const alertType = event.type === 'server_restarted' ? 'SERVER_RESTART' : 'SERVER_DOWN';
+    await this.alerts.sendMatchingRules(alertType, {
+      orgId: instance.orgId,
+      serverInstanceId: instance.id,
+      serverInstanceName: instance.name,
+      hostId,
+      hostName: instance.host.name,
+      reason: event.message ?? event.type,
+    }).catch(() => undefined);
+    return { ok: true, eventId: created.id };
+  }
+
   /**
    * Sweep hosts that have not sent a heartbeat within thresholdMs milliseconds
    * and mark them as offline. Returns the list of hosts that were newly set offline.
@@ -3,6 +3,7 @@ export const JOB_TYPES = [
   'SERVER_START',
   'SERVER_STOP',
   'SERVER_KILL',
+  'SERVER_LOGS',
   'SERVER_RESTART',
   'SERVER_SAFE_RESTART',
   'SERVER_WIPE_SAVE',
@@ -10,11 +11,20 @@ export const JOB_TYPES = [
   'SEND_COMMAND',
   'REGION_HEALER_START',
   'REGION_HEALER_STOP',
+  'REGION_RESET',
+  'REGION_LIST',
   'SAVE_LIST',
+  'SAVE_DIFF',
   'SAVE_BACKUP',
   'SAVE_RESTORE',
+  'SAVE_RESTORE_REGIONS',
+  'SAVE_VERIFY',
+  'SAVE_REPLICATE',
   'SAVE_DELETE',
   'SAVE_RETENTION',
+  'SAVE_PIN',
+  'SAVE_MIGRATE',
+  'SAVE_PERMISSIONS_REPAIR',
   'PLAYER_KICK',
   'PLAYER_KICK_ALL',
   'PLAYER_BAN',
@@ -22,6 +32,15 @@ export const JOB_TYPES = [
   'PLAYER_ADMIN_LIST',
   'PLAYER_ADMIN_PROMOTE',
   'PLAYER_ADMIN_DEMOTE',
+  'SERVER_ADMIN_LIST',
+  'SERVER_ADMIN_ADD',
+  'SERVER_ADMIN_UPDATE',
+  'SERVER_ADMIN_REMOVE',
+  'SERVER_CONFIG_WRITE',
+  'SERVER_CONFIG_ROLLBACK',
+  'GAME_INSTALL',
+  'GAME_UPDATE',
+  'SERVER_CONFIG_READ',
   'MOD_LIST',
   'MOD_QUARANTINE',
   'MOD_QUARANTINE_LIST',
@@ -29,7 +48,17 @@ export const JOB_TYPES = [
   'MOD_DELETE',
   'MOD_CONFIG_READ',
   'MOD_CONFIG_WRITE',
+  'MOD_CONFIG_HISTORY',
+  'MOD_CONFIG_DIFF',
+  'MOD_CONFIG_REVERT',
   'MOD_UPLOAD_QUARANTINE',
+  'MOD_INSTALL',
+  'MOD_CONFLICT_SCAN',
+  'MOD_VALIDATE',
+  'MOD_SET_LIST',
+  'MOD_SET_SAVE',
+  'MOD_SET_DELETE',
+  'MOD_SET_APPLY',
   'PROFILE_LIST',
   'PROFILE_READ',
   'PROFILE_STAGE',
@@ -43,6 +72,18 @@ export type JobType = (typeof JOB_TYPES)[number];
 export const JOB_RUN_STATUS = ['pending', 'running', 'success', 'failed', 'cancelled'] as const;
 export type JobRunStatus = (typeof JOB_RUN_STATUS)[number];
 
+/** Payload fields dropped from the stored job once its run finishes. */
+export const JOB_PAYLOAD_SECRETS: Partial<Record<JobType, readonly string[]>> = {
+  SAVE_RESTORE: ['private_key'],
+  SAVE_VERIFY: ['private_key'],
+  SAVE_DIFF: ['private_key'],
+  SAVE_RESTORE_REGIONS: ['private_key'],
+  SERVER_ADMIN_ADD: ['secret'],
+  SERVER_ADMIN_UPDATE: ['secret'],
+  GAME_INSTALL: ['beta_password'],
+  GAME_UPDATE: ['beta_password'],
+};
+
 export const MAX_RETRIES = 2;
 export const JOB_ATTEMPTS = MAX_RETRIES + 1;
 
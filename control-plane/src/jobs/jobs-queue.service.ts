@@ -78,6 +78,6 @@ export class JobsQueueService implements OnModuleDestroy {
     // Keep this allowlist aligned with the agent. Arbitrary RCON and
     // SEND_COMMAND payloads can mutate game state and must stay behind the
     // per-host mutation gate.
-    return ['MOD_LIST','MOD_QUARANTINE_LIST','MOD_CONFIG_READ','PROFILE_LIST','PROFILE_READ','PLAYER_LIST_SYNC','PLAYER_ADMIN_LIST','SAVE_LIST'].includes(type);
+    return ['MOD_LIST','MOD_QUARANTINE_LIST','MOD_CONFIG_READ','PROFILE_LIST','PROFILE_READ','PLAYER_LIST_SYNC','PLAYER_ADMIN_LIST','SERVER_ADMIN_LIST','SERVER_CONFIG_READ','MOD_CONFLICT_SCAN','MOD_VALIDATE','MOD_SET_LIST','MOD_CONFIG_HISTORY','MOD_CONFIG_DIFF','SERVER_LOGS','SAVE_VERIFY','SAVE_DIFF','REGION_LIST','SAVE_LIST'].includes(type);
   }
 }
@@ -24,9 +24,15 @@ export class JobsController {
     @Param('orgId') orgId: string,
     @Req() req: RequestWithUser,
     @Body('serverInstanceId') serverInstanceId: string,
+    @Body('mode') mode: string | undefined,
+    @Body('allowDowngrade') allowDowngrade: string | undefined,
     @UploadedFile() file?: { originalname: string; size: number; buffer: Buffer },
   ) {
     if (!serverInstanceId) throw new BadRequestException('Server instance is required');
+    const installMode = mode || 'quarantine';
+    if (!['quarantine', 'install', 'update'].includes(installMode)) {
+      throw new BadRequestException('Mode must be quarantine, install or update');
+    }
     if (!file?.buffer?.length) throw new BadRequestException('Choose a non-empty ZIP archive');
     if (!/\.zip$/i.test(file.originalname || '')) throw new BadRequestException('Only .zip mod archives are supported');
     const signature = file.buffer.subarray(0, 4).toString('hex');
@@ -43,8 +49,10 @@ export class JobsController {
         orgId,
         req.user!.id,
         serverInstanceId,
-        'MOD_UPLOAD_QUARANTINE',
-        { uploadId, originalName: file.originalname, sizeBytes: file.size },
+        installMode === 'quarantine' ? 'MOD_UPLOAD_QUARANTINE' : 'MOD_INSTALL',
+        installMode === 'quarantine'
+          ? { uploadId, originalName: file.originalname, sizeBytes: file.size }
+          : { uploadId, originalName: file.originalname, sizeBytes: file.size, mode: installMode, allow_downgrade: allowDowngrade === 'true' },
       );
     } catch (error) {
       await unlink(stagedPath).catch(() => undefined);
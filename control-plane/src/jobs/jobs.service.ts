@@ -10,6 +10,7 @@ import type { Prisma } from '@prisma/client';
 import { PrismaService } from '../prisma.service';
 import { BatchesService } from '../batches/batches.service';
 import { JobsQueueService } from './jobs-queue.service';
+import { JOB_PAYLOAD_SECRETS, type JobType } from './constants';
 import type { ReportResultDto } from './dto/report-result.dto';
 import { reconcileNameFallback } from '../players/player-identity';
 import { AlertsService } from '../alerts/alerts.service';
@@ -38,17 +39,27 @@ export class JobsService implements OnModuleInit, OnModuleDestroy {
 
   private async failStaleRunningJobs() {
     const cutoff = Date.now() - 3 * 60_000;
-    const runs = await this.prisma.jobRun.findMany({ where: { status: 'running' }, select: { id: true, result: true, startedAt: true } });
+    const runs = await this.prisma.jobRun.findMany({ where: { status: 'running' }, select: { id: true, result: true, startedAt: true, job: { select: { id: true, type: true, payload: true } } } });
     for (const run of runs) {
       const result = (run.result ?? {}) as Record<string, unknown>;
       const heartbeat = Date.parse(String(result.updatedAt ?? '')) || run.startedAt?.getTime() || 0;
       if (heartbeat >= cutoff) continue;
-      await this.prisma.jobRun.updateMany({ where: { id: run.id, status: 'running' }, data: {
+      const released = await this.prisma.jobRun.updateMany({ where: { id: run.id, status: 'running' }, data: {
         status: 'failed', finishedAt: new Date(), result: { ...result, errorMessage: 'Agent stopped reporting progress; job released as stale. Retry if still needed.', recoveredAt: new Date().toISOString() },
       }});
+      if (released.count > 0) await this.scrubPayloadSecrets(run.job.id, run.job.type, run.job.payload);
     }
   }
 
+  /** Remove the job type's secret payload fields once its run is finished. */
+  private async scrubPayloadSecrets(jobId: string, type: string, payload: Prisma.JsonValue) {
+    const secrets = JOB_PAYLOAD_SECRETS[type as JobType];
+    const previous = (payload ?? {}) as Record<string, unknown>;
+    if (!secrets || !secrets.some((key) => key in previous)) return;
+    const scrubbed = Object.fromEntries(Object.entries(previous).filter(([key]) => !secrets.includes(key)));
+    await this.prisma.job.update({ where: { id: jobId }, data: { payload: scrubbed as Prisma.InputJsonValue } });
+  }
+
   /**
    * Create a single job + job run and enqueue it for the target host.
    */
@@ -67,7 +78,12 @@ export class JobsService implements OnModuleInit, OnModuleDestroy {
       if (/[\r\n]/.test(command)) throw new BadRequestException('Only one console command may be sent at a time');
       payload = { ...(payload ?? {}), command };
     }
-    if (normalizedJobType === 'PLAYER_ADMIN_PROMOTE' || normalizedJobType === 'PLAYER_ADMIN_DEMOTE') {
+    if (normalizedJobType === 'MOD_CONFIG_WRITE' || normalizedJobType === 'MOD_CONFIG_REVERT') {
+      // The agent records the author in the file's version history; never trust a client-supplied name.
+      const author = await this.prisma.user.findUnique({ where: { id: userId }, select: { name: true, email: true } });
+      payload = { ...(payload ?? {}), author: author?.name || author?.email || userId };
+    }
+    if (['PLAYER_ADMIN_PROMOTE', 'PLAYER_ADMIN_DEMOTE', 'SERVER_ADMIN_ADD', 'SERVER_ADMIN_UPDATE', 'SERVER_ADMIN_REMOVE'].includes(normalizedJobType)) {
       const membership = await this.prisma.userOrg.findUnique({
         where: { userId_orgId: { userId, orgId } },
         include: { role: true },
@@ -85,7 +101,7 @@ export class JobsService implements OnModuleInit, OnModuleDestroy {
         throw new ForbiddenException('Only organization administrators or operators may perform this action');
       }
     }
-    if (['SAVE_BACKUP', 'SAVE_RESTORE', 'SAVE_DELETE', 'SAVE_RETENTION'].includes(normalizedJobType)) {
+    if (['SAVE_BACKUP', 'SAVE_RESTORE', 'SAVE_DELETE', 'SAVE_RETENTION', 'REGION_RESET', 'SAVE_RESTORE_REGIONS', 'SAVE_MIGRATE', 'SAVE_PIN', 'SAVE_REPLICATE', 'SAVE_PERMISSIONS_REPAIR'].includes(normalizedJobType)) {
       const membership = await this.prisma.userOrg.findUnique({
         where: { userId_orgId: { userId, orgId } },
         include: { role: true },
@@ -94,11 +110,23 @@ export class JobsService implements OnModuleInit, OnModuleDestroy {
         throw new ForbiddenException('Only organization administrators or operators may manage saves');
       }
     }
+    if (['SERVER_CONFIG_WRITE', 'SERVER_CONFIG_ROLLBACK'].includes(normalizedJobType)) {
+      const membership = await this.prisma.userOrg.findUnique({ where: { userId_orgId: { userId, orgId } }, include: { role: true } });
+      if (!membership || !['admin', 'operator'].includes(membership.role.name)) throw new ForbiddenException('Only organization administrators or operators may change server configuration');
+    }
+    if (['GAME_INSTALL', 'GAME_UPDATE'].includes(normalizedJobType)) {
+      const membership = await this.prisma.userOrg.findUnique({ where: { userId_orgId: { userId, orgId } }, include: { role: true } });
+      if (!membership || !['admin', 'operator'].includes(membership.role.name)) throw new ForbiddenException('Only organization administrators or operators may install or update game files');
+    }
     if (normalizedJobType === 'PROFILE_STAGE') {
       const membership = await this.prisma.userOrg.findUnique({ where: { userId_orgId: { userId, orgId } }, include: { role: true } });
       if (!membership || !['admin', 'operator'].includes(membership.role.name)) throw new ForbiddenException('Only organization administrators or operators may stage player profiles');
     }
-    if (normalizedJobType === 'MOD_UPLOAD_QUARANTINE') {
+    if (['MOD_SET_SAVE', 'MOD_SET_DELETE', 'MOD_SET_APPLY'].includes(normalizedJobType)) {
+      const membership = await this.prisma.userOrg.findUnique({ where: { userId_orgId: { userId, orgId } }, include: { role: true } });
+      if (!membership || !['admin', 'operator'].includes(membership.role.name)) throw new ForbiddenException('Only organization administrators or operators may manage mod sets');
+    }
+    if (['MOD_UPLOAD_QUARANTINE', 'MOD_INSTALL'].includes(normalizedJobType)) {
       const membership = await this.prisma.userOrg.findUnique({ where: { userId_orgId: { userId, orgId } }, include: { role: true } });
       if (!membership || !['admin', 'operator'].includes(membership.role.name)) throw new ForbiddenException('Only organization administrators or operators may upload mods');
     }
@@ -193,7 +221,7 @@ export class JobsService implements OnModuleInit, OnModuleDestroy {
         result,
       },
     });
-    if (run.job.type === 'MOD_UPLOAD_QUARANTINE') {
+    if (['MOD_UPLOAD_QUARANTINE', 'MOD_INSTALL'].includes(run.job.type)) {
       const payload = (run.job.payload ?? {}) as Record<string, unknown>;
       const uploadId = typeof payload.uploadId === 'string' ? payload.uploadId : '';
       if (/^[0-9a-f-]{36}$/i.test(uploadId)) {
@@ -204,6 +232,7 @@ export class JobsService implements OnModuleInit, OnModuleDestroy {
       const previous = (run.job.payload ?? {}) as Record<string, unknown>;
       await this.prisma.job.update({ where: { id: run.jobId }, data: { payload: { path: previous.path, staged: runStatus === 'success' } as Prisma.InputJsonValue } });
     }
+    await this.scrubPayloadSecrets(run.jobId, run.job.type, run.job.payload);
 
     if (run.job.type === 'PLAYER_LIST_SYNC' && runStatus === 'success' && dto.output && run.job.serverInstanceId) {
       await this.reconcilePlayers(run.job.orgId, run.job.serverInstanceId, dto.output);